@@ -0,0 +1,57 @@
+package librefsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTransitionAborted is matched by the error returned from Context.Abort
+var ErrTransitionAborted = errors.New("transition aborted")
+
+// AbortError carries the reason a transition action gave for aborting
+type AbortError struct {
+	Reason string
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("transition aborted: %s", e.Reason)
+}
+
+// Is reports whether target is ErrTransitionAborted
+func (e *AbortError) Is(target error) bool {
+	return target == ErrTransitionAborted
+}
+
+// Abort cancels the transition whose action is running, for validation that
+// can only happen inside the action after the guard passed:
+//
+//	return c.Abort("battery too low to unlock")
+//
+// The states exited for the transition are entered again, running their entry
+// actions, so the machine ends up in its source state; internal transitions
+// simply keep it. The event counts as handled and SendSyncResult reports
+// SendAborted. Abort only has an effect in transition actions; returning the
+// error from other actions fails them like any other error.
+func (c *Context) Abort(reason string) error {
+	err := &AbortError{Reason: reason}
+	if c.abort == nil {
+		c.abort = err
+	}
+	return err
+}
+
+// abortTransition restores the source state after an aborted transition action
+func (m *Machine) abortTransition(abort *AbortError, fromState, lca StateID, event *Event) error {
+	m.logger.Info("transition aborted", "from", fromState, "event", eventID(event), "reason", abort.Reason)
+	if m.sendResult != nil {
+		m.sendResult.Outcome = SendAborted
+		m.sendResult.AbortReason = abort.Reason
+	}
+	if lca == fromState {
+		return nil // Nothing was exited
+	}
+	if err := m.enterFromAncestor(fromState, lca, event, fromState); err != nil {
+		return fmt.Errorf("re-enter after abort: %w", err)
+	}
+	return nil
+}
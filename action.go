@@ -0,0 +1,148 @@
+package librefsm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ActionTimeoutPolicy decides what happens when an action overruns its timeout
+type ActionTimeoutPolicy int
+
+const (
+	// ActionTimeoutFail cancels the action's context and fails it with
+	// ErrActionTimeout once it returned, see WithActionCancelGrace
+	ActionTimeoutFail ActionTimeoutPolicy = iota
+	// ActionTimeoutLog logs the overrun and keeps waiting for the action to finish
+	ActionTimeoutLog
+	// ActionTimeoutAbandon cancels the action's context and continues as if it succeeded.
+	// The action's goroutine keeps running until it observes the cancellation.
+	ActionTimeoutAbandon
+)
+
+// WithActionTimeout limits how long entry, exit, transition and timer actions may run.
+// Actions observe the deadline through Context.Context(); overruns are handled per policy.
+func WithActionTimeout(d time.Duration, policy ActionTimeoutPolicy) MachineOption {
+	return func(m *Machine) {
+		m.actionTimeout = d
+		m.actionTimeoutPolicy = policy
+	}
+}
+
+// defaultActionCancelGrace bounds the wait for a cancelled action to return
+const defaultActionCancelGrace = 100 * time.Millisecond
+
+// WithActionCancelGrace sets how long the machine waits for an action to
+// return after cancelling its context on an interrupt or under
+// ActionTimeoutFail, 100ms by default. Waiting keeps a cancelled action from
+// running alongside the transition that follows; an action still running
+// after the grace period is abandoned with a warning and keeps its goroutine
+// until it observes the cancellation.
+func WithActionCancelGrace(d time.Duration) MachineOption {
+	return func(m *Machine) {
+		m.actionCancelGrace = d
+	}
+}
+
+// WithStateActionTimeout overrides the machine's action timeout for the state's entry and exit actions
+func WithStateActionTimeout(d time.Duration) StateOption {
+	return func(s *State) {
+		s.ActionTimeout = d
+	}
+}
+
+// stateActionTimeout returns the effective action timeout for a state's callbacks
+func (m *Machine) stateActionTimeout(state *State) time.Duration {
+	if state.ActionTimeout > 0 {
+		return state.ActionTimeout
+	}
+	return m.actionTimeout
+}
+
+// baseContext returns the machine's lifetime context
+func (m *Machine) baseContext() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+// runAction executes a callback, enforcing the given timeout if positive
+func (m *Machine) runAction(ctx *Context, timeout time.Duration, name string, fn func(*Context) error) error {
+	return m.runActionPolicy(ctx, timeout, m.actionTimeoutPolicy, name, fn)
+}
+
+// runActionPolicy executes a callback per policy and counts failures
+func (m *Machine) runActionPolicy(ctx *Context, timeout time.Duration, policy ActionTimeoutPolicy, name string, fn func(*Context) error) error {
+	end := m.beginAction(name)
+	err := m.runActionTimed(ctx, timeout, policy, name, fn)
+	end()
+	if err != nil {
+		m.stats.actionErrs.Add(1)
+	}
+	return err
+}
+
+// runActionTimed executes a callback, handling a timeout overrun per policy
+func (m *Machine) runActionTimed(ctx *Context, timeout time.Duration, policy ActionTimeoutPolicy, name string, fn func(*Context) error) error {
+	if timeout <= 0 && len(m.interruptEvents) == 0 {
+		return fn(ctx)
+	}
+
+	actx, cancel := context.WithCancel(m.baseContext())
+	defer cancel()
+	ctx.ctx = actx
+
+	interrupted, release := m.trackAction(cancel)
+	defer release()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-interrupted:
+		m.logger.Warn("action interrupted", "action", name)
+		m.awaitCancelled(name, done)
+		return fmt.Errorf("%s: %w", name, ErrActionInterrupted)
+	case <-expired:
+	}
+
+	switch policy {
+	case ActionTimeoutLog:
+		m.logger.Warn("action exceeded timeout, still waiting", "action", name, "timeout", timeout)
+		return <-done
+	case ActionTimeoutAbandon:
+		m.logger.Warn("action exceeded timeout, abandoned", "action", name, "timeout", timeout)
+		return nil
+	default:
+		cancel()
+		m.awaitCancelled(name, done)
+		return fmt.Errorf("%s: %w after %s", name, ErrActionTimeout, timeout)
+	}
+}
+
+// awaitCancelled waits up to the cancel grace period for a cancelled action to
+// return, see WithActionCancelGrace
+func (m *Machine) awaitCancelled(name string, done <-chan error) {
+	if m.actionCancelGrace <= 0 {
+		return
+	}
+	timer := time.NewTimer(m.actionCancelGrace)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		m.logger.Warn("cancelled action still running, abandoned", "action", name, "grace", m.actionCancelGrace)
+	}
+}
@@ -0,0 +1,104 @@
+package librefsm
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// The interfaces below connect a machine to the outside world. The core
+// package implements them only with the standard library; adapters for
+// Redis, MQTT, gRPC, Prometheus and the like live in their own modules so
+// that importing librefsm never pulls in their dependencies. Pending events
+// are persisted through EventPersister, see WithDurableQueue and the
+// reference implementation in the filestore package.
+
+// EventSource feeds events from outside the process into a machine, e.g. the
+// messages of a Redis channel or an MQTT topic
+type EventSource interface {
+	// Run delivers events through send until ctx is cancelled or the source
+	// fails. It is called on its own goroutine when the machine starts.
+	Run(ctx context.Context, send func(Event)) error
+}
+
+// StateSink publishes state changes to the outside, e.g. to a Redis hash
+type StateSink interface {
+	// PublishState is called on the event loop and must not block; sinks
+	// talking to the network should buffer and publish asynchronously.
+	PublishState(change StateChanged) error
+}
+
+// MetricsCollector receives a machine's measurements, e.g. to export them to
+// Prometheus. Its methods are called on the event loop and must return quickly.
+type MetricsCollector interface {
+	ObserveEvent(sample LatencySample)
+	ObserveStateChange(from, to StateID)
+}
+
+// WithEventSource runs src while the machine runs and sends the events it
+// delivers to the machine. A source failing is logged; it is not restarted.
+func WithEventSource(src EventSource) MachineOption {
+	return func(m *Machine) {
+		m.eventSources = append(m.eventSources, src)
+	}
+}
+
+// WithStateSink publishes every state change of the machine to sink, naming
+// the machine name in the StateChanged payload. Publishing errors are logged.
+func WithStateSink(sink StateSink, name string) MachineOption {
+	return func(m *Machine) {
+		m.statePublishers = append(m.statePublishers, func(from, to StateID) {
+			if err := sink.PublishState(StateChanged{Machine: name, From: from, To: to}); err != nil {
+				m.logger.Warn("state sink failed", "machine", name, "to", to, "error", err)
+			}
+		})
+	}
+}
+
+// WithMetricsCollector reports the latency of every processed event, see
+// WithLatencyMetrics, and every state change to c
+func WithMetricsCollector(c MetricsCollector) MachineOption {
+	return func(m *Machine) {
+		m.enableLatency()
+		m.collectors = append(m.collectors, c)
+		m.statePublishers = append(m.statePublishers, c.ObserveStateChange)
+	}
+}
+
+// sourceRunner tracks the event sources running for the current run
+type sourceRunner struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// startSources runs the configured event sources until stopSources
+func (m *Machine) startSources() {
+	if len(m.eventSources) == 0 {
+		return
+	}
+	r := &m.sources
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ctx, cancel := context.WithCancel(m.baseContext())
+	r.cancel = cancel
+	for _, src := range m.eventSources {
+		src := src
+		go func() {
+			if err := src.Run(ctx, m.Send); err != nil && !errors.Is(err, context.Canceled) {
+				m.logger.Error("event source failed", "error", err)
+			}
+		}()
+	}
+}
+
+// stopSources cancels the running event sources. It does not wait for them,
+// so a source ignoring its context cannot hold up Stop.
+func (m *Machine) stopSources() {
+	r := &m.sources
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
@@ -0,0 +1,138 @@
+package librefsm
+
+import (
+	"sort"
+	"sync"
+)
+
+// Bus is an in-process publish/subscribe hub that decouples machines and
+// plain Go components, e.g. the vehicle, battery and dashboard machines of one
+// service. Topics are event ID prefixes: publish "battery:low" and subscribe
+// to EventPrefix("battery:"). Handlers run synchronously in the publisher's
+// goroutine and must not block; Machine.Send is a suitable handler.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[int]busSubscriber
+	next int
+}
+
+type busSubscriber struct {
+	pattern EventID
+	fn      func(Event)
+}
+
+// StateChanged is the payload of state change events published to a Bus
+type StateChanged struct {
+	Machine string
+	From    StateID
+	To      StateID
+}
+
+// NewBus creates an empty bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]busSubscriber)}
+}
+
+// StateChangedEvent returns the ID of the events a machine published with
+// WithStatePublishing raises on each state change, i.e. "state.<name>".
+// Subscribe to EventPrefix("state.") to follow all machines.
+func StateChangedEvent(name string) EventID {
+	return EventID("state." + name)
+}
+
+// Subscribe calls fn for every published event matching pattern, which may be
+// an event ID, an EventPrefix or WildcardEvent. It returns a function that
+// removes the subscription.
+func (b *Bus) Subscribe(pattern EventID, fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = busSubscriber{pattern: pattern, fn: fn}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Publish delivers the event to all matching subscribers, in subscription order
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	ids := make([]int, 0, len(b.subs))
+	for id, sub := range b.subs {
+		if eventMatches(sub.pattern, event.ID) {
+			ids = append(ids, id)
+		}
+	}
+	fns := make([]func(Event), 0, len(ids))
+	sort.Ints(ids)
+	for _, id := range ids {
+		fns = append(fns, b.subs[id].fn)
+	}
+	b.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(event)
+	}
+}
+
+// WithStatePublishing publishes every state change of the machine to bus as a
+// StateChangedEvent(name) carrying a StateChanged payload
+func WithStatePublishing(bus *Bus, name string) MachineOption {
+	return func(m *Machine) {
+		m.statePublishers = append(m.statePublishers, func(from, to StateID) {
+			bus.Publish(Event{ID: StateChangedEvent(name), Payload: StateChanged{Machine: name, From: from, To: to}})
+		})
+	}
+}
+
+// WithSubscription forwards events published on bus that match any of the
+// patterns to the machine with Send while it runs
+func WithSubscription(bus *Bus, patterns ...EventID) MachineOption {
+	return func(m *Machine) {
+		for _, p := range patterns {
+			m.busSubscriptions = append(m.busSubscriptions, busSubscription{bus: bus, pattern: p})
+		}
+	}
+}
+
+// busSubscription is a pattern the machine subscribes to while running
+type busSubscription struct {
+	bus     *Bus
+	pattern EventID
+}
+
+// subscribeBus subscribes the machine to its configured bus patterns
+func (m *Machine) subscribeBus() {
+	m.busMu.Lock()
+	defer m.busMu.Unlock()
+	for _, s := range m.busSubscriptions {
+		m.busUnsubscribe = append(m.busUnsubscribe, s.bus.Subscribe(s.pattern, m.Send))
+	}
+}
+
+// unsubscribeBus removes the machine's bus subscriptions
+func (m *Machine) unsubscribeBus() {
+	m.busMu.Lock()
+	defer m.busMu.Unlock()
+	for _, unsubscribe := range m.busUnsubscribe {
+		unsubscribe()
+	}
+	m.busUnsubscribe = nil
+}
+
+// notifyStateChange calls the state change callback and publishes the change
+func (m *Machine) notifyStateChange(from, to StateID) {
+	m.releaseDeferred()
+	if m.stateChangeCallback != nil {
+		m.stateChangeCallback(from, to)
+	}
+	for _, publish := range m.statePublishers {
+		publish(from, to)
+	}
+}
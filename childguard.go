@@ -0,0 +1,55 @@
+package librefsm
+
+import "fmt"
+
+// WithChildEntryGuard defers entering the state's DefaultChild until guard passes,
+// e.g. enter "drive" but only enter "motor_enabled" once the ECU handshake is done.
+// Meanwhile the machine holds in the composite state itself; the guard is
+// re-checked whenever the machine settles (after each event and variable change).
+// If holdEvent is set, it is sent when entry is deferred, so the application can
+// start whatever the guard waits for; a WithTimeout on the state bounds the hold.
+func WithChildEntryGuard(guard func(*GuardContext) bool, holdEvent EventID) StateOption {
+	return func(s *State) {
+		if guard == nil {
+			s.errs = append(s.errs, fmt.Errorf("WithChildEntryGuard: nil guard"))
+		}
+		s.ChildEntryGuard = guard
+		s.HoldEvent = holdEvent
+	}
+}
+
+// childEntryAllowed evaluates a state's child entry guard
+func (m *Machine) childEntryAllowed(state *State, event *Event) bool {
+	if state.ChildEntryGuard == nil {
+		return true
+	}
+	return state.ChildEntryGuard(&GuardContext{
+		Event:     event,
+		FromState: m.currentState,
+		ToState:   state.DefaultChild,
+		Data:      m.data,
+		Logger:    m.logger,
+		fsm:       m,
+	})
+}
+
+// resumeDeferredChild enters the default child of a holding composite state once
+// its child entry guard passes. It reports whether the child was entered.
+func (m *Machine) resumeDeferredChild() (bool, error) {
+	id := m.currentState
+	state := m.definition.states[id]
+	if state == nil || state.DefaultChild == "" || state.ChildEntryGuard == nil {
+		return false, nil
+	}
+	if !m.childEntryAllowed(state, nil) {
+		return false, nil
+	}
+
+	m.logger.Debug("child entry guard passed", "state", id, "child", state.DefaultChild)
+	if err := m.enterStateChain(state.DefaultChild, nil, id, 1); err != nil {
+		return false, fmt.Errorf("deferred entry of %q: %w", state.DefaultChild, err)
+	}
+	m.recordTransition(id, "")
+	m.notifyStateChange(id, m.currentState)
+	return true, nil
+}
@@ -0,0 +1,43 @@
+package librefsm
+
+import "fmt"
+
+// Weighted is a target of a random choice state with its relative weight
+type Weighted struct {
+	Target StateID
+	Weight float64
+}
+
+// RandomChoiceState adds a condition pseudo-state that picks one of the targets at
+// random, proportionally to their weights. Meant for test benches that soak-test
+// downstream services against randomized behavior; seed the machine with
+// WithSeed for reproducible runs.
+func (d *Definition) RandomChoiceState(id StateID, targets []Weighted, opts ...StateOption) *Definition {
+	s := &State{ID: id, Type: StateCondition}
+
+	var total float64
+	for _, w := range targets {
+		if w.Weight <= 0 {
+			s.errs = append(s.errs, fmt.Errorf("target %q has non-positive weight %v", w.Target, w.Weight))
+		}
+		total += w.Weight
+		s.PossibleTargets = append(s.PossibleTargets, w.Target)
+	}
+	if len(targets) == 0 {
+		s.errs = append(s.errs, fmt.Errorf("no targets"))
+	}
+
+	choices := append([]Weighted(nil), targets...)
+	s.Condition = func(c *Context) StateID {
+		r := c.FSM.randFloat64() * total
+		for _, w := range choices {
+			r -= w.Weight
+			if r < 0 {
+				return w.Target
+			}
+		}
+		return choices[len(choices)-1].Target
+	}
+
+	return d.addState("RandomChoiceState", s, opts)
+}
@@ -0,0 +1,58 @@
+// Command fsmrepl loads a JSON statechart document and lets you drive it
+// interactively: type events, inspect states and timers, fire timers early.
+// Named actions are stubbed, so a chart can be explored before any code exists.
+//
+// Usage:
+//
+//	fsmrepl [-v] chart.json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/librescoot/librefsm"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "log machine activity to stderr")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-v] chart.json\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *verbose); err != nil {
+		fmt.Fprintf(os.Stderr, "fsmrepl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, verbose bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	def, err := librefsm.ParseDocument(data)
+	if err != nil {
+		return err
+	}
+
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return librefsm.RunREPL(ctx, def, os.Stdin, os.Stdout, librefsm.WithLogger(logger))
+}
@@ -0,0 +1,91 @@
+package librefsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// PayloadCodec encodes event payloads for crossing process boundaries, e.g. in
+// network adapters or persisted journals
+type PayloadCodec interface {
+	// ContentType identifies the wire format, e.g. "application/json"
+	ContentType() string
+	Encode(event EventID, payload any) ([]byte, error)
+	// Decode returns a payload of the type registered for the event, or a
+	// generic value (map[string]any, []any, string, ...) if none is registered
+	Decode(event EventID, data []byte) (any, error)
+}
+
+// PayloadTypes maps event IDs to the Go types of their payloads so codecs can
+// restore typed payloads. The zero value is ready to use.
+type PayloadTypes struct {
+	mu    sync.RWMutex
+	types map[EventID]reflect.Type
+}
+
+// RegisterPayload records T as the payload type of event
+func RegisterPayload[T any](types *PayloadTypes, event EventID) {
+	types.mu.Lock()
+	defer types.mu.Unlock()
+	if types.types == nil {
+		types.types = make(map[EventID]reflect.Type)
+	}
+	types.types[event] = reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// lookup returns the registered payload type of event, or nil
+func (p *PayloadTypes) lookup(event EventID) reflect.Type {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.types[event]
+}
+
+// decodeJSON unmarshals data into the registered type of event, or a generic value
+func (p *PayloadTypes) decodeJSON(event EventID, data []byte) (any, error) {
+	typ := p.lookup(event)
+	if typ == nil {
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("decode payload of %q: %w", event, err)
+		}
+		return v, nil
+	}
+	ptr := reflect.New(typ)
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return nil, fmt.Errorf("decode payload of %q as %s: %w", event, typ, err)
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// JSONCodec encodes payloads as JSON
+type JSONCodec struct {
+	Types *PayloadTypes // Optional: payload types to decode into
+}
+
+// ContentType returns "application/json"
+func (c JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+// Encode marshals the payload as JSON; a nil payload encodes as null
+func (c JSONCodec) Encode(event EventID, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode payload of %q: %w", event, err)
+	}
+	return data, nil
+}
+
+// Decode unmarshals a JSON payload
+func (c JSONCodec) Decode(event EventID, data []byte) (any, error) {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil, nil
+	}
+	return c.Types.decodeJSON(event, data)
+}
@@ -0,0 +1,137 @@
+package librefsm
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer delays an event until no further event of the same ID arrived for window
+type debouncer struct {
+	window  time.Duration
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending Event
+	gen     uint64 // Invalidates timers that fired while being replaced
+
+	deadline  time.Time
+	remaining time.Duration // Time left when suspended
+	suspended bool          // Paused by NotifySuspend
+}
+
+// throttle lets at most one event of an ID through per interval
+type throttle struct {
+	interval time.Duration
+	mu       sync.Mutex
+	last     time.Time
+}
+
+// WithEventDebounce delivers an event only after it has not been sent again for
+// window; the latest payload wins. Use it for bouncing inputs like switches.
+func WithEventDebounce(event EventID, window time.Duration) MachineOption {
+	return func(m *Machine) {
+		if window <= 0 {
+			return
+		}
+		if m.debouncers == nil {
+			m.debouncers = make(map[EventID]*debouncer)
+		}
+		m.debouncers[event] = &debouncer{window: window}
+	}
+}
+
+// WithEventThrottle delivers at most one event per interval; further events of
+// the same ID within the interval are dropped.
+func WithEventThrottle(event EventID, interval time.Duration) MachineOption {
+	return func(m *Machine) {
+		if interval <= 0 {
+			return
+		}
+		if m.throttles == nil {
+			m.throttles = make(map[EventID]*throttle)
+		}
+		m.throttles[event] = &throttle{interval: interval}
+	}
+}
+
+// conditionEvent applies debounce and throttle settings.
+// It returns true if the event was taken over or dropped and must not be queued.
+func (m *Machine) conditionEvent(event Event) bool {
+	if th := m.throttles[event.ID]; th != nil {
+		th.mu.Lock()
+		now := time.Now()
+		if !th.last.IsZero() && now.Sub(th.last) < th.interval {
+			th.mu.Unlock()
+			m.logger.Debug("event throttled", "event", event.ID)
+			m.logEvent(event, EventDropped, "", now, 0)
+			return true
+		}
+		th.last = now
+		th.mu.Unlock()
+	}
+
+	if db := m.debouncers[event.ID]; db != nil {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		db.pending = event
+		if db.timer != nil {
+			db.timer.Stop()
+		}
+		db.gen++
+		gen := db.gen
+		db.deadline = time.Now().Add(db.window)
+		db.suspended = false
+		db.timer = time.AfterFunc(db.window, func() {
+			db.mu.Lock()
+			if db.gen != gen {
+				db.mu.Unlock()
+				return
+			}
+			ev := db.pending
+			db.timer = nil
+			db.mu.Unlock()
+			m.enqueue(ev)
+		})
+		return true
+	}
+
+	return false
+}
+
+// suspendDebounceTimers pauses running debounce windows, see NotifySuspend
+func (m *Machine) suspendDebounceTimers() {
+	for _, db := range m.debouncers {
+		db.mu.Lock()
+		if db.timer != nil && db.timer.Stop() {
+			db.remaining = time.Until(db.deadline)
+			db.suspended = true
+		}
+		db.mu.Unlock()
+	}
+}
+
+// resumeDebounceTimers re-arms paused debounce windows per the resume policy
+func (m *Machine) resumeDebounceTimers(slept time.Duration) {
+	for _, db := range m.debouncers {
+		db.mu.Lock()
+		if db.suspended && db.timer != nil {
+			db.suspended = false
+			d := m.resumeWait(db.remaining, db.window, slept)
+			db.deadline = time.Now().Add(d)
+			db.timer.Reset(d)
+		}
+		db.mu.Unlock()
+	}
+}
+
+// stopDebounceTimers discards events still waiting for their debounce window
+func (m *Machine) stopDebounceTimers() {
+	for _, db := range m.debouncers {
+		db.mu.Lock()
+		if db.timer != nil {
+			db.timer.Stop()
+			db.timer = nil
+		}
+		db.gen++
+		db.mu.Unlock()
+	}
+}
@@ -0,0 +1,68 @@
+package librefsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ConflictPolicy decides which transition wins when several match an event
+// from the current state, its ancestors and any-state transitions. Within
+// each group, transitions are tried in declaration order and guards decide
+// as usual; the policy orders the groups.
+type ConflictPolicy int
+
+const (
+	// ConflictMostSpecific tries the current state first, then its ancestors
+	// innermost first, then any-state transitions. This is the default.
+	ConflictMostSpecific ConflictPolicy = iota
+	// ConflictWildcardFirst tries any-state transitions before state-specific
+	// ones, so a global override (e.g. an emergency stop) always wins
+	ConflictWildcardFirst
+	// ConflictPriority orders transitions by WithPriority, highest first, and
+	// falls back to ConflictMostSpecific order between equal priorities
+	ConflictPriority
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictMostSpecific:
+		return "most-specific"
+	case ConflictWildcardFirst:
+		return "wildcard-first"
+	case ConflictPriority:
+		return "priority"
+	default:
+		return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+	}
+}
+
+// WithConflictPolicy sets how conflicts between child, ancestor and any-state
+// transitions are resolved
+func WithConflictPolicy(p ConflictPolicy) MachineOption {
+	return func(m *Machine) {
+		m.conflictPolicy = p
+	}
+}
+
+// WithPriority sets the transition's priority under ConflictPriority.
+// Higher values are tried first; the default is 0.
+func WithPriority(priority int) TransitionOption {
+	return func(t *Transition) {
+		t.Priority = priority
+	}
+}
+
+// resolveConflicts orders candidate transitions per the conflict policy.
+// specific holds the current state's and ancestors' matches, innermost first.
+func (m *Machine) resolveConflicts(specific, wildcard []*Transition) []*Transition {
+	switch m.conflictPolicy {
+	case ConflictWildcardFirst:
+		return append(wildcard, specific...)
+	case ConflictPriority:
+		matches := append(specific, wildcard...)
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Priority > matches[j].Priority })
+		return matches
+	default:
+		return append(specific, wildcard...)
+	}
+}
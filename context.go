@@ -1,6 +1,7 @@
 package librefsm
 
 import (
+	"context"
 	"log/slog"
 	"time"
 )
@@ -13,6 +14,19 @@ type Context struct {
 	ToState   StateID // State we're transitioning to
 	Data      any     // User-provided application data
 	Logger    *slog.Logger
+
+	ctx   context.Context
+	state StateID     // State owning the running entry/exit action
+	abort *AbortError // Set by Abort
+}
+
+// Context returns the context.Context the current action should honor.
+// It is cancelled when the action times out or the machine stops.
+func (c *Context) Context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return c.FSM.baseContext()
 }
 
 // CurrentState returns the current active state
@@ -56,7 +70,54 @@ func (c *Context) TimerActive(name string) bool {
 	return c.FSM.TimerActive(name)
 }
 
+// TimeInState returns how long the machine has been in its current state.
+// During entry actions this is the time since the entering state was activated.
+func (c *Context) TimeInState() time.Duration {
+	return c.FSM.timeInState()
+}
+
 // Send queues an event for asynchronous processing
 func (c *Context) Send(event Event) {
 	c.FSM.Send(event)
 }
+
+// DeferEvent holds an event back until the next state change, then delivers it
+// ahead of parked events, e.g. to handle c.Event again once the machine left a
+// busy state. It shares the parked buffer's limit and overflow policy. The
+// deferred copy is not subject to deduplication.
+func (c *Context) DeferEvent(event Event) {
+	c.FSM.deferEvent(event)
+}
+
+// GuardContext is the read-only view passed to guards. Guards may be evaluated
+// several times per event, so it deliberately offers no timer or Send operations.
+type GuardContext struct {
+	Event     *Event  // Event being evaluated
+	FromState StateID // Current state
+	ToState   StateID // Target of the transition being guarded
+	Data      any     // User-provided application data
+	Logger    *slog.Logger
+
+	fsm *Machine
+}
+
+// CurrentState returns the current active state
+func (c *GuardContext) CurrentState() StateID {
+	return c.fsm.currentState
+}
+
+// IsInState checks if the given state is current or an ancestor of current
+func (c *GuardContext) IsInState(id StateID) bool {
+	return c.fsm.isInStateInternal(id)
+}
+
+// TimerActive checks if a timer is currently running
+func (c *GuardContext) TimerActive(name string) bool {
+	return c.fsm.TimerActive(name)
+}
+
+// TimeInState returns how long the machine has been in its current state,
+// e.g. for hysteresis like "only lock if parked for at least 3s"
+func (c *GuardContext) TimeInState() time.Duration {
+	return c.fsm.timeInState()
+}
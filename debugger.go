@@ -0,0 +1,137 @@
+package librefsm
+
+import (
+	"context"
+	"fmt"
+)
+
+// DebugStep is the machine's configuration after one recorded event
+type DebugStep struct {
+	Index    int          `json:"index"`            // Position in the journal, -1 for the starting point
+	Record   *EventRecord `json:"record,omitempty"` // Recorded event, nil for the starting point
+	Skipped  bool         `json:"skipped,omitempty"`
+	Diverged bool         `json:"diverged,omitempty"` // The replay ended differently than recorded
+	Error    string       `json:"error,omitempty"`    // Processing error of the replaying machine
+	Snapshot Snapshot     `json:"snapshot"`
+}
+
+// Debugger steps forward and backward through a recorded run, e.g. the
+// recent_events of a snapshot from the field. It replays the whole journal
+// once up front and keeps a snapshot per event, so stepping is cheap and
+// does not touch a live machine.
+type Debugger struct {
+	steps []DebugStep
+	pos   int
+}
+
+// NewDebugger replays journal on a fresh machine built from def and records
+// the configuration after every event. Events are replayed instantly, ignoring
+// opts.Speed, so recorded timer events stand in for the machine's own timers;
+// timer deadlines in the snapshots refer to the replay, not the recording.
+func NewDebugger(ctx context.Context, def *Definition, journal []EventRecord, opts ReplayOptions, machineOpts ...MachineOption) (*Debugger, error) {
+	m, err := def.Build(machineOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Start(ctx); err != nil {
+		return nil, err
+	}
+	defer m.Stop()
+
+	for _, rec := range journal {
+		if rec.Outcome == EventDropped || isInternalEvent(rec.ID) {
+			continue
+		}
+		if rec.State != "" && rec.State != m.CurrentState() {
+			if err := m.SetState(rec.State); err != nil {
+				return nil, fmt.Errorf("debugger start state: %w", err)
+			}
+		}
+		break
+	}
+
+	d := &Debugger{steps: []DebugStep{{Index: -1, Snapshot: debugSnapshot(m)}}}
+	opts.Speed = 0
+	err = replayJournal(ctx, m, journal, opts, func(i int, rec EventRecord, err error) {
+		step := DebugStep{Index: i, Record: &rec, Snapshot: debugSnapshot(m)}
+		if rec.Outcome == EventDropped || isInternalEvent(rec.ID) {
+			step.Skipped = true
+		} else {
+			step.Diverged = diverged(rec, m.CurrentState(), err)
+		}
+		if err != nil {
+			step.Error = err.Error()
+		}
+		d.steps = append(d.steps, step)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// debugSnapshot captures a snapshot without the event history, which the steps already hold
+func debugSnapshot(m *Machine) Snapshot {
+	snap := m.Snapshot()
+	snap.RecentEvents = nil
+	return snap
+}
+
+// Len returns the number of steps, including the starting point
+func (d *Debugger) Len() int {
+	return len(d.steps)
+}
+
+// Position returns the index of the current step, 0 being the starting point
+func (d *Debugger) Position() int {
+	return d.pos
+}
+
+// Current returns the current step
+func (d *Debugger) Current() DebugStep {
+	return d.steps[d.pos]
+}
+
+// Step moves one event forward. It returns false at the end of the run.
+func (d *Debugger) Step() (DebugStep, bool) {
+	if d.pos+1 >= len(d.steps) {
+		return d.Current(), false
+	}
+	d.pos++
+	return d.Current(), true
+}
+
+// Back moves one event backward. It returns false at the starting point.
+func (d *Debugger) Back() (DebugStep, bool) {
+	if d.pos == 0 {
+		return d.Current(), false
+	}
+	d.pos--
+	return d.Current(), true
+}
+
+// Seek moves to step n, 0 being the starting point
+func (d *Debugger) Seek(n int) (DebugStep, error) {
+	if n < 0 || n >= len(d.steps) {
+		return d.Current(), fmt.Errorf("step %d out of range [0, %d)", n, len(d.steps))
+	}
+	d.pos = n
+	return d.Current(), nil
+}
+
+// NextDivergence moves forward to the next step whose replay differs from
+// the recording. It returns false and stays put if there is none.
+func (d *Debugger) NextDivergence() (DebugStep, bool) {
+	for i := d.pos + 1; i < len(d.steps); i++ {
+		if d.steps[i].Diverged {
+			d.pos = i
+			return d.Current(), true
+		}
+	}
+	return d.Current(), false
+}
+
+// Steps returns all steps, e.g. for rendering a timeline
+func (d *Debugger) Steps() []DebugStep {
+	return append([]DebugStep(nil), d.steps...)
+}
@@ -0,0 +1,143 @@
+// Package debughttp serves a live dashboard of a librefsm machine over HTTP.
+// It lives apart from librefsm so that machines built without it do not link
+// the HTTP stack.
+package debughttp
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+//go:embed index.html
+var dashboardHTML []byte
+
+// dashboardPollInterval is how often the stream checks the machine for changes
+const dashboardPollInterval = 100 * time.Millisecond
+
+// streamMessage is a message on the dashboard's WebSocket stream
+type streamMessage struct {
+	Type     string                `json:"type"` // "snapshot" or "event"
+	Snapshot *librefsm.Snapshot    `json:"snapshot,omitempty"`
+	Event    *librefsm.EventRecord `json:"event,omitempty"`
+}
+
+// Handler serves a live dashboard for the machine, meant for bench rigs
+// and development; do not expose it on untrusted networks. Mount it under a
+// prefix with http.StripPrefix, e.g.
+//
+//	mux.Handle("/fsm/", http.StripPrefix("/fsm", debughttp.Handler(m)))
+//
+// Routes:
+//
+//	/          single-page UI rendering the Mermaid chart with the active states highlighted
+//	/snapshot  Snapshot as JSON
+//	/describe  Description as JSON
+//	/mermaid   Mermaid source
+//	/text      RenderText output
+//	/stream    WebSocket pushing a snapshot on every state or timer change and each processed event
+func Handler(m *librefsm.Machine) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && r.URL.Path != "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, m.Snapshot())
+	})
+	mux.HandleFunc("/describe", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, m.Describe())
+	})
+	mux.HandleFunc("/mermaid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(m.Describe().Mermaid()))
+	})
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		m.RenderText(w)
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return // upgradeWebSocket has responded
+		}
+		defer conn.Close()
+		stream(m, conn, r)
+	})
+	return mux
+}
+
+// stream pushes changes to a dashboard client until it disconnects
+func stream(m *librefsm.Machine, conn *wsConn, r *http.Request) {
+	send := func(msg streamMessage) bool {
+		data, err := json.Marshal(msg)
+		return err == nil && conn.WriteText(data) == nil
+	}
+
+	var lastEvent time.Time
+	if recent := m.RecentEvents(1); len(recent) > 0 {
+		lastEvent = recent[0].Received
+	}
+	lastKey := ""
+
+	ticker := time.NewTicker(dashboardPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, rec := range m.RecentEvents(0) {
+			if !rec.Received.After(lastEvent) {
+				continue
+			}
+			lastEvent = rec.Received
+			rec := rec
+			if !send(streamMessage{Type: "event", Event: &rec}) {
+				return
+			}
+		}
+
+		// The events were sent above
+		snap := m.Snapshot()
+		snap.RecentEvents = nil
+		if key := snapshotKey(snap); key != lastKey {
+			lastKey = key
+			if !send(streamMessage{Type: "snapshot", Snapshot: &snap}) {
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-conn.Done():
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// snapshotKey identifies the parts of a snapshot the dashboard highlights
+func snapshotKey(s librefsm.Snapshot) string {
+	var b strings.Builder
+	b.WriteString(string(s.State))
+	if s.LastTransition != nil {
+		b.WriteString("|" + s.LastTransition.Time.String())
+	}
+	for _, t := range s.Timers {
+		b.WriteString("|" + t.Name + "@" + t.Deadline.String())
+	}
+	return b.String()
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
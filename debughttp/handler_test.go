@@ -0,0 +1,97 @@
+package debughttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+func TestHandler(t *testing.T) {
+	def := librefsm.NewDefinition().
+		State("a").
+		State("b").
+		Transition("a", "go", "b").
+		Initial("a")
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	srv := httptest.NewServer(http.StripPrefix("/fsm", Handler(m)))
+	defer srv.Close()
+
+	for path, want := range map[string]string{
+		"/fsm/":         "<title>librefsm dashboard</title>",
+		"/fsm/mermaid":  "a --> b: go",
+		"/fsm/snapshot": `"state": "a"`,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s failed: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), want) {
+			t.Errorf("%s: expected %q, got:\n%s", path, want, body)
+		}
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET /fsm/stream HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("expected websocket handshake, got %v %v", resp, err)
+	}
+
+	readMessage := func() streamMessage {
+		t.Helper()
+		var head [2]byte
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			t.Fatalf("read frame failed: %v", err)
+		}
+		n := int(head[1] & 0x7F)
+		if n == 126 {
+			var ext [2]byte
+			io.ReadFull(br, ext[:])
+			n = int(ext[0])<<8 | int(ext[1])
+		}
+		payload := make([]byte, n)
+		io.ReadFull(br, payload)
+		var msg streamMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatalf("bad message %q: %v", payload, err)
+		}
+		return msg
+	}
+
+	if msg := readMessage(); msg.Type != "snapshot" || msg.Snapshot.State != "a" {
+		t.Fatalf("expected initial snapshot, got %+v", msg)
+	}
+	m.SendSync(librefsm.Event{ID: "go"})
+	if msg := readMessage(); msg.Type != "event" || msg.Event.ID != "go" || msg.Event.Result != "b" {
+		t.Errorf("expected event message, got %+v", msg)
+	}
+	if msg := readMessage(); msg.Type != "snapshot" || msg.Snapshot.State != "b" {
+		t.Errorf("expected snapshot after transition, got %+v", msg)
+	}
+}
@@ -0,0 +1,71 @@
+package librefsm
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupCache is a small LRU of recently processed event keys
+type dedupCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // Front is most recent
+	keys  map[string]*list.Element
+}
+
+// WithDeduplication drops events whose Key matches one of the last size
+// processed events, so re-delivered commands (e.g. from at-least-once
+// transports) are handled once. Events without a Key are never deduplicated.
+// A key is only remembered once its event was processed without error, so
+// failed events can be retried. SendSync returns nil for duplicates.
+func WithDeduplication(size int) MachineOption {
+	return func(m *Machine) {
+		if size <= 0 {
+			m.dedup = nil
+			return
+		}
+		m.dedup = &dedupCache{
+			size:  size,
+			order: list.New(),
+			keys:  make(map[string]*list.Element),
+		}
+	}
+}
+
+// seen reports whether key was recently processed, refreshing its position
+func (c *dedupCache) seen(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.keys[key]; ok {
+		c.order.MoveToFront(el)
+		return true
+	}
+	return false
+}
+
+// remember records a processed key, evicting the oldest beyond the size
+func (c *dedupCache) remember(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.keys[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.keys[key] = c.order.PushFront(key)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.keys, oldest.Value.(string))
+	}
+}
+
+// isDuplicate reports whether the event was already processed and should be skipped
+func (m *Machine) isDuplicate(event Event) bool {
+	if m.dedup == nil || event.Key == "" || !m.dedup.seen(event.Key) {
+		return false
+	}
+	m.logger.Debug("dropping duplicate event", "event", event.ID, "key", event.Key)
+	m.logEvent(event, EventDropped, "", time.Now(), 0)
+	return true
+}
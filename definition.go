@@ -1,7 +1,11 @@
 package librefsm
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
 )
 
 // Definition holds the FSM structure before building a Machine
@@ -9,6 +13,11 @@ type Definition struct {
 	states      map[StateID]*State
 	transitions []Transition
 	initial     StateID
+	initialFunc func(*Context) StateID
+	actions     map[string]func(*Context) error // Named actions, see RegisterAction
+	errs        []error                         // Errors recorded by builder methods
+	frozen      bool                            // Set by Build; running machines share the definition
+	hasQuiet    bool                            // Some transition is quiet, see WithQuiet; set by prepare
 }
 
 // NewDefinition creates a new FSM definition builder
@@ -21,133 +30,435 @@ func NewDefinition() *Definition {
 
 // State adds a normal state to the definition
 func (d *Definition) State(id StateID, opts ...StateOption) *Definition {
-	s := &State{
+	return d.addState("State", &State{
 		ID:   id,
 		Type: StateNormal,
-	}
-	for _, opt := range opts {
-		opt(s)
-	}
-	d.states[id] = s
-	return d
+	}, opts)
 }
 
 // ConditionState adds a condition pseudo-state that evaluates immediately on entry
 func (d *Definition) ConditionState(id StateID, cond func(*Context) StateID, opts ...StateOption) *Definition {
-	s := &State{
+	return d.addState("ConditionState", &State{
 		ID:        id,
 		Type:      StateCondition,
 		Condition: cond,
-	}
-	for _, opt := range opts {
-		opt(s)
-	}
-	d.states[id] = s
-	return d
+	}, opts)
 }
 
 // JunctionState adds a junction pseudo-state (like condition but entry action runs first)
 func (d *Definition) JunctionState(id StateID, cond func(*Context) StateID, opts ...StateOption) *Definition {
-	s := &State{
+	return d.addState("JunctionState", &State{
 		ID:        id,
 		Type:      StateJunction,
 		Condition: cond,
-	}
-	for _, opt := range opts {
-		opt(s)
-	}
-	d.states[id] = s
-	return d
+	}, opts)
 }
 
 // FinalState adds a terminal state with no outgoing transitions
 func (d *Definition) FinalState(id StateID, opts ...StateOption) *Definition {
-	s := &State{
+	return d.addState("FinalState", &State{
 		ID:   id,
 		Type: StateFinal,
-	}
+	}, opts)
+}
+
+// addState applies options and registers the state, recording builder errors
+// against the user's call site
+func (d *Definition) addState(call string, s *State, opts []StateOption) *Definition {
+	d.mustBeMutable(call, 3)
 	for _, opt := range opts {
 		opt(s)
 	}
-	d.states[id] = s
+	routes := s.timeoutRoutes()
+
+	if s.ID == "" {
+		d.recordError(call, 3, fmt.Errorf("empty state ID"))
+	} else if _, exists := d.states[s.ID]; exists {
+		d.recordError(call, 3, fmt.Errorf("duplicate state %q", s.ID))
+	}
+	for _, err := range s.errs {
+		d.recordError(call, 3, fmt.Errorf("state %q: %w", s.ID, err))
+	}
+	s.errs = nil
+	s.source = callerLocation(3)
+
+	d.states[s.ID] = s
+	for _, h := range s.EventHandlers {
+		d.transitions = append(d.transitions, Transition{
+			From:   s.ID,
+			Event:  h.Event,
+			To:     s.ID,
+			Kind:   TransitionInternal,
+			Action: h.Handler,
+			source: s.source,
+		})
+	}
+	for _, t := range routes {
+		t.source = s.source
+		d.transitions = append(d.transitions, t)
+	}
 	return d
 }
 
 // Transition adds a transition rule
 func (d *Definition) Transition(from StateID, event EventID, to StateID, opts ...TransitionOption) *Definition {
+	return d.addTransition("Transition", from, event, to, opts)
+}
+
+// AnyStateTransition adds a transition that can fire from any state
+func (d *Definition) AnyStateTransition(event EventID, to StateID, opts ...TransitionOption) *Definition {
+	return d.addTransition("AnyStateTransition", WildcardState, event, to, opts)
+}
+
+// AnyEventTransition adds a transition from a state that fires on any event not
+// handled by a more specific transition of the same state
+func (d *Definition) AnyEventTransition(from StateID, to StateID, opts ...TransitionOption) *Definition {
+	return d.addTransition("AnyEventTransition", from, WildcardEvent, to, opts)
+}
+
+// SelfTransition adds a transition from a state back to itself. kind must be
+// TransitionExternal (exit and re-enter, re-running actions and timers) or
+// TransitionInternal (run only the action, leaving the state untouched).
+func (d *Definition) SelfTransition(state StateID, event EventID, kind TransitionKind, opts ...TransitionOption) *Definition {
+	if kind != TransitionExternal && kind != TransitionInternal {
+		d.recordError("SelfTransition", 2, fmt.Errorf("kind must be TransitionExternal or TransitionInternal"))
+	}
+	opts = append([]TransitionOption{func(t *Transition) {
+		t.Kind = kind
+	}}, opts...)
+	return d.addTransition("SelfTransition", state, event, state, opts)
+}
+
+// SwitchTransition adds a transition whose target is chosen from the event payload.
+// selector must return one of the declared branches, or "" to not take the transition
+// (remaining transitions for the event are then tried, as with a rejected guard).
+func (d *Definition) SwitchTransition(from StateID, event EventID, selector func(payload any) StateID, branches []StateID, opts ...TransitionOption) *Definition {
+	if selector == nil {
+		d.recordError("SwitchTransition", 2, fmt.Errorf("nil selector"))
+	}
+	if len(branches) == 0 {
+		d.recordError("SwitchTransition", 2, fmt.Errorf("no branches declared"))
+	}
+	opts = append([]TransitionOption{func(t *Transition) {
+		t.Select = selector
+		t.Branches = branches
+	}}, opts...)
+	return d.addTransition("SwitchTransition", from, event, "", opts)
+}
+
+// addTransition applies options and appends the transition, recording builder
+// errors against the user's call site
+func (d *Definition) addTransition(call string, from StateID, event EventID, to StateID, opts []TransitionOption) *Definition {
 	t := Transition{
 		From:  from,
 		Event: event,
 		To:    to,
 	}
+	d.mustBeMutable(call, 3)
 	for _, opt := range opts {
 		opt(&t)
 	}
+
+	if from == "" || (to == "" && t.Select == nil) {
+		d.recordError(call, 3, fmt.Errorf("transition %q -> %q has an empty state ID", from, to))
+	}
+	if event == "" && !t.Eventless {
+		d.recordError(call, 3, fmt.Errorf("transition %q -> %q has an empty event ID", from, to))
+	}
+	if t.Eventless && from == WildcardState {
+		d.recordError(call, 3, fmt.Errorf("eventless transition -> %q cannot start from any state", to))
+	}
+	for _, err := range t.errs {
+		d.recordError(call, 3, fmt.Errorf("transition %q --%s--> %q: %w", from, event, to, err))
+	}
+	t.errs = nil
+	t.source = callerLocation(3)
+
 	d.transitions = append(d.transitions, t)
 	return d
 }
 
-// AnyStateTransition adds a transition that can fire from any state
-func (d *Definition) AnyStateTransition(event EventID, to StateID, opts ...TransitionOption) *Definition {
-	return d.Transition(WildcardState, event, to, opts...)
-}
-
 // Initial sets the initial state
 func (d *Definition) Initial(id StateID) *Definition {
+	d.mustBeMutable("Initial", 2)
+	if id == "" {
+		d.recordError("Initial", 2, fmt.Errorf("empty initial state ID"))
+	}
 	d.initial = id
 	return d
 }
 
-// Validate checks the definition for errors
+// InitialFunc sets a function choosing the initial state when the machine starts,
+// e.g. from persisted data or hardware probing. If it returns "", the state set
+// with Initial is used.
+func (d *Definition) InitialFunc(fn func(*Context) StateID) *Definition {
+	d.mustBeMutable("InitialFunc", 2)
+	if fn == nil {
+		d.recordError("InitialFunc", 2, fmt.Errorf("nil initial state function"))
+	}
+	d.initialFunc = fn
+	return d
+}
+
+// Merge adds the states, transitions and registered actions of fragment, e.g.
+// a reusable building block, together with its builder errors. The fragment's
+// initial state is ignored; enter it through a transition. Duplicate state IDs
+// and action names are builder errors.
+func (d *Definition) Merge(fragment *Definition) *Definition {
+	d.mustBeMutable("Merge", 2)
+	if fragment == nil {
+		d.recordError("Merge", 2, fmt.Errorf("nil fragment"))
+		return d
+	}
+
+	d.errs = append(d.errs, fragment.errs...)
+	for _, id := range fragment.stateIDs() {
+		if _, exists := d.states[id]; exists {
+			d.recordError("Merge", 2, fmt.Errorf("duplicate state %q", id))
+		}
+		s := *fragment.states[id]
+		d.states[id] = &s
+	}
+	d.transitions = append(d.transitions, fragment.transitions...)
+
+	names := make([]string, 0, len(fragment.actions))
+	for name := range fragment.actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if d.actions[name] != nil {
+			d.recordError("Merge", 2, fmt.Errorf("duplicate action %q", name))
+		}
+		if d.actions == nil {
+			d.actions = make(map[string]func(*Context) error)
+		}
+		d.actions[name] = fragment.actions[name]
+	}
+	return d
+}
+
+// Err returns all errors recorded by builder methods, or nil
+func (d *Definition) Err() error {
+	return errors.Join(d.errs...)
+}
+
+// recordError stores a builder error with the call site skip frames above recordError
+func (d *Definition) recordError(call string, skip int, err error) {
+	d.errs = append(d.errs, builderError(call, skip, err))
+}
+
+// builderError wraps err in a BuilderError pointing at the call site skip
+// frames above its caller
+func builderError(call string, skip int, err error) *BuilderError {
+	be := &BuilderError{Call: call, Err: err}
+	if _, file, line, ok := runtime.Caller(skip + 1); ok {
+		be.File = file
+		be.Line = line
+	}
+	return be
+}
+
+// mustBeMutable panics with a BuilderError wrapping ErrDefinitionFrozen if the
+// definition was already built, pointing at the call site skip frames above it
+func (d *Definition) mustBeMutable(call string, skip int) {
+	if !d.frozen {
+		return
+	}
+	be := &BuilderError{Call: call, Err: ErrDefinitionFrozen}
+	if _, file, line, ok := runtime.Caller(skip); ok {
+		be.File = file
+		be.Line = line
+	}
+	panic(be)
+}
+
+// sourceLocation is the builder call site that declared a state or transition
+type sourceLocation struct {
+	File string
+	Line int
+}
+
+func (l sourceLocation) String() string {
+	if l.File == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// callerLocation returns the call site skip frames above callerLocation
+func callerLocation(skip int) sourceLocation {
+	if _, file, line, ok := runtime.Caller(skip); ok {
+		return sourceLocation{File: file, Line: line}
+	}
+	return sourceLocation{}
+}
+
+// Validate checks the definition for errors and returns the first one found.
+// Use Report to get every problem at once.
 func (d *Definition) Validate() error {
-	if d.initial == "" {
-		return fmt.Errorf("no initial state defined")
+	var first error
+	d.validate(func(issue ValidationIssue) {
+		if first == nil {
+			first = issue.err
+		}
+	})
+	return first
+}
+
+// validate runs all structural checks, reporting every error found
+func (d *Definition) validate(report func(ValidationIssue)) {
+	fail := func(state StateID, loc sourceLocation, err error) {
+		report(ValidationIssue{Severity: SeverityError, State: state, Location: loc.String(), Message: err.Error(), err: err})
+	}
+
+	if d.initial == "" && d.initialFunc == nil {
+		fail("", sourceLocation{}, fmt.Errorf("no initial state defined"))
 	}
 
-	if _, ok := d.states[d.initial]; !ok {
-		return fmt.Errorf("initial state %q not defined", d.initial)
+	if _, ok := d.states[d.initial]; !ok && d.initial != "" {
+		fail(d.initial, sourceLocation{}, unknownState("initial state %q not defined", d.initial))
 	}
 
 	// Check all parent references are valid
-	for id, state := range d.states {
+	for _, id := range d.stateIDs() {
+		state := d.states[id]
 		if state.Parent != "" {
 			if _, ok := d.states[state.Parent]; !ok {
-				return fmt.Errorf("state %q references undefined parent %q", id, state.Parent)
+				fail(id, state.source, unknownState("state %q references undefined parent %q", id, state.Parent))
 			}
 		}
 		if state.DefaultChild != "" {
-			if _, ok := d.states[state.DefaultChild]; !ok {
-				return fmt.Errorf("state %q references undefined default child %q", id, state.DefaultChild)
+			child, ok := d.states[state.DefaultChild]
+			if !ok {
+				fail(id, state.source, unknownState("state %q references undefined default child %q", id, state.DefaultChild))
+			} else if child.Parent != id {
+				fail(id, state.source, fmt.Errorf("state %q default child %q is not its child", id, state.DefaultChild))
 			}
 		}
 	}
 
 	// Check all transition targets are valid
-	for _, t := range d.transitions {
+	for i := range d.transitions {
+		t := &d.transitions[i]
 		if t.From != WildcardState {
 			if _, ok := d.states[t.From]; !ok {
-				return fmt.Errorf("transition from undefined state %q", t.From)
+				fail(t.From, t.source, unknownState("transition from undefined state %q", t.From))
 			}
 		}
-		if _, ok := d.states[t.To]; !ok {
-			return fmt.Errorf("transition to undefined state %q", t.To)
+		if t.Select != nil {
+			for _, branch := range t.Branches {
+				if _, ok := d.states[branch]; !ok {
+					fail(t.From, t.source, unknownState("switch transition from %q declares undefined branch %q", t.From, branch))
+				}
+			}
+		} else if _, ok := d.states[t.To]; !ok {
+			fail(t.From, t.source, unknownState("transition to undefined state %q", t.To))
+		}
+	}
+
+	// Check for duplicate and shadowed transitions
+	d.checkShadowedTransitions(func(t *Transition, err error) {
+		fail(t.From, t.source, err)
+	})
+
+	// Check final states have no outgoing transitions
+	for i := range d.transitions {
+		t := &d.transitions[i]
+		if state, ok := d.states[t.From]; ok && state.Type == StateFinal {
+			fail(t.From, t.source, fmt.Errorf("final state %q has outgoing transition on %q", t.From, t.Event))
+		}
+	}
+	for _, id := range d.stateIDs() {
+		if state := d.states[id]; state.Type == StateFinal && state.TimeoutTarget != "" {
+			fail(id, state.source, fmt.Errorf("final state %q has a timeout transition", id))
 		}
 	}
 
 	// Check condition/junction states have conditions
-	for id, state := range d.states {
+	for _, id := range d.stateIDs() {
+		state := d.states[id]
 		if (state.Type == StateCondition || state.Type == StateJunction) && state.Condition == nil {
-			return fmt.Errorf("condition/junction state %q has no condition function", id)
+			fail(id, state.source, fmt.Errorf("condition/junction state %q has no condition function", id))
+		}
+	}
+
+	// Check declared condition targets exist and don't loop
+	for _, id := range d.stateIDs() {
+		state := d.states[id]
+		for _, target := range state.PossibleTargets {
+			if _, ok := d.states[target]; !ok {
+				fail(id, state.source, unknownState("condition/junction state %q declares undefined target %q", id, target))
+			}
+		}
+	}
+	for _, id := range d.stateIDs() {
+		if err := d.checkConditionLoop(id, nil); err != nil {
+			fail(id, d.states[id].source, err)
 		}
 	}
 
 	// Check for cycles in parent hierarchy
-	for id := range d.states {
+	for _, id := range d.stateIDs() {
 		if err := d.checkParentCycle(id); err != nil {
-			return err
+			fail(id, d.states[id].source, err)
+		}
+	}
+}
+
+// stateIDs returns the IDs of all states in sorted order
+func (d *Definition) stateIDs() []StateID {
+	ids := make([]StateID, 0, len(d.states))
+	for id := range d.states {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// checkShadowedTransitions reports transitions that can never be taken because an
+// earlier unguarded transition on the same source state and event always wins
+func (d *Definition) checkShadowedTransitions(report func(*Transition, error)) {
+	type key struct {
+		from  StateID
+		event EventID
+	}
+	unguarded := make(map[key]*Transition)
+	for i := range d.transitions {
+		t := &d.transitions[i]
+		k := key{t.From, t.Event}
+		if earlier, ok := unguarded[k]; ok {
+			if earlier.To == t.To && !t.hasGuard() {
+				report(t, fmt.Errorf("duplicate transition %q --%s--> %q", t.From, t.Event, t.To))
+			} else {
+				report(t, fmt.Errorf("transition %q --%s--> %q is shadowed by unguarded transition to %q", t.From, t.Event, t.To, earlier.To))
+			}
+			continue
+		}
+		if !t.hasGuard() && t.Select == nil {
+			unguarded[k] = t
 		}
 	}
+}
 
+// checkConditionLoop follows declared targets through condition/junction states
+// and reports a chain that leads back to a state already on the path
+func (d *Definition) checkConditionLoop(id StateID, path []StateID) error {
+	state := d.states[id]
+	if state == nil || (state.Type != StateCondition && state.Type != StateJunction) {
+		return nil
+	}
+	for _, p := range path {
+		if p == id {
+			return fmt.Errorf("condition loop detected: %v", append(path, id))
+		}
+	}
+	path = append(path, id)
+	for _, target := range state.PossibleTargets {
+		if err := d.checkConditionLoop(target, path); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -168,18 +479,66 @@ func (d *Definition) checkParentCycle(id StateID) error {
 	return nil
 }
 
-// Build creates a Machine from the definition
+// Build creates a Machine from the definition. The definition is frozen
+// afterwards: further builder calls panic with ErrDefinitionFrozen. A
+// definition may be built into several machines.
 func (d *Definition) Build(opts ...MachineOption) (*Machine, error) {
-	if err := d.Validate(); err != nil {
+	if err := d.prepare(); err != nil {
+		return nil, err
+	}
+
+	m := &Machine{
+		currentState:      "",
+		queue:             newEventQueue(defaultQueueSize),
+		timers:            make(map[string]*timerEntry),
+		logger:            Logger,
+		eventLog:          eventLog{size: defaultEventLogSize},
+		maxChainDepth:     defaultMaxChainDepth,
+		actionCancelGrace: defaultActionCancelGrace,
+		traceLevel:        slog.LevelDebug,
+		workers:           newWorkerPool(WorkerPoolOptions{}),
+		rand:              newSeededRand(defaultSeed()),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	if len(m.optErrs) > 0 {
+		return nil, fmt.Errorf("invalid option: %w", m.optErrs[0])
+	}
+
+	if err := m.checkActionNames(d); err != nil {
 		return nil, fmt.Errorf("invalid definition: %w", err)
 	}
+	if _, ok := d.states[m.shutdownState]; m.shutdownState != "" && !ok {
+		return nil, unknownState("shutdown state %q not defined", m.shutdownState)
+	}
+	d.frozen = true
+	m.setDefinition(d)
+
+	return m, nil
+}
+
+// prepare validates the definition and adds auto-generated transitions.
+// Preparing a frozen definition is a no-op.
+func (d *Definition) prepare() error {
+	if d.frozen {
+		return nil
+	}
+	if len(d.errs) > 0 {
+		return fmt.Errorf("invalid definition: %w", d.errs[0])
+	}
+
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("invalid definition: %w", err)
+	}
 
 	// Auto-create transitions for states with TimeoutTarget
 	for id, state := range d.states {
-		if state.TimeoutTarget != "" {
+		if state.TimeoutTarget != "" && !d.hasTransition(id, state.TimeoutEvent, state.TimeoutTarget) {
 			// Verify target state exists
 			if _, ok := d.states[state.TimeoutTarget]; !ok {
-				return nil, fmt.Errorf("state %q timeout target %q not defined", id, state.TimeoutTarget)
+				return unknownState("state %q timeout target %q not defined", id, state.TimeoutTarget)
 			}
 			// Add automatic transition
 			d.transitions = append(d.transitions, Transition{
@@ -190,33 +549,20 @@ func (d *Definition) Build(opts ...MachineOption) (*Machine, error) {
 		}
 	}
 
-	m := &Machine{
-		definition:   d,
-		currentState: "",
-		events:       make(chan Event, 100),
-		timers:       make(map[string]*timerEntry),
-		logger:       Logger,
-	}
-
-	for _, opt := range opts {
-		opt(m)
+	for i := range d.transitions {
+		d.hasQuiet = d.hasQuiet || d.transitions[i].Quiet
 	}
+	return nil
+}
 
-	// Build parent-child relationships
-	m.children = make(map[StateID][]StateID)
-	for id, state := range d.states {
-		if state.Parent != "" {
-			m.children[state.Parent] = append(m.children[state.Parent], id)
+// hasTransition reports whether an identical unguarded transition already exists
+func (d *Definition) hasTransition(from StateID, event EventID, to StateID) bool {
+	for _, t := range d.transitions {
+		if t.From == from && t.Event == event && t.To == to && !t.hasGuard() {
+			return true
 		}
 	}
-
-	// Compute depth for each state
-	m.depth = make(map[StateID]int)
-	for id := range d.states {
-		m.depth[id] = d.computeDepth(id)
-	}
-
-	return m, nil
+	return false
 }
 
 func (d *Definition) computeDepth(id StateID) int {
@@ -0,0 +1,57 @@
+package librefsm
+
+import (
+	"fmt"
+	"time"
+)
+
+// delayTimerName is the timer backing the pending delayed transition
+const delayTimerName = "_delayed_transition"
+
+// delayedTransition is a matched transition waiting for its delay to pass
+type delayedTransition struct {
+	t      *Transition
+	target StateID
+	event  Event
+}
+
+// WithDelay postpones the state change by d after the transition matched, e.g.
+// "blinker off 5s after the signal is released". The pending transition is
+// cancelled if any other transition changes state first, and restarted if the
+// same transition matches again. Guards are evaluated when the event arrives.
+func WithDelay(d time.Duration) TransitionOption {
+	return func(t *Transition) {
+		if d <= 0 {
+			t.errs = append(t.errs, fmt.Errorf("WithDelay: duration must be positive, got %v", d))
+		}
+		t.Delay = d
+	}
+}
+
+// scheduleDelayed arms the delay timer for a matched transition, replacing any pending one
+func (m *Machine) scheduleDelayed(t *Transition, target StateID, event Event) {
+	d := &delayedTransition{t: t, target: target, event: event}
+	m.pendingDelay = d
+	m.trace("transition delayed", "event", event.ID, "from", t.From, "to", target, "delay", t.Delay)
+	m.startTimerInternal(delayTimerName, t.Delay, Event{ID: eventDelayed, Payload: d}, TimerScopeGlobal, "")
+}
+
+// fireDelayed executes the pending delayed transition, ignoring stale timer events
+func (m *Machine) fireDelayed(event Event) error {
+	d, _ := event.Payload.(*delayedTransition)
+	if d == nil || d != m.pendingDelay {
+		return nil
+	}
+	m.pendingDelay = nil
+	return m.runTransition(d.t, d.target, &d.event)
+}
+
+// cancelDelayed drops the pending delayed transition, if any
+func (m *Machine) cancelDelayed() {
+	if m.pendingDelay == nil {
+		return
+	}
+	m.trace("delayed transition cancelled", "event", m.pendingDelay.event.ID, "to", m.pendingDelay.target)
+	m.pendingDelay = nil
+	m.StopTimer(delayTimerName)
+}
@@ -0,0 +1,195 @@
+package librefsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Description is a serializable model of a definition for external tooling
+// such as UIs and documentation generators.
+type Description struct {
+	Initial     StateID                 `json:"initial,omitempty"`
+	States      []StateDescription      `json:"states"`
+	Transitions []TransitionDescription `json:"transitions"`
+}
+
+// StateDescription describes a single state
+type StateDescription struct {
+	ID              StateID                   `json:"id"`
+	Parent          StateID                   `json:"parent,omitempty"`
+	Type            string                    `json:"type"`
+	DefaultChild    StateID                   `json:"default_child,omitempty"`
+	Children        []StateID                 `json:"children,omitempty"`
+	HasEntry        bool                      `json:"has_entry,omitempty"`
+	HasExit         bool                      `json:"has_exit,omitempty"`
+	EntryAction     string                    `json:"entry_action,omitempty"` // Registered action name
+	ExitAction      string                    `json:"exit_action,omitempty"`  // Registered action name
+	Timeout         string                    `json:"timeout,omitempty"`
+	TimeoutEvent    EventID                   `json:"timeout_event,omitempty"`
+	TimeoutTarget   StateID                   `json:"timeout_target,omitempty"`
+	TimeoutStages   []TimeoutStageDescription `json:"timeout_stages,omitempty"`
+	Timers          []string                  `json:"timers,omitempty"`
+	PossibleTargets []StateID                 `json:"possible_targets,omitempty"`
+	Layout          *Layout                   `json:"layout,omitempty"`
+	Transitional    bool                      `json:"transitional,omitempty"`
+	MinimumDwell    string                    `json:"minimum_dwell,omitempty"`
+	PollInterval    string                    `json:"poll_interval,omitempty"`
+}
+
+// TimeoutStageDescription describes a timeout stage
+type TimeoutStageDescription struct {
+	After string  `json:"after"`
+	Event EventID `json:"event"`
+}
+
+// TransitionDescription describes a single transition.
+// Unnamed guards are reported as "<anonymous>".
+type TransitionDescription struct {
+	From      StateID   `json:"from"`
+	Event     EventID   `json:"event,omitempty"`
+	To        StateID   `json:"to,omitempty"`
+	Branches  []StateID `json:"branches,omitempty"`
+	Kind      string    `json:"kind"`
+	Guard     string    `json:"guard,omitempty"`
+	Label     string    `json:"label,omitempty"`
+	HasAction bool      `json:"has_action,omitempty"`
+	Action    string    `json:"action,omitempty"` // Registered action name
+	Eventless bool      `json:"eventless,omitempty"`
+	Delay     string    `json:"delay,omitempty"`
+	Priority  int       `json:"priority,omitempty"`
+	Layout    *Layout   `json:"layout,omitempty"`
+
+	// Guard re-check interval and timeout, see WithWaitFor
+	WaitInterval     string  `json:"wait_interval,omitempty"`
+	WaitTimeout      string  `json:"wait_timeout,omitempty"`
+	WaitTimeoutEvent EventID `json:"wait_timeout_event,omitempty"`
+}
+
+func (t StateType) String() string {
+	switch t {
+	case StateNormal:
+		return "normal"
+	case StateCondition:
+		return "condition"
+	case StateJunction:
+		return "junction"
+	case StateFinal:
+		return "final"
+	default:
+		return fmt.Sprintf("StateType(%d)", int(t))
+	}
+}
+
+func (k TransitionKind) String() string {
+	switch k {
+	case TransitionNormal:
+		return "normal"
+	case TransitionExternal:
+		return "external"
+	case TransitionInternal:
+		return "internal"
+	default:
+		return fmt.Sprintf("TransitionKind(%d)", int(k))
+	}
+}
+
+// Describe returns a model of the machine's definition
+func (m *Machine) Describe() Description {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.definition.Describe()
+}
+
+// Describe returns a model of the definition. States are sorted by ID,
+// transitions keep their declaration (priority) order.
+func (d *Definition) Describe() Description {
+	desc := Description{
+		Initial:     d.initial,
+		States:      make([]StateDescription, 0, len(d.states)),
+		Transitions: make([]TransitionDescription, 0, len(d.transitions)),
+	}
+
+	children := make(map[StateID][]StateID)
+	for id, s := range d.states {
+		if s.Parent != "" {
+			children[s.Parent] = append(children[s.Parent], id)
+		}
+	}
+
+	for id, s := range d.states {
+		sd := StateDescription{
+			ID:              id,
+			Parent:          s.Parent,
+			Type:            s.Type.String(),
+			DefaultChild:    s.DefaultChild,
+			Children:        sortedStates(children[id]),
+			HasEntry:        s.OnEnter != nil || s.OnEnterName != "",
+			HasExit:         s.OnExit != nil || s.OnExitName != "",
+			EntryAction:     s.OnEnterName,
+			ExitAction:      s.OnExitName,
+			TimeoutEvent:    s.TimeoutEvent,
+			TimeoutTarget:   s.TimeoutTarget,
+			Timers:          append([]string(nil), s.DeclaredTimers...),
+			PossibleTargets: append([]StateID(nil), s.PossibleTargets...),
+			Layout:          s.Layout.orNil(),
+			Transitional:    s.Transitional,
+		}
+		if s.Timeout > 0 {
+			sd.Timeout = s.Timeout.String()
+		}
+		for _, stage := range s.TimeoutStages {
+			sd.TimeoutStages = append(sd.TimeoutStages, TimeoutStageDescription{After: stage.After.String(), Event: stage.Event})
+		}
+		if s.PollInterval > 0 {
+			sd.PollInterval = s.PollInterval.String()
+		}
+		if s.MinimumDwell > 0 {
+			sd.MinimumDwell = s.MinimumDwell.String()
+		}
+		desc.States = append(desc.States, sd)
+	}
+	sort.Slice(desc.States, func(i, j int) bool { return desc.States[i].ID < desc.States[j].ID })
+
+	for i := range d.transitions {
+		t := &d.transitions[i]
+		td := TransitionDescription{
+			From:      t.From,
+			Event:     t.Event,
+			To:        t.To,
+			Branches:  append([]StateID(nil), t.Branches...),
+			Kind:      t.Kind.String(),
+			Label:     t.Label,
+			HasAction: t.Action != nil || t.ActionName != "",
+			Action:    t.ActionName,
+			Eventless: t.Eventless,
+			Priority:  t.Priority,
+			Layout:    t.Layout.orNil(),
+		}
+		if t.Delay > 0 {
+			td.Delay = t.Delay.String()
+		}
+		if t.WaitInterval > 0 {
+			td.WaitInterval = t.WaitInterval.String()
+			td.WaitTimeoutEvent = t.WaitTimeoutEvent
+		}
+		if t.WaitTimeout > 0 {
+			td.WaitTimeout = t.WaitTimeout.String()
+		}
+		if t.hasGuard() {
+			td.Guard = t.guardLabel()
+		}
+		desc.Transitions = append(desc.Transitions, td)
+	}
+
+	return desc
+}
+
+// sortedStates returns a sorted copy of the given state IDs
+func sortedStates(ids []StateID) []StateID {
+	if len(ids) == 0 {
+		return nil
+	}
+	out := append([]StateID(nil), ids...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
@@ -0,0 +1,177 @@
+package librefsm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Changes lists the differences between two definitions
+type Changes struct {
+	Initial             *InitialChange          `json:"initial,omitempty"`
+	AddedStates         []StateID               `json:"added_states,omitempty"`
+	RemovedStates       []StateID               `json:"removed_states,omitempty"`
+	ModifiedStates      []StateChange           `json:"modified_states,omitempty"`
+	AddedTransitions    []TransitionDescription `json:"added_transitions,omitempty"`
+	RemovedTransitions  []TransitionDescription `json:"removed_transitions,omitempty"`
+	ModifiedTransitions []TransitionChange      `json:"modified_transitions,omitempty"`
+}
+
+// InitialChange records a changed initial state
+type InitialChange struct {
+	Before StateID `json:"before"`
+	After  StateID `json:"after"`
+}
+
+// StateChange describes a state present in both definitions whose description differs
+type StateChange struct {
+	ID     StateID          `json:"id"`
+	Fields []string         `json:"fields"` // Changed description fields, by JSON name
+	Before StateDescription `json:"before"`
+	After  StateDescription `json:"after"`
+}
+
+// TransitionChange describes a transition present in both definitions whose description differs.
+// Transitions are matched by source, event and position among transitions sharing both.
+type TransitionChange struct {
+	Fields []string              `json:"fields"` // Changed description fields, by JSON name
+	Before TransitionDescription `json:"before"`
+	After  TransitionDescription `json:"after"`
+}
+
+// Diff compares two definitions, reporting what b adds, removes and modifies relative to a
+func Diff(a, b *Definition) Changes {
+	da, db := a.Describe(), b.Describe()
+	var c Changes
+
+	if da.Initial != db.Initial {
+		c.Initial = &InitialChange{Before: da.Initial, After: db.Initial}
+	}
+
+	before := make(map[StateID]StateDescription, len(da.States))
+	for _, s := range da.States {
+		before[s.ID] = s
+	}
+	after := make(map[StateID]bool, len(db.States))
+	for _, s := range db.States {
+		after[s.ID] = true
+		old, ok := before[s.ID]
+		if !ok {
+			c.AddedStates = append(c.AddedStates, s.ID)
+			continue
+		}
+		if fields := changedFields(old, s); len(fields) > 0 {
+			c.ModifiedStates = append(c.ModifiedStates, StateChange{ID: s.ID, Fields: fields, Before: old, After: s})
+		}
+	}
+	for _, s := range da.States {
+		if !after[s.ID] {
+			c.RemovedStates = append(c.RemovedStates, s.ID)
+		}
+	}
+
+	oldTransitions := keyTransitions(da.Transitions)
+	newKeys := make(map[string]bool, len(db.Transitions))
+	for _, kt := range keyTransitions(db.Transitions) {
+		newKeys[kt.key] = true
+		old, ok := findKeyed(oldTransitions, kt.key)
+		if !ok {
+			c.AddedTransitions = append(c.AddedTransitions, kt.desc)
+			continue
+		}
+		if fields := changedFields(old, kt.desc); len(fields) > 0 {
+			c.ModifiedTransitions = append(c.ModifiedTransitions, TransitionChange{Fields: fields, Before: old, After: kt.desc})
+		}
+	}
+	for _, kt := range oldTransitions {
+		if !newKeys[kt.key] {
+			c.RemovedTransitions = append(c.RemovedTransitions, kt.desc)
+		}
+	}
+
+	return c
+}
+
+// Empty reports whether the definitions were equivalent
+func (c Changes) Empty() bool {
+	return c.Initial == nil &&
+		len(c.AddedStates) == 0 && len(c.RemovedStates) == 0 && len(c.ModifiedStates) == 0 &&
+		len(c.AddedTransitions) == 0 && len(c.RemovedTransitions) == 0 && len(c.ModifiedTransitions) == 0
+}
+
+// String renders the changes one per line, suitable for release notes
+func (c Changes) String() string {
+	var b strings.Builder
+	if c.Initial != nil {
+		fmt.Fprintf(&b, "~ initial: %s -> %s\n", c.Initial.Before, c.Initial.After)
+	}
+	for _, id := range c.AddedStates {
+		fmt.Fprintf(&b, "+ state %s\n", id)
+	}
+	for _, id := range c.RemovedStates {
+		fmt.Fprintf(&b, "- state %s\n", id)
+	}
+	for _, sc := range c.ModifiedStates {
+		fmt.Fprintf(&b, "~ state %s: %s\n", sc.ID, strings.Join(sc.Fields, ", "))
+	}
+	for _, t := range c.AddedTransitions {
+		fmt.Fprintf(&b, "+ transition %s\n", transitionLabel(t))
+	}
+	for _, t := range c.RemovedTransitions {
+		fmt.Fprintf(&b, "- transition %s\n", transitionLabel(t))
+	}
+	for _, tc := range c.ModifiedTransitions {
+		fmt.Fprintf(&b, "~ transition %s: %s\n", transitionLabel(tc.Before), strings.Join(tc.Fields, ", "))
+	}
+	return b.String()
+}
+
+// keyedTransition pairs a transition description with its matching key
+type keyedTransition struct {
+	key  string
+	desc TransitionDescription
+}
+
+// keyTransitions keys transitions by source, event and occurrence
+func keyTransitions(ts []TransitionDescription) []keyedTransition {
+	seen := make(map[string]int)
+	out := make([]keyedTransition, len(ts))
+	for i, t := range ts {
+		base := fmt.Sprintf("%s|%s|%t", t.From, t.Event, t.Eventless)
+		out[i] = keyedTransition{key: fmt.Sprintf("%s|%d", base, seen[base]), desc: t}
+		seen[base]++
+	}
+	return out
+}
+
+func findKeyed(ts []keyedTransition, key string) (TransitionDescription, bool) {
+	for _, kt := range ts {
+		if kt.key == key {
+			return kt.desc, true
+		}
+	}
+	return TransitionDescription{}, false
+}
+
+// transitionLabel renders a transition as "from --event--> to"
+func transitionLabel(t TransitionDescription) string {
+	event := string(t.Event)
+	if t.Eventless {
+		event = "(eventless)"
+	}
+	return fmt.Sprintf("%s --%s--> %s", t.From, event, t.To)
+}
+
+// changedFields returns the JSON names of the struct fields that differ between a and b
+func changedFields(a, b any) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var fields []string
+	for i := 0; i < va.NumField(); i++ {
+		if reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(va.Type().Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}
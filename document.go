@@ -0,0 +1,172 @@
+package librefsm
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//go:embed definition.schema.json
+var documentSchema []byte
+
+// DocumentSchema returns the JSON Schema describing the declarative document
+// format accepted by ParseDocument, for use in editors and CI pipelines.
+func DocumentSchema() []byte {
+	return bytes.Clone(documentSchema)
+}
+
+// Document is the declarative (JSON) form of a definition. Guards and
+// conditions are code and cannot be expressed in a document; add them to the
+// parsed Definition before building. Actions are referenced by name and must
+// be registered with RegisterAction or WithActionRegistry.
+type Document struct {
+	Initial     StateID              `json:"initial"`
+	States      []DocumentState      `json:"states"`
+	Transitions []DocumentTransition `json:"transitions,omitempty"`
+}
+
+// DocumentState is a state entry in a Document
+type DocumentState struct {
+	ID            StateID `json:"id"`
+	Parent        StateID `json:"parent,omitempty"`
+	Type          string  `json:"type,omitempty"` // "normal" (default) or "final"
+	DefaultChild  StateID `json:"default_child,omitempty"`
+	Timeout       string  `json:"timeout,omitempty"` // Positive Go duration string without sign
+	TimeoutEvent  EventID `json:"timeout_event,omitempty"`
+	TimeoutTarget StateID `json:"timeout_target,omitempty"`
+	OnEnter       string  `json:"on_enter,omitempty"` // Registered action name
+	OnExit        string  `json:"on_exit,omitempty"`  // Registered action name
+	Layout        *Layout `json:"layout,omitempty"`
+}
+
+// DocumentTransition is a transition entry in a Document
+type DocumentTransition struct {
+	From   StateID `json:"from"`
+	Event  EventID `json:"event"`
+	To     StateID `json:"to"`
+	Label  string  `json:"label,omitempty"`
+	Action string  `json:"action,omitempty"` // Registered action name
+	Layout *Layout `json:"layout,omitempty"`
+}
+
+// ParseDocument decodes a JSON statechart document into a Definition.
+// Unknown fields are rejected. The result is not validated; call Validate or
+// Build once guards and actions have been attached.
+func ParseDocument(data []byte) (*Definition, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var doc Document
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode document: %w", err)
+	}
+
+	return doc.Definition()
+}
+
+// ValidateDocument checks that a JSON statechart document decodes into a
+// valid definition, without building a machine: unknown fields, missing or
+// malformed values and the definition's structural validation are reported.
+// It does not run a JSON Schema validator; DocumentSchema describes the same
+// format for editors and other tooling.
+func ValidateDocument(data []byte) error {
+	def, err := ParseDocument(data)
+	if err != nil {
+		return err
+	}
+	return def.Validate()
+}
+
+// Definition converts the document into a Definition
+func (doc *Document) Definition() (*Definition, error) {
+	if doc.Initial == "" {
+		return nil, errors.New("document: initial state is required")
+	}
+	if len(doc.States) == 0 {
+		return nil, errors.New("document: at least one state is required")
+	}
+
+	def := NewDefinition()
+
+	for i, s := range doc.States {
+		if s.ID == "" {
+			return nil, fmt.Errorf("document: states[%d]: id is required", i)
+		}
+
+		var opts []StateOption
+		if s.Parent != "" {
+			opts = append(opts, WithParent(s.Parent))
+		}
+		if s.DefaultChild != "" {
+			opts = append(opts, WithDefaultChild(s.DefaultChild))
+		}
+		if s.OnEnter != "" {
+			opts = append(opts, WithNamedOnEnter(s.OnEnter))
+		}
+		if s.OnExit != "" {
+			opts = append(opts, WithNamedOnExit(s.OnExit))
+		}
+		if s.Layout != nil {
+			opts = append(opts, WithLayout(*s.Layout))
+		}
+
+		if s.Timeout != "" {
+			d, err := time.ParseDuration(s.Timeout)
+			if err == nil && (d <= 0 || strings.ContainsAny(s.Timeout[:1], "+-")) {
+				err = fmt.Errorf("%q is not a positive duration", s.Timeout)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("document: state %q: invalid timeout: %w", s.ID, err)
+			}
+			switch {
+			case s.TimeoutEvent != "" && s.TimeoutTarget != "":
+				return nil, fmt.Errorf("document: state %q: timeout_event and timeout_target are mutually exclusive", s.ID)
+			case s.TimeoutTarget != "":
+				opts = append(opts, WithTimeoutTransition(d, s.TimeoutTarget))
+			case s.TimeoutEvent != "":
+				opts = append(opts, WithTimeout(d, s.TimeoutEvent))
+			default:
+				return nil, fmt.Errorf("document: state %q: timeout requires timeout_event or timeout_target", s.ID)
+			}
+		} else if s.TimeoutEvent != "" || s.TimeoutTarget != "" {
+			return nil, fmt.Errorf("document: state %q: timeout_event/timeout_target require timeout", s.ID)
+		}
+
+		switch s.Type {
+		case "", "normal":
+			def.State(s.ID, opts...)
+		case "final":
+			def.FinalState(s.ID, opts...)
+		default:
+			return nil, fmt.Errorf("document: state %q: unknown type %q", s.ID, s.Type)
+		}
+	}
+
+	for i, t := range doc.Transitions {
+		if t.From == "" || t.Event == "" || t.To == "" {
+			return nil, fmt.Errorf("document: transitions[%d]: from, event and to are required", i)
+		}
+		var opts []TransitionOption
+		if t.Label != "" {
+			opts = append(opts, WithLabel(t.Label))
+		}
+		if t.Action != "" {
+			opts = append(opts, WithNamedAction(t.Action))
+		}
+		if t.Layout != nil {
+			opts = append(opts, WithEdgeLayout(*t.Layout))
+		}
+		def.Transition(t.From, t.Event, t.To, opts...)
+	}
+
+	def.Initial(doc.Initial)
+
+	if err := def.Err(); err != nil {
+		return nil, fmt.Errorf("document: %w", err)
+	}
+	return def, nil
+}
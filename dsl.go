@@ -0,0 +1,103 @@
+package librefsm
+
+// TransitionBuilder declares a transition fluently:
+//
+//	def.From(stateParked).On(evGoToDrive).If(kickstandUp).Do(startMotor).To(stateDrive)
+//
+// It produces the same Transition as Definition.Transition.
+type TransitionBuilder struct {
+	def     *Definition
+	from    StateID
+	event   EventID
+	guards  []func(*GuardContext) bool
+	actions []func(*Context) error
+	opts    []TransitionOption
+}
+
+// From starts a fluent transition declaration from the given state
+func (d *Definition) From(from StateID) *TransitionBuilder {
+	return &TransitionBuilder{def: d, from: from}
+}
+
+// On sets the triggering event
+func (b *TransitionBuilder) On(event EventID) *TransitionBuilder {
+	b.event = event
+	return b
+}
+
+// If adds a guard. Multiple guards must ALL pass.
+func (b *TransitionBuilder) If(guard func(*GuardContext) bool) *TransitionBuilder {
+	b.guards = append(b.guards, guard)
+	return b
+}
+
+// Do adds an action. Multiple actions run in order; the first error aborts the rest.
+func (b *TransitionBuilder) Do(action func(*Context) error) *TransitionBuilder {
+	b.actions = append(b.actions, action)
+	return b
+}
+
+// With applies additional transition options
+func (b *TransitionBuilder) With(opts ...TransitionOption) *TransitionBuilder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// To sets the target state, adds the transition and returns the definition for further chaining
+func (b *TransitionBuilder) To(to StateID) *Definition {
+	var opts []TransitionOption
+	switch len(b.guards) {
+	case 0:
+	case 1:
+		opts = append(opts, WithGuard(b.guards[0]))
+	default:
+		opts = append(opts, WithGuards(b.guards...))
+	}
+
+	switch len(b.actions) {
+	case 0:
+	case 1:
+		opts = append(opts, WithAction(b.actions[0]))
+	default:
+		actions := b.actions
+		opts = append(opts, WithAction(func(c *Context) error {
+			for _, action := range actions {
+				if err := action(c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+
+	opts = append(opts, b.opts...)
+	return b.def.addTransition("From/On/To", b.from, b.event, to, opts)
+}
+
+// TransitionSpec declares a transition as data, for use with Definition.Transitions
+type TransitionSpec struct {
+	From   StateID
+	Event  EventID
+	To     StateID
+	Guard  func(*GuardContext) bool // Optional
+	Action func(*Context) error     // Optional
+	Label  string                   // Optional
+}
+
+// Transitions adds a table of transitions in order, as if each were declared with Transition
+func (d *Definition) Transitions(specs []TransitionSpec) *Definition {
+	for _, spec := range specs {
+		var opts []TransitionOption
+		if spec.Guard != nil {
+			opts = append(opts, WithGuard(spec.Guard))
+		}
+		if spec.Action != nil {
+			opts = append(opts, WithAction(spec.Action))
+		}
+		if spec.Label != "" {
+			opts = append(opts, WithLabel(spec.Label))
+		}
+		d.addTransition("Transitions", spec.From, spec.Event, spec.To, opts)
+	}
+	return d
+}
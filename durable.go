@@ -0,0 +1,98 @@
+package librefsm
+
+import (
+	"fmt"
+	"sync"
+)
+
+// EventPersister stores events that were accepted but not yet processed, so a
+// machine can pick them up again after a crash or power loss. Payloads must be
+// serializable by the implementation. They are passed as sent, not redacted by
+// WithPayloadRedactor, since they are delivered again; an implementation
+// storing sensitive payloads should encrypt them.
+type EventPersister interface {
+	// SavePending replaces the stored pending events, in processing order
+	SavePending(events []Event) error
+	// LoadPending returns the events stored by a previous run
+	LoadPending() ([]Event, error)
+}
+
+// durableQueue persists the queue's pending events through an EventPersister
+type durableQueue struct {
+	mu        sync.Mutex // Serializes snapshots so saves land in order
+	persister EventPersister
+}
+
+// WithDurableQueue persists pending events through p. The stored set is
+// replaced whenever an event is queued or finishes processing; an event stays
+// stored while it is processed, so delivery is at-least-once. On Start,
+// stored events are queued again after the initial state is entered.
+// Timer and internal events are not persisted. Events still queued on Stop
+// stay stored unless a later WithStopPolicy says otherwise.
+func WithDurableQueue(p EventPersister) MachineOption {
+	return func(m *Machine) {
+		m.durable = &durableQueue{persister: p}
+		m.stopFate = QueuePersist
+	}
+}
+
+// restorePending queues events left over from a previous run
+func (m *Machine) restorePending() error {
+	if m.durable == nil {
+		return nil
+	}
+	events, err := m.durable.persister.LoadPending()
+	if err != nil {
+		return fmt.Errorf("load pending events: %w", err)
+	}
+	if len(events) > 0 {
+		m.logger.Info("restoring pending events", "count", len(events))
+	}
+	for _, event := range events {
+		m.enqueue(event)
+	}
+	return nil
+}
+
+// persistPending saves the current set of pending events
+func (m *Machine) persistPending() {
+	if m.durable == nil {
+		return
+	}
+	m.durable.mu.Lock()
+	defer m.durable.mu.Unlock()
+	if err := m.durable.persister.SavePending(append(m.parkedEvents(), m.queue.pending()...)); err != nil {
+		m.logger.Error("failed to persist pending events", "error", err)
+	}
+}
+
+// pending returns the in-flight and queued events worth persisting, in processing order
+func (q *eventQueue) pending() []Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var events []Event
+	add := func(qe *queuedEvent) {
+		if qe.timer {
+			return
+		}
+		batch := qe.batch
+		if batch == nil {
+			batch = []Event{qe.event}
+		}
+		for _, event := range batch {
+			if !isInternalEvent(event.ID) {
+				events = append(events, event)
+			}
+		}
+	}
+	if q.inflight != nil {
+		add(q.inflight)
+	}
+	for _, lane := range [][]*queuedEvent{q.control, q.timers, q.items} {
+		for _, qe := range lane {
+			add(qe)
+		}
+	}
+	return events
+}
@@ -0,0 +1,67 @@
+package librefsm
+
+import "time"
+
+// WithMinimumDwell defers transitions out of the state until it has been
+// active for at least d, e.g. to keep a sensor sitting on a threshold from
+// flipping between park and drive. Events that would leave the state are
+// parked meanwhile and delivered in order once d has passed; internal
+// transitions run immediately. The parked buffer is shared with
+// WithDeferUntilStable and uses its limit and overflow policy.
+func WithMinimumDwell(d time.Duration) StateOption {
+	return func(s *State) {
+		s.MinimumDwell = d
+	}
+}
+
+// dwellRemaining returns how long the active states with a minimum dwell time
+// still hold on to the machine. Callers hold mu.
+func (m *Machine) dwellRemaining() time.Duration {
+	var wait time.Duration
+	for _, id := range m.activeChain() {
+		s := m.definition.states[id]
+		if s == nil || s.MinimumDwell <= 0 {
+			continue
+		}
+		if left := s.MinimumDwell - time.Since(m.stateEnteredAt[id]); left > wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// leavesState reports whether an event matches a transition from an active
+// state that is not internal. Callers hold mu.
+func (m *Machine) leavesState(id EventID) bool {
+	active := make(map[StateID]bool)
+	for _, s := range m.activeChain() {
+		active[s] = true
+	}
+	for i := range m.definition.transitions {
+		t := &m.definition.transitions[i]
+		if (active[t.From] || t.From == WildcardState) && !t.Eventless && eventMatches(t.Event, id) && t.Kind != TransitionInternal {
+			return true
+		}
+	}
+	return false
+}
+
+// wakeAfterDwell makes the event loop retry parked events once the dwell time has passed
+func (m *Machine) wakeAfterDwell(wait time.Duration) {
+	m.dwellMu.Lock()
+	defer m.dwellMu.Unlock()
+	if m.dwellTimer != nil {
+		m.dwellTimer.Stop()
+	}
+	m.dwellTimer = time.AfterFunc(wait, m.wakeLoop)
+}
+
+// stopDwellTimer cancels a pending dwell wake-up
+func (m *Machine) stopDwellTimer() {
+	m.dwellMu.Lock()
+	defer m.dwellMu.Unlock()
+	if m.dwellTimer != nil {
+		m.dwellTimer.Stop()
+		m.dwellTimer = nil
+	}
+}
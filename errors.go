@@ -0,0 +1,129 @@
+package librefsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoTransition is returned in strict mode when an event matches no transition
+var ErrNoTransition = errors.New("no transition for event")
+
+// ErrGuardRejected is returned in strict mode, together with ErrNoTransition,
+// when transitions matched the event but all their guards rejected it
+var ErrGuardRejected = errors.New("guard rejected transition")
+
+// errGuardsRejected matches both ErrNoTransition and ErrGuardRejected
+var errGuardsRejected = fmt.Errorf("%w: %w", ErrNoTransition, ErrGuardRejected)
+
+// ErrUnknownState is matched by errors about a state ID that is not defined
+var ErrUnknownState = errors.New("unknown state")
+
+// ErrQueueFull is returned by SendSync when the event could not be queued
+var ErrQueueFull = errors.New("event queue full")
+
+// ErrActionFailed is matched by every ActionError
+var ErrActionFailed = errors.New("action failed")
+
+// ErrActionTimeout is returned when an action overruns its timeout under ActionTimeoutFail
+var ErrActionTimeout = errors.New("action timed out")
+
+// ErrChainDepthExceeded is returned when condition states or default children
+// keep redirecting entry beyond the maximum chain depth
+var ErrChainDepthExceeded = errors.New("state entry chain too deep")
+
+// ErrExitDeadline is reported when exit actions were skipped because a transition's exit deadline passed
+var ErrExitDeadline = errors.New("exit deadline exceeded")
+
+// ErrActionInterrupted is returned when an action was cancelled by an interrupt event
+var ErrActionInterrupted = errors.New("action interrupted")
+
+// ErrWorkerPoolFull is the error of a job rejected because too many jobs were
+// waiting for a worker, see WorkerPoolOptions.QueueLimit
+var ErrWorkerPoolFull = errors.New("worker pool queue full")
+
+// ErrJobPanicked is matched by the error of a job that panicked
+var ErrJobPanicked = errors.New("job panicked")
+
+// ErrRateLimited is returned by SendSync when its event was dropped because
+// the transition exceeded its rate limit, see WithRateLimit
+var ErrRateLimited = errors.New("transition rate limited")
+
+// ErrMachineStopped is returned when an event or command cannot be accepted
+// because the machine has stopped
+var ErrMachineStopped = errors.New("machine stopped")
+
+// ErrNotStarted is returned by commands that need the event loop, such as
+// Reset, before Start
+var ErrNotStarted = errors.New("machine not started")
+
+// ErrEventDiscarded is returned by SendSync when its queued event was discarded
+// by Stop or Reset before being processed
+var ErrEventDiscarded = errors.New("event discarded")
+
+// ErrPayloadMismatch is returned when an event's payload does not have the type
+// registered with RegisterEvent
+var ErrPayloadMismatch = errors.New("event payload type mismatch")
+
+// ErrDefinitionFrozen is the cause of the panic raised by builder methods
+// called on a definition that was already built
+var ErrDefinitionFrozen = errors.New("definition is frozen after Build")
+
+// BuilderError is a mistake recorded by a Definition builder method
+type BuilderError struct {
+	Call string // Builder method, e.g. "State"
+	File string // Source file of the offending call
+	Line int    // Source line of the offending call
+	Err  error
+}
+
+func (e *BuilderError) Error() string {
+	return fmt.Sprintf("%s at %s:%d: %v", e.Call, e.File, e.Line, e.Err)
+}
+
+func (e *BuilderError) Unwrap() error {
+	return e.Err
+}
+
+// ActionError reports a failed entry, exit or transition action. It matches
+// ErrActionFailed and unwraps to the action's error, so errors.Is also finds
+// causes like ErrActionTimeout.
+type ActionError struct {
+	Action string  // e.g. "entry action", "exit action", "transition action"
+	State  StateID // State whose entry or exit action failed, empty for transition actions
+	Err    error
+}
+
+func (e *ActionError) Error() string {
+	if e.State == "" {
+		return fmt.Sprintf("%s failed: %v", e.Action, e.Err)
+	}
+	return fmt.Sprintf("%s failed for %q: %v", e.Action, e.State, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrActionFailed
+func (e *ActionError) Is(target error) bool {
+	return target == ErrActionFailed
+}
+
+// unknownStateError keeps a descriptive message while matching ErrUnknownState
+type unknownStateError struct {
+	msg string
+}
+
+// unknownState formats an error about an undefined state that matches ErrUnknownState
+func unknownState(format string, args ...any) error {
+	return &unknownStateError{msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *unknownStateError) Error() string {
+	return e.msg
+}
+
+// Is reports whether target is ErrUnknownState
+func (e *unknownStateError) Is(target error) bool {
+	return target == ErrUnknownState
+}
@@ -1,14 +1,61 @@
 package librefsm
 
+import "strings"
+
 // Event carries data through the state machine
 type Event struct {
 	ID      EventID
-	Payload any // Optional typed payload
+	Payload any    // Optional typed payload
+	Key     string // Optional deduplication key, see WithDeduplication
 }
 
 // Internal event IDs
 const (
-	eventEntry   EventID = "_entry"
-	eventExit    EventID = "_exit"
-	eventTimeout EventID = "_timeout"
+	eventEntry      EventID = "_entry"
+	eventExit       EventID = "_exit"
+	eventTimeout    EventID = "_timeout"
+	eventDelayed    EventID = "_delayed"
+	eventWaitCheck  EventID = "_wait"
+	eventPoll       EventID = "_poll"
+	eventJob        EventID = "_job"
+	eventVarChanged EventID = "_var_changed"
+	eventReset      EventID = "_reset"
 )
+
+// WildcardEvent matches any event in transition rules
+const WildcardEvent EventID = "*"
+
+// EventPrefix returns an event pattern matching every event ID that starts with prefix,
+// e.g. Transition(s, EventPrefix("battery:"), target). The concrete event is
+// available as Context.Event.ID.
+func EventPrefix(prefix string) EventID {
+	return EventID(prefix + "*")
+}
+
+// eventMatches reports whether a transition's event pattern matches an event ID.
+// Patterns ending in "*" match by prefix; WildcardEvent is the empty prefix.
+func eventMatches(pattern, id EventID) bool {
+	if pattern == id {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(string(pattern), "*"); ok {
+		return strings.HasPrefix(string(id), prefix)
+	}
+	return false
+}
+
+// eventID returns the event's ID, or empty for lifecycle callbacks without an event
+func eventID(event *Event) EventID {
+	if event == nil {
+		return ""
+	}
+	return event.ID
+}
+
+// eventValue returns the event, or an empty event for eventless processing
+func eventValue(event *Event) Event {
+	if event == nil {
+		return Event{}
+	}
+	return *event
+}
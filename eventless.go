@@ -0,0 +1,71 @@
+package librefsm
+
+import "fmt"
+
+// EventlessTransition adds a transition without a triggering event. Its guard is
+// re-evaluated whenever the machine settles: after entering the initial state and
+// after processing each event. Without a guard it fires as soon as from is active.
+// from must be a state; WildcardState is reported as a definition error.
+func (d *Definition) EventlessTransition(from StateID, to StateID, opts ...TransitionOption) *Definition {
+	opts = append([]TransitionOption{func(t *Transition) {
+		t.Eventless = true
+	}}, opts...)
+	return d.addTransition("EventlessTransition", from, "", to, opts)
+}
+
+// settle resumes deferred child entries and takes enabled eventless transitions
+// until none applies
+func (m *Machine) settle() error {
+	for step := 0; ; step++ {
+		if _, err := m.resumeDeferredChild(); err != nil {
+			return err
+		}
+
+		t, err := m.findEventless()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		if step >= m.maxChainDepth {
+			return fmt.Errorf("eventless transitions from %q: %w (limit %d)", m.currentState, ErrChainDepthExceeded, m.maxChainDepth)
+		}
+
+		m.trace("executing eventless transition", "from", t.From, "to", t.To, "guard", t.GuardName)
+		if err := m.runTransition(t, t.To, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// findEventless returns the first enabled eventless transition from the current
+// state or its ancestors
+func (m *Machine) findEventless() (*Transition, error) {
+	if state := m.definition.states[m.currentState]; state != nil && state.Type == StateFinal {
+		return nil, nil
+	}
+
+	current := m.currentState
+	for current != "" {
+		for i := range m.definition.transitions {
+			t := &m.definition.transitions[i]
+			if !t.Eventless || t.From != current || m.follows(t) {
+				continue
+			}
+			passed, err := m.checkGuard(t, nil)
+			if err != nil {
+				return nil, err
+			}
+			if passed {
+				return t, nil
+			}
+		}
+		state := m.definition.states[current]
+		if state == nil {
+			break
+		}
+		current = state.Parent
+	}
+	return nil, nil
+}
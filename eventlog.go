@@ -0,0 +1,173 @@
+package librefsm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultEventLogSize is the number of events kept by RecentEvents unless configured
+const defaultEventLogSize = 64
+
+// maxPayloadSummary bounds the length of payload summaries in the event log
+const maxPayloadSummary = 64
+
+// EventOutcome describes what happened to a received event
+type EventOutcome int
+
+const (
+	EventAccepted  EventOutcome = iota // A transition was taken
+	EventUnhandled                     // No transition matched or all guards rejected
+	EventFailed                        // Processing returned an error
+	EventDropped                       // The event was dropped, e.g. because the queue was full or it was rate limited
+)
+
+func (o EventOutcome) String() string {
+	switch o {
+	case EventAccepted:
+		return "accepted"
+	case EventUnhandled:
+		return "unhandled"
+	case EventFailed:
+		return "failed"
+	case EventDropped:
+		return "dropped"
+	default:
+		return fmt.Sprintf("EventOutcome(%d)", int(o))
+	}
+}
+
+// MarshalText renders the outcome by name
+func (o EventOutcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText parses an outcome name produced by MarshalText
+func (o *EventOutcome) UnmarshalText(text []byte) error {
+	for _, candidate := range []EventOutcome{EventAccepted, EventUnhandled, EventFailed, EventDropped} {
+		if candidate.String() == string(text) {
+			*o = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event outcome %q", text)
+}
+
+// EventRecord is an entry of the recent event log
+type EventRecord struct {
+	ID       EventID       `json:"id"`
+	Payload  string        `json:"payload,omitempty"` // Short %v rendering of the payload, empty if nil
+	Outcome  EventOutcome  `json:"outcome"`
+	State    StateID       `json:"state,omitempty"`    // State when processing started, empty for dropped events
+	Result   StateID       `json:"result,omitempty"`   // State after processing, empty for dropped events
+	Received time.Time     `json:"received"`           // When processing started (or the event was dropped)
+	Duration time.Duration `json:"duration,omitempty"` // Processing time in ns, zero for dropped events
+	Seq      uint64        `json:"seq,omitempty"`      // Queue sequence number, zero for dropped events
+}
+
+// eventLog is a fixed-size ring buffer of event records
+type eventLog struct {
+	mu      sync.Mutex
+	size    int
+	records []EventRecord
+	next    int
+}
+
+// WithEventLog sets how many received events are kept for RecentEvents.
+// A size of 0 disables the log.
+func WithEventLog(size int) MachineOption {
+	return func(m *Machine) {
+		if size < 0 {
+			size = 0
+		}
+		m.eventLog.size = size
+	}
+}
+
+// RecentEvents returns up to n of the most recently received events, oldest first.
+// A non-positive n returns the whole log.
+func (m *Machine) RecentEvents(n int) []EventRecord {
+	l := &m.eventLog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := len(l.records)
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	out := make([]EventRecord, 0, n)
+	start := l.next - n
+	if count < l.size {
+		start = count - n
+	}
+	for i := 0; i < n; i++ {
+		out = append(out, l.records[(start+i+count)%count])
+	}
+	return out
+}
+
+// logEvent counts a processed or dropped event and appends a record for it
+func (m *Machine) logEvent(event Event, outcome EventOutcome, state StateID, received time.Time, seq uint64) {
+	m.countOutcome(outcome)
+
+	l := &m.eventLog
+	if l.size == 0 {
+		return
+	}
+
+	rec := EventRecord{
+		ID:       event.ID,
+		Payload:  summarizePayload(m.redact(event)),
+		Outcome:  outcome,
+		State:    state,
+		Received: received,
+		Seq:      seq,
+	}
+	if outcome != EventDropped {
+		rec.Result = m.currentState
+		rec.Duration = time.Since(received)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) < l.size {
+		l.records = append(l.records, rec)
+		l.next = len(l.records) % l.size
+		return
+	}
+	l.records[l.next] = rec
+	l.next = (l.next + 1) % l.size
+}
+
+// WithPayloadRedactor rewrites payloads before they reach diagnostics: the
+// event log and everything built on it (RecentEvents, snapshots, journals for
+// Replay and the debugger, debughttp). Return a masked copy, e.g. with PIN
+// codes or coordinates blanked, or nil to drop the payload. The machine itself,
+// guards, actions and WithDurableQueue still see the original payload; the
+// durable queue needs it to deliver the events again, see EventPersister.
+func WithPayloadRedactor(fn func(EventID, any) any) MachineOption {
+	return func(m *Machine) {
+		m.redactor = fn
+	}
+}
+
+// redact returns the event's payload as it may appear in diagnostics
+func (m *Machine) redact(event Event) any {
+	if m.redactor == nil || event.Payload == nil {
+		return event.Payload
+	}
+	return m.redactor(event.ID, event.Payload)
+}
+
+// summarizePayload renders a payload for the event log
+func summarizePayload(payload any) string {
+	if payload == nil {
+		return ""
+	}
+	s := fmt.Sprintf("%v", payload)
+	if len(s) > maxPayloadSummary {
+		s = s[:maxPayloadSummary] + "…"
+	}
+	return s
+}
@@ -148,7 +148,7 @@ func Example_vehicleFSM() {
 		Transition(stateInit, evInitComplete, stateCondInit).
 		Transition(stateStandby, evUnlock, stateParked).
 		Transition(stateParked, evGoToDrive, stateDrive,
-			librefsm.WithGuard(func(c *librefsm.Context) bool {
+			librefsm.WithGuard(func(c *librefsm.GuardContext) bool {
 				v := c.Data.(*VehicleData)
 				return v.KickstandUp && v.DashboardReady
 			}),
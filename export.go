@@ -0,0 +1,458 @@
+package librefsm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// anyStateNode is the diagram node standing in for any-state transitions
+const anyStateNode = "__any"
+
+// diagram indexes a description for the exporters
+type diagram struct {
+	Description
+	byID   map[StateID]StateDescription
+	groups []string                      // Sorted names of top-level groups
+	roots  map[string][]StateDescription // Top-level states by group, "" for ungrouped
+}
+
+func newDiagram(d Description) *diagram {
+	g := &diagram{
+		Description: d,
+		byID:        make(map[StateID]StateDescription, len(d.States)),
+		roots:       make(map[string][]StateDescription),
+	}
+	for _, s := range d.States {
+		g.byID[s.ID] = s
+		if s.Parent != "" {
+			continue
+		}
+		group := layoutOf(s.Layout).Group
+		if _, ok := g.roots[group]; !ok && group != "" {
+			g.groups = append(g.groups, group)
+		}
+		g.roots[group] = append(g.roots[group], s)
+	}
+	sort.Strings(g.groups)
+	return g
+}
+
+// hasAnyState reports whether any transition starts from WildcardState
+func (g *diagram) hasAnyState() bool {
+	for _, t := range g.Transitions {
+		if t.From == WildcardState {
+			return true
+		}
+	}
+	return false
+}
+
+// targets returns the states a transition can lead to
+func (t TransitionDescription) targets() []StateID {
+	if len(t.Branches) > 0 {
+		return t.Branches
+	}
+	return []StateID{t.To}
+}
+
+// Mermaid renders the description as a Mermaid stateDiagram-v2. Edges are
+// labelled "event [guard] / action"; any-state transitions start from an "any state" node.
+// Layout groups become composite blocks, colors become classes and notes are
+// attached to states or appended to edge labels.
+func (d Description) Mermaid() string {
+	g := newDiagram(d)
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+
+	var writeState func(s StateDescription, indent string, declare bool)
+	writeState = func(s StateDescription, indent string, declare bool) {
+		id := mermaidID(s.ID)
+		declared := false
+		if id != string(s.ID) {
+			fmt.Fprintf(&b, "%sstate \"%s\" as %s\n", indent, s.ID, id)
+			declared = true
+		}
+		switch s.Type {
+		case StateCondition.String(), StateJunction.String():
+			fmt.Fprintf(&b, "%sstate %s <<choice>>\n", indent, id)
+			declared = true
+		}
+		if len(s.Children) == 0 {
+			if declare && !declared {
+				fmt.Fprintf(&b, "%s%s\n", indent, id)
+			}
+			return
+		}
+		fmt.Fprintf(&b, "%sstate %s {\n", indent, id)
+		if s.DefaultChild != "" {
+			fmt.Fprintf(&b, "%s    [*] --> %s\n", indent, mermaidID(s.DefaultChild))
+		}
+		for _, child := range s.Children {
+			writeState(g.byID[child], indent+"    ", false)
+		}
+		fmt.Fprintf(&b, "%s}\n", indent)
+	}
+
+	if d.Initial != "" {
+		fmt.Fprintf(&b, "    [*] --> %s\n", mermaidID(d.Initial))
+	}
+	if g.hasAnyState() {
+		fmt.Fprintf(&b, "    state \"any state\" as %s\n", anyStateNode)
+	}
+	for _, s := range g.roots[""] {
+		writeState(s, "    ", false)
+	}
+	for _, group := range g.groups {
+		fmt.Fprintf(&b, "    state \"%s\" as %s {\n", group, groupID(group))
+		for _, s := range g.roots[group] {
+			writeState(s, "        ", true)
+		}
+		b.WriteString("    }\n")
+	}
+
+	for _, s := range d.States {
+		for _, target := range s.PossibleTargets {
+			fmt.Fprintf(&b, "    %s --> %s\n", mermaidID(s.ID), mermaidID(target))
+		}
+		if s.Type == StateFinal.String() {
+			fmt.Fprintf(&b, "    %s --> [*]\n", mermaidID(s.ID))
+		}
+	}
+	for _, t := range d.Transitions {
+		for _, to := range t.targets() {
+			fmt.Fprintf(&b, "    %s --> %s", mermaidID(t.From), mermaidID(to))
+			if label := edgeText(t, "<br/>"); label != "" {
+				fmt.Fprintf(&b, ": %s", label)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	var colors []string
+	for _, s := range d.States {
+		l := layoutOf(s.Layout)
+		if l.Note != "" {
+			fmt.Fprintf(&b, "    note right of %s : %s\n", mermaidID(s.ID), oneLine(l.Note))
+		}
+		if l.Color == "" {
+			continue
+		}
+		i := indexOf(colors, l.Color)
+		if i < 0 {
+			i = len(colors)
+			colors = append(colors, l.Color)
+		}
+		fmt.Fprintf(&b, "    class %s color%d\n", mermaidID(s.ID), i)
+	}
+	for i, c := range colors {
+		fmt.Fprintf(&b, "    classDef color%d fill:%s\n", i, c)
+	}
+	return b.String()
+}
+
+// DOT renders the description as a Graphviz digraph. Composite states and
+// layout groups become clusters; edges to and from composite states attach to
+// their default leaf and are clipped at the cluster. States sharing a layout
+// rank are placed side by side.
+func (d Description) DOT() string {
+	g := newDiagram(d)
+	var b strings.Builder
+	b.WriteString("digraph fsm {\n")
+	b.WriteString("    compound=true;\n")
+	b.WriteString("    node [shape=box, style=rounded];\n")
+
+	var writeState func(s StateDescription, indent string)
+	writeState = func(s StateDescription, indent string) {
+		l := layoutOf(s.Layout)
+		if len(s.Children) > 0 {
+			fmt.Fprintf(&b, "%ssubgraph %s {\n", indent, clusterID(s.ID))
+			fmt.Fprintf(&b, "%s    label=%s;\n", indent, strconv.Quote(string(s.ID)))
+			if l.Color != "" {
+				fmt.Fprintf(&b, "%s    style=\"rounded,filled\";\n", indent)
+				fmt.Fprintf(&b, "%s    fillcolor=%s;\n", indent, strconv.Quote(l.Color))
+			} else {
+				fmt.Fprintf(&b, "%s    style=rounded;\n", indent)
+			}
+			for _, child := range s.Children {
+				writeState(g.byID[child], indent+"    ")
+			}
+			fmt.Fprintf(&b, "%s}\n", indent)
+			return
+		}
+
+		attrs := []string{}
+		switch s.Type {
+		case StateCondition.String(), StateJunction.String():
+			attrs = append(attrs, "shape=diamond")
+		case StateFinal.String():
+			attrs = append(attrs, "shape=doublecircle")
+		}
+		if l.Color != "" {
+			attrs = append(attrs, `style="rounded,filled"`, "fillcolor="+strconv.Quote(l.Color))
+		}
+		fmt.Fprintf(&b, "%s%s", indent, strconv.Quote(string(s.ID)))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+
+	if d.Initial != "" {
+		b.WriteString("    __start [shape=point];\n")
+	}
+	if g.hasAnyState() {
+		fmt.Fprintf(&b, "    %s [label=\"any state\", style=dashed];\n", anyStateNode)
+	}
+	for _, s := range g.roots[""] {
+		writeState(s, "    ")
+	}
+	for _, group := range g.groups {
+		fmt.Fprintf(&b, "    subgraph cluster_%s {\n", groupID(group))
+		fmt.Fprintf(&b, "        label=%s;\n", strconv.Quote(group))
+		b.WriteString("        style=dashed;\n")
+		for _, s := range g.roots[group] {
+			writeState(s, "        ")
+		}
+		b.WriteString("    }\n")
+	}
+
+	ranks := make(map[int][]StateID)
+	var rankOrder []int
+	for _, s := range d.States {
+		if r := layoutOf(s.Layout).Rank; r != 0 && len(s.Children) == 0 {
+			if _, ok := ranks[r]; !ok {
+				rankOrder = append(rankOrder, r)
+			}
+			ranks[r] = append(ranks[r], s.ID)
+		}
+	}
+	sort.Ints(rankOrder)
+	for _, r := range rankOrder {
+		b.WriteString("    { rank=same;")
+		for _, id := range ranks[r] {
+			fmt.Fprintf(&b, " %s;", strconv.Quote(string(id)))
+		}
+		b.WriteString(" }\n")
+	}
+
+	edge := func(from, to StateID, attrs []string) {
+		if s, ok := g.byID[from]; ok && len(s.Children) > 0 {
+			attrs = append(attrs, "ltail="+clusterID(from))
+		}
+		if s, ok := g.byID[to]; ok && len(s.Children) > 0 {
+			attrs = append(attrs, "lhead="+clusterID(to))
+		}
+		fmt.Fprintf(&b, "    %s -> %s", g.dotNode(from), g.dotNode(to))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+
+	if d.Initial != "" {
+		edge("__start", d.Initial, nil)
+	}
+	for _, s := range d.States {
+		for _, target := range s.PossibleTargets {
+			edge(s.ID, target, []string{"style=dashed"})
+		}
+	}
+	for _, t := range d.Transitions {
+		var attrs []string
+		if label := edgeText(t, "\n"); label != "" {
+			attrs = append(attrs, "label="+strconv.Quote(label))
+		}
+		if c := layoutOf(t.Layout).Color; c != "" {
+			attrs = append(attrs, "color="+strconv.Quote(c))
+		}
+		for _, to := range t.targets() {
+			edge(t.From, to, attrs)
+		}
+	}
+
+	n := 0
+	for _, s := range d.States {
+		if note := layoutOf(s.Layout).Note; note != "" {
+			fmt.Fprintf(&b, "    __note%d [shape=note, label=%s];\n", n, strconv.Quote(note))
+			fmt.Fprintf(&b, "    __note%d -> %s [style=dotted, arrowhead=none];\n", n, g.dotNode(s.ID))
+			n++
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotNode returns the quoted DOT node for a state, descending into composite
+// states to their default (or first) leaf
+func (g *diagram) dotNode(id StateID) string {
+	switch id {
+	case "__start":
+		return "__start"
+	case WildcardState:
+		return anyStateNode
+	}
+	for {
+		s, ok := g.byID[id]
+		if !ok || len(s.Children) == 0 {
+			return strconv.Quote(string(id))
+		}
+		if s.DefaultChild != "" {
+			id = s.DefaultChild
+		} else {
+			id = s.Children[0]
+		}
+	}
+}
+
+// PlantUML renders the description as a PlantUML state diagram. Layout groups
+// become dashed composite blocks, colors fill states and edges, and notes are
+// attached to states or appended to edge labels.
+func (d Description) PlantUML() string {
+	g := newDiagram(d)
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	b.WriteString("hide empty description\n")
+
+	var writeState func(s StateDescription, indent string)
+	writeState = func(s StateDescription, indent string) {
+		id := mermaidID(s.ID)
+		fmt.Fprintf(&b, "%sstate \"%s\" as %s", indent, s.ID, id)
+		switch s.Type {
+		case StateCondition.String(), StateJunction.String():
+			b.WriteString(" <<choice>>")
+		}
+		if c := layoutOf(s.Layout).Color; c != "" {
+			b.WriteString(" " + plantUMLColor(c))
+		}
+		if len(s.Children) == 0 {
+			b.WriteString("\n")
+			return
+		}
+		b.WriteString(" {\n")
+		if s.DefaultChild != "" {
+			fmt.Fprintf(&b, "%s    [*] --> %s\n", indent, mermaidID(s.DefaultChild))
+		}
+		for _, child := range s.Children {
+			writeState(g.byID[child], indent+"    ")
+		}
+		fmt.Fprintf(&b, "%s}\n", indent)
+	}
+
+	if d.Initial != "" {
+		fmt.Fprintf(&b, "[*] --> %s\n", mermaidID(d.Initial))
+	}
+	if g.hasAnyState() {
+		fmt.Fprintf(&b, "state \"any state\" as %s ##[dashed]\n", anyStateNode)
+	}
+	for _, s := range g.roots[""] {
+		writeState(s, "")
+	}
+	for _, group := range g.groups {
+		fmt.Fprintf(&b, "state \"%s\" as %s ##[dashed] {\n", group, groupID(group))
+		for _, s := range g.roots[group] {
+			writeState(s, "    ")
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, s := range d.States {
+		for _, target := range s.PossibleTargets {
+			fmt.Fprintf(&b, "%s -[dashed]-> %s\n", mermaidID(s.ID), mermaidID(target))
+		}
+		if s.Type == StateFinal.String() {
+			fmt.Fprintf(&b, "%s --> [*]\n", mermaidID(s.ID))
+		}
+	}
+	for _, t := range d.Transitions {
+		arrow := "-->"
+		if c := layoutOf(t.Layout).Color; c != "" {
+			arrow = "-[" + plantUMLColor(c) + "]->"
+		}
+		for _, to := range t.targets() {
+			fmt.Fprintf(&b, "%s %s %s", mermaidID(t.From), arrow, mermaidID(to))
+			if label := edgeText(t, `\n`); label != "" {
+				fmt.Fprintf(&b, " : %s", label)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	for _, s := range d.States {
+		if note := layoutOf(s.Layout).Note; note != "" {
+			fmt.Fprintf(&b, "note right of %s : %s\n", mermaidID(s.ID), strings.ReplaceAll(note, "\n", `\n`))
+		}
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+// edgeLabel renders a transition as "event [guard] / action", naming only
+// registered actions
+func edgeLabel(t TransitionDescription) string {
+	label := string(t.Event)
+	if t.Guard != "" {
+		label += " [" + t.Guard + "]"
+	}
+	if t.Action != "" {
+		label += " / " + t.Action
+	}
+	return strings.TrimSpace(label)
+}
+
+// edgeText is edgeLabel followed by the layout note, joined by the exporter's line break
+func edgeText(t TransitionDescription, lineBreak string) string {
+	label := edgeLabel(t)
+	note := oneLine(layoutOf(t.Layout).Note)
+	switch {
+	case note == "":
+		return label
+	case label == "":
+		return note
+	default:
+		return label + lineBreak + note
+	}
+}
+
+// mermaidID maps a state ID to a Mermaid-safe identifier
+func mermaidID(id StateID) string {
+	if id == WildcardState {
+		return anyStateNode
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, string(id))
+}
+
+// groupID returns the diagram identifier of a layout group
+func groupID(group string) string {
+	return "__group_" + mermaidID(StateID(group))
+}
+
+// clusterID returns the DOT cluster name of a composite state
+func clusterID(id StateID) string {
+	return "cluster_" + mermaidID(id)
+}
+
+// plantUMLColor formats a color name or "#rrggbb" for PlantUML
+func plantUMLColor(c string) string {
+	return "#" + strings.TrimPrefix(c, "#")
+}
+
+// oneLine collapses line breaks for formats without multi-line labels
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
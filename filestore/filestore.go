@@ -0,0 +1,113 @@
+// Package filestore keeps the pending events of a librefsm machine in a local
+// file, see librefsm.WithDurableQueue. It is the reference EventPersister:
+// adapters for Redis or other stores follow the same shape in their own
+// modules, so that librefsm itself stays free of their dependencies.
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/librescoot/librefsm"
+)
+
+// Persister stores pending events in a file. Every save replaces the file
+// atomically, so a crash mid-write leaves the previous set in place.
+type Persister struct {
+	path  string
+	codec librefsm.PayloadCodec
+}
+
+// storedEvent is an event as written to the file
+type storedEvent struct {
+	ID      librefsm.EventID `json:"id"`
+	Key     string           `json:"key,omitempty"`
+	Payload []byte           `json:"payload"` // Encoded by the codec
+}
+
+// storedFile is the content of the file
+type storedFile struct {
+	ContentType string        `json:"content_type"`
+	Events      []storedEvent `json:"events"`
+}
+
+// New returns a Persister for the file at path, encoding payloads with codec.
+// Register payload types with the codec to get typed payloads back on load.
+// A nil codec is a librefsm.JSONCodec without registered types.
+func New(path string, codec librefsm.PayloadCodec) *Persister {
+	if codec == nil {
+		codec = librefsm.JSONCodec{}
+	}
+	return &Persister{path: path, codec: codec}
+}
+
+// SavePending replaces the stored events
+func (p *Persister) SavePending(events []librefsm.Event) error {
+	f := storedFile{ContentType: p.codec.ContentType(), Events: make([]storedEvent, 0, len(events))}
+	for _, event := range events {
+		payload, err := p.codec.Encode(event.ID, event.Payload)
+		if err != nil {
+			return err
+		}
+		f.Events = append(f.Events, storedEvent{ID: event.ID, Key: event.Key, Payload: payload})
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("encode pending events: %w", err)
+	}
+	return writeFile(p.path, data)
+}
+
+// LoadPending returns the stored events, or none if the file does not exist
+func (p *Persister) LoadPending() ([]librefsm.Event, error) {
+	data, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f storedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", p.path, err)
+	}
+	if f.ContentType != p.codec.ContentType() {
+		return nil, fmt.Errorf("%s holds %s payloads, codec reads %s", p.path, f.ContentType, p.codec.ContentType())
+	}
+	events := make([]librefsm.Event, 0, len(f.Events))
+	for _, stored := range f.Events {
+		payload, err := p.codec.Decode(stored.ID, stored.Payload)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, librefsm.Event{ID: stored.ID, Payload: payload, Key: stored.Key})
+	}
+	return events, nil
+}
+
+// writeFile replaces the file at path with data through a synced temporary
+// file in the same directory
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
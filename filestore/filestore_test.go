@@ -0,0 +1,73 @@
+package filestore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+type unlockRequest struct {
+	User string
+	PIN  int
+}
+
+func TestPersister(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending.json")
+	var types librefsm.PayloadTypes
+	librefsm.RegisterPayload[unlockRequest](&types, "unlock")
+	p := New(path, librefsm.JSONCodec{Types: &types})
+
+	if events, err := p.LoadPending(); err != nil || len(events) != 0 {
+		t.Fatalf("expected no events before the first save, got %v, %v", events, err)
+	}
+
+	saved := []librefsm.Event{
+		{ID: "unlock", Payload: unlockRequest{User: "rider", PIN: 1234}, Key: "u1"},
+		{ID: "lock"},
+	}
+	if err := p.SavePending(saved); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	loaded, err := p.LoadPending()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0] != saved[0] || loaded[1] != saved[1] {
+		t.Errorf("expected %v, got %v", saved, loaded)
+	}
+
+	if _, err := New(path, librefsm.MsgpackCodec{}).LoadPending(); err == nil {
+		t.Error("expected an error reading JSON payloads with another codec")
+	}
+
+	// A machine picks up the stored events on Start
+	def := librefsm.NewDefinition().
+		State("locked").
+		State("unlocked").
+		Transition("locked", "unlock", "unlocked", librefsm.WithGuard(librefsm.PayloadWhere(func(r unlockRequest) bool {
+			return r.PIN == 1234
+		}))).
+		Transition("unlocked", "lock", "locked").
+		Initial("locked")
+	m, err := def.Build(librefsm.WithDurableQueue(p))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := p.SavePending(saved[:1]); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+	time.Sleep(20 * time.Millisecond)
+	if m.CurrentState() != "unlocked" {
+		t.Errorf("expected the restored event to be processed, got %s", m.CurrentState())
+	}
+	if events, _ := p.LoadPending(); len(events) != 0 {
+		t.Errorf("expected processed events to be removed, got %v", events)
+	}
+}
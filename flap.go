@@ -0,0 +1,80 @@
+package librefsm
+
+import "time"
+
+// Flap is the payload of the event raised by flap detection
+type Flap struct {
+	State   StateID
+	Entries int // Entries observed within Window
+	Window  time.Duration
+}
+
+// flapDetector tracks recent entries of one state
+type flapDetector struct {
+	threshold int
+	window    time.Duration
+	event     EventID
+	entries   []time.Time
+}
+
+// WithFlapDetection sends event (with a Flap payload) when state is entered
+// threshold times within window, e.g. to catch lock/unlock oscillation.
+// The detector resets after firing.
+func WithFlapDetection(state StateID, threshold int, window time.Duration, event EventID) MachineOption {
+	return func(m *Machine) {
+		if threshold <= 0 || window <= 0 {
+			return
+		}
+		if m.flapDetectors == nil {
+			m.flapDetectors = make(map[StateID]*flapDetector)
+		}
+		m.flapDetectors[state] = &flapDetector{threshold: threshold, window: window, event: event}
+	}
+}
+
+// EntryCount returns how often the state has been entered since Start
+func (m *Machine) EntryCount(state StateID) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.entryCounts[state]
+}
+
+// EntryCounts returns a copy of the entry counters of all entered states
+func (m *Machine) EntryCounts() map[StateID]uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := make(map[StateID]uint64, len(m.entryCounts))
+	for id, n := range m.entryCounts {
+		counts[id] = n
+	}
+	return counts
+}
+
+// countEntry updates the entry counter and flap detector of a state
+func (m *Machine) countEntry(id StateID) {
+	if m.entryCounts == nil {
+		m.entryCounts = make(map[StateID]uint64)
+	}
+	m.entryCounts[id]++
+
+	fd := m.flapDetectors[id]
+	if fd == nil {
+		return
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-fd.window)
+	kept := fd.entries[:0]
+	for _, t := range fd.entries {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	fd.entries = append(kept, now)
+
+	if len(fd.entries) >= fd.threshold {
+		m.logger.Warn("state flapping", "state", id, "entries", len(fd.entries), "window", fd.window)
+		m.enqueue(Event{ID: fd.event, Payload: Flap{State: id, Entries: len(fd.entries), Window: fd.window}})
+		fd.entries = fd.entries[:0]
+	}
+}
@@ -0,0 +1,71 @@
+package librefsm
+
+import "fmt"
+
+// StateAnnouncement is a payload of follower announcements, see WithFollowerMode
+type StateAnnouncement struct {
+	State StateID
+}
+
+// WithFollowerMode makes the machine mirror an external authority, e.g.
+// legacy firmware publishing its state to Redis, instead of deciding
+// transitions itself. Events with ID announce move the machine to the state
+// in their payload, a StateID, string or StateAnnouncement, like SetState:
+// exit and entry actions, timeouts and timers run locally. All other events,
+// including timer events, only take internal transitions, which change no
+// state; eventless transitions are not taken and polling states do not poll.
+// Announcements are processed in order with other events, so feed them
+// through Send, e.g. with WithEventSource or WithSubscription. Unknown states
+// are reported like processing errors.
+func WithFollowerMode(announce EventID) MachineOption {
+	return func(m *Machine) {
+		m.followEvent = announce
+	}
+}
+
+// follows reports whether follower mode keeps t from being taken
+func (m *Machine) follows(t *Transition) bool {
+	return m.followEvent != "" && t.Kind != TransitionInternal
+}
+
+// dropFollowed removes the transitions follower mode does not take
+func (m *Machine) dropFollowed(ts []*Transition) []*Transition {
+	if m.followEvent == "" {
+		return ts
+	}
+	kept := ts[:0]
+	for _, t := range ts {
+		if !m.follows(t) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// follow moves the machine to the state announced by event
+func (m *Machine) follow(event Event) error {
+	var target StateID
+	switch p := event.Payload.(type) {
+	case StateID:
+		target = p
+	case string:
+		target = StateID(p)
+	case StateAnnouncement:
+		target = p.State
+	default:
+		return fmt.Errorf("announcement %q: unsupported payload %T", event.ID, event.Payload)
+	}
+
+	if m.currentState == target {
+		m.trace("announced state already active", "state", target)
+		m.noteOutcome(SendNoMatch)
+		return nil
+	}
+	m.trace("following announced state", "from", m.currentState, "to", target)
+	if err := m.forceState(target, setStateConfig{}); err != nil {
+		return err
+	}
+	m.stats.transitions.Add(1)
+	m.noteOutcome(SendTransitioned)
+	return nil
+}
@@ -1,7 +1,16 @@
 package librefsm
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -9,16 +18,16 @@ import (
 
 // Test states
 const (
-	stateInit    StateID = "init"
-	stateA       StateID = "a"
-	stateB       StateID = "b"
-	stateC       StateID = "c"
-	stateParent  StateID = "parent"
-	stateChild1  StateID = "child1"
-	stateChild2  StateID = "child2"
-	stateCond    StateID = "condition"
-	stateJunc    StateID = "junction"
-	stateFinal   StateID = "final"
+	stateInit   StateID = "init"
+	stateA      StateID = "a"
+	stateB      StateID = "b"
+	stateC      StateID = "c"
+	stateParent StateID = "parent"
+	stateChild1 StateID = "child1"
+	stateChild2 StateID = "child2"
+	stateCond   StateID = "condition"
+	stateJunc   StateID = "junction"
+	stateFinal  StateID = "final"
 )
 
 // Test events
@@ -125,7 +134,7 @@ func TestGuard(t *testing.T) {
 		State(stateA).
 		State(stateB).
 		Transition(stateA, evGo, stateB,
-			WithGuard(func(c *Context) bool {
+			WithGuard(func(c *GuardContext) bool {
 				return allowed
 			}),
 		).
@@ -168,12 +177,12 @@ func TestGuardFallthrough(t *testing.T) {
 		State(stateC).
 		// Multiple transitions for same event, different guards
 		Transition(stateA, evGo, stateB,
-			WithGuard(func(c *Context) bool {
+			WithGuard(func(c *GuardContext) bool {
 				return option1Allowed
 			}),
 		).
 		Transition(stateA, evGo, stateC,
-			WithGuard(func(c *Context) bool {
+			WithGuard(func(c *GuardContext) bool {
 				return option2Allowed
 			}),
 		).
@@ -767,6 +776,75 @@ func TestValidation(t *testing.T) {
 			def:     NewDefinition().ConditionState(stateCond, nil).Initial(stateCond),
 			wantErr: true,
 		},
+		{
+			name: "transition out of final state",
+			def: NewDefinition().
+				State(stateA).
+				FinalState(stateFinal).
+				Transition(stateA, evDone, stateFinal).
+				Transition(stateFinal, evBack, stateA).
+				Initial(stateA),
+			wantErr: true,
+		},
+		{
+			name: "duplicate transition",
+			def: NewDefinition().
+				State(stateA).
+				State(stateB).
+				Transition(stateA, evGo, stateB).
+				Transition(stateA, evGo, stateB).
+				Initial(stateA),
+			wantErr: true,
+		},
+		{
+			name: "shadowed transition",
+			def: NewDefinition().
+				State(stateA).
+				State(stateB).
+				State(stateC).
+				Transition(stateA, evGo, stateB).
+				Transition(stateA, evGo, stateC, WithGuard(func(c *GuardContext) bool { return true })).
+				Initial(stateA),
+			wantErr: true,
+		},
+		{
+			name: "guarded before unguarded",
+			def: NewDefinition().
+				State(stateA).
+				State(stateB).
+				State(stateC).
+				Transition(stateA, evGo, stateB, WithGuard(func(c *GuardContext) bool { return true })).
+				Transition(stateA, evGo, stateC).
+				Initial(stateA),
+			wantErr: false,
+		},
+		{
+			name: "undefined condition target",
+			def: NewDefinition().
+				ConditionState(stateCond, func(c *Context) StateID { return stateB },
+					WithPossibleTargets(stateB)).
+				Initial(stateCond),
+			wantErr: true,
+		},
+		{
+			name: "condition loop",
+			def: NewDefinition().
+				ConditionState(stateCond, func(c *Context) StateID { return stateJunc },
+					WithPossibleTargets(stateJunc)).
+				JunctionState(stateJunc, func(c *Context) StateID { return stateCond },
+					WithPossibleTargets(stateCond)).
+				Initial(stateCond),
+			wantErr: true,
+		},
+		{
+			name: "default child not a child",
+			def: NewDefinition().
+				State(stateParent, WithDefaultChild(stateA)).
+				State(stateChild1, WithParent(stateParent)).
+				State(stateA).
+				Initial(stateParent),
+			wantErr: true,
+		},
 		{
 			name: "valid definition",
 			def: NewDefinition().
@@ -913,3 +991,4933 @@ func TestTimeoutTransitionUndefinedTarget(t *testing.T) {
 		t.Error("expected error for undefined timeout target, got nil")
 	}
 }
+
+func TestEntryRetry(t *testing.T) {
+	var attempts int32
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB,
+			WithRetry(3, time.Millisecond),
+			WithOnEnter(func(c *Context) error {
+				if atomic.AddInt32(&attempts, 1) < 3 {
+					return errors.New("hardware busy")
+				}
+				return nil
+			}),
+		).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: evGo}); err != nil {
+		t.Fatalf("entry should succeed after retries: %v", err)
+	}
+
+	if atomic.LoadInt32(&attempts) != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestActionRetryExhausted(t *testing.T) {
+	var attempts int32
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB,
+			WithAction(func(c *Context) error {
+				atomic.AddInt32(&attempts, 1)
+				return errors.New("redis unreachable")
+			}),
+			WithActionRetry(2, time.Millisecond),
+		).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: evGo}); err == nil {
+		t.Error("expected error after retries were exhausted")
+	}
+
+	if atomic.LoadInt32(&attempts) != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestStrictEvents(t *testing.T) {
+	unhandled := make(chan EventID, 1)
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+
+	m, err := def.Build(
+		WithStrictEvents(),
+		WithUnhandledEventHandler(func(event Event, state StateID) {
+			unhandled <- event.ID
+		}),
+	)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: "typo"}); !errors.Is(err, ErrNoTransition) {
+		t.Errorf("expected ErrNoTransition, got %v", err)
+	}
+
+	m.Send(Event{ID: evBack})
+	select {
+	case id := <-unhandled:
+		if id != evBack {
+			t.Errorf("expected unhandled %s, got %s", evBack, id)
+		}
+	case <-time.After(time.Second):
+		t.Error("unhandled handler was not invoked")
+	}
+
+	if err := m.SendSync(Event{ID: evGo}); err != nil {
+		t.Errorf("handled event should succeed: %v", err)
+	}
+}
+
+func TestGuardContext(t *testing.T) {
+	var seen GuardContext
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB,
+			WithGuard(func(c *GuardContext) bool {
+				seen = *c
+				return c.CurrentState() == stateA && c.IsInState(stateA)
+			}),
+		).
+		Initial(stateA)
+
+	m, err := def.Build(WithData("app"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo, Payload: 42})
+
+	if m.CurrentState() != stateB {
+		t.Errorf("expected state %s, got %s", stateB, m.CurrentState())
+	}
+	if seen.FromState != stateA || seen.ToState != stateB {
+		t.Errorf("unexpected guard states: from=%s to=%s", seen.FromState, seen.ToState)
+	}
+	if seen.Event == nil || seen.Event.Payload != 42 || seen.Data != "app" {
+		t.Errorf("guard context missing event or data: %+v", seen)
+	}
+}
+
+func TestActionTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    ActionTimeoutPolicy
+		wantErr   bool
+		wantState StateID
+	}{
+		{name: "fail", policy: ActionTimeoutFail, wantErr: true, wantState: stateB},
+		{name: "abandon", policy: ActionTimeoutAbandon, wantErr: false, wantState: stateB},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handled error
+
+			def := NewDefinition().
+				State(stateA).
+				State(stateB,
+					WithOnEnter(func(c *Context) error {
+						<-c.Context().Done()
+						return c.Context().Err()
+					}),
+				).
+				Transition(stateA, evGo, stateB).
+				Initial(stateA)
+
+			m, err := def.Build(
+				WithActionTimeout(20*time.Millisecond, tt.policy),
+				WithErrorHandler(func(event Event, err error) {
+					handled = err
+				}),
+			)
+			if err != nil {
+				t.Fatalf("build failed: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if err := m.Start(ctx); err != nil {
+				t.Fatalf("start failed: %v", err)
+			}
+			defer m.Stop()
+
+			err = m.SendSync(Event{ID: evGo})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendSync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrActionTimeout) {
+				t.Errorf("expected ErrActionTimeout, got %v", err)
+			}
+			if tt.wantErr && handled == nil {
+				t.Error("error handler should have been invoked")
+			}
+			if m.CurrentState() != tt.wantState {
+				t.Errorf("expected state %s, got %s", tt.wantState, m.CurrentState())
+			}
+		})
+	}
+}
+
+func TestFinalStateIgnoresWildcards(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		FinalState(stateFinal).
+		Transition(stateA, evDone, stateFinal).
+		AnyStateTransition(evBack, stateA).
+		Initial(stateA)
+
+	m, err := def.Build(WithStrictEvents())
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evDone})
+
+	if err := m.SendSync(Event{ID: evBack}); !errors.Is(err, ErrNoTransition) {
+		t.Errorf("expected ErrNoTransition in final state, got %v", err)
+	}
+	if m.CurrentState() != stateFinal {
+		t.Errorf("expected to stay in %s, got %s", stateFinal, m.CurrentState())
+	}
+}
+
+func TestConditionChainDepth(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		ConditionState(stateCond, func(c *Context) StateID { return stateJunc }).
+		JunctionState(stateJunc, func(c *Context) StateID { return stateCond }).
+		Transition(stateA, evGo, stateCond).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: evGo}); !errors.Is(err, ErrChainDepthExceeded) {
+		t.Errorf("expected ErrChainDepthExceeded, got %v", err)
+	}
+}
+
+func TestMaxChainDepthOption(t *testing.T) {
+	// a -> b -> c via eventless transitions needs two steps
+	def := NewDefinition().
+		State(stateInit).
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateInit, evGo, stateA).
+		EventlessTransition(stateA, stateB).
+		EventlessTransition(stateB, stateC).
+		Initial(stateInit)
+
+	reported := make(chan error, 1)
+	m, err := def.Build(
+		WithMaxChainDepth(1),
+		WithErrorHandler(func(ev Event, err error) { reported <- err }),
+	)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.Send(Event{ID: evGo})
+	select {
+	case err := <-reported:
+		if !errors.Is(err, ErrChainDepthExceeded) {
+			t.Errorf("expected ErrChainDepthExceeded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected chain overflow to reach the error handler")
+	}
+}
+
+func TestConditionUndeclaredTarget(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		ConditionState(stateCond, func(c *Context) StateID { return stateC },
+			WithPossibleTargets(stateB)).
+		Transition(stateA, evGo, stateCond).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: evGo}); err == nil {
+		t.Error("expected error for undeclared condition target")
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateA).
+		State(stateB, WithParent(stateB)).
+		Transition(stateA, "", stateB).
+		Initial(stateA)
+
+	err := def.Err()
+	if err == nil {
+		t.Fatal("expected builder errors")
+	}
+
+	var be *BuilderError
+	if !errors.As(err, &be) {
+		t.Fatalf("expected BuilderError, got %T", err)
+	}
+	if be.Call != "State" || !strings.HasSuffix(be.File, "fsm_test.go") {
+		t.Errorf("unexpected call site: %s at %s:%d", be.Call, be.File, be.Line)
+	}
+	if n := strings.Count(err.Error(), "\n") + 1; n != 3 {
+		t.Errorf("expected 3 builder errors, got %d: %v", n, err)
+	}
+
+	if _, err := def.Build(); err == nil || !strings.Contains(err.Error(), "duplicate state") {
+		t.Errorf("Build should report the first builder error, got %v", err)
+	}
+}
+
+func TestFluentTransition(t *testing.T) {
+	var allowed bool
+	var actions []string
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		From(stateA).On(evGo).
+		If(func(c *GuardContext) bool { return allowed }).
+		Do(func(c *Context) error { actions = append(actions, "first"); return nil }).
+		Do(func(c *Context) error { actions = append(actions, "second"); return nil }).
+		To(stateB).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateA {
+		t.Errorf("guard should have blocked transition")
+	}
+
+	allowed = true
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateB {
+		t.Errorf("expected state %s, got %s", stateB, m.CurrentState())
+	}
+	if len(actions) != 2 || actions[0] != "first" || actions[1] != "second" {
+		t.Errorf("expected actions in order, got %v", actions)
+	}
+}
+
+func TestTransitionTable(t *testing.T) {
+	var actionRan bool
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transitions([]TransitionSpec{
+			{From: stateA, Event: evGo, To: stateB, Label: "start"},
+			{From: stateB, Event: evNext, To: stateC, Action: func(c *Context) error {
+				actionRan = true
+				return nil
+			}},
+			{From: stateC, Event: evBack, To: stateA, Guard: func(c *GuardContext) bool { return false }},
+		}).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	m.SendSync(Event{ID: evNext})
+	m.SendSync(Event{ID: evBack})
+
+	if m.CurrentState() != stateC {
+		t.Errorf("expected state %s, got %s", stateC, m.CurrentState())
+	}
+	if !actionRan {
+		t.Error("table action should have run")
+	}
+	if def.transitions[0].Label != "start" {
+		t.Errorf("expected label to be kept, got %q", def.transitions[0].Label)
+	}
+}
+
+func TestGuardCombinators(t *testing.T) {
+	kickstandUp := NamedGuard("kickstand_up", func(c *GuardContext) bool {
+		return c.Data.(map[string]bool)["kickstand"]
+	})
+	seatboxOpen := NamedGuard("seatbox_open", func(c *GuardContext) bool {
+		return c.Data.(map[string]bool)["seatbox"]
+	})
+
+	guard := And(kickstandUp, Not(seatboxOpen))
+	if guard.Name != "kickstand_up && !seatbox_open" {
+		t.Errorf("unexpected composed name %q", guard.Name)
+	}
+	if name := Or(guard, seatboxOpen).Name; name != "(kickstand_up && !seatbox_open) || seatbox_open" {
+		t.Errorf("unexpected nested name %q", name)
+	}
+
+	tests := []struct {
+		kickstand, seatbox bool
+		want               bool
+	}{
+		{true, false, true},
+		{true, true, false},
+		{false, false, false},
+	}
+	for _, tt := range tests {
+		ctx := &GuardContext{Data: map[string]bool{"kickstand": tt.kickstand, "seatbox": tt.seatbox}}
+		if got := guard.Check(ctx); got != tt.want {
+			t.Errorf("kickstand=%v seatbox=%v: got %v, want %v", tt.kickstand, tt.seatbox, got, tt.want)
+		}
+		if got := Or(kickstandUp, seatboxOpen).Check(ctx); got != (tt.kickstand || tt.seatbox) {
+			t.Errorf("Or: kickstand=%v seatbox=%v: got %v", tt.kickstand, tt.seatbox, got)
+		}
+	}
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB, When(guard)).
+		Initial(stateA)
+	if def.transitions[0].GuardName != guard.Name {
+		t.Errorf("expected guard name on transition, got %q", def.transitions[0].GuardName)
+	}
+}
+
+func TestPayloadGuards(t *testing.T) {
+	type reading struct {
+		Slot  int
+		Level int
+	}
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateB, WithGuard(PayloadEquals("unlock"))).
+		Transition(stateA, evNext, stateC, WithGuard(PayloadWhere(func(r reading) bool {
+			return r.Level < 10
+		}))).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo, Payload: "lock"})
+	m.SendSync(Event{ID: evGo, Payload: 42})
+	m.SendSync(Event{ID: evNext, Payload: reading{Slot: 1, Level: 80}})
+	m.SendSync(Event{ID: evNext})
+	if m.CurrentState() != stateA {
+		t.Fatalf("non-matching payloads should be rejected, got %s", m.CurrentState())
+	}
+
+	m.SendSync(Event{ID: evNext, Payload: reading{Slot: 1, Level: 5}})
+	if m.CurrentState() != stateC {
+		t.Errorf("expected state %s, got %s", stateC, m.CurrentState())
+	}
+}
+
+func TestDefaultStateTimeout(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB, WithTimeout(time.Hour, evNext)).
+		State(stateC).
+		Transition(stateA, evGo, stateB).
+		AnyStateTransition(evTimeout, stateC).
+		Initial(stateA)
+
+	m, err := def.Build(WithDefaultStateTimeout(30*time.Millisecond, evTimeout))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	// State B declares its own timeout, so the default must not apply
+	m.SendSync(Event{ID: evGo})
+	time.Sleep(60 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("declared timeout should win, got %s", m.CurrentState())
+	}
+
+	m.Stop()
+
+	m2, err := def.Build(WithDefaultStateTimeout(30*time.Millisecond, evTimeout))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m2.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m2.Stop()
+
+	time.Sleep(60 * time.Millisecond)
+	if m2.CurrentState() != stateC {
+		t.Errorf("expected default timeout to reach %s, got %s", stateC, m2.CurrentState())
+	}
+}
+
+func TestVerboseStateLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+
+	m, err := def.Build(WithLogger(logger), WithVerboseStateLogging(slog.LevelInfo))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+
+	out := buf.String()
+	for _, want := range []string{
+		`msg="state entered" state=a`,
+		`msg="state exited" state=a`,
+		`msg="state entered" state=b from=a event=go`,
+		`msg="transition completed" from=a to=b event=go`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestAnyEventTransition(t *testing.T) {
+	var captured []EventID
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateB).
+		AnyEventTransition(stateA, stateC, WithAction(func(c *Context) error {
+			captured = append(captured, c.Event.ID)
+			return nil
+		})).
+		Transition(stateC, evBack, stateA).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: "diagnostic"})
+	if m.CurrentState() != stateC {
+		t.Fatalf("expected any-event transition to %s, got %s", stateC, m.CurrentState())
+	}
+
+	// An exact match takes precedence over the wildcard
+	m.SendSync(Event{ID: evBack})
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateB {
+		t.Errorf("expected exact transition to %s, got %s", stateB, m.CurrentState())
+	}
+	if len(captured) != 1 || captured[0] != "diagnostic" {
+		t.Errorf("expected concrete event in context, got %v", captured)
+	}
+}
+
+func TestEventPrefix(t *testing.T) {
+	var slots []EventID
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, EventPrefix("battery:"), stateB, WithAction(func(c *Context) error {
+			slots = append(slots, c.Event.ID)
+			return nil
+		})).
+		Transition(stateB, EventPrefix("battery:"), stateA).
+		Initial(stateA)
+
+	m, err := def.Build(WithStrictEvents())
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: "seatbox:open"}); !errors.Is(err, ErrNoTransition) {
+		t.Errorf("non-matching prefix should be unhandled, got %v", err)
+	}
+
+	m.SendSync(Event{ID: "battery:0:present"})
+	m.SendSync(Event{ID: "battery:1:present"})
+	m.SendSync(Event{ID: "battery:1:active"})
+
+	if m.CurrentState() != stateB {
+		t.Errorf("expected state %s, got %s", stateB, m.CurrentState())
+	}
+	if len(slots) != 2 || slots[0] != "battery:0:present" || slots[1] != "battery:1:active" {
+		t.Errorf("unexpected concrete events: %v", slots)
+	}
+}
+
+func TestSwitchTransition(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		SwitchTransition(stateA, evGo, func(payload any) StateID {
+			switch payload {
+			case "b":
+				return stateB
+			case "c":
+				return stateC
+			case "bogus":
+				return stateA
+			}
+			return ""
+		}, []StateID{stateB, stateC}).
+		AnyStateTransition(evBack, stateA).
+		Initial(stateA)
+
+	m, err := def.Build(WithStrictEvents())
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: evGo, Payload: "x"}); !errors.Is(err, ErrNoTransition) {
+		t.Errorf("no branch should leave the event unhandled, got %v", err)
+	}
+	if err := m.SendSync(Event{ID: evGo, Payload: "bogus"}); err == nil {
+		t.Error("undeclared branch should fail")
+	}
+
+	m.SendSync(Event{ID: evGo, Payload: "c"})
+	if m.CurrentState() != stateC {
+		t.Errorf("expected state %s, got %s", stateC, m.CurrentState())
+	}
+
+	m.SendSync(Event{ID: evBack})
+	m.SendSync(Event{ID: evGo, Payload: "b"})
+	if m.CurrentState() != stateB {
+		t.Errorf("expected state %s, got %s", stateB, m.CurrentState())
+	}
+
+	invalid := NewDefinition().
+		State(stateA).
+		SwitchTransition(stateA, evGo, func(any) StateID { return "" }, []StateID{"missing"}).
+		Initial(stateA)
+	if _, err := invalid.Build(); err == nil {
+		t.Error("expected error for undefined branch")
+	}
+}
+
+func TestEventlessTransition(t *testing.T) {
+	type prereqs struct {
+		Network bool
+		GPS     bool
+	}
+	data := &prereqs{}
+
+	def := NewDefinition().
+		State(stateInit).
+		State(stateA).
+		State(stateB).
+		EventlessTransition(stateInit, stateA).
+		EventlessTransition(stateA, stateB, WithGuard(func(c *GuardContext) bool {
+			p := c.Data.(*prereqs)
+			return p.Network && p.GPS
+		})).
+		Initial(stateInit)
+
+	m, err := def.Build(WithData(data))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if m.CurrentState() != stateA {
+		t.Fatalf("unguarded eventless transition should fire on start, got %s", m.CurrentState())
+	}
+
+	data.Network = true
+	m.SendSync(Event{ID: "network_up"})
+	if m.CurrentState() != stateA {
+		t.Fatalf("guard should still block, got %s", m.CurrentState())
+	}
+
+	data.GPS = true
+	m.SendSync(Event{ID: "gps_fix"})
+	if m.CurrentState() != stateB {
+		t.Errorf("expected state %s once prerequisites are met, got %s", stateB, m.CurrentState())
+	}
+
+	if _, err := NewDefinition().State(stateA).EventlessTransition(WildcardState, stateA).Initial(stateA).Build(); err == nil {
+		t.Error("expected build error for an eventless transition from any state")
+	}
+}
+
+func TestSelfTransition(t *testing.T) {
+	var entries, exits, actions int32
+
+	def := NewDefinition().
+		State(stateA,
+			WithOnEnter(func(c *Context) error {
+				atomic.AddInt32(&entries, 1)
+				return nil
+			}),
+			WithOnExit(func(c *Context) error {
+				atomic.AddInt32(&exits, 1)
+				return nil
+			}),
+		).
+		SelfTransition(stateA, evNext, TransitionInternal, WithAction(func(c *Context) error {
+			atomic.AddInt32(&actions, 1)
+			return nil
+		})).
+		SelfTransition(stateA, evBack, TransitionExternal).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evNext})
+	if entries != 1 || exits != 0 || actions != 1 {
+		t.Errorf("internal: expected entries=1 exits=0 actions=1, got %d/%d/%d", entries, exits, actions)
+	}
+
+	m.SendSync(Event{ID: evBack})
+	if entries != 2 || exits != 1 {
+		t.Errorf("external: expected entries=2 exits=1, got %d/%d", entries, exits)
+	}
+	if m.CurrentState() != stateA {
+		t.Errorf("expected state %s, got %s", stateA, m.CurrentState())
+	}
+}
+
+func TestExternalSelfTransitionFromChild(t *testing.T) {
+	var entries []StateID
+
+	record := func(id StateID) func(*Context) error {
+		return func(c *Context) error {
+			entries = append(entries, id)
+			return nil
+		}
+	}
+
+	def := NewDefinition().
+		State(stateParent, WithDefaultChild(stateChild1), WithOnEnter(record(stateParent))).
+		State(stateChild1, WithParent(stateParent), WithOnEnter(record(stateChild1))).
+		State(stateChild2, WithParent(stateParent), WithOnEnter(record(stateChild2))).
+		Transition(stateChild1, evNext, stateChild2).
+		SelfTransition(stateParent, evBack, TransitionExternal).
+		Initial(stateParent)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evNext})
+	entries = nil
+
+	m.SendSync(Event{ID: evBack})
+	if len(entries) != 2 || entries[0] != stateParent || entries[1] != stateChild1 {
+		t.Errorf("expected parent re-entry into default child, got %v", entries)
+	}
+}
+
+func TestInitialFunc(t *testing.T) {
+	resume := stateB
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		InitialFunc(func(c *Context) StateID {
+			return resume
+		}).
+		Initial(stateA)
+
+	for _, tt := range []struct {
+		resume StateID
+		want   StateID
+	}{
+		{resume: stateB, want: stateB},
+		{resume: "", want: stateA},
+	} {
+		resume = tt.resume
+
+		m, err := def.Build()
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+		if m.CurrentState() != tt.want {
+			t.Errorf("resume=%q: expected state %s, got %s", tt.resume, tt.want, m.CurrentState())
+		}
+		m.Stop()
+	}
+
+	resume = "bogus"
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err == nil {
+		t.Error("expected error for unknown initial state")
+		m.Stop()
+	}
+}
+
+func TestGuardErr(t *testing.T) {
+	sysfsErr := errors.New("sysfs read failed")
+	var readErr error
+	var handled error
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateB, WithGuardErr(func(c *GuardContext) (bool, error) {
+			return readErr == nil, readErr
+		})).
+		Transition(stateA, evGo, stateC).
+		Initial(stateA)
+
+	m, err := def.Build(WithErrorHandler(func(event Event, err error) {
+		handled = err
+	}))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	readErr = sysfsErr
+	if err := m.SendSync(Event{ID: evGo}); !errors.Is(err, sysfsErr) {
+		t.Errorf("expected guard error, got %v", err)
+	}
+	if !errors.Is(handled, sysfsErr) {
+		t.Errorf("expected error handler to see guard error, got %v", handled)
+	}
+	if m.CurrentState() != stateA {
+		t.Errorf("guard error must not fall through, got %s", m.CurrentState())
+	}
+
+	readErr = nil
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateB {
+		t.Errorf("expected state %s, got %s", stateB, m.CurrentState())
+	}
+
+	// WithGuards replaces an earlier guard of any kind
+	reject := func(*GuardContext) bool { return false }
+	def2 := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB,
+			WithNamedGuard("kickstand_up", reject),
+			WithGuardErr(func(*GuardContext) (bool, error) { return true, nil }),
+			WithGuards(reject)).
+		Initial(stateA)
+	m2, err := def2.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if tr := def2.transitions[0]; tr.GuardErr != nil || tr.GuardName != "" {
+		t.Errorf("expected WithGuards to clear the earlier guard, got name %q", tr.GuardName)
+	}
+	if err := m2.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m2.Stop()
+	m2.SendSync(Event{ID: evGo})
+	if m2.CurrentState() != stateA {
+		t.Errorf("expected the last guard option to win, got %s", m2.CurrentState())
+	}
+
+	if _, err := NewDefinition().State(stateA).Transition(stateA, evGo, stateA, WithGuards(reject, nil)).Initial(stateA).Build(); err == nil {
+		t.Error("expected build error for a nil guard in WithGuards")
+	}
+}
+
+func TestTraversalHooksAndOrder(t *testing.T) {
+	var trace []string
+
+	record := func(prefix string, id StateID) func(*Context) error {
+		return func(c *Context) error {
+			trace = append(trace, prefix+string(id))
+			return nil
+		}
+	}
+	hook := func(name string) func(*Context, []StateID) {
+		return func(c *Context, states []StateID) {
+			trace = append(trace, fmt.Sprintf("%s%v", name, states))
+		}
+	}
+
+	def := NewDefinition().
+		State(stateParent, WithDefaultChild(stateChild1),
+			WithOnEnter(record("+", stateParent)), WithOnExit(record("-", stateParent))).
+		State(stateChild1, WithParent(stateParent),
+			WithOnEnter(record("+", stateChild1)), WithOnExit(record("-", stateChild1))).
+		State(stateChild2, WithParent(stateParent),
+			WithOnEnter(record("+", stateChild2)), WithOnExit(record("-", stateChild2))).
+		State(stateA, WithOnEnter(record("+", stateA)), WithOnExit(record("-", stateA))).
+		Transition(stateChild1, evGo, stateA).
+		Transition(stateA, evBack, stateChild2).
+		Initial(stateParent)
+
+	m, err := def.Build(
+		WithExitOrder(ParentFirst),
+		WithEntryOrder(ChildFirst),
+		WithTraversalHooks(TraversalHooks{
+			BeforeExit:  hook("before-exit"),
+			AfterExit:   hook("after-exit"),
+			BeforeEnter: hook("before-enter"),
+			AfterEnter:  hook("after-enter"),
+		}),
+	)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	trace = nil
+	m.SendSync(Event{ID: evGo})
+	want := []string{"before-exit[parent child1]", "-parent", "-child1", "after-exit[parent child1]",
+		"before-enter[a]", "+a", "after-enter[a]"}
+	if fmt.Sprint(trace) != fmt.Sprint(want) {
+		t.Errorf("exit trace:\n got %v\nwant %v", trace, want)
+	}
+
+	// Entering child2 directly must not pass through the parent's default child
+	trace = nil
+	m.SendSync(Event{ID: evBack})
+	want = []string{"before-exit[a]", "-a", "after-exit[a]",
+		"before-enter[parent child2]", "+child2", "+parent", "after-enter[parent child2]"}
+	if fmt.Sprint(trace) != fmt.Sprint(want) {
+		t.Errorf("entry trace:\n got %v\nwant %v", trace, want)
+	}
+	if m.CurrentState() != stateChild2 {
+		t.Errorf("expected state %s, got %s", stateChild2, m.CurrentState())
+	}
+}
+
+func TestExitDeadline(t *testing.T) {
+	var parentExited int32
+	handled := make(chan error, 1)
+
+	def := NewDefinition().
+		State(stateParent, WithDefaultChild(stateChild1),
+			WithOnExit(func(c *Context) error {
+				atomic.AddInt32(&parentExited, 1)
+				return nil
+			}),
+		).
+		State(stateChild1, WithParent(stateParent),
+			WithOnExit(func(c *Context) error {
+				<-c.Context().Done()
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			}),
+		).
+		State(stateB).
+		Transition(stateParent, evGo, stateB).
+		Initial(stateParent)
+
+	m, err := def.Build(
+		WithExitDeadline(20*time.Millisecond),
+		WithErrorHandler(func(event Event, err error) {
+			handled <- err
+		}),
+	)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	start := time.Now()
+	if err := m.SendSync(Event{ID: evGo}); err != nil {
+		t.Fatalf("transition should complete despite the deadline: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("exit chain not bounded: took %s", elapsed)
+	}
+	if m.CurrentState() != stateB {
+		t.Errorf("expected state %s, got %s", stateB, m.CurrentState())
+	}
+	if atomic.LoadInt32(&parentExited) != 0 {
+		t.Error("parent exit action should have been skipped")
+	}
+
+	select {
+	case err := <-handled:
+		if !errors.Is(err, ErrExitDeadline) {
+			t.Errorf("expected ErrExitDeadline, got %v", err)
+		}
+	default:
+		t.Error("error handler should have been invoked")
+	}
+}
+
+func TestSwapDefinition(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Transition(stateA, "later", stateB, WithDelay(20*time.Millisecond)).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	// Pending delayed transitions of the old definition are cancelled by the swap
+	m.SendSync(Event{ID: "later"})
+
+	updated := NewDefinition().
+		State(stateA, WithTimeoutTransition(30*time.Millisecond, stateC)).
+		State(stateC).
+		Initial(stateA)
+
+	if err := m.Swap(updated); err != nil {
+		t.Fatalf("swap failed: %v", err)
+	}
+
+	// The old transition is gone and the new timeout was armed
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateA {
+		t.Fatalf("old transition should no longer apply, got %s", m.CurrentState())
+	}
+	time.Sleep(60 * time.Millisecond)
+	if m.CurrentState() != stateC {
+		t.Errorf("expected re-armed timeout to reach %s, got %s", stateC, m.CurrentState())
+	}
+
+	missing := NewDefinition().State(stateA).Initial(stateA)
+	if err := m.Swap(missing); err == nil {
+		t.Error("expected error when current state is missing from new definition")
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc := []byte(`{
+		"initial": "idle",
+		"states": [
+			{"id": "idle"},
+			{"id": "running", "timeout": "20ms", "timeout_target": "done"},
+			{"id": "done", "type": "final"}
+		],
+		"transitions": [
+			{"from": "idle", "event": "start", "to": "running", "label": "go"}
+		]
+	}`)
+
+	if err := ValidateDocument(doc); err != nil {
+		t.Fatalf("expected valid document, got %v", err)
+	}
+
+	def, err := ParseDocument(doc)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: "start"})
+	time.Sleep(50 * time.Millisecond)
+	if m.CurrentState() != "done" {
+		t.Errorf("expected done, got %s", m.CurrentState())
+	}
+
+	invalid := map[string]string{
+		"unknown field":   `{"initial": "a", "states": [{"id": "a", "colour": "red"}]}`,
+		"bad duration":    `{"initial": "a", "states": [{"id": "a", "timeout": "soon", "timeout_event": "t"}]}`,
+		"unknown type":    `{"initial": "a", "states": [{"id": "a", "type": "parallel"}]}`,
+		"undefined state": `{"initial": "a", "states": [{"id": "a"}], "transitions": [{"from": "a", "event": "e", "to": "b"}]}`,
+		"no initial":      `{"states": [{"id": "a"}]}`,
+	}
+	for name, src := range invalid {
+		if err := ValidateDocument([]byte(src)); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+
+	var schema struct {
+		Defs struct {
+			Duration struct {
+				Pattern string `json:"pattern"`
+			} `json:"duration"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(DocumentSchema(), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	// The schema accepts the timeouts the document loader accepts
+	pattern := regexp.MustCompile(schema.Defs.Duration.Pattern)
+	for _, d := range []string{"500ms", "1m30s", "1.5h", ".5s", "1.s", "0s1ms", "3µs", "0", "0s", "0.0ms", "-2s", "+1s", "", "5", "1d", "s", "1.5.5s", "00"} {
+		src := fmt.Sprintf(`{"initial": "a", "states": [{"id": "a", "timeout": %q, "timeout_target": "a"}]}`, d)
+		err := ValidateDocument([]byte(src))
+		if pattern.MatchString(d) != (err == nil) {
+			t.Errorf("schema and loader disagree on timeout %q: %v", d, err)
+		}
+	}
+}
+
+func TestRecentEvents(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+
+	m, err := def.Build(WithEventLog(2))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evBack})
+	m.SendSync(Event{ID: evGo, Payload: 42})
+	m.SendSync(Event{ID: evBack})
+
+	events := m.RecentEvents(0)
+	if len(events) != 2 {
+		t.Fatalf("expected log bounded to 2 entries, got %d", len(events))
+	}
+	if events[0].ID != evGo || events[0].Outcome != EventAccepted || events[0].Payload != "42" || events[0].State != stateA {
+		t.Errorf("unexpected first record: %+v", events[0])
+	}
+	if events[1].ID != evBack || events[1].Outcome != EventUnhandled || events[1].State != stateB {
+		t.Errorf("unexpected second record: %+v", events[1])
+	}
+
+	if last := m.RecentEvents(1); len(last) != 1 || last[0].ID != evBack {
+		t.Errorf("expected only the newest record, got %+v", last)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	def := NewDefinition().
+		State(stateParent, WithDefaultChild(stateChild1)).
+		State(stateChild1, WithParent(stateParent), WithOnEnter(func(*Context) error { return nil })).
+		State(stateChild2, WithParent(stateParent), WithTimeout(time.Second, evTimeout)).
+		Transition(stateChild1, evGo, stateChild2, When(NamedGuard("ready", func(*GuardContext) bool { return true }))).
+		Transition(stateChild2, evBack, stateChild1, WithGuard(func(*GuardContext) bool { return true }), WithLabel("back")).
+		Initial(stateParent)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	desc := m.Describe()
+	if desc.Initial != stateParent || len(desc.States) != 3 || len(desc.Transitions) != 2 {
+		t.Fatalf("unexpected description: %+v", desc)
+	}
+
+	parent := desc.States[2]
+	if parent.ID != stateParent || len(parent.Children) != 2 || parent.DefaultChild != stateChild1 {
+		t.Errorf("unexpected parent description: %+v", parent)
+	}
+	if !desc.States[0].HasEntry || desc.States[1].Timeout != "1s" {
+		t.Errorf("unexpected child descriptions: %+v", desc.States[:2])
+	}
+	if desc.Transitions[0].Guard != "ready" || desc.Transitions[1].Guard != "<anonymous>" || desc.Transitions[1].Label != "back" {
+		t.Errorf("unexpected transition descriptions: %+v", desc.Transitions)
+	}
+
+	if _, err := json.Marshal(desc); err != nil {
+		t.Errorf("description not serializable: %v", err)
+	}
+}
+
+func TestLastTransition(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if _, ok := m.LastTransition(); ok {
+		t.Error("expected no transition before any event")
+	}
+
+	before := time.Now()
+	m.SendSync(Event{ID: evGo})
+
+	info, ok := m.LastTransition()
+	if !ok {
+		t.Fatal("expected a recorded transition")
+	}
+	if info.From != stateA || info.To != stateB || info.Event != evGo || info.Time.Before(before) {
+		t.Errorf("unexpected transition info: %+v", info)
+	}
+
+	if err := m.SetState(stateA); err != nil {
+		t.Fatalf("SetState failed: %v", err)
+	}
+	if info, _ := m.LastTransition(); info.From != stateB || info.To != stateA || info.Event != "" {
+		t.Errorf("unexpected transition info after SetState: %+v", info)
+	}
+}
+
+func TestFlapDetection(t *testing.T) {
+	flaps := make(chan Flap, 1)
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evBack, stateA).
+		AnyStateTransition("flap", stateA, WithAction(func(c *Context) error {
+			flaps <- c.Event.Payload.(Flap)
+			return nil
+		})).
+		Initial(stateA)
+
+	m, err := def.Build(WithFlapDetection(stateB, 3, time.Second, "flap"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	for i := 0; i < 3; i++ {
+		m.SendSync(Event{ID: evGo})
+		m.SendSync(Event{ID: evBack})
+	}
+
+	select {
+	case f := <-flaps:
+		if f.State != stateB || f.Entries != 3 {
+			t.Errorf("unexpected flap payload: %+v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected flap event")
+	}
+
+	if n := m.EntryCount(stateB); n != 3 {
+		t.Errorf("expected 3 entries of %s, got %d", stateB, n)
+	}
+	if counts := m.EntryCounts(); counts[stateA] < 4 {
+		t.Errorf("expected at least 4 entries of %s, got %d", stateA, counts[stateA])
+	}
+}
+
+func TestEventDebounceAndThrottle(t *testing.T) {
+	var kicks, ticks atomic.Int32
+	var lastPayload atomic.Value
+	def := NewDefinition().
+		State(stateA).
+		Transition(stateA, "kickstand", stateA, WithAction(func(c *Context) error {
+			kicks.Add(1)
+			lastPayload.Store(c.Event.Payload)
+			return nil
+		})).
+		Transition(stateA, "tick", stateA, WithAction(func(c *Context) error {
+			ticks.Add(1)
+			return nil
+		})).
+		Initial(stateA)
+
+	m, err := def.Build(
+		WithEventDebounce("kickstand", 30*time.Millisecond),
+		WithEventThrottle("tick", time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	for i := 0; i < 5; i++ {
+		m.Send(Event{ID: "kickstand", Payload: i})
+		m.Send(Event{ID: "tick"})
+	}
+	time.Sleep(80 * time.Millisecond)
+
+	if n := kicks.Load(); n != 1 {
+		t.Errorf("expected 1 debounced event, got %d", n)
+	}
+	if p := lastPayload.Load(); p != 4 {
+		t.Errorf("expected latest payload 4, got %v", p)
+	}
+	if n := ticks.Load(); n != 1 {
+		t.Errorf("expected 1 throttled event, got %d", n)
+	}
+}
+
+func TestEventCoalescing(t *testing.T) {
+	release := make(chan struct{})
+	var updates []any
+	def := NewDefinition().
+		State(stateA).
+		Transition(stateA, "busy", stateA, WithAction(func(c *Context) error {
+			<-release
+			return nil
+		})).
+		Transition(stateA, "sensor", stateA, WithAction(func(c *Context) error {
+			updates = append(updates, c.Event.Payload)
+			return nil
+		})).
+		Initial(stateA)
+
+	m, err := def.Build(WithEventCoalescing("sensor"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.Send(Event{ID: "busy"})
+	for i := 0; i < 5; i++ {
+		m.Send(Event{ID: "sensor", Payload: i})
+	}
+	close(release)
+	m.SendSync(Event{ID: "sync"})
+
+	if len(updates) != 1 || updates[0] != 4 {
+		t.Errorf("expected a single update with the latest payload, got %v", updates)
+	}
+}
+
+func TestDelayedTransition(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, "released", stateB, WithDelay(40*time.Millisecond)).
+		Transition(stateA, evGo, stateC).
+		Transition(stateC, evBack, stateA).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: "released"})
+	if m.CurrentState() != stateA {
+		t.Fatalf("transition should be delayed, got %s", m.CurrentState())
+	}
+	time.Sleep(80 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected delayed transition to %s, got %s", stateB, m.CurrentState())
+	}
+
+	// Another transition firing first cancels the pending one
+	if err := m.SetState(stateA); err != nil {
+		t.Fatalf("SetState failed: %v", err)
+	}
+	m.SendSync(Event{ID: "released"})
+	m.SendSync(Event{ID: evGo})
+	m.SendSync(Event{ID: evBack})
+	time.Sleep(80 * time.Millisecond)
+	if m.CurrentState() != stateA {
+		t.Errorf("expected cancelled delayed transition, got %s", m.CurrentState())
+	}
+
+	if _, err := NewDefinition().State(stateA).Transition(stateA, evGo, stateA, WithDelay(0)).Initial(stateA).Build(); err == nil {
+		t.Error("expected error for non-positive delay")
+	}
+}
+
+func TestSendAt(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, "hibernate", stateC).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	now := time.Now()
+	m.SendAt(now.Add(40*time.Millisecond), Event{ID: "hibernate"})
+	m.SendAt(now.Add(-time.Hour), Event{ID: evGo}) // Missed while offline: fires immediately
+
+	pending := m.ScheduledEvents()
+	if len(pending) != 1 || pending[0].Event.ID != "hibernate" {
+		t.Errorf("unexpected pending schedule: %+v", pending)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if m.CurrentState() != stateC {
+		t.Errorf("expected %s after scheduled events, got %s", stateC, m.CurrentState())
+	}
+	if pending := m.ScheduledEvents(); len(pending) != 0 {
+		t.Errorf("expected empty schedule, got %+v", pending)
+	}
+}
+
+func TestTimeInState(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB, WithGuard(func(c *GuardContext) bool {
+			return c.TimeInState() >= 30*time.Millisecond
+		})).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateA {
+		t.Fatalf("guard should reject before hysteresis elapsed, got %s", m.CurrentState())
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateB {
+		t.Errorf("expected %s after hysteresis, got %s", stateB, m.CurrentState())
+	}
+}
+
+func TestControlLane(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []EventID
+	record := func(c *Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, c.Event.ID)
+		return nil
+	}
+	def := NewDefinition().
+		State(stateA).
+		Transition(stateA, "busy", stateA, WithAction(func(c *Context) error {
+			<-release
+			return nil
+		})).
+		Transition(stateA, "sensor", stateA, WithAction(record)).
+		Transition(stateA, "emergency", stateA, WithAction(record)).
+		Transition(stateA, "kill", stateA, WithAction(record)).
+		Initial(stateA)
+
+	m, err := def.Build(WithEventQueueSize(4), WithControlEvents("kill"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.Send(Event{ID: "busy"})
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		m.Send(Event{ID: "sensor"}) // Floods the regular queue
+	}
+	m.SendControl(Event{ID: "emergency"})
+	m.Send(Event{ID: "kill"})
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) < 2 || order[0] != "emergency" || order[1] != "kill" {
+		t.Errorf("expected control events first, got %v", order)
+	}
+}
+
+func TestInterruptEvents(t *testing.T) {
+	started := make(chan struct{})
+	errs := make(chan error, 1)
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, "upload", stateB, WithAction(func(c *Context) error {
+			close(started)
+			<-c.Context().Done()
+			return c.Context().Err()
+		})).
+		Transition(stateA, "brake", stateC).
+		Initial(stateA)
+
+	m, err := def.Build(
+		WithInterruptEvents("brake"),
+		WithErrorHandler(func(ev Event, err error) { errs <- err }),
+	)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.Send(Event{ID: "upload"})
+	<-started
+	if err := m.SendSync(Event{ID: "brake"}); err != nil {
+		t.Fatalf("brake failed: %v", err)
+	}
+
+	if m.CurrentState() != stateC {
+		t.Errorf("expected %s after interrupt, got %s", stateC, m.CurrentState())
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrActionInterrupted) {
+			t.Errorf("expected ErrActionInterrupted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected interrupted action to be reported")
+	}
+}
+
+func TestCancelledActionAwaited(t *testing.T) {
+	var finished atomic.Int32
+	started := make(chan struct{}, 2)
+	slowStop := func(c *Context) error {
+		started <- struct{}{}
+		<-c.Context().Done()
+		time.Sleep(20 * time.Millisecond) // Cleanup after cancellation
+		finished.Add(1)
+		return c.Context().Err()
+	}
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, "upload", stateB, WithAction(slowStop)).
+		Transition(stateA, "brake", stateC).
+		Transition(stateC, "reset", stateA).
+		Transition(stateA, "sync", stateB, WithAction(slowStop)).
+		Initial(stateA)
+
+	m, err := def.Build(WithInterruptEvents("brake"), WithActionTimeout(10*time.Millisecond, ActionTimeoutFail))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	// An interrupted action has returned before the interrupt is processed
+	m.Send(Event{ID: "upload"})
+	<-started
+	if err := m.SendSync(Event{ID: "brake"}); err != nil {
+		t.Fatalf("brake failed: %v", err)
+	}
+	if finished.Load() != 1 {
+		t.Error("expected interrupted action to return before the interrupt was processed")
+	}
+
+	// So has an action that timed out
+	m.SendSync(Event{ID: "reset"})
+	if err := m.SendSync(Event{ID: "sync"}); !errors.Is(err, ErrActionTimeout) {
+		t.Fatalf("expected ErrActionTimeout, got %v", err)
+	}
+	if finished.Load() != 2 {
+		t.Error("expected timed out action to return before SendSync")
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	var atExit any
+	def := NewDefinition().
+		State(stateParent, WithDefaultChild(stateChild1),
+			WithOnEnter(func(c *Context) error {
+				c.StateStore().Set("session", "parent-session")
+				return nil
+			}),
+			WithOnExit(func(c *Context) error {
+				atExit, _ = c.StateStore().Get("session")
+				return nil
+			})).
+		State(stateChild1, WithParent(stateParent), WithOnEnter(func(c *Context) error {
+			c.StateStore().Set("session", "child-session")
+			return nil
+		})).
+		State(stateB).
+		Transition(stateParent, evGo, stateB).
+		Transition(stateB, evBack, stateParent).
+		Initial(stateParent)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	if atExit != "parent-session" {
+		t.Errorf("expected exit action to see the parent's value, got %v", atExit)
+	}
+	if _, ok := m.stateStore(stateParent).Get("session"); ok {
+		t.Error("expected store to be cleared on exit")
+	}
+}
+
+func TestVars(t *testing.T) {
+	release := make(chan struct{})
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		EventlessTransition(stateA, stateB, WithGuard(func(c *GuardContext) bool {
+			v, _ := c.Var("speed")
+			return v == 0
+		})).
+		SelfTransition(stateA, "hold", TransitionInternal, WithAction(func(c *Context) error {
+			<-release
+			return nil
+		})).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	m.SetVar("speed", 20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if m.CurrentState() != stateA {
+		t.Fatalf("guard should hold while moving, got %s", m.CurrentState())
+	}
+
+	m.SetVar("speed", 0)
+	time.Sleep(20 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Errorf("expected variable change to trigger eventless transition, got %s", m.CurrentState())
+	}
+
+	if v, ok := m.GetVar("speed"); !ok || v != 0 {
+		t.Errorf("unexpected variable value %v", v)
+	}
+	if vars := m.Vars(); len(vars) != 1 {
+		t.Errorf("unexpected variables %v", vars)
+	}
+
+	// A change is not lost when the queue is full
+	m2, err := def.Build(WithEventQueueSize(1))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	m2.SetVar("speed", 20)
+	if err := m2.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m2.Stop()
+	time.Sleep(10 * time.Millisecond)
+	m2.Send(Event{ID: "hold"})
+	time.Sleep(10 * time.Millisecond)
+	m2.Send(Event{ID: "noise"})
+	for i := 1; i <= 100; i++ {
+		m2.SetVar("speed", i)
+	}
+	m2.SetVar("speed", 0)
+	if depth := m2.Stats().QueueDepth; depth != 2 {
+		t.Errorf("expected pending changes of a variable to be merged, queue depth %d", depth)
+	}
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if s := m2.Stats(); s.EventsDropped != 0 || m2.CurrentState() != stateB {
+		t.Errorf("expected variable change to be queued despite the full queue, dropped %d, state %s", s.EventsDropped, m2.CurrentState())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next TransitionFunc) TransitionFunc {
+			return func(c *Context) error {
+				calls = append(calls, fmt.Sprintf("%s:%s->%s", name, c.FromState, c.ToState))
+				return next(c)
+			}
+		}
+	}
+	deny := func(next TransitionFunc) TransitionFunc {
+		return func(c *Context) error {
+			if c.Event != nil && c.Event.Payload == "remote" {
+				return errors.New("unauthorized")
+			}
+			return next(c)
+		}
+	}
+
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evBack, stateA).
+		Initial(stateA)
+
+	m, err := def.Build(WithMiddleware(trace("outer"), trace("inner"), deny))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: evGo}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.SendSync(Event{ID: evBack, Payload: "remote"}); err == nil {
+		t.Error("expected middleware to reject the event")
+	}
+	if m.CurrentState() != stateB {
+		t.Errorf("expected rejected transition to leave state %s, got %s", stateB, m.CurrentState())
+	}
+
+	want := []string{"outer:a->b", "inner:a->b", "outer:b->a", "inner:b->a"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("expected middleware calls %v, got %v", want, calls)
+	}
+}
+
+func TestLint(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB, WithTimeout(time.Second, evTimeout)).
+		State(stateC).
+		State(stateInit).
+		Transition(stateA, evGo, stateB, WithGuard(func(*GuardContext) bool { return true })).
+		Transition(stateInit, evBack, stateA).
+		Initial(stateA)
+
+	rules := map[string]StateID{}
+	for _, f := range def.Lint() {
+		rules[f.Rule+":"+string(f.State)] = f.State
+	}
+
+	for _, want := range []string{
+		"dead-end:" + string(stateC),
+		"unnamed-guard:" + string(stateA),
+		"unhandled-timeout:" + string(stateB),
+		"unreachable:" + string(stateC),
+		"unreachable:" + string(stateInit),
+	} {
+		if _, ok := rules[want]; !ok {
+			t.Errorf("expected finding %s, got %v", want, def.Lint())
+		}
+	}
+	composite := NewDefinition().
+		State(stateParent).
+		State(stateChild1, WithParent(stateParent)).
+		Initial(stateParent)
+	if findings := composite.Lint(LintMissingDefaultChild); len(findings) != 1 || findings[0].State != stateParent {
+		t.Errorf("expected missing default child finding, got %v", findings)
+	}
+	if _, ok := rules["dead-end:"+string(stateB)]; ok {
+		t.Error("state with a timeout should not be a dead end")
+	}
+
+	custom := LintRule{
+		Name:     "no-init",
+		Severity: SeverityError,
+		Check: func(d Description) []LintFinding {
+			return []LintFinding{{State: stateInit, Message: "init is banned"}}
+		},
+	}
+	findings := def.Lint(custom, LintUnnamedGuards.WithSeverity(SeverityError))
+	if len(findings) != 2 || findings[0].Severity != SeverityError || findings[1].Rule != "unnamed-guard" {
+		t.Errorf("unexpected custom findings: %v", findings)
+	}
+}
+
+func TestValidationReport(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB, WithParent("missing")).
+		State(stateParent).
+		State(stateChild1, WithParent(stateParent)).
+		State("").
+		Transition(stateA, evGo, stateC).
+		Transition(stateA, evBack, stateB).
+		Initial(stateA)
+
+	r := def.Report()
+	if !r.HasErrors() {
+		t.Fatal("expected errors")
+	}
+	if n := len(r.Errors()); n != 3 {
+		t.Errorf("expected 3 errors (builder, undefined parent, undefined target), got %d:\n%s", n, r)
+	}
+	if len(r.Warnings()) == 0 {
+		t.Errorf("expected lint warnings, got none:\n%s", r)
+	}
+	if r.Issues[0].Severity != SeverityError {
+		t.Error("expected errors to be listed first")
+	}
+
+	var located bool
+	for _, issue := range r.Errors() {
+		if issue.State == stateB && strings.Contains(issue.Location, "fsm_test.go:") {
+			located = true
+		}
+	}
+	if !located {
+		t.Errorf("expected the undefined parent to point at the State call:\n%s", r)
+	}
+
+	if err := r.Err(); err == nil || !strings.Contains(err.Error(), "undefined parent") {
+		t.Errorf("expected joined error, got %v", err)
+	}
+}
+
+func TestSCXMLSemantics(t *testing.T) {
+	var trace []string
+	record := func(s string) func(*Context) error {
+		return func(*Context) error {
+			trace = append(trace, s)
+			return nil
+		}
+	}
+
+	def := NewDefinition().
+		State(stateParent, WithDefaultChild(stateChild1), WithOnEnter(record("+parent")), WithOnExit(record("-parent"))).
+		State(stateChild1, WithParent(stateParent)).
+		FinalState(stateFinal, WithParent(stateParent)).
+		State(stateB).
+		State(stateC).
+		Transition(stateChild1, EventPrefix("go"), stateB).
+		Transition(stateChild1, "go.now", stateC).
+		Transition(stateParent, evBack, stateChild1).
+		Transition(stateChild1, evDone, stateFinal).
+		Transition(stateParent, DoneEvent(stateParent), stateC).
+		Initial(stateParent)
+
+	run := func(opts ...MachineOption) *Machine {
+		m, err := def.Build(opts...)
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+		t.Cleanup(func() { m.Stop() })
+		return m
+	}
+
+	// Native semantics prefer the exact match; SCXML uses document order
+	native := run()
+	native.SendSync(Event{ID: "go.now"})
+	if native.CurrentState() != stateC {
+		t.Errorf("native: expected exact match to %s, got %s", stateC, native.CurrentState())
+	}
+
+	m := run(WithSemantics(SemanticsSCXML))
+	m.SendSync(Event{ID: "go.now"})
+	if m.CurrentState() != stateB {
+		t.Errorf("scxml: expected document order to pick %s, got %s", stateB, m.CurrentState())
+	}
+
+	// A transition from the parent to its own child exits and re-enters the parent
+	m = run(WithSemantics(SemanticsSCXML))
+	trace = nil
+	m.SendSync(Event{ID: evBack})
+	if fmt.Sprint(trace) != "[-parent +parent]" {
+		t.Errorf("scxml: expected parent to be re-entered, got %v", trace)
+	}
+
+	// Entering a final child raises the parent's done event
+	m.SendSync(Event{ID: evDone})
+	m.SendSync(Event{ID: "sync"})
+	if m.CurrentState() != stateC {
+		t.Errorf("scxml: expected done event to reach %s, got %s", stateC, m.CurrentState())
+	}
+}
+
+func TestTimerEventOrdering(t *testing.T) {
+	run := func(opts ...MachineOption) []EventID {
+		release := make(chan struct{})
+		def := NewDefinition().
+			State(stateA).
+			Transition(stateA, "busy", stateA, WithAction(func(c *Context) error {
+				<-release
+				return nil
+			})).
+			Transition(stateA, "external", stateA).
+			Transition(stateA, "tick", stateA).
+			Initial(stateA)
+
+		m, err := def.Build(append([]MachineOption{WithEventQueueSize(1)}, opts...)...)
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+		defer m.Stop()
+
+		m.Send(Event{ID: "busy"})
+		time.Sleep(10 * time.Millisecond)
+		m.Send(Event{ID: "external"}) // Fills the queue
+		m.StartTimer("tick", time.Millisecond, Event{ID: "tick"})
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		time.Sleep(10 * time.Millisecond)
+
+		var ids []EventID
+		for _, rec := range m.RecentEvents(0) {
+			if rec.Outcome == EventDropped {
+				t.Errorf("event %s was dropped", rec.ID)
+			}
+			ids = append(ids, rec.ID)
+		}
+		return ids
+	}
+
+	if got := fmt.Sprint(run()); got != "[busy external tick]" {
+		t.Errorf("expected timer event in firing order, got %s", got)
+	}
+	if got := fmt.Sprint(run(WithTimerPriority())); got != "[busy tick external]" {
+		t.Errorf("expected prioritized timer event first, got %s", got)
+	}
+}
+
+func TestSendSequence(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, "auth_ok", stateB).
+		Transition(stateB, "session_start", stateC).
+		Transition(stateB, "telemetry", stateA).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			m.Send(Event{ID: "telemetry"})
+		}
+	}()
+	m.SendSequence(Event{ID: "auth_ok"}, Event{ID: "session_start"})
+	<-done
+	m.SendSync(Event{ID: "sync"})
+
+	if m.CurrentState() != stateC {
+		t.Errorf("expected handshake to complete in %s, got %s", stateC, m.CurrentState())
+	}
+
+	var seqs []uint64
+	for _, rec := range m.RecentEvents(0) {
+		if rec.ID == "auth_ok" || rec.ID == "session_start" {
+			seqs = append(seqs, rec.Seq)
+		}
+	}
+	if len(seqs) != 2 || seqs[0] != seqs[1] {
+		t.Errorf("expected both events to share one queue slot, got %v", seqs)
+	}
+}
+
+func TestChildEntryGuard(t *testing.T) {
+	var handshakes atomic.Int32
+	def := NewDefinition().
+		State(stateA).
+		State(stateParent,
+			WithDefaultChild(stateChild1),
+			WithChildEntryGuard(func(c *GuardContext) bool {
+				ready, _ := c.Var("ecu_ready")
+				return ready == true
+			}, "start_handshake")).
+		State(stateChild1, WithParent(stateParent)).
+		Transition(stateA, evGo, stateParent).
+		SelfTransition(stateParent, "start_handshake", TransitionInternal, WithAction(func(*Context) error {
+			handshakes.Add(1)
+			return nil
+		})).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	m.SendSync(Event{ID: "sync"})
+	if m.CurrentState() != stateParent {
+		t.Fatalf("expected to hold in %s, got %s", stateParent, m.CurrentState())
+	}
+	if handshakes.Load() != 1 {
+		t.Errorf("expected hold event once, got %d", handshakes.Load())
+	}
+
+	m.SetVar("ecu_ready", true)
+	m.SendSync(Event{ID: "sync"})
+	if m.CurrentState() != stateChild1 {
+		t.Errorf("expected deferred child %s to be entered, got %s", stateChild1, m.CurrentState())
+	}
+}
+
+func TestRandomChoiceState(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		RandomChoiceState(stateCond, []Weighted{{stateB, 3}, {stateC, 1}}).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateCond).
+		Transition(stateB, evBack, stateA).
+		Transition(stateC, evBack, stateA).
+		Initial(stateA)
+
+	sample := func(seed int64) map[StateID]int {
+		m, err := def.Build(WithRandSource(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+		defer m.Stop()
+
+		counts := make(map[StateID]int)
+		for i := 0; i < 400; i++ {
+			m.SendSync(Event{ID: evGo})
+			counts[m.CurrentState()]++
+			m.SendSync(Event{ID: evBack})
+		}
+		return counts
+	}
+
+	counts := sample(42)
+	if counts[stateB] < 250 || counts[stateC] < 50 || counts[stateB]+counts[stateC] != 400 {
+		t.Errorf("expected roughly 3:1 split, got %v", counts)
+	}
+	if again := sample(42); fmt.Sprint(again) != fmt.Sprint(counts) {
+		t.Errorf("expected identical runs for the same seed, got %v and %v", counts, again)
+	}
+
+	if _, err := NewDefinition().RandomChoiceState(stateCond, []Weighted{{stateA, 0}}).State(stateA).Initial(stateCond).Build(); err == nil {
+		t.Error("expected error for zero weight")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateA).
+		Transition(stateA, "idle", stateB).
+		Initial(stateA)
+
+	m, err := def.Build(WithIdleTimeout(40*time.Millisecond, "idle"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	// Activity keeps the watchdog from firing
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		m.SendSync(Event{ID: evGo})
+	}
+	if m.CurrentState() != stateA {
+		t.Fatalf("watchdog fired despite activity, state %s", m.CurrentState())
+	}
+
+	time.Sleep(70 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Errorf("expected idle event to reach %s, got %s", stateB, m.CurrentState())
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	beats := make(chan Heartbeat, 10)
+	def := NewDefinition().
+		State(stateA).
+		Initial(stateA)
+
+	m, err := def.Build(WithHeartbeat(10*time.Millisecond, func(hb Heartbeat) {
+		select {
+		case beats <- hb:
+		default:
+		}
+	}))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	select {
+	case hb := <-beats:
+		if hb.State != stateA || hb.QueueDepth != 0 || hb.Time.IsZero() {
+			t.Errorf("unexpected heartbeat %+v", hb)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a heartbeat")
+	}
+
+	m.Stop()
+	time.Sleep(20 * time.Millisecond)
+	for len(beats) > 0 {
+		<-beats
+	}
+	time.Sleep(30 * time.Millisecond)
+	if len(beats) != 0 {
+		t.Error("expected heartbeats to stop with the machine")
+	}
+}
+
+func TestStats(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evBack, stateA, WithAction(func(*Context) error { return errors.New("boom") })).
+		Initial(stateA)
+
+	m, err := def.Build(WithErrorHandler(func(Event, error) {}))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	m.SendSync(Event{ID: evGo}) // Unhandled in b
+	m.SendSync(Event{ID: evBack})
+
+	s := m.Stats()
+	if s.EventsProcessed != 3 || s.EventsUnhandled != 1 || s.EventsFailed != 1 {
+		t.Errorf("unexpected event counters %+v", s)
+	}
+	if s.Transitions != 2 || s.ActionErrors != 1 || s.QueueDepth != 0 || s.Uptime <= 0 {
+		t.Errorf("unexpected counters %+v", s)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var limited atomic.Int32
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, "lock", stateB).
+		Transition(stateB, "unlock", stateA).
+		Initial(stateA)
+
+	m, err := def.Build(
+		WithRateLimit(stateA, "lock", RateLimit{Max: 2, Window: time.Hour, OnLimit: func(Event, bool) { limited.Add(1) }}),
+	)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	for i := 0; i < 2; i++ {
+		m.SendSync(Event{ID: "lock"})
+		m.SendSync(Event{ID: "unlock"})
+	}
+	if err := m.SendSync(Event{ID: "lock"}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+	if m.CurrentState() != stateA || limited.Load() != 1 {
+		t.Errorf("expected third lock to be dropped, state %s, limited %d", m.CurrentState(), limited.Load())
+	}
+	if rec := m.RecentEvents(1); rec[0].Outcome != EventDropped {
+		t.Errorf("expected drop to be logged, got %s", rec[0].Outcome)
+	}
+	if r, err := m.SendSyncResult(Event{ID: "lock"}); err != nil || r.Outcome != SendRateLimited {
+		t.Errorf("expected rate-limited result, got %v, %v", r.Outcome, err)
+	}
+
+	_, err = def.Build(WithRateLimit(stateA, "lock", RateLimit{Max: 0, Window: time.Hour}))
+	var be *BuilderError
+	if !errors.As(err, &be) || be.Call != "WithRateLimit" || !strings.HasSuffix(be.File, "fsm_test.go") {
+		t.Errorf("expected build error at the WithRateLimit call, got %v", err)
+	}
+
+	// Exceed the unlock limit: the excess is deferred, not dropped
+	m2, _ := def.Build(WithRateLimit(stateB, "unlock", RateLimit{Max: 1, Window: 40 * time.Millisecond, Defer: true}))
+	if err := m2.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m2.Stop()
+	m2.SendSync(Event{ID: "lock"})
+	m2.SendSync(Event{ID: "unlock"})
+	m2.SendSync(Event{ID: "lock"})
+	m2.SendSync(Event{ID: "unlock"})
+	if m2.CurrentState() != stateB {
+		t.Fatalf("expected second unlock to be deferred, got %s", m2.CurrentState())
+	}
+	time.Sleep(80 * time.Millisecond)
+	if m2.CurrentState() != stateA {
+		t.Errorf("expected deferred unlock to be delivered, got %s", m2.CurrentState())
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateParent, WithDefaultChild(stateChild1), WithTimeout(time.Hour, evTimeout)).
+		State(stateChild1, WithParent(stateParent)).
+		Transition(stateA, evGo, stateParent).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo, Payload: 42})
+	m.SetVar("soc", 80)
+
+	data, err := m.SnapshotJSON()
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatalf("snapshot is not valid JSON: %v\n%s", err, data)
+	}
+	if snap.State != stateChild1 || len(snap.Path) != 2 || snap.Path[0] != stateParent {
+		t.Errorf("unexpected state path %s %v", snap.State, snap.Path)
+	}
+	if len(snap.Timers) != 1 || snap.Timers[0].Event != evTimeout || !snap.Timers[0].Deadline.After(snap.Time) {
+		t.Errorf("unexpected timers %+v", snap.Timers)
+	}
+	if snap.LastTransition == nil || snap.LastTransition.Event != evGo {
+		t.Errorf("unexpected last transition %+v", snap.LastTransition)
+	}
+	if len(snap.RecentEvents) == 0 || snap.Stats.Transitions != 1 || snap.Vars["soc"] != float64(80) {
+		t.Errorf("unexpected history %+v", snap)
+	}
+	if !strings.Contains(string(data), `"outcome": "accepted"`) {
+		t.Errorf("expected readable outcome in %s", data)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evGo, stateC).
+		Transition(stateC, evBack, stateA).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	m.SendSync(Event{ID: evGo})
+	time.Sleep(10 * time.Millisecond)
+	m.SendSync(Event{ID: evBack}) // Unhandled in b
+	m.SendSync(Event{ID: evGo})
+	m.SendSync(Event{ID: evBack})
+	m.Stop()
+	journal := m.RecentEvents(0)
+
+	data, _ := json.Marshal(journal)
+	var loaded []EventRecord
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("journal round trip failed: %v", err)
+	}
+
+	res, err := Replay(context.Background(), def, loaded, ReplayOptions{})
+	if err != nil || res.Err() != nil || res.Replayed != 4 {
+		t.Fatalf("expected faithful replay, got %v %v %+v", err, res.Err(), res)
+	}
+
+	start := time.Now()
+	if res, _ := Replay(context.Background(), def, loaded, ReplayOptions{Speed: 2}); res.Err() != nil {
+		t.Errorf("scaled replay diverged: %v", res.Err())
+	}
+	if time.Since(start) < 5*time.Millisecond {
+		t.Errorf("expected scaled replay to keep recorded gaps")
+	}
+
+	changed := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateC).
+		Transition(stateC, evBack, stateA).
+		Initial(stateA)
+	res, _ = Replay(context.Background(), changed, loaded, ReplayOptions{})
+	if len(res.Mismatches) == 0 || res.Mismatches[0].Index != 0 || res.Mismatches[0].Got != stateC {
+		t.Errorf("expected divergence at first event, got %+v", res.Mismatches)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	v1 := NewDefinition().
+		State(stateA).
+		State(stateB, WithTimeout(time.Second, evTimeout)).
+		State(stateC).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evTimeout, stateA).
+		Transition(stateB, evBack, stateC).
+		Initial(stateA)
+	v2 := NewDefinition().
+		State(stateA).
+		State(stateB, WithTimeout(2*time.Second, evTimeout)).
+		FinalState(stateFinal).
+		Transition(stateA, evGo, stateB, WithLabel("start")).
+		Transition(stateB, evTimeout, stateA).
+		Transition(stateB, evDone, stateFinal).
+		Initial(stateA)
+
+	if !Diff(v1, v1).Empty() {
+		t.Errorf("expected no changes against itself, got %s", Diff(v1, v1))
+	}
+
+	c := Diff(v1, v2)
+	if len(c.AddedStates) != 1 || c.AddedStates[0] != stateFinal || len(c.RemovedStates) != 1 || c.RemovedStates[0] != stateC {
+		t.Errorf("unexpected state changes %+v %+v", c.AddedStates, c.RemovedStates)
+	}
+	if len(c.ModifiedStates) != 1 || c.ModifiedStates[0].ID != stateB || c.ModifiedStates[0].Fields[0] != "timeout" {
+		t.Errorf("unexpected modified states %+v", c.ModifiedStates)
+	}
+	if len(c.AddedTransitions) != 1 || c.AddedTransitions[0].Event != evDone || len(c.RemovedTransitions) != 1 || c.RemovedTransitions[0].Event != evBack {
+		t.Errorf("unexpected transition changes %+v %+v", c.AddedTransitions, c.RemovedTransitions)
+	}
+	if len(c.ModifiedTransitions) != 1 || c.ModifiedTransitions[0].Fields[0] != "label" {
+		t.Errorf("unexpected modified transitions %+v", c.ModifiedTransitions)
+	}
+	if !strings.Contains(c.String(), "~ state b: timeout") {
+		t.Errorf("unexpected release notes:\n%s", c)
+	}
+}
+
+func TestDefinitionFrozenAfterBuild(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB, WithTimeoutTransition(time.Second, stateA)).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+
+	if _, err := def.Build(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	count := len(def.transitions)
+	if _, err := def.Build(); err != nil || len(def.transitions) != count {
+		t.Fatalf("expected rebuilding to leave the definition untouched, got %v, %d transitions", err, len(def.transitions))
+	}
+
+	defer func() {
+		var be *BuilderError
+		err, _ := recover().(error)
+		if !errors.As(err, &be) || !errors.Is(err, ErrDefinitionFrozen) || be.Call != "State" || !strings.HasSuffix(be.File, "fsm_test.go") {
+			t.Errorf("expected frozen builder panic at the call site, got %v", err)
+		}
+	}()
+	def.State(stateC)
+}
+
+type memoryPersister struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (p *memoryPersister) SavePending(events []Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append([]Event(nil), events...)
+	return nil
+}
+
+func (p *memoryPersister) LoadPending() ([]Event, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Event(nil), p.events...), nil
+}
+
+func TestDurableQueue(t *testing.T) {
+	block := make(chan struct{})
+	var blocking atomic.Bool
+	blocking.Store(true)
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateB, WithAction(func(*Context) error {
+			if blocking.Load() {
+				<-block
+			}
+			return nil
+		})).
+		Transition(stateB, evGo, stateC).
+		Initial(stateA)
+
+	p := &memoryPersister{}
+	m1, err := def.Build(WithDurableQueue(p))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m1.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	m1.Send(Event{ID: evGo, Payload: 1})
+	m1.Send(Event{ID: evGo, Payload: 2})
+	time.Sleep(20 * time.Millisecond)
+
+	// The first event is in flight, the second queued: both must be stored
+	pending, _ := p.LoadPending()
+	if len(pending) != 2 || pending[0].Payload != 1 {
+		t.Fatalf("expected both events persisted, got %+v", pending)
+	}
+
+	// Simulate a crash: m1 never finishes, a new machine takes over
+	blocking.Store(false)
+	m2, _ := def.Build(WithDurableQueue(p))
+	if err := m2.Start(context.Background()); err != nil {
+		t.Fatalf("restart failed: %v", err)
+	}
+	defer m2.Stop()
+	time.Sleep(20 * time.Millisecond)
+	if m2.CurrentState() != stateC {
+		t.Errorf("expected restored events to be processed, got %s", m2.CurrentState())
+	}
+	if pending, _ := p.LoadPending(); len(pending) != 0 {
+		t.Errorf("expected no pending events after processing, got %+v", pending)
+	}
+
+	m1.Stop()
+	close(block)
+}
+
+func TestOnEvent(t *testing.T) {
+	var entries, updates atomic.Int32
+	def := NewDefinition().
+		State(stateA,
+			WithOnEnter(func(*Context) error { entries.Add(1); return nil }),
+			WithTimeout(time.Hour, evTimeout),
+			WithOnEvent("update", func(c *Context) error {
+				updates.Add(1)
+				c.SetVar("value", c.Event.Payload)
+				return nil
+			}),
+		).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: "update", Payload: 7})
+	m.SendSync(Event{ID: "update", Payload: 8})
+	if m.CurrentState() != stateA || entries.Load() != 1 || updates.Load() != 2 {
+		t.Errorf("expected in-place handling, state %s, entries %d, updates %d", m.CurrentState(), entries.Load(), updates.Load())
+	}
+	if v, _ := m.GetVar("value"); v != 8 || !m.TimerActive("_timeout_a") {
+		t.Errorf("expected handler to update data and keep the timeout, got %v", v)
+	}
+
+	m.SendSync(Event{ID: evGo})
+	m.SendSync(Event{ID: "update"})
+	if updates.Load() != 2 {
+		t.Errorf("expected handler to apply only in its state")
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	def := NewDefinition().
+		State(stateParent, WithDefaultChild(stateChild1)).
+		State(stateChild1, WithParent(stateParent)).
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateChild1, evGo, stateA).
+		Transition(stateParent, evGo, stateB, WithPriority(1)).
+		AnyStateTransition(evGo, stateC).
+		Initial(stateParent)
+
+	for _, tc := range []struct {
+		policy ConflictPolicy
+		want   StateID
+	}{
+		{ConflictMostSpecific, stateA},
+		{ConflictWildcardFirst, stateC},
+		{ConflictPriority, stateB},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			m, err := def.Build(WithConflictPolicy(tc.policy))
+			if err != nil {
+				t.Fatalf("build failed: %v", err)
+			}
+			if err := m.Start(context.Background()); err != nil {
+				t.Fatalf("start failed: %v", err)
+			}
+			defer m.Stop()
+
+			m.SendSync(Event{ID: evGo})
+			if m.CurrentState() != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, m.CurrentState())
+			}
+		})
+	}
+}
+
+func TestNamedGuard(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB, WithNamedGuard("kickstand_up", func(*GuardContext) bool { return false })).
+		Initial(stateA)
+
+	var buf bytes.Buffer
+	m, err := def.Build(WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	if !strings.Contains(buf.String(), `guard \"kickstand_up\" rejected`) {
+		t.Errorf("expected guard name in logs, got:\n%s", buf.String())
+	}
+	if diagram := def.Describe().Mermaid(); !strings.Contains(diagram, "a --> b: go [kickstand_up]") {
+		t.Errorf("expected labelled edge, got:\n%s", diagram)
+	}
+}
+
+func TestActionRegistry(t *testing.T) {
+	doc := []byte(`{
+		"initial": "a",
+		"states": [{"id": "a"}, {"id": "b", "on_enter": "unlock"}],
+		"transitions": [{"from": "a", "event": "go", "to": "b", "action": "beep"}]
+	}`)
+	def, err := ParseDocument(doc)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if _, err := def.Build(); err == nil || !strings.Contains(err.Error(), "[beep unlock]") {
+		t.Fatalf("expected unregistered actions to fail the build, got %v", err)
+	}
+
+	var calls []string
+	var mu sync.Mutex
+	record := func(name string) func(*Context) error {
+		return func(*Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	def.RegisterAction("unlock", record("unlock")).RegisterAction("beep", record("beep"))
+
+	m, err := def.Build(WithActionRegistry(map[string]func(*Context) error{"beep": record("stub beep")}))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	mu.Lock()
+	if m.CurrentState() != stateB || strings.Join(calls, ",") != "stub beep,unlock" {
+		t.Errorf("expected named actions to run with machine overrides, got %v in %s", calls, m.CurrentState())
+	}
+	mu.Unlock()
+
+	if diagram := def.Describe().Mermaid(); !strings.Contains(diagram, "a --> b: go / beep") {
+		t.Errorf("expected action name on edge, got:\n%s", diagram)
+	}
+}
+
+func TestPayloadCodecs(t *testing.T) {
+	type batteryInfo struct {
+		Slot    int     `json:"slot"`
+		Charge  float64 `json:"charge"`
+		Serial  string  `json:"serial"`
+		Temps   []int   `json:"temps"`
+		Present bool    `json:"present"`
+	}
+	var types PayloadTypes
+	RegisterPayload[batteryInfo](&types, "battery")
+
+	want := batteryInfo{Slot: 1, Charge: 87.5, Serial: strings.Repeat("x", 40), Temps: []int{-40, 300, 70000}, Present: true}
+	for _, codec := range []PayloadCodec{JSONCodec{Types: &types}, MsgpackCodec{Types: &types}} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			data, err := codec.Encode("battery", want)
+			if err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+			got, err := codec.Decode("battery", data)
+			if err != nil {
+				t.Fatalf("decode failed: %v", err)
+			}
+			if info, ok := got.(batteryInfo); !ok || info.Serial != want.Serial || info.Temps[2] != 70000 || info.Charge != 87.5 {
+				t.Errorf("expected typed round trip, got %#v", got)
+			}
+
+			// Unregistered events decode to generic values
+			data, _ = codec.Encode("other", map[string]any{"level": 3})
+			if generic, err := codec.Decode("other", data); err != nil || fmt.Sprint(generic) != "map[level:3]" {
+				t.Errorf("expected generic map, got %#v (%v)", generic, err)
+			}
+
+			data, _ = codec.Encode("ping", nil)
+			if v, err := codec.Decode("ping", data); v != nil || err != nil {
+				t.Errorf("expected nil payload, got %#v (%v)", v, err)
+			}
+		})
+	}
+
+	if _, err := (MsgpackCodec{}).Decode("x", []byte{0x92, 0x01}); err == nil {
+		t.Errorf("expected truncated msgpack to fail")
+	}
+}
+
+func TestRegisterEvent(t *testing.T) {
+	type lockCommand struct{ Force bool }
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, "lock", stateB, WithAction(func(c *Context) error {
+			_ = c.Event.Payload.(lockCommand) // Would panic on a bad payload
+			return nil
+		})).
+		Initial(stateA)
+
+	m, err := def.Build(RegisterEvent[lockCommand]("lock"), RegisterEvent[*lockCommand]("ptr"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: "lock", Payload: "yes"}); !errors.Is(err, ErrPayloadMismatch) {
+		t.Errorf("expected payload mismatch, got %v", err)
+	}
+	m.Send(Event{ID: "lock"})
+	if err := m.SendSync(Event{ID: "ptr"}); err != nil {
+		t.Errorf("expected nil to match a pointer type, got %v", err)
+	}
+	if s := m.Stats(); s.EventsDropped != 2 || m.CurrentState() != stateA {
+		t.Errorf("expected both bad events dropped, got %+v in %s", s, m.CurrentState())
+	}
+	if err := m.SendSync(Event{ID: "lock", Payload: lockCommand{}}); err != nil || m.CurrentState() != stateB {
+		t.Errorf("expected valid payload to be accepted, got %v in %s", err, m.CurrentState())
+	}
+
+	warn, _ := def.Build(RegisterEvent[lockCommand]("ptr"), WithPayloadPolicy(PayloadWarn))
+	if err := warn.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer warn.Stop()
+	if err := warn.SendSync(Event{ID: "ptr", Payload: 1}); err != nil {
+		t.Errorf("expected warn policy to queue the event, got %v", err)
+	}
+}
+
+func TestSendWaitFairness(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []int
+	def := NewDefinition().
+		State(stateA,
+			WithOnEvent("block", func(*Context) error { <-release; return nil }),
+			WithOnEvent("work", func(c *Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+				order = append(order, c.Event.Payload.(int))
+				return nil
+			}),
+		).
+		Initial(stateA)
+
+	m, err := def.Build(WithEventQueueSize(2))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.Send(Event{ID: "block"})
+	time.Sleep(10 * time.Millisecond)
+	m.Send(Event{ID: "work", Payload: 0})
+	m.Send(Event{ID: "work", Payload: 1})
+
+	var wg sync.WaitGroup
+	for i := 2; i <= 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := m.SendWait(context.Background(), Event{ID: "work", Payload: i}); err != nil {
+				t.Errorf("send %d failed: %v", i, err)
+			}
+		}(i)
+		// Wait until the producer is in line to fix the arrival order
+		for waiting := 0; waiting < i-1; {
+			time.Sleep(time.Millisecond)
+			m.queue.mu.Lock()
+			waiting = len(m.queue.waiters)
+			m.queue.mu.Unlock()
+		}
+	}
+
+	// A non-blocking send must not jump the line
+	m.Send(Event{ID: "work", Payload: 99})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.SendWait(ctx, Event{ID: "work", Payload: 100}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline while waiting, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	// Events 3 and 4 may still be queued, so wait for room rather than failing
+	if err := m.SendWait(context.Background(), Event{ID: "work", Payload: 5}); err != nil {
+		t.Fatalf("send 5 failed: %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := len(order)
+		mu.Unlock()
+		if n >= 6 {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(order) != "[0 1 2 3 4 5]" {
+		t.Errorf("expected producers served in arrival order, got %v", order)
+	}
+}
+
+func TestPauseResetStop(t *testing.T) {
+	var handled atomic.Int32
+	release := make(chan struct{})
+	def := NewDefinition().
+		State(stateA, WithOnEvent("block", func(*Context) error { <-release; return nil })).
+		State(stateB, WithOnEvent("work", func(*Context) error { handled.Add(1); return nil })).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+
+	var discarded []EventID
+	var mu sync.Mutex
+	onDiscard := func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		discarded = append(discarded, e.ID)
+	}
+
+	m, err := def.Build(WithStopPolicy(QueueDiscard, onDiscard))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Reset(); !errors.Is(err, ErrNotStarted) {
+		t.Fatalf("expected ErrNotStarted before Start, got %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	// Paused machines queue events without processing them
+	m.Pause()
+	m.Send(Event{ID: evGo})
+	time.Sleep(10 * time.Millisecond)
+	if m.CurrentState() != stateA || !m.Paused() {
+		t.Fatalf("expected paused machine to stay in a, got %s", m.CurrentState())
+	}
+	m.Resume()
+	time.Sleep(10 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected queued event after resume, got %s", m.CurrentState())
+	}
+
+	// Reset runs ahead of queued events and discards them
+	m.Pause()
+	m.Send(Event{ID: "work"})
+	if err := m.Reset(); err != nil || m.CurrentState() != stateA {
+		t.Fatalf("expected reset to initial state, got %v in %s", err, m.CurrentState())
+	}
+	m.Resume()
+
+	// Stop discards queued events and fails their SendSync callers
+	m.Send(Event{ID: "block"})
+	time.Sleep(10 * time.Millisecond)
+	result := make(chan error, 1)
+	go func() { result <- m.SendSync(Event{ID: evGo}) }()
+	time.Sleep(10 * time.Millisecond)
+	m.Stop()
+	close(release)
+	<-m.Done()
+	if err := <-result; !errors.Is(err, ErrEventDiscarded) {
+		t.Errorf("expected discarded SendSync, got %v", err)
+	}
+	if err := m.SendSync(Event{ID: evGo}); !errors.Is(err, ErrMachineStopped) {
+		t.Errorf("expected stopped machine to reject events, got %v", err)
+	}
+	mu.Lock()
+	if fmt.Sprint(discarded) != "[work go]" {
+		t.Errorf("unexpected discarded events %v", discarded)
+	}
+	mu.Unlock()
+
+	// Draining processes what was queued before Stop
+	block := make(chan struct{})
+	drainDef := NewDefinition().
+		State(stateA, WithOnEvent("block", func(*Context) error { <-block; return nil })).
+		State(stateB, WithOnEvent("work", func(*Context) error { handled.Add(1); return nil })).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+	d, _ := drainDef.Build(WithStopPolicy(QueueDrain, nil))
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	d.Send(Event{ID: "block"})
+	d.Send(Event{ID: evGo})
+	d.Send(Event{ID: "work"})
+	d.Stop()
+	close(block)
+	<-d.Done()
+	if d.CurrentState() != stateB || handled.Load() != 1 {
+		t.Errorf("expected queued events to drain, got %s with %d handled", d.CurrentState(), handled.Load())
+	}
+}
+
+func TestDiscardWakeUp(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+	m, err := def.Build(WithStopPolicy(QueueDiscard, nil))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	within := func(what string, fn func()) {
+		t.Helper()
+		finished := make(chan struct{})
+		go func() {
+			fn()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(time.Second):
+			t.Fatalf("%s hung on a queued wake-up", what)
+		}
+	}
+
+	// Wake-ups have no done channel; discarding one must not block
+	m.Pause()
+	m.wakeLoop()
+	within("Reset", func() { m.Reset() })
+	m.Pause()
+	m.wakeLoop()
+	within("Stop", func() { m.Stop() })
+	within("Done", func() { <-m.Done() })
+}
+
+func TestHealth(t *testing.T) {
+	release := make(chan struct{})
+	def := NewDefinition().
+		State(stateA, WithOnEvent("block", func(*Context) error { <-release; return nil })).
+		Initial(stateA)
+
+	changes := make(chan Health, 4)
+	m, err := def.Build(
+		WithEventQueueSize(2),
+		WithHealthCheck(HealthConfig{Interval: 5 * time.Millisecond, ProgressTimeout: 20 * time.Millisecond, SlowAction: 20 * time.Millisecond}, func(h Health) { changes <- h }),
+	)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if h := m.Health(); h.Healthy || h.Running {
+		t.Errorf("expected unstarted machine to be unhealthy, got %+v", h)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+	if h := m.Health(); !h.Healthy {
+		t.Fatalf("expected healthy machine, got %v", h.Problems)
+	}
+
+	m.Send(Event{ID: "block"})
+	time.Sleep(5 * time.Millisecond)
+	m.Send(Event{ID: "block"})
+	m.Send(Event{ID: "block"})
+
+	select {
+	case h := <-changes:
+		if h.Healthy {
+			t.Errorf("expected unhealthy report, got %+v", h)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected unhealthy report")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if h := m.Health(); len(h.Problems) != 3 || h.SlowAction != "transition action" || h.Saturation != 1 {
+		t.Errorf("expected stuck, slow and saturated report, got %+v", h)
+	}
+
+	close(release)
+	select {
+	case h := <-changes:
+		if !h.Healthy {
+			t.Errorf("expected recovery, got %v", h.Problems)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected recovery report")
+	}
+}
+
+func TestShutdownState(t *testing.T) {
+	var mu sync.Mutex
+	var rituals []string
+	record := func(s string) func(*Context) error {
+		return func(*Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			rituals = append(rituals, s)
+			return nil
+		}
+	}
+	def := NewDefinition().
+		State(stateA, WithOnExit(record("exit a"))).
+		State(stateB, WithOnExit(record("exit b"))).
+		State(stateC, WithOnEnter(record("persist"))).
+		FinalState(stateFinal, WithOnEnter(record("power off"))).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, ShutdownEvent, stateC).
+		Transition(stateC, evDone, stateFinal).
+		Initial(stateA)
+
+	if _, err := def.Build(WithShutdownState("nowhere", 0)); err == nil {
+		t.Errorf("expected unknown shutdown state to fail the build")
+	}
+
+	// Without a transition for ShutdownEvent, the machine is routed directly
+	m, _ := def.Build(WithShutdownState(stateFinal, time.Second))
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	m.Stop()
+	<-m.Done()
+	if m.CurrentState() != stateFinal || fmt.Sprint(rituals) != "[exit a power off]" {
+		t.Errorf("expected direct shutdown, got %s with %v", m.CurrentState(), rituals)
+	}
+
+	// A definition handling ShutdownEvent takes its own route
+	m, _ = def.Build(WithShutdownState(stateC, time.Second))
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	m.SendSync(Event{ID: evGo})
+	mu.Lock()
+	rituals = nil
+	mu.Unlock()
+	m.Stop()
+	if m.CurrentState() != stateC || fmt.Sprint(rituals) != "[exit b persist]" {
+		t.Errorf("expected shutdown through the definition, got %s with %v", m.CurrentState(), rituals)
+	}
+}
+
+func TestSetStateOptionsAndRestore(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(s string) func(*Context) error {
+		return func(*Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, s)
+			return nil
+		}
+	}
+	def := NewDefinition().
+		State(stateInit, WithOnExit(record("exit init"))).
+		State(stateParent, WithDefaultChild(stateChild1), WithOnEnter(record("arm motor")), WithOnRestore(record("read motor"))).
+		State(stateChild1, WithParent(stateParent), WithOnEnter(record("enter child")), WithTimeout(time.Hour, evTimeout)).
+		State(stateA, WithOnEnter(record("enter a")), WithOnExit(record("exit a"))).
+		Initial(stateInit)
+
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.Restore(stateParent); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if m.CurrentState() != stateChild1 || fmt.Sprint(calls) != "[read motor]" {
+		t.Errorf("expected restore hooks only, got %v in %s", calls, m.CurrentState())
+	}
+	if !m.TimerActive("_timeout_child1") {
+		t.Errorf("expected timeouts to be armed on restore")
+	}
+
+	calls = nil
+	if err := m.SetState(stateA, SkipEntryActions()); err != nil || fmt.Sprint(calls) != "[]" {
+		t.Errorf("expected no entry action, got %v (%v)", calls, err)
+	}
+	if err := m.SetState(stateInit, SkipExitActions()); err != nil || fmt.Sprint(calls) != "[]" {
+		t.Errorf("expected no exit action, got %v (%v)", calls, err)
+	}
+	if err := m.SetState(stateA); err != nil || fmt.Sprint(calls) != "[exit init enter a]" {
+		t.Errorf("expected actions by default, got %v (%v)", calls, err)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	var toggles int
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB, WithAction(func(*Context) error {
+			toggles++
+			return nil
+		})).
+		Transition(stateB, evBack, stateA).
+		Initial(stateA)
+
+	m, err := def.Build(WithDeduplication(2), WithEventLog(10))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	send := func(id EventID, key string) {
+		t.Helper()
+		if err := m.SendSync(Event{ID: id, Key: key}); err != nil && !errors.Is(err, ErrNoTransition) {
+			t.Fatalf("send %s failed: %v", key, err)
+		}
+	}
+
+	send(evGo, "cmd-1")
+	send(evBack, "cmd-2")
+	send(evGo, "cmd-1") // Re-delivered
+	if m.CurrentState() != stateA || toggles != 1 {
+		t.Errorf("expected duplicate to be dropped, got %s after %d toggles", m.CurrentState(), toggles)
+	}
+	if recent := m.RecentEvents(1); len(recent) != 1 || recent[0].Outcome != EventDropped {
+		t.Errorf("expected duplicate to be logged as dropped, got %+v", recent)
+	}
+
+	// cmd-1 is evicted once two newer keys were processed
+	send(evGo, "cmd-3")
+	send(evBack, "cmd-4")
+	send(evGo, "cmd-1")
+	if m.CurrentState() != stateB || toggles != 3 {
+		t.Errorf("expected evicted key to be processed again, got %s after %d toggles", m.CurrentState(), toggles)
+	}
+
+	// Events without a key are never deduplicated
+	send(evBack, "")
+	send(evGo, "")
+	if toggles != 4 {
+		t.Errorf("expected unkeyed events to be processed, got %d toggles", toggles)
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB, WithTimeout(time.Hour, evTimeout)).
+		State(stateC).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evGo, stateC).
+		Transition(stateC, evBack, stateA).
+		Initial(stateA)
+
+	journal := []EventRecord{
+		{ID: evGo, Outcome: EventAccepted, State: stateA, Result: stateB},
+		{ID: evBack, Outcome: EventUnhandled, State: stateB, Result: stateB},
+		{ID: evGo, Outcome: EventAccepted, State: stateB, Result: stateA}, // Recorded with another definition
+		{ID: evBack, Outcome: EventAccepted, State: stateC, Result: stateA},
+	}
+
+	d, err := NewDebugger(context.Background(), def, journal, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("debugger failed: %v", err)
+	}
+	if d.Len() != 5 || d.Current().Snapshot.State != stateA || d.Current().Record != nil {
+		t.Fatalf("expected 5 steps starting in a, got %d in %s", d.Len(), d.Current().Snapshot.State)
+	}
+
+	step, _ := d.Step()
+	if step.Snapshot.State != stateB || len(step.Snapshot.Timers) != 1 {
+		t.Errorf("expected b with its timeout after first event, got %+v", step.Snapshot)
+	}
+
+	step, ok := d.NextDivergence()
+	if !ok || step.Index != 2 || step.Snapshot.State != stateC {
+		t.Errorf("expected divergence at journal index 2, got %+v", step)
+	}
+
+	step, _ = d.Back()
+	if step.Index != 1 || step.Snapshot.State != stateB {
+		t.Errorf("expected to step back to index 1, got %+v", step)
+	}
+
+	if _, err := d.Seek(4); err != nil || d.Current().Snapshot.State != stateA {
+		t.Errorf("expected seek to final step in a, got %s (%v)", d.Current().Snapshot.State, err)
+	}
+	if _, ok := d.Step(); ok {
+		t.Errorf("expected step past the end to fail")
+	}
+	if _, err := d.Seek(5); err == nil {
+		t.Errorf("expected out of range seek to fail")
+	}
+}
+
+func TestREPL(t *testing.T) {
+	def, err := ParseDocument([]byte(`{
+		"initial": "a",
+		"states": [
+			{"id": "a", "on_enter": "lock"},
+			{"id": "b", "timeout": "1h", "timeout_target": "a"}
+		],
+		"transitions": [{"from": "a", "event": "go", "to": "b", "action": "unlock"}]
+	}`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	in := strings.NewReader("events\ngo\ntimers\nfire _timeout_b\nback\nstate\nset nowhere\nquit\n")
+	var out bytes.Buffer
+	if err := RunREPL(context.Background(), def, in, &out); err != nil {
+		t.Fatalf("repl failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"  go\n",             // Events handled in a
+		"  action unlock\n",  // Stubbed transition action
+		"  a -> b\n",         // State change
+		"  _timeout_b: ",     // Running timeout
+		"  b -> a\n",         // Fired timer
+		"  unhandled in a\n", // Unknown event
+		"> a\n",              // State
+		"error: ",            // Unknown state
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+	if n := strings.Count(out.String(), "action lock"); n != 2 {
+		t.Errorf("expected stubbed entry action to run twice, got %d", n)
+	}
+}
+
+func TestDiagramLayout(t *testing.T) {
+	def := NewDefinition().
+		State(stateA, WithLayout(Layout{Group: "drive", Rank: 1, Color: "#ffcc00", Note: "kickstand up"})).
+		State(stateB, WithLayout(Layout{Group: "drive", Rank: 1})).
+		State(stateParent, WithDefaultChild(stateChild1)).
+		State(stateChild1, WithParent(stateParent)).
+		Transition(stateA, evGo, stateB, WithEdgeLayout(Layout{Color: "red", Note: "brake held"})).
+		Transition(stateB, evGo, stateParent).
+		Initial(stateA)
+	desc := def.Describe()
+
+	for name, tc := range map[string]struct {
+		out  string
+		want []string
+	}{
+		"mermaid": {desc.Mermaid(), []string{
+			"state \"drive\" as __group_drive {",
+			"a --> b: go<br/>brake held",
+			"note right of a : kickstand up",
+			"class a color0",
+			"classDef color0 fill:#ffcc00",
+		}},
+		"dot": {desc.DOT(), []string{
+			"subgraph cluster___group_drive {",
+			`"a" [style="rounded,filled", fillcolor="#ffcc00"];`,
+			`{ rank=same; "a"; "b"; }`,
+			`"a" -> "b" [label="go\nbrake held", color="red"];`,
+			`"b" -> "child1" [label="go", lhead=cluster_parent];`,
+			`label="kickstand up"`,
+		}},
+		"plantuml": {desc.PlantUML(), []string{
+			"state \"drive\" as __group_drive ##[dashed] {",
+			"state \"a\" as a #ffcc00",
+			`a -[#red]-> b : go\nbrake held`,
+			"note right of a : kickstand up",
+		}},
+	} {
+		for _, want := range tc.want {
+			if !strings.Contains(tc.out, want) {
+				t.Errorf("%s: expected %q in:\n%s", name, want, tc.out)
+			}
+		}
+	}
+
+	doc := []byte(`{"initial": "a", "states": [{"id": "a", "layout": {"group": "drive", "color": "green"}}]}`)
+	if err := ValidateDocument(doc); err != nil {
+		t.Errorf("expected layout in documents to be accepted: %v", err)
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateParent, WithDefaultChild(stateChild1)).
+		State(stateChild1, WithParent(stateParent), WithTimeout(time.Second, evTimeout)).
+		State(stateChild2, WithParent(stateParent)).
+		Transition(stateA, evGo, stateParent, WithNamedGuard("ready", func(*GuardContext) bool { return true })).
+		Transition(stateChild1, evTimeout, stateChild2).
+		Transition(WildcardState, evBack, stateA).
+		Initial(stateA)
+
+	var buf bytes.Buffer
+	if err := def.RenderText(&buf, stateChild1); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	want := `  a (initial)
+      go [ready] -> parent
+* parent
+*   child1 (default, timeout 1s)
+        timeout -> child2
+    child2
+  (any state)
+      back -> a
+`
+	if buf.String() != want {
+		t.Errorf("unexpected rendering:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+
+	vehicle, err := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA).
+		Build(WithStatePublishing(bus, "vehicle"))
+	if err != nil {
+		t.Fatalf("build vehicle failed: %v", err)
+	}
+	dashboard, err := NewDefinition().
+		State(stateInit).
+		State(stateC).
+		Transition(stateInit, StateChangedEvent("vehicle"), stateC, WithGuard(func(ctx *GuardContext) bool {
+			return ctx.Event.Payload.(StateChanged).To == stateB
+		})).
+		Initial(stateInit).
+		Build(WithSubscription(bus, EventPrefix("state.")))
+	if err != nil {
+		t.Fatalf("build dashboard failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	var battery []EventID
+	unsubscribe := bus.Subscribe(EventPrefix("battery:"), func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		battery = append(battery, e.ID)
+	})
+
+	for _, m := range []*Machine{vehicle, dashboard} {
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+		defer m.Stop()
+	}
+
+	vehicle.SendSync(Event{ID: evGo})
+	time.Sleep(20 * time.Millisecond)
+	if dashboard.CurrentState() != stateC {
+		t.Errorf("expected dashboard to follow the vehicle, got %s", dashboard.CurrentState())
+	}
+
+	bus.Publish(Event{ID: "battery:low"})
+	bus.Publish(Event{ID: "vehicle:locked"})
+	unsubscribe()
+	bus.Publish(Event{ID: "battery:empty"})
+	mu.Lock()
+	if fmt.Sprint(battery) != "[battery:low]" {
+		t.Errorf("expected only battery topic until unsubscribed, got %v", battery)
+	}
+	mu.Unlock()
+
+	dashboard.Stop()
+	if n := len(bus.subs); n != 0 {
+		t.Errorf("expected stop to unsubscribe the machine, %d subscriptions left", n)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	fragment := NewDefinition().
+		State(stateB).
+		State(stateC).
+		Transition(stateB, evGo, stateC).
+		RegisterAction("beep", func(*Context) error { return nil })
+
+	def := NewDefinition().
+		State(stateA).
+		Transition(stateA, evGo, stateB).
+		Merge(fragment).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+	m.SendSync(Event{ID: evGo})
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateC {
+		t.Errorf("expected merged transitions to run, got %s", m.CurrentState())
+	}
+
+	dup := NewDefinition().State(stateB).Merge(fragment).Merge(fragment)
+	if err := dup.Err(); err == nil || !strings.Contains(err.Error(), `duplicate state "b"`) || !strings.Contains(err.Error(), `duplicate action "beep"`) {
+		t.Errorf("expected duplicate errors, got %v", err)
+	}
+}
+
+func TestSuspendResume(t *testing.T) {
+	for _, policy := range []ResumePolicy{ResumeCredit, ResumeFreeze} {
+		t.Run(policy.String(), func(t *testing.T) {
+			def := NewDefinition().
+				State(stateA, WithTimeoutTransition(40*time.Millisecond, stateB)).
+				State(stateB).
+				Initial(stateA)
+			m, err := def.Build(WithResumePolicy(policy))
+			if err != nil {
+				t.Fatalf("build failed: %v", err)
+			}
+			if err := m.Start(context.Background()); err != nil {
+				t.Fatalf("start failed: %v", err)
+			}
+			defer m.Stop()
+
+			m.NotifySuspend()
+			time.Sleep(60 * time.Millisecond)
+			if m.CurrentState() != stateA || !m.Suspended() {
+				t.Fatalf("expected timeout to be paused while suspended, got %s", m.CurrentState())
+			}
+			if slept := m.NotifyResume(); slept < 60*time.Millisecond {
+				t.Errorf("expected suspend duration of at least 60ms, got %v", slept)
+			}
+
+			time.Sleep(15 * time.Millisecond)
+			credited := m.CurrentState() == stateB
+			if credited != (policy == ResumeCredit) {
+				t.Errorf("expected overdue timeout to fire on resume only when credited, got %s", m.CurrentState())
+			}
+			time.Sleep(45 * time.Millisecond)
+			if m.CurrentState() != stateB {
+				t.Errorf("expected timeout after resume, got %s", m.CurrentState())
+			}
+			if s := m.Stats(); s.Suspends != 1 || s.Suspended < 60*time.Millisecond {
+				t.Errorf("expected suspend to be recorded, got %+v", s)
+			}
+		})
+	}
+}
+
+func TestSuspendDebounceAndIdle(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, "switch", stateB).
+		Transition(stateB, "idle", stateC).
+		Initial(stateA)
+	m, err := def.Build(WithEventDebounce("switch", 40*time.Millisecond), WithIdleTimeout(40*time.Millisecond, "idle"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	time.Sleep(60 * time.Millisecond) // Let the watchdog armed by Start fire
+
+	// A debounce window is paused while suspended; credited on resume, it ends right away
+	m.Send(Event{ID: "switch"})
+	m.NotifySuspend()
+	time.Sleep(80 * time.Millisecond)
+	if m.CurrentState() != stateA {
+		t.Fatalf("expected debounce window to be paused while suspended, got %s", m.CurrentState())
+	}
+	m.NotifyResume()
+	time.Sleep(10 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected debounced event after resume, got %s", m.CurrentState())
+	}
+
+	// So is the idle watchdog
+	m.NotifySuspend()
+	time.Sleep(80 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected idle watchdog to be paused while suspended, got %s", m.CurrentState())
+	}
+	m.NotifyResume()
+	time.Sleep(10 * time.Millisecond)
+	if m.CurrentState() != stateC {
+		t.Errorf("expected idle event after resume, got %s", m.CurrentState())
+	}
+}
+
+func TestDeferUntilStable(t *testing.T) {
+	build := func(limit int, overflow ParkOverflow) *Machine {
+		def := NewDefinition().
+			State(stateInit).
+			State(stateA).
+			State(stateB, WithTransitional()).
+			State(stateC).
+			Transition(stateA, evGo, stateB).
+			Transition(stateB, evDone, stateC).
+			Transition(stateC, evGo, stateA).
+			AnyStateTransition(evBack, stateInit).
+			Initial(stateA)
+		m, err := def.Build(WithDeferUntilStable(limit, overflow))
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+		m.SendSync(Event{ID: evGo})
+		return m
+	}
+
+	m := build(0, ParkDropOldest)
+	defer m.Stop()
+	delivered := make(chan error, 1)
+	go func() { delivered <- m.SendSync(Event{ID: evGo}) }()
+	time.Sleep(10 * time.Millisecond)
+	m.Send(Event{ID: evBack}) // Would be consumed by the any-state rule
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-delivered:
+		t.Fatal("expected SendSync of a parked event to wait for delivery")
+	default:
+	}
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected events to be parked in transitional state, got %s", m.CurrentState())
+	}
+
+	m.SendSync(Event{ID: evDone})
+	if err := <-delivered; err != nil {
+		t.Errorf("expected parked event to be delivered, got %v", err)
+	}
+	var results []StateID
+	for _, rec := range m.RecentEvents(3) {
+		results = append(results, rec.Result)
+	}
+	if fmt.Sprint(results) != "[c a init]" {
+		t.Errorf("expected parked events in arrival order after stabilizing, got %v", results)
+	}
+
+	for _, tc := range []struct {
+		overflow ParkOverflow
+		want     StateID
+	}{
+		{ParkDropOldest, stateInit}, // evGo dropped, evBack delivered
+		{ParkRejectNew, stateA},     // evBack rejected, evGo delivered
+	} {
+		m := build(1, tc.overflow)
+		m.Send(Event{ID: evGo})
+		m.Send(Event{ID: evBack})
+		m.SendSync(Event{ID: evDone})
+		if m.CurrentState() != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.overflow, tc.want, m.CurrentState())
+		}
+		m.Stop()
+	}
+}
+
+func TestMinimumDwell(t *testing.T) {
+	var internal atomic.Int32
+	def := NewDefinition().
+		State(stateA).
+		State(stateB, WithMinimumDwell(60*time.Millisecond)).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evBack, stateA).
+		SelfTransition(stateB, evDone, TransitionInternal, WithAction(func(c *Context) error {
+			internal.Add(1)
+			return nil
+		})).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	m.Send(Event{ID: evBack})
+	m.SendSync(Event{ID: evDone})
+	if internal.Load() != 1 {
+		t.Error("expected internal transition to run during the dwell time")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected transition out to wait for the dwell time, got %s", m.CurrentState())
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if m.CurrentState() != stateA {
+		t.Errorf("expected parked event after the dwell time, got %s", m.CurrentState())
+	}
+
+	// Once the dwell time has passed, events are handled immediately
+	m.SendSync(Event{ID: evGo})
+	time.Sleep(70 * time.Millisecond)
+	if err := m.SendSync(Event{ID: evBack}); err != nil || m.CurrentState() != stateA {
+		t.Errorf("expected immediate transition after the dwell time, got %s, %v", m.CurrentState(), err)
+	}
+}
+
+func TestWaitForTransition(t *testing.T) {
+	var ready atomic.Bool
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateB,
+			WithNamedGuard("ready", func(*GuardContext) bool { return ready.Load() }),
+			WithWaitFor(10*time.Millisecond, 80*time.Millisecond, evTimeout)).
+		Transition(stateA, evTimeout, stateC).
+		Transition(stateC, evBack, stateA).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: evGo}); err != nil {
+		t.Fatalf("expected waiting transition to claim the event, got %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if m.CurrentState() != stateA {
+		t.Fatalf("expected to wait while the guard rejects, got %s", m.CurrentState())
+	}
+	ready.Store(true)
+	time.Sleep(30 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected transition once the guard passes, got %s", m.CurrentState())
+	}
+
+	m.SetState(stateA)
+	ready.Store(false)
+	m.SendSync(Event{ID: evGo})
+	time.Sleep(120 * time.Millisecond)
+	if m.CurrentState() != stateC {
+		t.Fatalf("expected timeout event after giving up, got %s", m.CurrentState())
+	}
+
+	// A state change cancels the wait
+	m.SendSync(Event{ID: evBack})
+	m.SendSync(Event{ID: evGo})
+	m.SetState(stateC)
+	m.SetState(stateA)
+	ready.Store(true)
+	time.Sleep(30 * time.Millisecond)
+	if m.CurrentState() != stateA {
+		t.Errorf("expected wait to be cancelled by a state change, got %s", m.CurrentState())
+	}
+}
+
+func TestPollingState(t *testing.T) {
+	var registered atomic.Bool
+	var polls atomic.Int32
+	def := NewDefinition().
+		PollingState(stateA, 10*time.Millisecond, func(c *Context) StateID {
+			polls.Add(1)
+			if registered.Load() {
+				return stateB
+			}
+			return ""
+		}, WithPossibleTargets(stateB)).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evBack, stateC).
+		Transition(stateB, evGo, stateA).
+		Transition(stateC, evGo, stateA).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	time.Sleep(35 * time.Millisecond)
+	if m.CurrentState() != stateA || polls.Load() < 2 {
+		t.Fatalf("expected repeated polling while no target is returned, got %s after %d polls", m.CurrentState(), polls.Load())
+	}
+	registered.Store(true)
+	time.Sleep(25 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected polling state to route to %s, got %s", stateB, m.CurrentState())
+	}
+
+	// Leaving the state stops polling
+	registered.Store(false)
+	m.SendSync(Event{ID: evGo})
+	m.SendSync(Event{ID: evBack})
+	n := polls.Load()
+	time.Sleep(30 * time.Millisecond)
+	if polls.Load() != n || m.CurrentState() != stateC {
+		t.Errorf("expected polling to stop on exit, got %d more polls in %s", polls.Load()-n, m.CurrentState())
+	}
+
+	if _, err := NewDefinition().PollingState(stateA, 0, nil).Initial(stateA).Build(); err == nil {
+		t.Error("expected build error for invalid polling state")
+	}
+
+	// Swapping a polling state for a plain one stops polling, and back resumes it
+	m.SendSync(Event{ID: evGo})
+	plain := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evBack, stateC).
+		Transition(stateB, evGo, stateA).
+		Transition(stateC, evGo, stateA).
+		Initial(stateA)
+	if err := m.Swap(plain); err != nil {
+		t.Fatalf("swap failed: %v", err)
+	}
+	n = polls.Load()
+	time.Sleep(30 * time.Millisecond)
+	if polls.Load() != n {
+		t.Errorf("expected polling to stop after swapping in a plain state, got %d more polls", polls.Load()-n)
+	}
+	if err := m.Swap(def); err != nil {
+		t.Fatalf("swap failed: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if polls.Load() == n {
+		t.Error("expected polling to resume after swapping the polling state back in")
+	}
+}
+
+func TestTimeoutStages(t *testing.T) {
+	const evWarn, evForce EventID = "warn", "force"
+	var warnings atomic.Int32
+	def := NewDefinition().
+		State(stateA,
+			WithTimeoutStage(20*time.Millisecond, evWarn),
+			WithTimeoutStage(50*time.Millisecond, evForce),
+			WithOnEvent(evWarn, func(*Context) error {
+				warnings.Add(1)
+				return nil
+			})).
+		State(stateB).
+		Transition(stateA, evForce, stateB).
+		Transition(stateB, evGo, stateA).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	time.Sleep(35 * time.Millisecond)
+	if warnings.Load() != 1 || m.CurrentState() != stateA {
+		t.Fatalf("expected the warning stage to fire first, got %d warnings in %s", warnings.Load(), m.CurrentState())
+	}
+	time.Sleep(30 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected the final stage to transition, got %s", m.CurrentState())
+	}
+
+	// Leaving early cancels all stages
+	m.SendSync(Event{ID: evGo})
+	m.SetState(stateB)
+	time.Sleep(70 * time.Millisecond)
+	if warnings.Load() != 1 {
+		t.Errorf("expected stages to be cancelled on exit, got %d warnings", warnings.Load())
+	}
+
+	findings := NewDefinition().
+		State(stateA, WithTimeoutStage(time.Second, evWarn)).
+		Initial(stateA).
+		Lint(LintUnhandledTimeouts)
+	if len(findings) != 1 {
+		t.Errorf("expected unhandled timeout stage to be flagged, got %v", findings)
+	}
+}
+
+func TestGuardedTimeoutTransitions(t *testing.T) {
+	var seatboxClosed atomic.Bool
+	build := func() *Machine {
+		def := NewDefinition().
+			State(stateA,
+				WithGuardedTimeoutTransition(20*time.Millisecond, stateB,
+					WithNamedGuard("seatbox_closed", func(*GuardContext) bool { return seatboxClosed.Load() })),
+				WithTimeoutTransition(20*time.Millisecond, stateC)).
+			State(stateB).
+			State(stateC).
+			Initial(stateA)
+		m, err := def.Build()
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+		return m
+	}
+
+	seatboxClosed.Store(true)
+	m := build()
+	time.Sleep(40 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Errorf("expected guarded timeout route, got %s", m.CurrentState())
+	}
+	m.Stop()
+
+	seatboxClosed.Store(false)
+	m = build()
+	time.Sleep(40 * time.Millisecond)
+	if m.CurrentState() != stateC {
+		t.Errorf("expected fallback timeout route, got %s", m.CurrentState())
+	}
+	desc := m.Describe()
+	m.Stop()
+
+	var routes []string
+	for _, td := range desc.Transitions {
+		if td.From == stateA {
+			routes = append(routes, fmt.Sprintf("%s->%s[%s]", td.Event, td.To, td.Guard))
+		}
+	}
+	if fmt.Sprint(routes) != "[__timeout_a->b[seatbox_closed] __timeout_a->c[]]" {
+		t.Errorf("expected generated timeout transitions in the description, got %v", routes)
+	}
+
+	_, err := NewDefinition().
+		State(stateA, WithGuardedTimeoutTransition(time.Second, stateB, WithGuard(func(*GuardContext) bool { return true }))).
+		Initial(stateA).
+		Build()
+	if err == nil {
+		t.Error("expected validation error for undefined timeout target")
+	}
+}
+
+func TestLogLevelControl(t *testing.T) {
+	const evTick EventID = "tick"
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evBack, stateA, WithQuiet()).
+		SelfTransition(stateA, evTick, TransitionInternal).
+		SelfTransition(stateB, evTick, TransitionInternal).
+		Initial(stateA)
+	m, err := def.Build(WithLogger(logger),
+		WithVerboseStateLogging(slog.LevelInfo),
+		WithEventLogLevel(evGo, slog.LevelInfo),
+		WithEventLogLevel(evTick, slog.LevelDebug-4))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+	buf.Reset()
+
+	m.SendSync(Event{ID: evGo})
+	if !strings.Contains(buf.String(), `msg="executing transition" from=a to=b`) {
+		t.Errorf("expected processing logs at info level, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	m.SendSync(Event{ID: evBack})
+	m.SendSync(Event{ID: evTick})
+	if buf.Len() != 0 {
+		t.Errorf("expected quiet transition and low-level event to log nothing, got:\n%s", buf.String())
+	}
+	if m.CurrentState() != stateA {
+		t.Errorf("expected quiet transition to be taken, got %s", m.CurrentState())
+	}
+}
+
+func TestPayloadRedactor(t *testing.T) {
+	const evPin EventID = "pin"
+	var seen atomic.Value
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evPin, stateB, WithAction(func(c *Context) error {
+			seen.Store(c.Event.Payload)
+			return nil
+		})).
+		Initial(stateA)
+	m, err := def.Build(WithPayloadRedactor(func(id EventID, payload any) any {
+		if id == evPin {
+			return "****"
+		}
+		return payload
+	}))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evPin, Payload: "1234"})
+	m.SendSync(Event{ID: evGo, Payload: 7})
+	if seen.Load() != "1234" {
+		t.Errorf("expected actions to see the original payload, got %v", seen.Load())
+	}
+	var payloads []string
+	for _, rec := range m.RecentEvents(2) {
+		payloads = append(payloads, rec.Payload)
+	}
+	if fmt.Sprint(payloads) != "[**** 7]" {
+		t.Errorf("expected redacted payloads in the event log, got %v", payloads)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	failure := errors.New("motor controller offline")
+	def := NewDefinition().
+		State(stateA).
+		State(stateB, WithOnEnter(func(*Context) error { return failure })).
+		State(stateC).
+		Transition(stateA, evGo, stateB).
+		Transition(stateA, evBack, stateC, WithGuard(func(*GuardContext) bool { return false })).
+		Initial(stateA)
+	m, err := def.Build(WithStrictEvents())
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	err = m.SendSync(Event{ID: evBack})
+	if !errors.Is(err, ErrGuardRejected) || !errors.Is(err, ErrNoTransition) {
+		t.Errorf("expected ErrGuardRejected and ErrNoTransition, got %v", err)
+	}
+	if err := m.SendSync(Event{ID: evDone}); !errors.Is(err, ErrNoTransition) || errors.Is(err, ErrGuardRejected) {
+		t.Errorf("expected plain ErrNoTransition, got %v", err)
+	}
+
+	err = m.SendSync(Event{ID: evGo})
+	var actionErr *ActionError
+	if !errors.Is(err, ErrActionFailed) || !errors.Is(err, failure) || !errors.As(err, &actionErr) || actionErr.State != stateB {
+		t.Errorf("expected ActionError for %s wrapping the cause, got %v", stateB, err)
+	}
+
+	if err := m.SetState("nowhere"); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("expected ErrUnknownState, got %v", err)
+	}
+	if _, err := NewDefinition().State(stateA).Transition(stateA, evGo, stateB).Initial(stateA).Build(); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("expected validation error to match ErrUnknownState, got %v", err)
+	}
+	m.Stop()
+}
+
+func TestSendSyncResult(t *testing.T) {
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		FinalState(stateFinal).
+		Transition(stateA, evGo, stateB, WithNamedGuard("kickstand_up", func(g *GuardContext) bool { return g.Event.Payload == true })).
+		Transition(stateA, evGo, stateC, WithNamedGuard("charging", func(*GuardContext) bool { return false })).
+		Transition(stateA, evDone, stateB, WithDelay(time.Hour)).
+		Transition(stateB, evDone, stateFinal).
+		State(stateC).
+		Initial(stateA)
+	m, err := def.Build(WithStrictEvents())
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	for _, tc := range []struct {
+		event Event
+		want  string
+	}{
+		{Event{ID: evBack}, "no match a->a []"},
+		{Event{ID: evGo}, "guards rejected a->a [kickstand_up charging]"},
+		{Event{ID: evDone}, "deferred a->a []"},
+		{Event{ID: evGo, Payload: true}, "transitioned a->b []"},
+		{Event{ID: evDone}, "transitioned b->final []"},
+		{Event{ID: evGo}, "final state final->final []"},
+	} {
+		res, err := m.SendSyncResult(tc.event)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc.event.ID, err)
+		}
+		if got := fmt.Sprintf("%s %s->%s %v", res.Outcome, res.From, res.To, res.RejectedGuards); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.event.ID, tc.want, got)
+		}
+	}
+}
+
+func TestDeferEvent(t *testing.T) {
+	var handled atomic.Int32
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		SelfTransition(stateA, evDone, TransitionInternal, WithAction(func(c *Context) error {
+			c.DeferEvent(*c.Event)
+			return nil
+		})).
+		SelfTransition(stateB, evDone, TransitionInternal, WithAction(func(c *Context) error {
+			handled.Add(1)
+			return nil
+		})).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evDone})
+	m.SendSync(Event{ID: evDone})
+	time.Sleep(10 * time.Millisecond)
+	if handled.Load() != 0 {
+		t.Fatal("expected deferred events to wait for a state change")
+	}
+
+	m.SendSync(Event{ID: evGo})
+	time.Sleep(10 * time.Millisecond)
+	if handled.Load() != 2 {
+		t.Errorf("expected both deferred events after the state change, got %d", handled.Load())
+	}
+}
+
+func TestAbortTransition(t *testing.T) {
+	var entries atomic.Int32
+	var battery atomic.Int32
+	def := NewDefinition().
+		State(stateParent).
+		State(stateA, WithParent(stateParent), WithOnEnter(func(*Context) error {
+			entries.Add(1)
+			return nil
+		})).
+		State(stateB).
+		Transition(stateA, evGo, stateB, WithAction(func(c *Context) error {
+			if battery.Load() < 10 {
+				return c.Abort("battery too low")
+			}
+			return nil
+		})).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	res, err := m.SendSyncResult(Event{ID: evGo})
+	if err != nil {
+		t.Fatalf("expected aborted transition to be handled, got %v", err)
+	}
+	if res.Outcome != SendAborted || res.AbortReason != "battery too low" || m.CurrentState() != stateA {
+		t.Errorf("expected abort back to %s, got %s (%q) in %s", stateA, res.Outcome, res.AbortReason, m.CurrentState())
+	}
+	if entries.Load() != 2 {
+		t.Errorf("expected source state to be re-entered, got %d entries", entries.Load())
+	}
+
+	battery.Store(50)
+	if res, _ := m.SendSyncResult(Event{ID: evGo}); res.Outcome != SendTransitioned || m.CurrentState() != stateB {
+		t.Errorf("expected transition to proceed, got %s in %s", res.Outcome, m.CurrentState())
+	}
+}
+
+func TestSendAsync(t *testing.T) {
+	release := make(chan struct{})
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB, WithAction(func(*Context) error {
+			<-release
+			return nil
+		})).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	r := m.SendAsync(Event{ID: evGo})
+	unhandled := m.SendAsync(Event{ID: evBack})
+	select {
+	case <-r.Done():
+		t.Fatal("expected result to resolve only after processing")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+
+	res, err := r.Wait()
+	if err != nil || res.Outcome != SendTransitioned || res.To != stateB {
+		t.Errorf("expected transition to %s, got %+v, %v", stateB, res, err)
+	}
+	if res, err := unhandled.Wait(); err != nil || res.Outcome != SendNoMatch {
+		t.Errorf("expected unhandled result, got %+v, %v", res, err)
+	}
+
+	m.Stop()
+	if res, err := m.SendAsync(Event{ID: evGo}).Wait(); err == nil || res.Outcome != SendFailed {
+		t.Errorf("expected failure after stop, got %+v, %v", res, err)
+	}
+}
+
+func TestLatencyMetrics(t *testing.T) {
+	var samples atomic.Int32
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB, WithAction(func(*Context) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})).
+		Transition(stateB, evBack, stateA).
+		Initial(stateA)
+	m, err := def.Build(WithLatencyMetrics(func(s LatencySample) { samples.Add(1) }))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.Send(Event{ID: evGo})
+	m.SendSync(Event{ID: evBack}) // Waits behind the slow action
+
+	lat := m.Stats().Latency
+	if samples.Load() != 2 || lat[evGo].Count != 1 || lat[evBack].Count != 1 {
+		t.Fatalf("expected one sample per event, got %d samples: %+v", samples.Load(), lat)
+	}
+	if lat[evGo].MaxProcessing < 20*time.Millisecond {
+		t.Errorf("expected processing time to include the action, got %v", lat[evGo].MaxProcessing)
+	}
+	if lat[evBack].MaxWait < 15*time.Millisecond || lat[evBack].MaxTotal < lat[evBack].MaxWait {
+		t.Errorf("expected queue wait behind the slow action, got %+v", lat[evBack])
+	}
+}
+
+func TestParallelGuards(t *testing.T) {
+	slow := func(pass bool) func(*GuardContext) (bool, error) {
+		return func(*GuardContext) (bool, error) {
+			time.Sleep(30 * time.Millisecond)
+			return pass, nil
+		}
+	}
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateFinal, WithGuardErr(slow(false))).
+		Transition(stateA, evGo, stateB, WithGuardErr(slow(true))).
+		Transition(stateA, evGo, stateC, WithGuardErr(func(*GuardContext) (bool, error) {
+			time.Sleep(30 * time.Millisecond)
+			return false, errors.New("sysfs read failed")
+		})).
+		FinalState(stateFinal).
+		Initial(stateA)
+	m, err := def.Build(WithParallelGuards())
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	start := time.Now()
+	if err := m.SendSync(Event{ID: evGo}); err != nil {
+		t.Fatalf("expected lower-priority guard error to be ignored, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 75*time.Millisecond {
+		t.Errorf("expected guards to run concurrently, took %v", elapsed)
+	}
+	if m.CurrentState() != stateB {
+		t.Errorf("expected highest-priority passing transition, got %s", m.CurrentState())
+	}
+}
+
+func TestContextGo(t *testing.T) {
+	cancelled := make(chan struct{})
+	def := NewDefinition().
+		State(stateA, WithOnEnter(func(c *Context) error {
+			c.Go("flash", func(ctx context.Context) (any, error) {
+				time.Sleep(20 * time.Millisecond)
+				return 42, nil
+			})
+			return nil
+		})).
+		State(stateB, WithOnEnter(func(c *Context) error {
+			c.Go("upload", func(ctx context.Context) (any, error) {
+				<-ctx.Done()
+				close(cancelled)
+				return nil, ctx.Err()
+			})
+			return nil
+		})).
+		State(stateC).
+		Transition(stateA, JobDone("flash"), stateB, WithGuard(PayloadWhere(func(r JobResult) bool { return r.Value == 42 }))).
+		Transition(stateB, evGo, stateC).
+		Transition(stateC, JobFailed("upload"), stateA).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SendSync(Event{ID: evDone}); err != nil {
+		t.Fatalf("expected event loop to stay responsive while the job runs, got %v", err)
+	}
+	if m.CurrentState() != stateA {
+		t.Fatalf("expected to wait for the job, got %s", m.CurrentState())
+	}
+	time.Sleep(40 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected job completion event, got %s", m.CurrentState())
+	}
+
+	m.SendSync(Event{ID: evGo})
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected job to be cancelled on state exit")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if m.CurrentState() != stateC {
+		t.Errorf("expected completion of the cancelled job to be dropped, got %s", m.CurrentState())
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	var mu sync.Mutex
+	var rejected atomic.Bool
+	phases := make(map[string][]JobPhase)
+	observe := func(e JobEvent) {
+		mu.Lock()
+		phases[e.Job] = append(phases[e.Job], e.Phase)
+		mu.Unlock()
+	}
+	block := func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	def := NewDefinition().
+		State(stateA, WithOnEnter(func(c *Context) error {
+			c.Go("slow", block)
+			c.Go("waiting", block)
+			c.Go("rejected", block)
+			return nil
+		})).
+		State(stateB, WithOnEnter(func(c *Context) error {
+			c.Go("boom", func(ctx context.Context) (any, error) { panic("flash write") })
+			return nil
+		})).
+		State(stateC).
+		SelfTransition(stateA, JobFailed("rejected"), TransitionInternal, WithAction(func(c *Context) error {
+			if r, _ := c.Event.Payload.(JobResult); errors.Is(r.Err, ErrWorkerPoolFull) {
+				rejected.Store(true)
+			}
+			return nil
+		})).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, JobFailed("boom"), stateC, WithGuard(PayloadWhere(func(r JobResult) bool {
+			return errors.Is(r.Err, ErrJobPanicked)
+		}))).
+		Initial(stateA)
+	m, err := def.Build(WithWorkerPool(WorkerPoolOptions{Size: 1, QueueLimit: 1, Observe: observe}))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	time.Sleep(20 * time.Millisecond)
+	if !rejected.Load() {
+		t.Fatal("expected the job over the queue limit to fail with ErrWorkerPoolFull")
+	}
+	m.SendSync(Event{ID: evGo})
+	time.Sleep(20 * time.Millisecond)
+	if m.CurrentState() != stateC {
+		t.Fatalf("expected the panicking job to fail, got %s", m.CurrentState())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// One of the two blocking jobs holds the only worker, the other waits for it
+	started := 0
+	for _, job := range []string{"slow", "waiting"} {
+		p := phases[job]
+		if len(p) < 2 || p[0] != JobPhaseQueued || p[len(p)-1] != JobPhaseCancelled {
+			t.Errorf("job %s: expected to be queued and cancelled, got %v", job, p)
+		}
+		if len(p) == 3 && p[1] == JobPhaseStarted {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Errorf("expected exactly one job to run on a pool of size 1, got %d", started)
+	}
+	want := map[string][]JobPhase{
+		"rejected": {JobPhaseRejected},
+		"boom":     {JobPhaseQueued, JobPhaseStarted, JobPhaseFailed},
+	}
+	for job, w := range want {
+		if fmt.Sprint(phases[job]) != fmt.Sprint(w) {
+			t.Errorf("job %s: expected phases %v, got %v", job, w, phases[job])
+		}
+	}
+}
+
+// chanSource is an EventSource delivering the events sent on a channel
+type chanSource chan Event
+
+func TestWorkerPoolRejectionOwned(t *testing.T) {
+	block := func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	def := NewDefinition().
+		State(stateA, WithOnEnter(func(c *Context) error {
+			c.Go("slow", block)
+			c.Go("waiting", block)
+			c.Go("rejected", block)
+			return nil
+		})).
+		State(stateB).
+		State(stateC).
+		EventlessTransition(stateA, stateB).
+		Transition(stateB, JobFailed("rejected"), stateC).
+		Initial(stateA)
+	m, err := def.Build(WithWorkerPool(WorkerPoolOptions{Size: 1, QueueLimit: 1}))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	// The rejection belongs to stateA like any completion and is dropped when it exits
+	time.Sleep(20 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Errorf("expected rejection of a job of an exited state to be dropped, got %s", m.CurrentState())
+	}
+}
+
+func (s chanSource) Run(ctx context.Context, send func(Event)) error {
+	for {
+		select {
+		case event := <-s:
+			send(event)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// recordingAdapter is a StateSink and MetricsCollector recording what it receives
+type recordingAdapter struct {
+	mu      sync.Mutex
+	changes []StateChanged
+	samples []LatencySample
+	moves   int
+}
+
+func (r *recordingAdapter) PublishState(change StateChanged) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, change)
+	return nil
+}
+
+func (r *recordingAdapter) ObserveEvent(sample LatencySample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, sample)
+}
+
+func (r *recordingAdapter) ObserveStateChange(from, to StateID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.moves++
+}
+
+func TestAdapters(t *testing.T) {
+	src := make(chanSource)
+	rec := &recordingAdapter{}
+	def := NewDefinition().
+		State(stateA).
+		State(stateB).
+		Transition(stateA, evGo, stateB).
+		Initial(stateA)
+	m, err := def.Build(WithEventSource(src), WithStateSink(rec, "vehicle"), WithMetricsCollector(rec))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	src <- Event{ID: evGo}
+	time.Sleep(20 * time.Millisecond)
+	if m.CurrentState() != stateB {
+		t.Fatalf("expected the source's event to be processed, got %s", m.CurrentState())
+	}
+
+	rec.mu.Lock()
+	if len(rec.changes) != 1 || rec.changes[0] != (StateChanged{Machine: "vehicle", From: stateA, To: stateB}) {
+		t.Errorf("expected the state change to reach the sink, got %+v", rec.changes)
+	}
+	if rec.moves != 1 || len(rec.samples) != 1 || rec.samples[0].Event != evGo {
+		t.Errorf("expected the collector to see the event and state change, got %d changes and %+v", rec.moves, rec.samples)
+	}
+	rec.mu.Unlock()
+
+	m.Stop()
+	select {
+	case src <- Event{ID: evGo}:
+		t.Error("expected the source to stop with the machine")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSeed(t *testing.T) {
+	var draws []int
+	def := NewDefinition().
+		State(stateA).
+		RandomChoiceState(stateCond, []Weighted{{stateB, 1}, {stateC, 1}}).
+		State(stateB).
+		State(stateC).
+		Transition(stateA, evGo, stateCond, WithAction(func(c *Context) error {
+			draws = append(draws, c.RandIntn(1000))
+			return nil
+		})).
+		Transition(stateB, evBack, stateA).
+		Transition(stateC, evBack, stateA).
+		Initial(stateA)
+
+	run := func(opts ...MachineOption) (*Machine, string) {
+		draws = nil
+		m, err := def.Build(append(opts, WithRetryJitter(0.5))...)
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+		defer m.Stop()
+
+		var trace []string
+		for i := 0; i < 20; i++ {
+			m.SendSync(Event{ID: evGo})
+			trace = append(trace, string(m.CurrentState()))
+			m.SendSync(Event{ID: evBack})
+		}
+		jitter := m.jitter(time.Second)
+		if jitter < 500*time.Millisecond || jitter > 1500*time.Millisecond {
+			t.Errorf("expected jitter within ±50%%, got %v", jitter)
+		}
+		return m, fmt.Sprint(trace, draws, jitter)
+	}
+
+	m, first := run()
+	seed, ok := m.Seed()
+	if !ok {
+		t.Fatal("expected the default seed to be known")
+	}
+	if snap := m.Snapshot(); snap.Seed == nil || *snap.Seed != seed {
+		t.Errorf("expected the snapshot to carry seed %d, got %v", seed, snap.Seed)
+	}
+	if _, again := run(WithSeed(seed)); again != first {
+		t.Errorf("expected the reported seed to reproduce the run:\n%s\n%s", first, again)
+	}
+
+	m, _ = run(WithRandSource(rand.NewSource(1)))
+	if _, ok := m.Seed(); ok {
+		t.Error("expected the seed of a custom source to be unknown")
+	}
+}
+
+func TestProduct(t *testing.T) {
+	var readyEntries, readyExits, activeEntries atomic.Int32
+	var closeAllowed atomic.Bool
+	vehicle := NewDefinition().
+		State("parked").
+		State("ready",
+			WithOnEnter(func(c *Context) error { readyEntries.Add(1); return nil }),
+			WithOnExit(func(c *Context) error { readyExits.Add(1); return nil })).
+		State("driving").
+		Transition("parked", "unlock", "ready").
+		Transition("ready", "drive", "driving").
+		Transition("driving", "stop", "ready").
+		Transition("ready", "lock", "parked").
+		Initial("parked")
+	battery := NewDefinition().
+		State("idle").
+		State("active", WithOnEnter(func(c *Context) error { activeEntries.Add(1); return nil })).
+		Transition("idle", "open", "active").
+		Transition("active", "close", "idle", WithGuard(func(*GuardContext) bool { return closeAllowed.Load() })).
+		Initial("idle")
+
+	def := Product(vehicle, battery, map[EventID]EventID{"unlock": "open", "lock": "close"})
+	if desc := def.Describe(); len(desc.States) != 6 || desc.Initial != ProductState("parked", "idle") {
+		t.Fatalf("expected 6 product states starting in parked|idle, got %d starting in %s", len(desc.States), desc.Initial)
+	}
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	expect := func(event EventID, want StateID) {
+		t.Helper()
+		m.SendSync(Event{ID: event})
+		if m.CurrentState() != want {
+			t.Fatalf("after %s: expected %s, got %s", event, want, m.CurrentState())
+		}
+	}
+	expect("drive", ProductState("parked", "idle"))
+	expect("open", ProductState("ready", "active"))
+	expect("drive", ProductState("driving", "active"))
+	if readyEntries.Load() != 1 || readyExits.Load() != 1 || activeEntries.Load() != 1 {
+		t.Errorf("expected entry and exit actions only for the moving component, got %d/%d/%d",
+			readyEntries.Load(), readyExits.Load(), activeEntries.Load())
+	}
+	expect("stop", ProductState("ready", "active"))
+	expect("lock", ProductState("ready", "active"))
+	closeAllowed.Store(true)
+	expect("lock", ProductState("parked", "idle"))
+	if activeEntries.Load() != 1 || readyEntries.Load() != 2 {
+		t.Errorf("expected the battery to stay active while the vehicle moved, got %d battery entries", activeEntries.Load())
+	}
+
+	nested := NewDefinition().State("p", WithDefaultChild("c")).State("c", WithParent("p")).Initial("p")
+	if err := Product(nested, battery, nil).Err(); err == nil || !strings.Contains(err.Error(), "hierarchical") {
+		t.Errorf("expected hierarchical components to be rejected, got %v", err)
+	}
+}
+
+func TestFollowerMode(t *testing.T) {
+	var entries, exits, timeouts atomic.Int32
+	def := NewDefinition().
+		State(stateA, WithOnExit(func(c *Context) error { exits.Add(1); return nil })).
+		State(stateB,
+			WithOnEnter(func(c *Context) error { entries.Add(1); return nil }),
+			WithTimeout(10*time.Millisecond, evTimeout)).
+		Transition(stateA, evGo, stateB).
+		Transition(stateB, evTimeout, stateA, WithGuard(func(*GuardContext) bool { return true })).
+		SelfTransition(stateB, evTimeout, TransitionInternal, WithAction(func(c *Context) error {
+			timeouts.Add(1)
+			return nil
+		})).
+		EventlessTransition(stateB, stateA).
+		Initial(stateA)
+	m, err := def.Build(WithFollowerMode("announce"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	m.SendSync(Event{ID: evGo})
+	if m.CurrentState() != stateA {
+		t.Fatalf("expected the follower not to decide transitions, got %s", m.CurrentState())
+	}
+
+	res, err := m.SendSyncResult(Event{ID: "announce", Payload: "b"})
+	if err != nil || res.Outcome != SendTransitioned || m.CurrentState() != stateB {
+		t.Fatalf("expected to follow the announcement, got %s (%v, %v)", m.CurrentState(), res.Outcome, err)
+	}
+	if entries.Load() != 1 || exits.Load() != 1 {
+		t.Errorf("expected local entry and exit actions, got %d entries and %d exits", entries.Load(), exits.Load())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if m.CurrentState() != stateB || timeouts.Load() != 1 {
+		t.Errorf("expected the local timeout to only take the internal transition, got %s after %d timeouts", m.CurrentState(), timeouts.Load())
+	}
+
+	if err := m.SendSync(Event{ID: "announce", Payload: StateAnnouncement{State: "nope"}}); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("expected ErrUnknownState for an unknown announced state, got %v", err)
+	}
+	m.SendSync(Event{ID: "announce", Payload: stateA})
+	if m.CurrentState() != stateA {
+		t.Errorf("expected to follow back to %s, got %s", stateA, m.CurrentState())
+	}
+}
+
+func TestSetStateOptions(t *testing.T) {
+	var slowDone atomic.Bool
+	def := NewDefinition().
+		State(stateA).
+		State(stateB, WithTimeout(10*time.Millisecond, evTimeout)).
+		State(stateC).
+		Transition(stateA, evGo, stateA, WithAction(func(c *Context) error {
+			time.Sleep(30 * time.Millisecond)
+			slowDone.Store(true)
+			return nil
+		})).
+		Transition(stateB, evTimeout, stateC).
+		Initial(stateA)
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	m.Send(Event{ID: evGo})
+	time.Sleep(5 * time.Millisecond)
+	if err := m.SetState(stateB, SuppressTimers(), Reason("legacy boot")); err != nil {
+		t.Fatalf("SetState failed: %v", err)
+	}
+	if !slowDone.Load() {
+		t.Error("expected SetState to wait for the event in flight")
+	}
+	if info, _ := m.LastTransition(); info.From != stateA || info.To != stateB || info.Reason != "legacy boot" {
+		t.Errorf("expected the reason to be recorded, got %+v", info)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if m.CurrentState() != stateB || m.TimerActive("_timeout_b") {
+		t.Errorf("expected no timeout to be armed, got %s", m.CurrentState())
+	}
+	m.SetState(stateA)
+	m.SetState(stateB)
+	time.Sleep(30 * time.Millisecond)
+	if m.CurrentState() != stateC {
+		t.Errorf("expected timeouts by default, got %s", m.CurrentState())
+	}
+
+	m.Stop()
+	<-m.Done()
+	if err := m.SetState(stateA); !errors.Is(err, ErrMachineStopped) {
+		t.Errorf("expected ErrMachineStopped after Stop, got %v", err)
+	}
+}
+
+func TestFollowerPollingState(t *testing.T) {
+	def := NewDefinition().
+		PollingState("waiting", 5*time.Millisecond, func(*Context) StateID { return "ready" }, WithPossibleTargets("ready")).
+		State("ready").
+		Initial("waiting")
+	m, err := def.Build(WithFollowerMode("announce"))
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	if m.CurrentState() != "waiting" || m.TimerActive(pollTimerName("waiting")) {
+		t.Fatalf("expected the follower not to poll, got %s", m.CurrentState())
+	}
+	m.SendSync(Event{ID: "announce", Payload: "ready"})
+	if m.CurrentState() != "ready" {
+		t.Errorf("expected to follow the announcement, got %s", m.CurrentState())
+	}
+}
@@ -0,0 +1,50 @@
+package librefsm
+
+import "context"
+
+// Result is the pending outcome of an event sent with SendAsync
+type Result struct {
+	ready  chan struct{}
+	result SendResult
+	err    error
+}
+
+// SendAsync queues an event like Send and returns a Result that resolves once
+// that event was processed, with the same information as SendSyncResult.
+// Like SendSync it bypasses debounce and throttle settings, and unhandled
+// events are not passed to the unhandled handler since the sender learns of them.
+func (m *Machine) SendAsync(event Event) *Result {
+	r := &Result{ready: make(chan struct{})}
+	result, done, err := m.sendWithResult(event)
+	if err != nil {
+		r.result, r.err = *result, err
+		close(r.ready)
+		return r
+	}
+	go func() {
+		r.result, r.err = settleResult(result, <-done)
+		close(r.ready)
+	}()
+	return r
+}
+
+// Done returns a channel closed once the event was processed or discarded
+func (r *Result) Done() <-chan struct{} {
+	return r.ready
+}
+
+// Wait blocks until the event was processed and returns what it did
+func (r *Result) Wait() (SendResult, error) {
+	<-r.ready
+	return r.result, r.err
+}
+
+// WaitContext is like Wait but gives up when ctx is done
+func (r *Result) WaitContext(ctx context.Context) (SendResult, error) {
+	select {
+	case <-r.ready:
+		return r.result, r.err
+	case <-ctx.Done():
+		return SendResult{}, ctx.Err()
+	}
+}
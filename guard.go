@@ -0,0 +1,196 @@
+package librefsm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Guard is a composable transition guard with an optional name.
+// Names show up in logs, making composed guard logic identifiable in the field.
+type Guard struct {
+	Name string
+	Fn   func(*GuardContext) bool
+}
+
+// NamedGuard wraps a guard function with a name
+func NamedGuard(name string, fn func(*GuardContext) bool) Guard {
+	return Guard{Name: name, Fn: fn}
+}
+
+// Check evaluates the guard
+func (g Guard) Check(ctx *GuardContext) bool {
+	return g.Fn(ctx)
+}
+
+// Not negates a guard
+func Not(g Guard) Guard {
+	return Guard{
+		Name: "!" + guardName(g),
+		Fn: func(ctx *GuardContext) bool {
+			return !g.Fn(ctx)
+		},
+	}
+}
+
+// And passes when all guards pass, evaluating them in order and stopping at the first failure
+func And(guards ...Guard) Guard {
+	return Guard{
+		Name: joinGuardNames(guards, " && "),
+		Fn: func(ctx *GuardContext) bool {
+			for _, g := range guards {
+				if !g.Fn(ctx) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// Or passes when any guard passes, evaluating them in order and stopping at the first success
+func Or(guards ...Guard) Guard {
+	return Guard{
+		Name: joinGuardNames(guards, " || "),
+		Fn: func(ctx *GuardContext) bool {
+			for _, g := range guards {
+				if g.Fn(ctx) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// When sets a composed guard on the transition, keeping its name
+func When(g Guard) TransitionOption {
+	return func(t *Transition) {
+		t.Guard = g.Fn
+		t.GuardErr = nil
+		t.GuardName = g.Name
+	}
+}
+
+// checkGuard evaluates the transition's guard; transitions without a guard pass
+func (m *Machine) checkGuard(t *Transition, event *Event) (bool, error) {
+	switch {
+	case t.GuardErr != nil:
+		passed, err := t.GuardErr(m.makeGuardContext(event, t))
+		if err != nil {
+			return false, fmt.Errorf("guard %q on %q -> %q failed: %w", t.guardLabel(), t.From, t.To, err)
+		}
+		return passed, nil
+	case t.Guard != nil:
+		return t.Guard(m.makeGuardContext(event, t)), nil
+	}
+	return true, nil
+}
+
+// guardLabel returns the transition's guard name for logs and diagrams
+func (t *Transition) guardLabel() string {
+	if t.GuardName == "" {
+		return "<anonymous>"
+	}
+	return t.GuardName
+}
+
+// guardName returns the guard's name, parenthesized if it is a composite expression
+func guardName(g Guard) string {
+	switch {
+	case g.Name == "":
+		return "<anonymous>"
+	case strings.ContainsAny(g.Name, " "):
+		return "(" + g.Name + ")"
+	default:
+		return g.Name
+	}
+}
+
+func joinGuardNames(guards []Guard, sep string) string {
+	names := make([]string, len(guards))
+	for i, g := range guards {
+		names[i] = guardName(g)
+	}
+	return strings.Join(names, sep)
+}
+
+// PayloadEquals returns a guard passing when the event payload is a T equal to want
+func PayloadEquals[T comparable](want T) func(*GuardContext) bool {
+	return PayloadWhere(func(p T) bool {
+		return p == want
+	})
+}
+
+// PayloadWhere returns a guard passing when the event payload is a T satisfying fn.
+// Events without a payload of type T are rejected.
+func PayloadWhere[T any](fn func(T) bool) func(*GuardContext) bool {
+	return func(ctx *GuardContext) bool {
+		if ctx.Event == nil {
+			return false
+		}
+		p, ok := ctx.Event.Payload.(T)
+		return ok && fn(p)
+	}
+}
+
+// WithParallelGuards evaluates the guards of all transitions matching an event
+// concurrently when more than one is guarded, for expensive guards such as
+// Redis or sysfs reads. The outcome is the same as with sequential evaluation
+// as long as guards have no side effects and do not depend on each other:
+//
+//   - the first passing transition in the usual priority order is taken
+//   - a guard error aborts processing only if it comes before that transition
+//     in priority order; errors of lower-priority guards are ignored
+//   - every candidate guard runs, including those sequential evaluation would
+//     have skipped, so guards must be safe for concurrent use
+//
+// Processing still waits for the slowest guard.
+func WithParallelGuards() MachineOption {
+	return func(m *Machine) {
+		m.parallelGuards = true
+	}
+}
+
+// guardResult is the outcome of one guard evaluation
+type guardResult struct {
+	passed bool
+	err    error
+}
+
+// checkGuardsParallel evaluates the candidates' guards concurrently and returns
+// a checkGuard replacement serving the precomputed results
+func (m *Machine) checkGuardsParallel(candidates []*Transition, event *Event) func(*Transition, *Event) (bool, error) {
+	guarded := 0
+	for _, t := range candidates {
+		if t.hasGuard() {
+			guarded++
+		}
+	}
+	if guarded < 2 {
+		return m.checkGuard
+	}
+
+	results := make(map[*Transition]*guardResult, guarded)
+	var wg sync.WaitGroup
+	for _, t := range candidates {
+		if !t.hasGuard() || results[t] != nil {
+			continue
+		}
+		r := &guardResult{}
+		results[t] = r
+		wg.Add(1)
+		go func(t *Transition) {
+			defer wg.Done()
+			r.passed, r.err = m.checkGuard(t, event)
+		}(t)
+	}
+	wg.Wait()
+
+	return func(t *Transition, event *Event) (bool, error) {
+		if r := results[t]; r != nil {
+			return r.passed, r.err
+		}
+		return m.checkGuard(t, event)
+	}
+}
@@ -0,0 +1,160 @@
+package librefsm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default thresholds used by Health unless WithHealthCheck sets them
+const (
+	defaultProgressTimeout = 30 * time.Second
+	defaultSlowAction      = 10 * time.Second
+	defaultSaturation      = 0.9
+)
+
+// HealthConfig sets the thresholds of the health check
+type HealthConfig struct {
+	Interval        time.Duration // How often OnChange is evaluated; zero disables the checker
+	ProgressTimeout time.Duration // Max time without finishing an event while work is pending
+	SlowAction      time.Duration // Max run time of a single action
+	Saturation      float64       // Max queue fill ratio, 0..1
+}
+
+// Health reports whether the machine is making progress
+type Health struct {
+	Healthy      bool
+	Problems     []string  // Human-readable reasons when unhealthy
+	Running      bool      // Event loop is running
+	Paused       bool      // Paused on purpose, not counted as stuck
+	LastProgress time.Time // When the event loop last finished an event
+	SlowAction   string    // Longest-running action, empty if none runs
+	ActionTime   time.Duration
+	QueueDepth   int
+	Saturation   float64 // Fill ratio of the regular queue lane
+}
+
+// healthState tracks what Health reports
+type healthState struct {
+	config       HealthConfig
+	onChange     func(Health)
+	lastProgress atomic.Int64 // Unix nanoseconds
+	mu           sync.Mutex
+	actions      map[*actionRun]struct{}
+}
+
+// actionRun is an action being executed
+type actionRun struct {
+	name  string
+	start time.Time
+}
+
+// WithHealthCheck sets the health thresholds and, if config.Interval and
+// onChange are set, checks health periodically and calls onChange whenever
+// the machine turns unhealthy or recovers, e.g. to restart the service.
+// Zero thresholds keep their defaults (30s, 10s and 90%).
+func WithHealthCheck(config HealthConfig, onChange func(Health)) MachineOption {
+	return func(m *Machine) {
+		m.health.config = config
+		m.health.onChange = onChange
+	}
+}
+
+// Health reports whether the event loop has made progress recently, whether an
+// action has been running longer than the threshold, and how full the queue is.
+// It does not wait for the event being processed.
+func (m *Machine) Health() Health {
+	cfg := m.health.config
+	if cfg.ProgressTimeout <= 0 {
+		cfg.ProgressTimeout = defaultProgressTimeout
+	}
+	if cfg.SlowAction <= 0 {
+		cfg.SlowAction = defaultSlowAction
+	}
+	if cfg.Saturation <= 0 {
+		cfg.Saturation = defaultSaturation
+	}
+
+	now := time.Now()
+	h := Health{LastProgress: time.Unix(0, m.health.lastProgress.Load())}
+
+	q := m.queue
+	q.mu.Lock()
+	h.Running = m.loopDone != nil && !q.closed
+	h.Paused = q.paused
+	h.QueueDepth = len(q.control) + len(q.timers) + len(q.items)
+	if q.capacity > 0 {
+		h.Saturation = float64(len(q.items)) / float64(q.capacity)
+	}
+	busy := q.inflight != nil
+	q.mu.Unlock()
+
+	m.health.mu.Lock()
+	for run := range m.health.actions {
+		if d := now.Sub(run.start); d > h.ActionTime {
+			h.SlowAction, h.ActionTime = run.name, d
+		}
+	}
+	m.health.mu.Unlock()
+
+	if !h.Running {
+		h.Problems = append(h.Problems, "event loop not running")
+	}
+	if h.Running && !h.Paused && (busy || h.QueueDepth > 0) && now.Sub(h.LastProgress) > cfg.ProgressTimeout {
+		h.Problems = append(h.Problems, fmt.Sprintf("no progress for %s", now.Sub(h.LastProgress).Round(time.Millisecond)))
+	}
+	if h.ActionTime > cfg.SlowAction {
+		h.Problems = append(h.Problems, fmt.Sprintf("%s running for %s", h.SlowAction, h.ActionTime.Round(time.Millisecond)))
+	}
+	if h.Saturation >= cfg.Saturation {
+		h.Problems = append(h.Problems, fmt.Sprintf("queue %.0f%% full", h.Saturation*100))
+	}
+	h.Healthy = len(h.Problems) == 0
+	return h
+}
+
+// markProgress records that the event loop finished an event
+func (m *Machine) markProgress() {
+	m.health.lastProgress.Store(time.Now().UnixNano())
+}
+
+// beginAction registers a running action for Health; call the returned func when it ends
+func (m *Machine) beginAction(name string) func() {
+	run := &actionRun{name: name, start: time.Now()}
+	m.health.mu.Lock()
+	if m.health.actions == nil {
+		m.health.actions = make(map[*actionRun]struct{})
+	}
+	m.health.actions[run] = struct{}{}
+	m.health.mu.Unlock()
+
+	return func() {
+		m.health.mu.Lock()
+		delete(m.health.actions, run)
+		m.health.mu.Unlock()
+	}
+}
+
+// runHealthCheck evaluates health every interval and reports changes
+func (m *Machine) runHealthCheck(ctx context.Context) {
+	ticker := time.NewTicker(m.health.config.Interval)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h := m.Health()
+			if h.Healthy != healthy {
+				healthy = h.Healthy
+				if !healthy {
+					m.logger.Warn("machine unhealthy", "problems", h.Problems)
+				}
+				m.health.onChange(h)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package librefsm
+
+import (
+	"context"
+	"sync"
+)
+
+// runningAction is an action that an interrupt event can cancel
+type runningAction struct {
+	cancel      context.CancelFunc
+	interrupted chan struct{}
+	once        sync.Once
+}
+
+// WithInterruptEvents designates events that preempt running actions: sending one
+// cancels the context of every action in progress, which then fails with
+// ErrActionInterrupted once it returned or WithActionCancelGrace passed, and
+// the event is queued on the control lane so it is processed right after. Use it for events like "brake
+// pressed" that must not wait behind a slow upload.
+func WithInterruptEvents(events ...EventID) MachineOption {
+	return func(m *Machine) {
+		if m.interruptEvents == nil {
+			m.interruptEvents = make(map[EventID]bool)
+		}
+		if m.queue.controlEvents == nil {
+			m.queue.controlEvents = make(map[EventID]bool)
+		}
+		for _, ev := range events {
+			m.interruptEvents[ev] = true
+			m.queue.controlEvents[ev] = true
+		}
+	}
+}
+
+// trackAction registers a running action. The returned channel is closed when
+// it is interrupted; release unregisters it.
+func (m *Machine) trackAction(cancel context.CancelFunc) (<-chan struct{}, func()) {
+	a := &runningAction{cancel: cancel, interrupted: make(chan struct{})}
+
+	m.actionsMu.Lock()
+	if m.runningActions == nil {
+		m.runningActions = make(map[*runningAction]struct{})
+	}
+	m.runningActions[a] = struct{}{}
+	m.actionsMu.Unlock()
+
+	return a.interrupted, func() {
+		m.actionsMu.Lock()
+		delete(m.runningActions, a)
+		m.actionsMu.Unlock()
+	}
+}
+
+// interruptActions cancels all running actions
+func (m *Machine) interruptActions() {
+	m.actionsMu.Lock()
+	defer m.actionsMu.Unlock()
+	for a := range m.runningActions {
+		a.once.Do(func() {
+			a.cancel()
+			close(a.interrupted)
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package librefsm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultWorkers is the number of jobs started with Context.Go that run at once
+const defaultWorkers = 4
+
+// JobResult is the payload of a job's completion event
+type JobResult struct {
+	Job      string
+	Value    any   // Returned by the job
+	Err      error // Set for JobFailed events
+	Duration time.Duration
+}
+
+// JobDone returns the event sent when the job named name returns without error
+func JobDone(name string) EventID {
+	return EventID("job:" + name + ":done")
+}
+
+// JobFailed returns the event sent when the job named name returns an error
+func JobFailed(name string) EventID {
+	return EventID("job:" + name + ":failed")
+}
+
+// JobPhase is a step in a job's lifecycle
+type JobPhase int
+
+const (
+	JobPhaseQueued    JobPhase = iota // Accepted, waiting for a worker
+	JobPhaseStarted                   // Running on a worker
+	JobPhaseFinished                  // Returned without error
+	JobPhaseFailed                    // Returned an error or panicked
+	JobPhaseCancelled                 // Cancelled by leaving its state or stopping the machine
+	JobPhaseRejected                  // Not accepted because the pool queue was full
+)
+
+func (p JobPhase) String() string {
+	switch p {
+	case JobPhaseQueued:
+		return "queued"
+	case JobPhaseStarted:
+		return "started"
+	case JobPhaseFinished:
+		return "finished"
+	case JobPhaseFailed:
+		return "failed"
+	case JobPhaseCancelled:
+		return "cancelled"
+	case JobPhaseRejected:
+		return "rejected"
+	default:
+		return fmt.Sprintf("JobPhase(%d)", int(p))
+	}
+}
+
+// JobEvent reports a step in a job's lifecycle, see WorkerPoolOptions.Observe
+type JobEvent struct {
+	Job      string
+	State    StateID // State owning the job
+	Phase    JobPhase
+	Err      error         // Set for JobPhaseFailed and JobPhaseRejected
+	Duration time.Duration // Run time, set for JobPhaseFinished and JobPhaseFailed
+}
+
+// WorkerPoolOptions configures the pool running jobs started with Context.Go
+type WorkerPoolOptions struct {
+	Size       int // Jobs running at once, default 4
+	QueueLimit int // Jobs waiting for a worker before further ones fail with ErrWorkerPoolFull; 0 means unlimited
+
+	// CrashOnPanic lets a panicking job crash the process instead of failing
+	// it with ErrJobPanicked
+	CrashOnPanic bool
+
+	// Observe, if not nil, is called for every lifecycle step of every job. It
+	// runs on the job's goroutine or the event loop and must return quickly.
+	Observe func(JobEvent)
+}
+
+// WithWorkerPool configures the pool running jobs started with Context.Go
+func WithWorkerPool(opts WorkerPoolOptions) MachineOption {
+	return func(m *Machine) {
+		m.workers = newWorkerPool(opts)
+	}
+}
+
+// job is a unit of background work started with Context.Go
+type job struct {
+	name   string
+	owner  StateID
+	cancel context.CancelFunc
+	active bool // Counted in workerPool.active, guarded by its mu
+}
+
+// workerPool runs background jobs with bounded concurrency
+type workerPool struct {
+	mu     sync.Mutex
+	slots  chan struct{}
+	jobs   map[*job]struct{}
+	active int // Jobs waiting for or holding a slot
+	opts   WorkerPoolOptions
+}
+
+func newWorkerPool(opts WorkerPoolOptions) *workerPool {
+	if opts.Size <= 0 {
+		opts.Size = defaultWorkers
+	}
+	return &workerPool{slots: make(chan struct{}, opts.Size), jobs: make(map[*job]struct{}), opts: opts}
+}
+
+// observe reports a lifecycle step to the configured observer
+func (p *workerPool) observe(j *job, phase JobPhase, err error, d time.Duration) {
+	if p.opts.Observe != nil {
+		p.opts.Observe(JobEvent{Job: j.name, State: j.owner, Phase: phase, Err: err, Duration: d})
+	}
+}
+
+// Go runs fn on the machine's worker pool so that slow side effects such as
+// hardware operations do not block the event loop. Its completion comes back
+// as a JobDone(name) or JobFailed(name) event carrying a JobResult. Jobs belong
+// to the current state: leaving it cancels fn's context and drops the
+// completion event, as does stopping the machine. See WithWorkerPool for
+// limits and observability.
+func (c *Context) Go(name string, fn func(ctx context.Context) (any, error)) {
+	c.FSM.startJob(name, c.FSM.currentState, fn)
+}
+
+// startJob registers a job and hands it to the pool
+func (m *Machine) startJob(name string, owner StateID, fn func(context.Context) (any, error)) {
+	jctx, cancel := context.WithCancel(m.baseContext())
+	j := &job{name: name, owner: owner, cancel: cancel}
+
+	p := m.workers
+	p.mu.Lock()
+	if p.opts.QueueLimit > 0 && p.active >= p.opts.Size+p.opts.QueueLimit {
+		// Registered without a place in the pool, so that its failure is
+		// dropped like any completion once the owner state exits
+		p.jobs[j] = struct{}{}
+		p.mu.Unlock()
+		cancel()
+		m.logger.Warn("worker pool full, rejecting job", "job", name, "state", owner)
+		p.observe(j, JobPhaseRejected, ErrWorkerPoolFull, 0)
+		result := JobResult{Job: name, Err: ErrWorkerPoolFull}
+		m.enqueueItem(&queuedEvent{event: Event{ID: eventJob, Payload: &jobCompletion{job: j, result: result}}, timer: true})
+		return
+	}
+	p.jobs[j] = struct{}{}
+	j.active = true
+	p.active++
+	p.mu.Unlock()
+	m.logger.Debug("job queued", "job", name, "state", owner)
+	p.observe(j, JobPhaseQueued, nil, 0)
+
+	go func() {
+		defer cancel()
+		select {
+		case p.slots <- struct{}{}:
+			if jctx.Err() != nil {
+				<-p.slots
+				p.done(j)
+				p.observe(j, JobPhaseCancelled, nil, 0)
+				return
+			}
+		case <-jctx.Done():
+			p.done(j)
+			p.observe(j, JobPhaseCancelled, nil, 0)
+			return
+		}
+		p.observe(j, JobPhaseStarted, nil, 0)
+		started := time.Now()
+		value, err := m.runJob(j, jctx, fn)
+		<-p.slots
+		p.done(j)
+
+		d := time.Since(started)
+		if jctx.Err() != nil && !p.live(j) {
+			p.observe(j, JobPhaseCancelled, nil, d)
+			return
+		}
+		if err != nil {
+			p.observe(j, JobPhaseFailed, err, d)
+		} else {
+			p.observe(j, JobPhaseFinished, nil, d)
+		}
+
+		result := JobResult{Job: name, Value: value, Err: err, Duration: d}
+		m.enqueueItem(&queuedEvent{event: Event{ID: eventJob, Payload: &jobCompletion{job: j, result: result}}, timer: true})
+	}()
+}
+
+// runJob runs a job's function, turning a panic into an error unless configured otherwise
+func (m *Machine) runJob(j *job, ctx context.Context, fn func(context.Context) (any, error)) (value any, err error) {
+	if !m.workers.opts.CrashOnPanic {
+		defer func() {
+			if r := recover(); r != nil {
+				m.logger.Error("job panicked", "job", j.name, "panic", r)
+				err = fmt.Errorf("%w: %v", ErrJobPanicked, r)
+			}
+		}()
+	}
+	return fn(ctx)
+}
+
+// done releases a job's place in the pool
+func (p *workerPool) done(j *job) {
+	p.mu.Lock()
+	p.release(j)
+	p.mu.Unlock()
+}
+
+// release releases a job's place in the pool once, with mu held
+func (p *workerPool) release(j *job) {
+	if j.active {
+		j.active = false
+		p.active--
+	}
+}
+
+// live reports whether a job is still registered, i.e. not cancelled
+func (p *workerPool) live(j *job) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.jobs[j]
+	return ok
+}
+
+// jobCompletion is the payload of the internal event finishing a job
+type jobCompletion struct {
+	job    *job
+	result JobResult
+}
+
+// resolveJobEvent turns a job's internal completion event into its public
+// event, or reports false if the job was cancelled in the meantime
+func (m *Machine) resolveJobEvent(event Event) (Event, bool) {
+	c, _ := event.Payload.(*jobCompletion)
+	if c == nil {
+		return event, false
+	}
+	p := m.workers
+	p.mu.Lock()
+	_, live := p.jobs[c.job]
+	delete(p.jobs, c.job)
+	p.mu.Unlock()
+	if !live {
+		m.logger.Debug("dropping completion of cancelled job", "job", c.job.name)
+		return event, false
+	}
+
+	id := JobDone(c.job.name)
+	if c.result.Err != nil {
+		id = JobFailed(c.job.name)
+	}
+	return Event{ID: id, Payload: c.result}, true
+}
+
+// cancelJobs cancels the jobs owned by a state, or all jobs for an empty owner
+func (m *Machine) cancelJobs(owner StateID) {
+	p := m.workers
+	var cancelled []*job
+	p.mu.Lock()
+	for j := range p.jobs {
+		if owner == "" || j.owner == owner {
+			j.cancel()
+			p.release(j)
+			delete(p.jobs, j)
+			cancelled = append(cancelled, j)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, j := range cancelled {
+		m.logger.Debug("job cancelled", "job", j.name, "state", j.owner)
+	}
+}
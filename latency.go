@@ -0,0 +1,115 @@
+package librefsm
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencySample is the latency of one processed event
+type LatencySample struct {
+	Event      EventID
+	Wait       time.Duration // From being queued until processing started, including time parked
+	Processing time.Duration // Processing, including actions and eventless follow-ups
+}
+
+// Total returns the event's end-to-end latency
+func (s LatencySample) Total() time.Duration {
+	return s.Wait + s.Processing
+}
+
+// LatencyStats summarizes the latency of one event ID. Durations
+// are in ns when encoded.
+type LatencyStats struct {
+	Count          uint64        `json:"count"`
+	MeanWait       time.Duration `json:"mean_wait"`
+	MaxWait        time.Duration `json:"max_wait"`
+	MeanProcessing time.Duration `json:"mean_processing"`
+	MaxProcessing  time.Duration `json:"max_processing"`
+	MeanTotal      time.Duration `json:"mean_total"`
+	MaxTotal       time.Duration `json:"max_total"`
+}
+
+// latencyMetrics accumulates latency samples per event ID
+type latencyMetrics struct {
+	mu      sync.Mutex
+	events  map[EventID]*latencySums
+	observe func(LatencySample)
+}
+
+// latencySums holds running totals for one event ID
+type latencySums struct {
+	count                            uint64
+	wait, processing, total          time.Duration
+	maxWait, maxProcessing, maxTotal time.Duration
+}
+
+// WithLatencyMetrics measures, per event ID, how long events wait in the queue
+// and how long they take to process, e.g. to prove that brake events are
+// handled within a 50ms reaction budget. Summaries are reported by
+// Stats.Latency; observe, if not nil, additionally receives every sample on
+// the event loop and must return quickly.
+func WithLatencyMetrics(observe func(LatencySample)) MachineOption {
+	return func(m *Machine) {
+		m.enableLatency()
+		m.latency.observe = observe
+	}
+}
+
+// enableLatency starts collecting latency samples
+func (m *Machine) enableLatency() {
+	if m.latency == nil {
+		m.latency = &latencyMetrics{events: make(map[EventID]*latencySums)}
+	}
+}
+
+// observeLatency records the latency of an event processed from started on
+func (m *Machine) observeLatency(id EventID, queued, started time.Time) {
+	if m.latency == nil || queued.IsZero() {
+		return
+	}
+	sample := LatencySample{Event: id, Wait: started.Sub(queued), Processing: time.Since(started)}
+	m.latency.add(sample)
+	if m.latency.observe != nil {
+		m.latency.observe(sample)
+	}
+	for _, c := range m.collectors {
+		c.ObserveEvent(sample)
+	}
+}
+
+func (l *latencyMetrics) add(s LatencySample) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sums := l.events[s.Event]
+	if sums == nil {
+		sums = &latencySums{}
+		l.events[s.Event] = sums
+	}
+	sums.count++
+	sums.wait += s.Wait
+	sums.processing += s.Processing
+	sums.total += s.Total()
+	sums.maxWait = max(sums.maxWait, s.Wait)
+	sums.maxProcessing = max(sums.maxProcessing, s.Processing)
+	sums.maxTotal = max(sums.maxTotal, s.Total())
+}
+
+// snapshot returns the summaries of all event IDs seen so far
+func (l *latencyMetrics) snapshot() map[EventID]LatencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[EventID]LatencyStats, len(l.events))
+	for id, sums := range l.events {
+		n := time.Duration(sums.count)
+		out[id] = LatencyStats{
+			Count:          sums.count,
+			MeanWait:       sums.wait / n,
+			MaxWait:        sums.maxWait,
+			MeanProcessing: sums.processing / n,
+			MaxProcessing:  sums.maxProcessing,
+			MeanTotal:      sums.total / n,
+			MaxTotal:       sums.maxTotal,
+		}
+	}
+	return out
+}
@@ -0,0 +1,48 @@
+package librefsm
+
+// Layout carries presentation hints for the DOT, PlantUML and Mermaid exporters.
+// It has no effect on the machine.
+type Layout struct {
+	// Group clusters top-level states under a shared box, e.g. "charging".
+	// Ignored on transitions and on states that have a parent.
+	Group string `json:"group,omitempty"`
+	// Rank places states with the same non-zero rank side by side. Only DOT
+	// honors it; ignored on transitions.
+	Rank int `json:"rank,omitempty"`
+	// Color is a color name or "#rrggbb", used to fill states and draw edges.
+	// Mermaid cannot color edges.
+	Color string `json:"color,omitempty"`
+	// Note is free text shown next to the state or appended to the edge label
+	Note string `json:"note,omitempty"`
+}
+
+// WithLayout sets presentation hints for the state in diagram exports
+func WithLayout(l Layout) StateOption {
+	return func(s *State) {
+		s.Layout = l
+	}
+}
+
+// WithEdgeLayout sets presentation hints for the transition in diagram exports.
+// Only Color and Note apply to edges.
+func WithEdgeLayout(l Layout) TransitionOption {
+	return func(t *Transition) {
+		t.Layout = l
+	}
+}
+
+// orNil returns a copy of the layout, or nil if it is empty
+func (l Layout) orNil() *Layout {
+	if l == (Layout{}) {
+		return nil
+	}
+	return &l
+}
+
+// layoutOf returns the layout of a description entry, or an empty one
+func layoutOf(l *Layout) Layout {
+	if l == nil {
+		return Layout{}
+	}
+	return *l
+}
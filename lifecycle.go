@@ -0,0 +1,195 @@
+package librefsm
+
+import (
+	"fmt"
+	"time"
+)
+
+// QueueFate decides what happens to events still queued when the machine
+// stops or resets
+type QueueFate int
+
+const (
+	// QueueDiscard drops queued events, passing each to the discard callback.
+	// SendSync callers waiting on them get ErrEventDiscarded.
+	QueueDiscard QueueFate = iota
+	// QueueDrain processes the events queued before Stop, then stops. On Reset
+	// they are kept and processed after the reset.
+	QueueDrain
+	// QueuePersist leaves queued events in the durable queue for the next run
+	// (see WithDurableQueue) instead of clearing it. On Reset they are kept.
+	QueuePersist
+)
+
+func (f QueueFate) String() string {
+	switch f {
+	case QueueDiscard:
+		return "discard"
+	case QueueDrain:
+		return "drain"
+	case QueuePersist:
+		return "persist"
+	default:
+		return fmt.Sprintf("QueueFate(%d)", int(f))
+	}
+}
+
+// WithStopPolicy sets the fate of queued events on Stop and Reset. onDiscard,
+// if set, is called for every discarded event. The default is QueueDiscard,
+// or QueuePersist if WithDurableQueue comes earlier in the options.
+func WithStopPolicy(fate QueueFate, onDiscard func(Event)) MachineOption {
+	return func(m *Machine) {
+		m.stopFate = fate
+		m.onDiscard = onDiscard
+	}
+}
+
+// Pause stops event processing at the next safe point, after the event being
+// processed. Events keep queueing and timers keep firing while paused; Stop
+// and Reset are still honored.
+func (m *Machine) Pause() {
+	m.queue.setPaused(true)
+	m.logger.Info("machine paused")
+}
+
+// Resume continues event processing after Pause
+func (m *Machine) Resume() {
+	m.queue.setPaused(false)
+	m.logger.Info("machine resumed")
+}
+
+// Paused reports whether event processing is paused
+func (m *Machine) Paused() bool {
+	m.queue.mu.Lock()
+	defer m.queue.mu.Unlock()
+	return m.queue.paused
+}
+
+// Reset returns the machine to its initial state at the next safe point, ahead
+// of queued events: active states are exited, all timers are stopped and the
+// initial state is entered again. Queued events are handled per the stop
+// policy. Like SendSync, it must not be called from actions. Before Start it
+// returns ErrNotStarted.
+func (m *Machine) Reset() error {
+	if m.cancel == nil {
+		return ErrNotStarted
+	}
+	done := make(chan error, 1)
+	qe := &queuedEvent{event: Event{ID: eventReset}, done: done, command: m.reset}
+	if !m.queue.pushCommand(qe, true) {
+		return ErrMachineStopped
+	}
+	return <-done
+}
+
+// reset runs on the event loop on behalf of Reset
+func (m *Machine) reset() error {
+	if m.stopFate == QueueDiscard {
+		m.discard(m.queue.removeAll())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := m.currentState
+	m.cancelDelayed()
+	m.cancelWait()
+	if from != "" {
+		if err := m.exitToAncestor(from, ""); err != nil {
+			return fmt.Errorf("reset: %w", err)
+		}
+	}
+	m.StopAllTimers()
+	m.currentState = ""
+	m.activeStates = make(map[StateID]StateID)
+
+	initial, err := m.resolveInitial()
+	if err != nil {
+		return fmt.Errorf("reset: %w", err)
+	}
+	if err := m.enterState(initial, nil, from); err != nil {
+		return fmt.Errorf("reset: enter initial state: %w", err)
+	}
+	if err := m.settle(); err != nil {
+		return fmt.Errorf("reset: settle initial state: %w", err)
+	}
+	m.recordTransition(from, eventReset)
+	m.logger.Info("machine reset", "from", from, "to", m.currentState)
+
+	m.notifyStateChange(from, m.currentState)
+	return nil
+}
+
+// Done returns a channel that is closed once the event loop has exited after
+// Stop. It is nil before Start.
+func (m *Machine) Done() <-chan struct{} {
+	return m.loopDone
+}
+
+// finishLoop applies the stop policy to whatever is still queued once the
+// event loop exits
+func (m *Machine) finishLoop() {
+	remaining := append(m.takeParked(), m.queue.close()...)
+	if m.stopFate == QueuePersist && m.durable != nil {
+		m.releaseWaiters(remaining)
+	} else {
+		m.discard(remaining)
+		m.persistPending()
+	}
+	m.stopActivity()
+	close(m.loopDone)
+}
+
+// discard drops queued items, notifying the discard callback and SendSync callers
+func (m *Machine) discard(items []*queuedEvent) {
+	for _, qe := range items {
+		if qe.command != nil {
+			if qe.done != nil {
+				qe.done <- ErrMachineStopped
+			}
+			continue
+		}
+		events := qe.batch
+		if events == nil {
+			events = []Event{qe.event}
+		}
+		for _, event := range events {
+			if isInternalEvent(event.ID) {
+				continue
+			}
+			m.logger.Debug("discarding queued event", "event", event.ID)
+			m.logEvent(event, EventDropped, "", time.Now(), 0)
+			if m.onDiscard != nil {
+				m.onDiscard(event)
+			}
+		}
+		if qe.done != nil {
+			qe.done <- ErrEventDiscarded
+		}
+	}
+}
+
+// releaseWaiters fails SendSync callers of items kept for the next run
+func (m *Machine) releaseWaiters(items []*queuedEvent) {
+	for _, qe := range items {
+		if qe.done != nil {
+			if qe.command != nil {
+				qe.done <- ErrMachineStopped
+			} else {
+				qe.done <- ErrEventDiscarded
+			}
+		}
+	}
+}
+
+// stopActivity stops everything that could raise further events
+func (m *Machine) stopActivity() {
+	m.StopAllTimers()
+	m.stopDebounceTimers()
+	m.stopScheduled()
+	m.stopIdleWatchdog()
+	m.unsubscribeBus()
+	m.stopSources()
+	m.stopDwellTimer()
+	m.cancelJobs("")
+}
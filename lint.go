@@ -0,0 +1,285 @@
+package librefsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Severity ranks lint findings and validation issues
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// LintFinding is a problem reported by a lint rule
+type LintFinding struct {
+	Rule     string
+	Severity Severity
+	State    StateID // Offending state, if any
+	Message  string
+}
+
+func (f LintFinding) String() string {
+	if f.State != "" {
+		return fmt.Sprintf("%s: %s: state %q: %s", f.Severity, f.Rule, f.State, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Rule, f.Message)
+}
+
+// LintRule is a house rule checked against the definition's Description.
+// Check reports findings with State and Message set; Lint fills in Rule and Severity.
+type LintRule struct {
+	Name     string
+	Severity Severity
+	Check    func(d Description) []LintFinding
+}
+
+// WithSeverity returns a copy of the rule reporting at the given severity
+func (r LintRule) WithSeverity(s Severity) LintRule {
+	r.Severity = s
+	return r
+}
+
+// DefaultLintRules returns the built-in rules used by Lint when none are given
+func DefaultLintRules() []LintRule {
+	return []LintRule{
+		LintDeadEnds,
+		LintUnnamedGuards,
+		LintUnhandledTimeouts,
+		LintUnreachable,
+		LintMissingDefaultChild,
+		LintMaxDepth(4),
+	}
+}
+
+// Lint checks the definition against rules (DefaultLintRules if none) and returns
+// all findings, most severe first. Unlike Validate it flags questionable rather
+// than broken definitions, so teams can enforce house rules in tests.
+func (d *Definition) Lint(rules ...LintRule) []LintFinding {
+	if len(rules) == 0 {
+		rules = DefaultLintRules()
+	}
+
+	desc := d.Describe()
+	var findings []LintFinding
+	for _, rule := range rules {
+		for _, f := range rule.Check(desc) {
+			f.Rule = rule.Name
+			f.Severity = rule.Severity
+			findings = append(findings, f)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Severity > findings[j].Severity })
+	return findings
+}
+
+// LintDeadEnds flags non-final leaf states that no transition or timeout leaves
+var LintDeadEnds = LintRule{
+	Name:     "dead-end",
+	Severity: SeverityWarning,
+	Check: func(d Description) []LintFinding {
+		var findings []LintFinding
+		for _, s := range d.States {
+			if s.Type != StateNormal.String() || len(s.Children) > 0 || s.Timeout != "" {
+				continue
+			}
+			leaves := false
+			for _, id := range d.ancestry(s.ID) {
+				for _, t := range d.Transitions {
+					if (t.From == id || t.From == WildcardState) && (t.To != s.ID || len(t.Branches) > 0) {
+						leaves = true
+					}
+				}
+			}
+			if !leaves {
+				findings = append(findings, LintFinding{State: s.ID, Message: "no transition leaves this state"})
+			}
+		}
+		return findings
+	},
+}
+
+// LintUnnamedGuards flags guarded transitions without a guard name
+var LintUnnamedGuards = LintRule{
+	Name:     "unnamed-guard",
+	Severity: SeverityInfo,
+	Check: func(d Description) []LintFinding {
+		var findings []LintFinding
+		for _, t := range d.Transitions {
+			if t.Guard == "<anonymous>" {
+				findings = append(findings, LintFinding{
+					State:   t.From,
+					Message: fmt.Sprintf("guard on %q -> %q has no name", t.Event, t.To),
+				})
+			}
+		}
+		return findings
+	},
+}
+
+// LintUnhandledTimeouts flags declarative timeouts whose event no transition handles
+var LintUnhandledTimeouts = LintRule{
+	Name:     "unhandled-timeout",
+	Severity: SeverityWarning,
+	Check: func(d Description) []LintFinding {
+		var findings []LintFinding
+		for _, s := range d.States {
+			var events []EventID
+			if s.Timeout != "" && s.TimeoutTarget == "" {
+				events = append(events, s.TimeoutEvent)
+			}
+			for _, stage := range s.TimeoutStages {
+				events = append(events, stage.Event)
+			}
+			for _, event := range events {
+				if !d.handles(s.ID, event) {
+					findings = append(findings, LintFinding{
+						State:   s.ID,
+						Message: fmt.Sprintf("timeout event %q is not handled by any transition", event),
+					})
+				}
+			}
+		}
+		return findings
+	},
+}
+
+// handles reports whether a transition from the state, its ancestors or any state matches the event
+func (d Description) handles(id StateID, event EventID) bool {
+	for _, from := range d.ancestry(id) {
+		for _, t := range d.Transitions {
+			if (t.From == from || t.From == WildcardState) && eventMatches(t.Event, event) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LintUnreachable flags states that cannot be reached from the initial state.
+// It is skipped for dynamic initial states and conditions without PossibleTargets.
+var LintUnreachable = LintRule{
+	Name:     "unreachable",
+	Severity: SeverityWarning,
+	Check: func(d Description) []LintFinding {
+		if d.Initial == "" {
+			return nil
+		}
+		states := make(map[StateID]StateDescription, len(d.States))
+		for _, s := range d.States {
+			states[s.ID] = s
+		}
+
+		reached := make(map[StateID]bool)
+		queue := []StateID{d.Initial}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			s, ok := states[id]
+			if !ok || reached[id] {
+				continue
+			}
+			reached[id] = true
+
+			if (s.Type == StateCondition.String() || s.Type == StateJunction.String()) && len(s.PossibleTargets) == 0 {
+				return nil // Targets unknown
+			}
+			queue = append(queue, s.PossibleTargets...)
+			if s.Parent != "" {
+				queue = append(queue, s.Parent)
+			}
+			if s.DefaultChild != "" {
+				queue = append(queue, s.DefaultChild)
+			}
+			if s.TimeoutTarget != "" {
+				queue = append(queue, s.TimeoutTarget)
+			}
+			for _, t := range d.Transitions {
+				if t.From == id || t.From == WildcardState {
+					if t.To != "" {
+						queue = append(queue, t.To)
+					}
+					queue = append(queue, t.Branches...)
+				}
+			}
+		}
+
+		var findings []LintFinding
+		for _, s := range d.States {
+			if !reached[s.ID] {
+				findings = append(findings, LintFinding{State: s.ID, Message: "not reachable from the initial state"})
+			}
+		}
+		return findings
+	},
+}
+
+// LintMissingDefaultChild flags composite states without a default child.
+// Entering such a state directly leaves the machine in a non-leaf state.
+var LintMissingDefaultChild = LintRule{
+	Name:     "missing-default-child",
+	Severity: SeverityWarning,
+	Check: func(d Description) []LintFinding {
+		var findings []LintFinding
+		for _, s := range d.States {
+			if len(s.Children) > 0 && s.DefaultChild == "" {
+				findings = append(findings, LintFinding{State: s.ID, Message: "has children but no default child"})
+			}
+		}
+		return findings
+	},
+}
+
+// LintMaxDepth flags states nested deeper than max levels
+func LintMaxDepth(max int) LintRule {
+	return LintRule{
+		Name:     "max-depth",
+		Severity: SeverityWarning,
+		Check: func(d Description) []LintFinding {
+			var findings []LintFinding
+			for _, s := range d.States {
+				if depth := len(d.ancestry(s.ID)); depth > max {
+					findings = append(findings, LintFinding{
+						State:   s.ID,
+						Message: fmt.Sprintf("nested %d levels deep, limit is %d", depth, max),
+					})
+				}
+			}
+			return findings
+		},
+	}
+}
+
+// ancestry returns the state followed by its ancestors
+func (d Description) ancestry(id StateID) []StateID {
+	parents := make(map[StateID]StateID, len(d.States))
+	for _, s := range d.States {
+		parents[s.ID] = s.Parent
+	}
+
+	var chain []StateID
+	seen := make(map[StateID]bool)
+	for id != "" && !seen[id] {
+		seen[id] = true
+		chain = append(chain, id)
+		id = parents[id]
+	}
+	return chain
+}
@@ -0,0 +1,75 @@
+package librefsm
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithVerboseStateLogging logs every state entry, exit and completed transition at
+// the given level with consistent fields (state, from, to, event), replacing
+// OnEnter/OnExit callbacks that only exist for logging
+func WithVerboseStateLogging(level slog.Level) MachineOption {
+	return func(m *Machine) {
+		m.stateLogging = true
+		m.stateLogLevel = level
+	}
+}
+
+// WithEventLogLevel logs the processing of event at level instead of debug,
+// e.g. slog.LevelInfo for transitions worth following in production or a level
+// below debug to keep a high-frequency periodic event out of debug logs
+func WithEventLogLevel(event EventID, level slog.Level) MachineOption {
+	return func(m *Machine) {
+		if m.eventLogLevels == nil {
+			m.eventLogLevels = make(map[EventID]slog.Level)
+		}
+		m.eventLogLevels[event] = level
+	}
+}
+
+// WithQuiet suppresses the processing and state logs of taking the transition,
+// including verbose state logging. Errors are still reported.
+func WithQuiet() TransitionOption {
+	return func(t *Transition) {
+		t.Quiet = true
+	}
+}
+
+// traceEvent applies the log level of the event about to be processed and
+// returns a function restoring the defaults. Callers hold mu.
+func (m *Machine) traceEvent(id EventID) func() {
+	if level, ok := m.eventLogLevels[id]; ok {
+		m.traceLevel = level
+	}
+	return func() {
+		m.traceLevel = slog.LevelDebug
+		m.quiet = false
+	}
+}
+
+// allQuiet reports whether every transition an event could take is quiet
+func allQuiet(transitions []*Transition) bool {
+	for _, t := range transitions {
+		if !t.Quiet {
+			return false
+		}
+	}
+	return len(transitions) > 0
+}
+
+// trace logs a processing detail at the current event's level, unless a quiet
+// transition is being processed. Callers hold mu.
+func (m *Machine) trace(msg string, args ...any) {
+	if m.quiet {
+		return
+	}
+	m.logger.Log(context.Background(), m.traceLevel, msg, args...)
+}
+
+// logStateChange logs a state lifecycle message if verbose state logging is enabled
+func (m *Machine) logStateChange(msg string, args ...any) {
+	if !m.stateLogging || m.quiet {
+		return
+	}
+	m.logger.Log(context.Background(), m.stateLogLevel, msg, args...)
+}
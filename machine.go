@@ -2,9 +2,12 @@ package librefsm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Machine is the runtime FSM instance
@@ -13,13 +16,104 @@ type Machine struct {
 	currentState StateID
 	mu           sync.RWMutex
 
-	events  chan Event
+	queue   *eventQueue
 	timers  map[string]*timerEntry
 	timerMu sync.Mutex
 
 	data                any
 	logger              *slog.Logger
 	stateChangeCallback func(from, to StateID)
+	strictEvents        bool
+	unhandledHandler    func(event Event, state StateID)
+	errorHandler        func(event Event, err error)
+	actionTimeout       time.Duration
+	actionTimeoutPolicy ActionTimeoutPolicy
+	actionCancelGrace   time.Duration // Wait for cancelled actions, see WithActionCancelGrace
+	defaultTimeout      time.Duration
+	defaultTimeoutEvent EventID
+	stateLogging        bool
+	stateLogLevel       slog.Level
+	eventLogLevels      map[EventID]slog.Level // See WithEventLogLevel
+	redactor            func(EventID, any) any // See WithPayloadRedactor
+	sendResult          *SendResult            // Result of the event being processed, see SendSyncResult
+	latency             *latencyMetrics        // See WithLatencyMetrics
+	parallelGuards      bool                   // See WithParallelGuards
+	workers             *workerPool            // Runs jobs started with Context.Go
+	traceLevel          slog.Level             // Level of the event being processed
+	quiet               bool                   // Processing a quiet transition, see WithQuiet
+	traversalHooks      TraversalHooks
+	exitOrder           TraversalOrder
+	entryOrder          TraversalOrder
+	enteredChain        []StateID // States entered by the current transition
+	exitDeadline        time.Duration
+	eventLog            eventLog
+	lastTransition      *TransitionInfo
+	entryCounts         map[StateID]uint64
+	flapDetectors       map[StateID]*flapDetector
+	debouncers          map[EventID]*debouncer
+	pendingDelay        *delayedTransition
+	pendingWait         *pendingWait
+	schedule            scheduler
+	enteredAt           time.Time // When currentState was entered
+	interruptEvents     map[EventID]bool
+	runningActions      map[*runningAction]struct{}
+	actionsMu           sync.Mutex
+	stateStores         map[StateID]*StateStore
+	storesMu            sync.Mutex
+	vars                map[string]any
+	varsMu              sync.RWMutex
+	middleware          []Middleware
+	maxChainDepth       int
+	semantics           Semantics
+	rand                *lockedRand
+	idleTimeout         time.Duration
+	idleEvent           EventID
+	idleTimer           *time.Timer
+	idleMu              sync.Mutex
+	idleDeadline        time.Time     // When the idle watchdog fires, guarded by idleMu
+	idleRemaining       time.Duration // Time left when suspended, guarded by idleMu
+	idleSuspended       bool          // Paused by NotifySuspend, guarded by idleMu
+	heartbeatInterval   time.Duration
+	heartbeatFn         func(Heartbeat)
+	stats               machineStats
+	rateLimits          map[rateKey]*rateLimiter
+	throttles           map[EventID]*throttle
+	durable             *durableQueue
+	conflictPolicy      ConflictPolicy
+	actions             map[string]func(*Context) error
+	payloadTypes        PayloadTypes
+	payloadPolicy       PayloadPolicy
+	stopFate            QueueFate
+	onDiscard           func(Event)
+	loopDone            chan struct{}
+	health              healthState
+	shutdownState       StateID
+	shutdownTimeout     time.Duration
+	shuttingDown        atomic.Bool
+	entryMode           entryMode // Set by SetState for the states it enters
+	suppressTimers      bool      // Set by SetState for the states it enters
+	dedup               *dedupCache
+	statePublishers     []func(from, to StateID)
+	busSubscriptions    []busSubscription
+	busUnsubscribe      []func()
+	busMu               sync.Mutex
+	eventSources        []EventSource
+	sources             sourceRunner
+	collectors          []MetricsCollector
+	retryJitter         float64
+	followEvent         EventID // Announcement event, see WithFollowerMode
+	resumePolicy        ResumePolicy
+	suspendedAt         time.Time // Wall clock time of NotifySuspend, guarded by timerMu
+	deferUntilStable    bool
+	parkLimit           int
+	parkOverflow        ParkOverflow
+	parked              []*queuedEvent // Events waiting for a stable state, guarded by parkMu
+	parkMu              sync.Mutex
+	deferred            []*queuedEvent        // Held until the next state change, see Context.DeferEvent
+	stateEnteredAt      map[StateID]time.Time // Entry time of each active state
+	dwellTimer          *time.Timer
+	dwellMu             sync.Mutex
+	optErrs             []error // Invalid options, reported by Build
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -38,7 +132,19 @@ type MachineOption func(*Machine)
 // WithEventQueueSize sets the event queue buffer size
 func WithEventQueueSize(size int) MachineOption {
 	return func(m *Machine) {
-		m.events = make(chan Event, size)
+		m.queue.capacity = size
+	}
+}
+
+// WithMaxChainDepth limits how many condition/junction redirects and default
+// children a single state entry may follow, and how many eventless transitions
+// may be taken in a row. Exceeding it fails with ErrChainDepthExceeded, which
+// is passed to the error handler. The default is 32.
+func WithMaxChainDepth(depth int) MachineOption {
+	return func(m *Machine) {
+		if depth > 0 {
+			m.maxChainDepth = depth
+		}
 	}
 }
 
@@ -63,6 +169,51 @@ func WithStateChangeCallback(fn func(from, to StateID)) MachineOption {
 	}
 }
 
+// WithStrictEvents makes unhandled events an error instead of silently ignoring them.
+// SendSync returns ErrNoTransition and asynchronously sent events are passed to the
+// unhandled event handler (or logged as a warning when none is set).
+func WithStrictEvents() MachineOption {
+	return func(m *Machine) {
+		m.strictEvents = true
+	}
+}
+
+// WithUnhandledEventHandler sets a callback invoked in strict mode for asynchronously
+// sent events that matched no transition
+func WithUnhandledEventHandler(fn func(event Event, state StateID)) MachineOption {
+	return func(m *Machine) {
+		m.unhandledHandler = fn
+	}
+}
+
+// WithErrorHandler sets a callback invoked whenever processing an event fails,
+// e.g. because an entry, exit or transition action returned an error
+func WithErrorHandler(fn func(event Event, err error)) MachineOption {
+	return func(m *Machine) {
+		m.errorHandler = fn
+	}
+}
+
+// WithDefaultStateTimeout applies a timeout to every normal leaf state that doesn't
+// declare its own, as a safety net against sitting in an unexpected state forever.
+// Composite, condition, junction and final states are exempt.
+func WithDefaultStateTimeout(d time.Duration, event EventID) MachineOption {
+	return func(m *Machine) {
+		m.defaultTimeout = d
+		m.defaultTimeoutEvent = event
+	}
+}
+
+// WithExitDeadline bounds the time a transition may spend in exit actions.
+// Once exceeded, the running exit action is abandoned (its context cancelled),
+// remaining exit actions are skipped, the transition continues and ErrExitDeadline
+// is passed to the error handler.
+func WithExitDeadline(d time.Duration) MachineOption {
+	return func(m *Machine) {
+		m.exitDeadline = d
+	}
+}
+
 // OnStateChange sets a callback invoked after each state change.
 // Can be called after Build() but before Start().
 func (m *Machine) OnStateChange(fn func(from, to StateID)) {
@@ -72,56 +223,153 @@ func (m *Machine) OnStateChange(fn func(from, to StateID)) {
 // Start initializes the machine and begins the event loop
 func (m *Machine) Start(ctx context.Context) error {
 	m.ctx, m.cancel = context.WithCancel(ctx)
+	m.loopDone = make(chan struct{})
+	m.queue.reopen()
+	m.shuttingDown.Store(false)
+	m.markProgress()
 	m.activeStates = make(map[StateID]StateID)
+	m.entryCounts = make(map[StateID]uint64)
+	m.stateEnteredAt = make(map[StateID]time.Time)
+	m.stats.started.Store(time.Now().UnixNano())
+	if seed, ok := m.Seed(); ok {
+		m.logger.Debug("starting machine", "seed", seed)
+	}
 
 	// Enter initial state
-	if err := m.enterState(m.definition.initial, nil, ""); err != nil {
+	initial, err := m.resolveInitial()
+	if err != nil {
+		return err
+	}
+	if err := m.enterState(initial, nil, ""); err != nil {
 		return fmt.Errorf("failed to enter initial state: %w", err)
 	}
+	if err := m.settle(); err != nil {
+		return fmt.Errorf("failed to settle initial state: %w", err)
+	}
+
+	if err := m.restorePending(); err != nil {
+		return err
+	}
 
 	// Start event loop
+	m.subscribeBus()
+	m.startSources()
+	m.armIdleWatchdog()
+	if m.heartbeatInterval > 0 && m.heartbeatFn != nil {
+		go m.runHeartbeat(m.ctx)
+	}
+	if m.health.config.Interval > 0 && m.health.onChange != nil {
+		go m.runHealthCheck(m.ctx)
+	}
 	go m.eventLoop()
 
 	return nil
 }
 
-// Stop gracefully shuts down the machine
+// resolveInitial determines the state to start in
+func (m *Machine) resolveInitial() (StateID, error) {
+	initial := m.definition.initial
+	if m.definition.initialFunc != nil {
+		if id := m.definition.initialFunc(m.makeContext(nil)); id != "" {
+			initial = id
+		}
+	}
+	if initial == "" {
+		return "", fmt.Errorf("initial state function returned no state")
+	}
+	if _, ok := m.definition.states[initial]; !ok {
+		return "", unknownState("initial state %q not defined", initial)
+	}
+	return initial, nil
+}
+
+// Stop shuts down the machine. Unless a shutdown state is configured (see
+// WithShutdownState) it does not wait: the event loop exits at the next safe
+// point, after the event being processed, and queued events are handled per
+// the stop policy (see WithStopPolicy). Running actions see their
+// context cancelled, except under QueueDrain until the queue is drained.
+// Use Done to wait for the loop to exit.
 func (m *Machine) Stop() error {
-	if m.cancel != nil {
-		m.cancel()
+	if m.cancel == nil {
+		m.stopActivity()
+		return nil
+	}
+	m.queue.setPaused(false)
+	m.shutdown()
+	if m.stopFate == QueueDrain {
+		cancel := m.cancel
+		qe := &queuedEvent{command: func() error {
+			cancel()
+			return nil
+		}}
+		if m.queue.pushCommand(qe, false) {
+			return nil
+		}
 	}
-	m.StopAllTimers()
+	m.cancel()
+	m.stopActivity()
 	return nil
 }
 
-// Send queues an event for asynchronous processing
+// Send queues an event for asynchronous processing.
+// Debounce and throttle settings for the event apply.
 func (m *Machine) Send(event Event) {
-	select {
-	case m.events <- event:
-	default:
-		m.logger.Warn("event queue full, dropping event", "event", event.ID)
+	if m.conditionEvent(event) {
+		return
+	}
+	m.enqueue(event)
+}
+
+// enqueue puts an event on the queue, dropping it if the queue is full
+func (m *Machine) enqueue(event Event) bool {
+	return m.enqueueItem(&queuedEvent{event: event})
+}
+
+func (m *Machine) enqueueItem(qe *queuedEvent) bool {
+	return m.tryEnqueue(qe) == nil
+}
+
+// tryEnqueue checks an event's payload and queues it, returning why it was
+// dropped if it was not queued
+func (m *Machine) tryEnqueue(qe *queuedEvent) error {
+	if err := m.checkPayloads(qe); err != nil {
+		m.logEvent(qe.event, EventDropped, "", time.Now(), 0)
+		return err
+	}
+	if m.queue.push(qe) {
+		m.afterPush(qe)
+		return nil
+	}
+	if m.queue.isClosed() {
+		m.logger.Debug("machine stopped, dropping event", "event", qe.event.ID)
+		return ErrMachineStopped
+	}
+	m.logger.Warn("event queue full, dropping event", "event", qe.event.ID)
+	m.logEvent(qe.event, EventDropped, "", time.Now(), 0)
+	return ErrQueueFull
+}
+
+// afterPush reacts to a newly queued event
+func (m *Machine) afterPush(qe *queuedEvent) {
+	if m.interruptEvents[qe.event.ID] {
+		m.interruptActions()
+	}
+	if !qe.timer {
+		m.persistPending()
 	}
 }
 
-// SendSync sends an event and waits for it to be processed
+// SendSync sends an event and waits for it to be processed.
+// It bypasses debounce and throttle settings. Use SendSyncResult to learn what
+// the event did.
 func (m *Machine) SendSync(event Event) error {
 	done := make(chan error, 1)
-	wrapper := Event{
-		ID: event.ID,
-		Payload: &syncEventPayload{
-			original: event.Payload,
-			done:     done,
-		},
-	}
-	m.Send(wrapper)
+	if err := m.tryEnqueue(&queuedEvent{event: event, done: done}); err != nil {
+		return err
+	}
 	return <-done
 }
 
-type syncEventPayload struct {
-	original any
-	done     chan error
-}
-
 // CurrentState returns the current leaf state
 func (m *Machine) CurrentState() StateID {
 	m.mu.RLock()
@@ -131,13 +379,49 @@ func (m *Machine) CurrentState() StateID {
 
 // SetState forces a direct state change, bypassing normal event-driven transitions.
 // This is useful for hybrid migrations where legacy code needs to set state directly.
-// It properly exits the current state and enters the new state, running callbacks.
-func (m *Machine) SetState(newState StateID) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// It properly exits the current state and enters the new state, running callbacks
+// unless options say otherwise.
+//
+// On a running machine the change is queued like an event and applied by the
+// event loop once the events queued before it are processed, so it never
+// interleaves with an event in flight; SetState waits for it. Like SendSync,
+// it must not be called from actions and waits while the machine is paused.
+// It returns ErrMachineStopped after Stop.
+func (m *Machine) SetState(newState StateID, opts ...SetStateOption) error {
+	var cfg setStateConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if m.cancel == nil { // Not started, nothing else runs yet
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.forceState(newState, cfg)
+	}
 
+	done := make(chan error, 1)
+	qe := &queuedEvent{done: done, command: func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		from := m.currentState
+		if err := m.forceState(newState, cfg); err != nil {
+			return err
+		}
+		if from != m.currentState {
+			m.logger.Info("state forced", "from", from, "to", m.currentState, "reason", cfg.reason)
+		}
+		return nil
+	}}
+	if !m.queue.pushCommand(qe, false) {
+		return ErrMachineStopped
+	}
+	return <-done
+}
+
+// forceState performs a SetState with the lock held
+func (m *Machine) forceState(newState StateID, cfg setStateConfig) error {
 	if _, ok := m.definition.states[newState]; !ok {
-		return fmt.Errorf("unknown state: %s", newState)
+		return fmt.Errorf("%w: %s", ErrUnknownState, newState)
 	}
 
 	if m.currentState == newState {
@@ -145,21 +429,29 @@ func (m *Machine) SetState(newState StateID) error {
 	}
 
 	fromState := m.currentState
+	m.cancelDelayed()
+	m.cancelWait()
 
 	// Exit current state
-	if err := m.exitState(m.currentState); err != nil {
+	if err := m.exitStateWithin(m.currentState, -1, cfg.skipExit); err != nil {
 		return fmt.Errorf("exit state %s: %w", m.currentState, err)
 	}
 
 	// Enter new state
-	if err := m.enterState(newState, nil, fromState); err != nil {
+	m.entryMode = cfg.entry
+	m.suppressTimers = cfg.suppressTimers
+	err := m.enterState(newState, nil, fromState)
+	m.entryMode = entryActions
+	m.suppressTimers = false
+	if err != nil {
 		return fmt.Errorf("enter state %s: %w", newState, err)
 	}
 
+	m.recordTransition(fromState, "")
+	m.lastTransition.Reason = cfg.reason
+
 	// Notify callback
-	if m.stateChangeCallback != nil {
-		m.stateChangeCallback(fromState, m.currentState)
-	}
+	m.notifyStateChange(fromState, m.currentState)
 
 	return nil
 }
@@ -188,81 +480,283 @@ func (m *Machine) isInStateInternal(id StateID) bool {
 
 // eventLoop processes events from the queue
 func (m *Machine) eventLoop() {
+	defer m.finishLoop()
 	for {
-		select {
-		case <-m.ctx.Done():
+		if m.ctx.Err() != nil {
 			return
-		case event := <-m.events:
-			var syncDone chan error
-			payload := event.Payload
-
-			// Handle sync events
-			if sp, ok := payload.(*syncEventPayload); ok {
-				syncDone = sp.done
-				payload = sp.original
+		}
+
+		qe, ok := m.queue.pop()
+		if !ok {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-m.queue.notify:
 			}
+			continue
+		}
 
-			actualEvent := Event{ID: event.ID, Payload: payload}
-			err := m.processEvent(actualEvent)
+		if qe.command != nil {
+			err := qe.command()
+			if qe.done != nil {
+				qe.done <- err
+			}
+			m.queue.finish()
+			m.deliverParked()
+			m.markProgress()
+			continue
+		}
 
-			if syncDone != nil {
-				syncDone <- err
+		if qe.batch != nil {
+			for _, event := range qe.batch {
+				m.handleEvent(&queuedEvent{event: event, seq: qe.seq, queued: qe.queued})
 			}
+		} else {
+			m.handleEvent(qe)
+		}
+		m.queue.finish()
+		m.deliverParked()
+		m.markProgress()
+		m.persistPending()
+	}
+}
+
+// handleEvent processes one dequeued event and routes its result
+func (m *Machine) handleEvent(qe *queuedEvent) {
+	if qe.event.ID == eventJob {
+		event, ok := m.resolveJobEvent(qe.event)
+		if !ok {
+			return
+		}
+		resolved := *qe
+		resolved.event = event
+		qe = &resolved
+	}
+	event, done := qe.event, qe.done
+	if m.shouldPark(event) {
+		m.park(qe)
+		return
+	}
+	if m.isDuplicate(event) {
+		if qe.result != nil {
+			qe.result.Outcome = SendDuplicate
+		}
+		if done != nil {
+			done <- nil
 		}
+		return
+	}
+
+	started := time.Now()
+	err := m.processEvent(event, qe.seq, qe.result)
+	m.observeLatency(event.ID, qe.queued, started)
+	if m.dedup != nil && event.Key != "" && (err == nil || errors.Is(err, ErrNoTransition)) {
+		m.dedup.remember(event.Key)
+	}
+	if event.ID != m.idleEvent {
+		m.armIdleWatchdog()
+	}
+
+	if errors.Is(err, ErrNoTransition) {
+		if done == nil {
+			m.reportUnhandled(event)
+		}
+	} else if err != nil && !(errors.Is(err, ErrRateLimited) && done == nil) {
+		// Asynchronous drops were already logged by the rate limiter
+		m.reportError(event, err, done != nil)
+	}
+
+	if done != nil {
+		done <- err
 	}
 }
 
 // processEvent handles a single event
-func (m *Machine) processEvent(event Event) error {
+func (m *Machine) processEvent(event Event, seq uint64, result *SendResult) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	defer m.traceEvent(event.ID)()
 
-	m.logger.Debug("processing event", "event", event.ID, "state", m.currentState)
+	received := time.Now()
+	state := m.currentState
+	if result != nil {
+		m.sendResult = result
+		defer m.finishResult(state)
+	}
+
+	err := m.dispatchEvent(event)
+	unhandled := errors.Is(err, ErrNoTransition)
+	if unhandled && !m.strictEvents {
+		err = nil
+	}
+	if errors.Is(err, ErrRateLimited) {
+		m.logEvent(event, EventDropped, state, received, seq)
+		return err
+	}
+	if err != nil && !unhandled {
+		m.logEvent(event, EventFailed, state, received, seq)
+		return err
+	}
+
+	// Let eventless transitions react to whatever the event changed
+	if serr := m.settle(); serr != nil {
+		m.logEvent(event, EventFailed, state, received, seq)
+		return serr
+	}
+
+	if unhandled {
+		m.logEvent(event, EventUnhandled, state, received, seq)
+	} else {
+		m.logEvent(event, EventAccepted, state, received, seq)
+	}
+	return err
+}
+
+// dispatchEvent selects and executes the transition for an event
+func (m *Machine) dispatchEvent(event Event) error {
+	// Matching is only needed up front to decide whether to log quietly
+	var transitions []*Transition
+	matched := false
+	if m.definition.hasQuiet {
+		transitions, matched = m.findAllTransitions(event), true
+		m.quiet = allQuiet(transitions)
+	}
+	m.trace("processing event", "event", event.ID, "state", m.currentState)
+
+	if m.followEvent != "" && event.ID == m.followEvent {
+		return m.follow(event)
+	}
+
+	switch event.ID {
+	case eventDelayed:
+		return m.fireDelayed(event)
+	case eventWaitCheck:
+		return m.checkWait(event)
+	case eventPoll:
+		return m.poll(event)
+	case eventVarChanged:
+		return nil // Only settles, so eventless transitions see the new value
+	}
+
+	// Final states are terminal: not even wildcard or ancestor transitions apply.
+	// Under SCXML semantics only top-level final states are; nested ones complete their parent.
+	if state := m.definition.states[m.currentState]; state != nil && state.Type == StateFinal &&
+		(m.semantics != SemanticsSCXML || state.Parent == "") {
+		m.trace("event ignored in final state", "event", event.ID, "state", m.currentState)
+		m.noteOutcome(SendFinalState)
+		return ErrNoTransition
+	}
 
 	// Find all matching transitions
-	transitions := m.findAllTransitions(event)
+	if !matched {
+		transitions = m.findAllTransitions(event)
+	}
 	if len(transitions) == 0 {
-		m.logger.Debug("no transition found", "event", event.ID, "state", m.currentState)
-		return nil
+		m.trace("no transition found", "event", event.ID, "state", m.currentState)
+		m.noteOutcome(SendNoMatch)
+		return ErrNoTransition
 	}
 
 	// Try each transition until one's guard passes
-	ctx := m.makeContext(&event)
+	checkGuard := m.checkGuard
+	if m.parallelGuards {
+		checkGuard = m.checkGuardsParallel(transitions, &event)
+	}
 	for _, transition := range transitions {
-		// No guard means transition is always allowed
-		if transition.Guard == nil {
-			m.logger.Debug("executing transition (no guard)", "event", event.ID, "from", transition.From, "to", transition.To)
-			return m.executeTransition(transition, &event)
+		passed, err := checkGuard(transition, &event)
+		if err != nil {
+			return err
 		}
 
-		// Check guard
-		if transition.Guard(ctx) {
-			m.logger.Debug("executing transition (guard passed)", "event", event.ID, "from", transition.From, "to", transition.To)
-			return m.executeTransition(transition, &event)
+		if !transition.hasGuard() {
+			// No guard means transition is always allowed
+			m.trace("executing transition (no guard)", "event", event.ID, "from", transition.From, "to", transition.To)
+		} else if passed {
+			m.trace("executing transition (guard passed)", "event", event.ID, "from", transition.From, "to", transition.To, "guard", transition.guardLabel())
+		} else if transition.WaitInterval > 0 {
+			m.scheduleWait(transition, event)
+			m.noteOutcome(SendDeferred)
+			return nil
+		} else {
+			m.trace(fmt.Sprintf("guard %q rejected transition", transition.guardLabel()), "event", event.ID, "from", transition.From, "to", transition.To, "guard", transition.guardLabel())
+			if m.sendResult != nil {
+				m.sendResult.RejectedGuards = append(m.sendResult.RejectedGuards, transition.guardLabel())
+			}
+			continue
 		}
 
-		m.logger.Debug("guard rejected transition", "event", event.ID, "from", transition.From, "to", transition.To)
+		if taken, err := m.takeTransition(transition, event); taken || err != nil {
+			return err
+		}
 	}
 
 	// All guards failed
-	m.logger.Debug("all guards rejected", "event", event.ID, "state", m.currentState)
-	return nil
+	m.trace("all guards rejected", "event", event.ID, "state", m.currentState)
+	m.noteOutcome(SendGuardsRejected)
+	return errGuardsRejected
+}
+
+// takeTransition executes a transition whose guard passed. It reports false if
+// a switch transition selected no branch, so the next candidate should be tried.
+func (m *Machine) takeTransition(transition *Transition, event Event) (bool, error) {
+	target := transition.To
+	if transition.Select != nil {
+		target = transition.Select(event.Payload)
+		if target == "" {
+			m.trace("switch selected no branch", "event", event.ID, "from", transition.From)
+			return false, nil
+		}
+		if !containsState(transition.Branches, target) {
+			return true, fmt.Errorf("switch on %q from %q selected undeclared branch %q", event.ID, transition.From, target)
+		}
+	}
+
+	if ok, err := m.allowTransition(transition, event); !ok {
+		m.noteOutcome(SendRateLimited)
+		return true, err
+	}
+
+	if transition.Delay > 0 {
+		m.scheduleDelayed(transition, target, event)
+		m.noteOutcome(SendDeferred)
+		return true, nil
+	}
+
+	return true, m.runTransition(transition, target, &event)
+}
+
+// reportError passes a processing error to the error handler.
+// Without a handler, errors of asynchronous events are logged since nobody else sees them.
+func (m *Machine) reportError(event Event, err error, sync bool) {
+	if m.errorHandler != nil {
+		m.errorHandler(event, err)
+		return
+	}
+	if !sync {
+		m.logger.Error("event processing failed", "event", event.ID, "error", err)
+	}
+}
+
+// reportUnhandled passes an unhandled asynchronous event to the handler
+func (m *Machine) reportUnhandled(event Event) {
+	state := m.CurrentState()
+	if m.unhandledHandler != nil {
+		m.unhandledHandler(event, state)
+		return
+	}
+	m.logger.Warn("unhandled event", "event", event.ID, "state", state)
 }
 
 // findAllTransitions finds all matching transitions for the event
-// Returns transitions in priority order: current state, then ancestors, then wildcards
+// Returns transitions in the conflict policy's order, by default current state,
+// then ancestors, then wildcards. Within each level, transitions naming the event exactly come before event patterns.
 func (m *Machine) findAllTransitions(event Event) []*Transition {
 	var matches []*Transition
 
 	// Check transitions from current state and ancestors
 	current := m.currentState
 	for current != "" {
-		for i := range m.definition.transitions {
-			t := &m.definition.transitions[i]
-			if t.Event == event.ID && t.From == current {
-				matches = append(matches, t)
-			}
-		}
+		matches = m.appendMatching(matches, current, event.ID)
 		state := m.definition.states[current]
 		if state == nil {
 			break
@@ -271,54 +765,131 @@ func (m *Machine) findAllTransitions(event Event) []*Transition {
 	}
 
 	// Check wildcard transitions
+	return m.dropFollowed(m.resolveConflicts(matches, m.appendMatching(nil, WildcardState, event.ID)))
+}
+
+// appendMatching appends transitions from the given source matching the event,
+// exact matches first
+func (m *Machine) appendMatching(matches []*Transition, from StateID, id EventID) []*Transition {
+	if m.semantics == SemanticsSCXML {
+		for i := range m.definition.transitions {
+			t := &m.definition.transitions[i]
+			if t.From == from && eventMatches(t.Event, id) {
+				matches = append(matches, t)
+			}
+		}
+		return matches
+	}
+
 	for i := range m.definition.transitions {
 		t := &m.definition.transitions[i]
-		if t.Event == event.ID && t.From == WildcardState {
+		if t.From == from && t.Event == id {
+			matches = append(matches, t)
+		}
+	}
+	for i := range m.definition.transitions {
+		t := &m.definition.transitions[i]
+		if t.From == from && t.Event != id && eventMatches(t.Event, id) {
 			matches = append(matches, t)
 		}
 	}
-
 	return matches
 }
 
-// executeTransition performs the state transition
-func (m *Machine) executeTransition(t *Transition, event *Event) error {
+// executeTransition performs the state transition to the resolved target
+func (m *Machine) executeTransition(t *Transition, toState StateID, event *Event) error {
 	fromState := m.currentState
-	toState := t.To
 
-	m.logger.Debug("executing transition", "from", fromState, "to", toState, "event", event.ID)
+	m.trace("executing transition", "from", fromState, "to", toState, "event", eventID(event))
 
-	// Find LCA (Least Common Ancestor)
+	// Internal transitions only run their action
+	if t.Kind == TransitionInternal {
+		err := m.runTransitionAction(t, event, fromState, fromState)
+		var abort *AbortError
+		if errors.As(err, &abort) {
+			return m.abortTransition(abort, fromState, fromState, event)
+		}
+		return err
+	}
+
+	// Any state change supersedes a delayed or waiting transition
+	m.cancelDelayed()
+	m.cancelWait()
+
+	// Find LCA (Least Common Ancestor). External self-transitions leave and
+	// re-enter their source, so they are scoped to its parent instead.
 	lca := m.findLCA(fromState, toState)
+	if source := m.definition.states[t.From]; source != nil && t.Kind == TransitionExternal {
+		lca = source.Parent
+	} else if m.semantics == SemanticsSCXML {
+		lca = m.scxmlDomain(t, toState, lca)
+	}
+
+	hookCtx := m.makeContext(event)
+	hookCtx.FromState = fromState
+	hookCtx.ToState = toState
 
 	// Exit states up to (but not including) LCA
+	exited := m.exitChain(fromState, lca)
+	m.runTraversalHook(m.traversalHooks.BeforeExit, hookCtx, exited)
 	if err := m.exitToAncestor(fromState, lca); err != nil {
-		return fmt.Errorf("exit failed: %w", err)
+		if !errors.Is(err, ErrExitDeadline) {
+			return fmt.Errorf("exit failed: %w", err)
+		}
+		// The transition proceeds so that time-bounded paths like power-down stay bounded
+		m.reportError(eventValue(event), err, false)
 	}
+	m.runTraversalHook(m.traversalHooks.AfterExit, hookCtx, exited)
 
 	// Execute transition action
-	if t.Action != nil {
-		ctx := m.makeContext(event)
-		ctx.FromState = fromState
-		ctx.ToState = toState
-		if err := t.Action(ctx); err != nil {
-			return fmt.Errorf("transition action failed: %w", err)
+	if err := m.runTransitionAction(t, event, fromState, toState); err != nil {
+		var abort *AbortError
+		if errors.As(err, &abort) {
+			return m.abortTransition(abort, fromState, lca, event)
 		}
+		return err
 	}
 
 	// Enter states from LCA down to target
+	m.runTraversalHook(m.traversalHooks.BeforeEnter, hookCtx, m.pathFromAncestor(toState, lca))
+	m.enteredChain = nil
 	if err := m.enterFromAncestor(toState, lca, event, fromState); err != nil {
 		return fmt.Errorf("enter failed: %w", err)
 	}
+	m.runTraversalHook(m.traversalHooks.AfterEnter, hookCtx, m.enteredChain)
+
+	m.logStateChange("transition completed", "from", fromState, "to", m.currentState, "event", eventID(event))
+	m.recordTransition(fromState, eventID(event))
 
 	// Notify callback
-	if m.stateChangeCallback != nil && fromState != m.currentState {
-		m.stateChangeCallback(fromState, m.currentState)
+	if fromState != m.currentState {
+		m.notifyStateChange(fromState, m.currentState)
 	}
 
 	return nil
 }
 
+// runTransitionAction runs the transition's action, if any
+func (m *Machine) runTransitionAction(t *Transition, event *Event, fromState, toState StateID) error {
+	action := m.transitionAction(t)
+	if action == nil {
+		return nil
+	}
+	ctx := m.makeContext(event)
+	ctx.FromState = fromState
+	ctx.ToState = toState
+	err := m.runWithRetry(t.ActionRetry, "transition action", func() error {
+		return m.runAction(ctx, m.actionTimeout, "transition action", action)
+	})
+	if ctx.abort != nil {
+		return ctx.abort
+	}
+	if err != nil {
+		return &ActionError{Action: "transition action", Err: err}
+	}
+	return nil
+}
+
 // findLCA finds the least common ancestor of two states
 func (m *Machine) findLCA(a, b StateID) StateID {
 	if a == b {
@@ -354,20 +925,58 @@ func (m *Machine) findLCA(a, b StateID) StateID {
 	return "" // Root
 }
 
-// exitToAncestor exits states from current up to (but not including) ancestor
+// exitToAncestor exits states from current up to (but not including) ancestor,
+// in the configured exit order. With an exit deadline, exit actions that would
+// start or run past it are skipped and ErrExitDeadline is returned after all
+// states were exited.
 func (m *Machine) exitToAncestor(from StateID, ancestor StateID) error {
+	if m.exitDeadline <= 0 {
+		for _, id := range m.exitChain(from, ancestor) {
+			if err := m.exitState(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	deadline := time.Now().Add(m.exitDeadline)
+	var skipped []StateID
+	for _, id := range m.exitChain(from, ancestor) {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			m.exitStateWithin(id, 0, true)
+			skipped = append(skipped, id)
+			continue
+		}
+		if err := m.exitStateWithin(id, remaining, false); err != nil {
+			if !errors.Is(err, ErrActionTimeout) {
+				return err
+			}
+			skipped = append(skipped, id)
+		}
+	}
+	if len(skipped) > 0 {
+		return fmt.Errorf("exit actions of %v skipped or cut short: %w (%s)", skipped, ErrExitDeadline, m.exitDeadline)
+	}
+	return nil
+}
+
+// exitChain lists the states exited when leaving from for ancestor, in exit order
+func (m *Machine) exitChain(from StateID, ancestor StateID) []StateID {
+	var chain []StateID
 	current := from
 	for current != "" && current != ancestor {
-		if err := m.exitState(current); err != nil {
-			return err
-		}
+		chain = append(chain, current)
 		state := m.definition.states[current]
 		if state == nil {
 			break
 		}
 		current = state.Parent
 	}
-	return nil
+	if m.exitOrder == ParentFirst {
+		reverseStates(chain)
+	}
+	return chain
 }
 
 // enterFromAncestor enters states from ancestor down to target
@@ -381,18 +990,37 @@ func (m *Machine) enterFromAncestor(target StateID, ancestor StateID, event *Eve
 	// Build path from ancestor to target
 	path := m.pathFromAncestor(target, ancestor)
 
-	// Enter each state in the path
 	// For the first state, use the fromState parameter
 	// For subsequent states, use the previous state in the path
+	from := make([]StateID, len(path))
 	prevState := fromState
-	for _, stateID := range path {
-		if err := m.enterState(stateID, event, prevState); err != nil {
-			return err
-		}
+	for i, stateID := range path {
+		from[i] = prevState
 		prevState = stateID
 	}
 
-	return nil
+	// Only the target resolves default children and conditions; states above it
+	// are merely passed through
+	last := len(path) - 1
+	if m.entryOrder == ChildFirst {
+		for i, stateID := range path {
+			m.activateState(stateID, event, from[i])
+		}
+		for i := last; i >= 0; i-- {
+			if err := m.runEntryAction(path[i], event, from[i]); err != nil {
+				return err
+			}
+		}
+		return m.completeEntry(target, event, 0)
+	}
+
+	for i, stateID := range path[:last] {
+		m.activateState(stateID, event, from[i])
+		if err := m.runEntryAction(stateID, event, from[i]); err != nil {
+			return err
+		}
+	}
+	return m.enterStateChain(target, event, from[last], 0)
 }
 
 // pathFromAncestor returns the path from ancestor to target (excluding ancestor)
@@ -410,50 +1038,120 @@ func (m *Machine) pathFromAncestor(target StateID, ancestor StateID) []StateID {
 	return path
 }
 
+// defaultMaxChainDepth bounds how often condition states and default children may
+// redirect a single state entry, and how many eventless transitions may follow each other
+const defaultMaxChainDepth = 32
+
 // enterState enters a state and handles conditions/default children
 func (m *Machine) enterState(id StateID, event *Event, fromState StateID) error {
-	state := m.definition.states[id]
-	if state == nil {
+	return m.enterStateChain(id, event, fromState, 0)
+}
+
+// enterStateChain enters a state, tracking how deep the redirect chain already is
+func (m *Machine) enterStateChain(id StateID, event *Event, fromState StateID, depth int) error {
+	if depth > m.maxChainDepth {
+		return fmt.Errorf("entering %q: %w (limit %d)", id, ErrChainDepthExceeded, m.maxChainDepth)
+	}
+
+	if m.definition.states[id] == nil {
 		return fmt.Errorf("state %q not found", id)
 	}
 
-	m.logger.Debug("entering state", "state", id, "type", state.Type)
+	m.activateState(id, event, fromState)
+	if err := m.runEntryAction(id, event, fromState); err != nil {
+		return err
+	}
+	return m.completeEntry(id, event, depth)
+}
+
+// activateState makes a state current and arms its declarative timeout
+func (m *Machine) activateState(id StateID, event *Event, fromState StateID) {
+	state := m.definition.states[id]
+
+	m.trace("entering state", "state", id, "type", state.Type)
+	m.logStateChange("state entered", "state", id, "from", fromState, "event", eventID(event))
 	m.currentState = id
+	m.enteredAt = time.Now()
+	m.stateEnteredAt[id] = m.enteredAt
+	m.enteredChain = append(m.enteredChain, id)
+	m.countEntry(id)
+
+	if !m.suppressTimers {
+		m.armTimeout(id, state)
+		m.armPoll(id, state)
+	}
+}
 
-	// Start declarative timeout timer
+// armTimeout starts the state's declarative (or machine default) timeout timer
+func (m *Machine) armTimeout(id StateID, state *State) {
+	timerName := fmt.Sprintf("_timeout_%s", id)
 	if state.Timeout > 0 && state.TimeoutEvent != "" {
-		timerName := fmt.Sprintf("_timeout_%s", id)
 		m.startTimerInternalWithAction(timerName, state.Timeout, Event{ID: state.TimeoutEvent}, TimerScopeState, id, state.TimeoutAction)
+	} else if m.defaultTimeout > 0 && state.Type == StateNormal && len(m.children[id]) == 0 {
+		m.startTimerInternal(timerName, m.defaultTimeout, Event{ID: m.defaultTimeoutEvent}, TimerScopeState, id)
 	}
+	for i, stage := range state.TimeoutStages {
+		m.startTimerInternal(timeoutStageTimer(id, i), stage.After, Event{ID: stage.Event}, TimerScopeState, id)
+	}
+}
 
-	// Execute entry action (for junction, this runs before condition)
-	if state.OnEnter != nil {
-		ctx := m.makeContext(event)
-		ctx.FromState = fromState
-		ctx.ToState = id
-		if err := state.OnEnter(ctx); err != nil {
-			return fmt.Errorf("entry action failed for %q: %w", id, err)
-		}
+// runEntryAction executes a state's entry action (for junction, this runs before condition)
+func (m *Machine) runEntryAction(id StateID, event *Event, fromState StateID) error {
+	state := m.definition.states[id]
+	action, name := m.entryHook(state)
+	if action == nil {
+		return nil
 	}
 
+	ctx := m.makeContext(event)
+	ctx.FromState = fromState
+	ctx.ToState = id
+	ctx.state = id
+	err := m.runWithRetry(state.EnterRetry, name, func() error {
+		return m.runAction(ctx, m.stateActionTimeout(state), name, action)
+	})
+	if err != nil {
+		return &ActionError{Action: name, State: id, Err: err}
+	}
+	return nil
+}
+
+// completeEntry resolves condition routing and default children of an entered state
+func (m *Machine) completeEntry(id StateID, event *Event, depth int) error {
+	state := m.definition.states[id]
+
 	// Handle condition/junction states
 	if state.Type == StateCondition || state.Type == StateJunction {
 		if state.Condition != nil {
 			ctx := m.makeContext(event)
 			nextState := state.Condition(ctx)
 			if nextState != "" {
+				if !state.allowsTarget(nextState) {
+					return fmt.Errorf("condition state %q returned undeclared target %q", id, nextState)
+				}
 				// Exit this state and enter the next
 				if err := m.exitState(id); err != nil {
 					return err
 				}
-				return m.enterState(nextState, event, id)
+				return m.enterStateChain(nextState, event, id, depth+1)
 			}
 		}
 	}
 
+	if state.Type == StateFinal {
+		m.raiseDone(state)
+	}
+
 	// Auto-enter default child
 	if state.DefaultChild != "" {
-		return m.enterState(state.DefaultChild, event, id)
+		if !m.childEntryAllowed(state, event) {
+			m.trace("child entry deferred", "state", id, "child", state.DefaultChild)
+			if state.HoldEvent != "" {
+				m.enqueue(Event{ID: state.HoldEvent})
+			}
+			return nil
+		}
+		return m.enterStateChain(state.DefaultChild, event, id, depth+1)
 	}
 
 	return nil
@@ -461,15 +1159,23 @@ func (m *Machine) enterState(id StateID, event *Event, fromState StateID) error
 
 // exitState exits a state
 func (m *Machine) exitState(id StateID) error {
+	return m.exitStateWithin(id, -1, false)
+}
+
+// exitStateWithin exits a state, abandoning its exit action once budget runs out.
+// A negative budget applies the regular action timeout; skipAction only cleans up.
+func (m *Machine) exitStateWithin(id StateID, budget time.Duration, skipAction bool) error {
 	state := m.definition.states[id]
 	if state == nil {
 		return nil
 	}
 
-	m.logger.Debug("exiting state", "state", id)
+	m.trace("exiting state", "state", id)
+	m.logStateChange("state exited", "state", id)
 
-	// Cancel state-scoped timers
+	// Cancel state-scoped timers and jobs
 	m.cleanupTimersForState(id)
+	m.cancelJobs(id)
 
 	// Cancel declared timers
 	for _, timerName := range state.DeclaredTimers {
@@ -480,11 +1186,24 @@ func (m *Machine) exitState(id StateID) error {
 	timerName := fmt.Sprintf("_timeout_%s", id)
 	m.StopTimer(timerName)
 
+	// The state's scratch store lives until its exit action has run
+	defer m.clearStateStore(id)
+
 	// Execute exit action
-	if state.OnExit != nil {
+	if action := m.exitAction(state); action != nil && !skipAction {
 		ctx := m.makeContext(nil)
-		if err := state.OnExit(ctx); err != nil {
-			return fmt.Errorf("exit action failed for %q: %w", id, err)
+		ctx.state = id
+		var err error
+		if timeout := m.stateActionTimeout(state); budget < 0 {
+			err = m.runAction(ctx, timeout, "exit action", action)
+		} else {
+			if timeout > 0 && timeout < budget {
+				budget = timeout
+			}
+			err = m.runActionPolicy(ctx, budget, ActionTimeoutFail, "exit action", action)
+		}
+		if err != nil {
+			return &ActionError{Action: "exit action", State: id, Err: err}
 		}
 	}
 
@@ -501,6 +1220,53 @@ func (m *Machine) makeContext(event *Event) *Context {
 	}
 }
 
+// makeGuardContext creates the read-only context for evaluating a transition's guard
+func (m *Machine) makeGuardContext(event *Event, t *Transition) *GuardContext {
+	return &GuardContext{
+		Event:     event,
+		FromState: m.currentState,
+		ToState:   t.To,
+		Data:      m.data,
+		Logger:    m.logger,
+		fsm:       m,
+	}
+}
+
+// timeInState returns the time since the current state was entered.
+// Only called from the event loop, which holds the lock.
+func (m *Machine) timeInState() time.Duration {
+	if m.enteredAt.IsZero() {
+		return 0
+	}
+	return time.Since(m.enteredAt)
+}
+
+// TransitionInfo describes a completed transition
+type TransitionInfo struct {
+	From  StateID   `json:"from"`
+	To    StateID   `json:"to"`              // Leaf state reached, after default children and conditions
+	Event EventID   `json:"event,omitempty"` // Empty for SetState
+	Time  time.Time `json:"time"`
+
+	// Why SetState forced the change, see Reason
+	Reason string `json:"reason,omitempty"`
+}
+
+// LastTransition returns the most recent completed transition.
+// Internal transitions are not recorded since they do not change state.
+func (m *Machine) LastTransition() (TransitionInfo, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.lastTransition == nil {
+		return TransitionInfo{}, false
+	}
+	return *m.lastTransition, true
+}
+
+func (m *Machine) recordTransition(from StateID, event EventID) {
+	m.lastTransition = &TransitionInfo{From: from, To: m.currentState, Event: event, Time: time.Now()}
+}
+
 // StateHistory returns recent state history (not yet implemented)
 func (m *Machine) StateHistory() []StateID {
 	m.mu.RLock()
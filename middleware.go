@@ -0,0 +1,42 @@
+package librefsm
+
+// TransitionFunc executes a transition. The Context describes it: Event (nil for
+// eventless transitions), FromState and ToState.
+type TransitionFunc func(ctx *Context) error
+
+// Middleware wraps the execution of every transition, e.g. for metrics,
+// persistence or authorization of externally injected events. Returning an
+// error without calling next aborts the transition.
+type Middleware func(next TransitionFunc) TransitionFunc
+
+// WithMiddleware appends transition middleware. The first registered
+// middleware is the outermost.
+func WithMiddleware(mw ...Middleware) MachineOption {
+	return func(m *Machine) {
+		m.middleware = append(m.middleware, mw...)
+	}
+}
+
+// runTransition executes a transition through the middleware chain
+func (m *Machine) runTransition(t *Transition, target StateID, event *Event) error {
+	m.stats.transitions.Add(1)
+	if t.Quiet {
+		m.quiet = true
+	}
+	m.noteOutcome(SendTransitioned)
+	if len(m.middleware) == 0 {
+		return m.executeTransition(t, target, event)
+	}
+
+	next := TransitionFunc(func(*Context) error {
+		return m.executeTransition(t, target, event)
+	})
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		next = m.middleware[i](next)
+	}
+
+	ctx := m.makeContext(event)
+	ctx.FromState = m.currentState
+	ctx.ToState = target
+	return next(ctx)
+}
@@ -0,0 +1,316 @@
+package librefsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MsgpackCodec encodes payloads as MessagePack. Payloads are mapped through
+// their JSON representation, so json struct tags apply and only JSON-compatible
+// values are supported; extension types are rejected.
+type MsgpackCodec struct {
+	Types *PayloadTypes // Optional: payload types to decode into
+}
+
+// ContentType returns "application/msgpack"
+func (c MsgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+// Encode converts the payload to MessagePack
+func (c MsgpackCodec) Encode(event EventID, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode payload of %q: %w", event, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("encode payload of %q: %w", event, err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, generic); err != nil {
+		return nil, fmt.Errorf("encode payload of %q: %w", event, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode converts a MessagePack payload
+func (c MsgpackCodec) Decode(event EventID, data []byte) (any, error) {
+	r := bytes.NewReader(data)
+	generic, err := readMsgpack(r)
+	if err == nil && r.Len() > 0 {
+		err = fmt.Errorf("%d trailing bytes", r.Len())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode payload of %q: %w", event, err)
+	}
+	if generic == nil {
+		return nil, nil
+	}
+	if c.Types.lookup(event) == nil {
+		return generic, nil
+	}
+	js, err := json.Marshal(generic)
+	if err != nil {
+		return nil, fmt.Errorf("decode payload of %q: %w", event, err)
+	}
+	return c.Types.decodeJSON(event, js)
+}
+
+// writeMsgpack encodes a generic JSON value decoded with UseNumber
+func writeMsgpack(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		switch n := len(v); {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdb)
+			binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		buf.WriteString(v)
+	case []any:
+		writeMsgpackHeader(buf, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		writeMsgpackHeader(buf, len(v), 0x80, 0xde)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeMsgpack(buf, k)
+			if err := writeMsgpack(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported value %T", v)
+	}
+	return nil
+}
+
+// writeMsgpackInt encodes an integer in its most compact form
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.Write([]byte{0xd0, byte(int8(i))})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// writeMsgpackHeader writes an array or map header; fix is the fixarray/fixmap
+// prefix and wide the 16-bit variant, followed by the 32-bit one
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix, wide byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(wide)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(wide + 1)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+var errMsgpackTruncated = errors.New("truncated msgpack data")
+
+// readMsgpack decodes one value into nil, bool, int64, uint64, float64,
+// string, []byte, []any or map[string]any
+func readMsgpack(r *bytes.Reader) (any, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, errMsgpackTruncated
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return readMsgpackMap(r, int(b&0x0f))
+	case b&0xf0 == 0x90:
+		return readMsgpackArray(r, int(b&0x0f))
+	case b&0xe0 == 0xa0:
+		return readMsgpackString(r, int(b&0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readMsgpackLen(r, b-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, n)
+		if _, err := r.Read(data); err != nil && n > 0 {
+			return nil, errMsgpackTruncated
+		}
+		return data, nil
+	case 0xca:
+		var bits uint32
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, errMsgpackTruncated
+		}
+		return float64(math.Float32frombits(bits)), nil
+	case 0xcb:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, errMsgpackTruncated
+		}
+		return math.Float64frombits(bits), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readMsgpackUint(r, 1<<(b-0xcc))
+		return n, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n, err := readMsgpackUint(r, 1<<(b-0xd0))
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*(1<<(b-0xd0))
+		return int64(n<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackLen(r, b-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, n)
+	case 0xdc, 0xdd:
+		n, err := readMsgpackLen(r, b-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, n)
+	case 0xde, 0xdf:
+		n, err := readMsgpackLen(r, b-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, n)
+	}
+	return nil, fmt.Errorf("unsupported msgpack type 0x%02x", b)
+}
+
+// readMsgpackLen reads a length of 1, 2 or 4 bytes for width 0, 1 or 2
+func readMsgpackLen(r *bytes.Reader, width byte) (int, error) {
+	n, err := readMsgpackUint(r, 1<<width)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, errMsgpackTruncated
+	}
+	return int(n), nil
+}
+
+// readMsgpackUint reads a big-endian unsigned integer of size bytes
+func readMsgpackUint(r *bytes.Reader, size int) (uint64, error) {
+	var n uint64
+	for i := 0; i < size; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, errMsgpackTruncated
+		}
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+func readMsgpackString(r *bytes.Reader, n int) (string, error) {
+	if n > r.Len() {
+		return "", errMsgpackTruncated
+	}
+	data := make([]byte, n)
+	r.Read(data)
+	return string(data), nil
+}
+
+func readMsgpackArray(r *bytes.Reader, n int) ([]any, error) {
+	if n > r.Len() {
+		return nil, errMsgpackTruncated
+	}
+	out := make([]any, n)
+	for i := range out {
+		v, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func readMsgpackMap(r *bytes.Reader, n int) (map[string]any, error) {
+	if n > r.Len() {
+		return nil, errMsgpackTruncated
+	}
+	out := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		out[fmt.Sprint(k)] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,211 @@
+package librefsm
+
+import "fmt"
+
+// ParkOverflow selects what happens when the parked event buffer is full
+type ParkOverflow int
+
+const (
+	// ParkDropOldest discards the longest parked event to make room
+	ParkDropOldest ParkOverflow = iota
+	// ParkRejectNew discards the incoming event
+	ParkRejectNew
+)
+
+func (p ParkOverflow) String() string {
+	switch p {
+	case ParkDropOldest:
+		return "drop-oldest"
+	case ParkRejectNew:
+		return "reject-new"
+	default:
+		return fmt.Sprintf("ParkOverflow(%d)", int(p))
+	}
+}
+
+// defaultParkLimit bounds the parked events unless configured
+const defaultParkLimit = 32
+
+// WithTransitional tags a state, and thereby its children, as transitional,
+// e.g. shutting_down or updating. See WithDeferUntilStable.
+func WithTransitional() StateOption {
+	return func(s *State) {
+		s.Transitional = true
+	}
+}
+
+// WithDeferUntilStable parks events that arrive while a transitional state is
+// active and that no active state handles explicitly, instead of letting
+// wildcard rules consume them or dropping them as unhandled. Parked events are
+// delivered in arrival order once no transitional state is active. At most
+// limit events are parked (zero means 32); overflow decides which event is
+// discarded when the buffer is full. SendSync callers of parked events wait
+// until delivery.
+func WithDeferUntilStable(limit int, overflow ParkOverflow) MachineOption {
+	return func(m *Machine) {
+		if limit <= 0 {
+			limit = defaultParkLimit
+		}
+		m.deferUntilStable = true
+		m.parkLimit = limit
+		m.parkOverflow = overflow
+	}
+}
+
+// shouldPark reports whether an event must wait, either for a stable state or
+// for a minimum dwell time to pass
+func (m *Machine) shouldPark(event Event) bool {
+	if isInternalEvent(event.ID) {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.deferUntilStable && m.inTransitional() && !m.handlesExplicitly(event.ID) {
+		return true
+	}
+	if wait := m.dwellRemaining(); wait > 0 && m.leavesState(event.ID) {
+		m.wakeAfterDwell(wait)
+		return true
+	}
+	return false
+}
+
+// inTransitional reports whether any active state is tagged transitional. Callers hold mu.
+func (m *Machine) inTransitional() bool {
+	for _, id := range m.activeChain() {
+		if s := m.definition.states[id]; s != nil && s.Transitional {
+			return true
+		}
+	}
+	return false
+}
+
+// handlesExplicitly reports whether an active state has a transition naming
+// the event, as opposed to wildcard state or event rules. Callers hold mu.
+func (m *Machine) handlesExplicitly(id EventID) bool {
+	active := make(map[StateID]bool)
+	for _, s := range m.activeChain() {
+		active[s] = true
+	}
+	for i := range m.definition.transitions {
+		t := &m.definition.transitions[i]
+		if active[t.From] && t.Event != WildcardEvent && eventMatches(t.Event, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// park holds an event back, applying the overflow policy if the buffer is full
+func (m *Machine) park(qe *queuedEvent) {
+	m.parkMu.Lock()
+	dropped := m.bufferLocked(&m.parked, qe)
+	m.parkMu.Unlock()
+
+	m.logger.Debug("event parked", "event", qe.event.ID, "state", m.CurrentState())
+	m.dropBuffered(dropped)
+}
+
+// bufferLocked appends qe to a buffer of held-back events, applying the park
+// limit and overflow policy, and returns the event that had to go. Callers hold parkMu.
+func (m *Machine) bufferLocked(buf *[]*queuedEvent, qe *queuedEvent) *queuedEvent {
+	var dropped *queuedEvent
+	limit := m.parkLimit
+	if limit <= 0 {
+		limit = defaultParkLimit
+	}
+	if len(*buf) >= limit {
+		if m.parkOverflow == ParkRejectNew {
+			return qe
+		}
+		dropped = (*buf)[0]
+		*buf = (*buf)[1:]
+	}
+	*buf = append(*buf, qe)
+	return dropped
+}
+
+// dropBuffered discards an event pushed out of a full buffer
+func (m *Machine) dropBuffered(dropped *queuedEvent) {
+	if dropped != nil {
+		m.logger.Warn("parked event buffer full", "dropped", dropped.event.ID, "policy", m.parkOverflow)
+		m.discard([]*queuedEvent{dropped})
+	}
+}
+
+// deliverParked hands parked events back to the machine, in arrival order, as
+// long as the oldest no longer has to wait
+func (m *Machine) deliverParked() {
+	for {
+		m.parkMu.Lock()
+		if len(m.parked) == 0 {
+			m.parkMu.Unlock()
+			return
+		}
+		if m.shouldPark(m.parked[0].event) {
+			m.parkMu.Unlock()
+			return
+		}
+		qe := m.parked[0]
+		m.parked = m.parked[1:]
+		m.parkMu.Unlock()
+
+		m.logger.Debug("delivering parked event", "event", qe.event.ID)
+		m.handleEvent(qe)
+	}
+}
+
+// takeParked removes and returns all parked events
+func (m *Machine) takeParked() []*queuedEvent {
+	m.parkMu.Lock()
+	defer m.parkMu.Unlock()
+	parked := append(m.deferred, m.parked...)
+	m.parked, m.deferred = nil, nil
+	return parked
+}
+
+// parkedEvents returns the parked events for persistence
+func (m *Machine) parkedEvents() []Event {
+	m.parkMu.Lock()
+	defer m.parkMu.Unlock()
+	events := make([]Event, 0, len(m.deferred)+len(m.parked))
+	for _, qe := range m.deferred {
+		events = append(events, qe.event)
+	}
+	for _, qe := range m.parked {
+		events = append(events, qe.event)
+	}
+	return events
+}
+
+// deferEvent holds an event until the next state change, see Context.DeferEvent
+func (m *Machine) deferEvent(event Event) {
+	event.Key = ""
+	m.parkMu.Lock()
+	dropped := m.bufferLocked(&m.deferred, &queuedEvent{event: event})
+	m.parkMu.Unlock()
+
+	m.logger.Debug("event deferred until state change", "event", event.ID)
+	m.dropBuffered(dropped)
+}
+
+// releaseDeferred moves deferred events to the front of the parked buffer and
+// wakes the event loop to deliver them
+func (m *Machine) releaseDeferred() {
+	m.parkMu.Lock()
+	released := len(m.deferred) > 0
+	if released {
+		m.parked = append(m.deferred, m.parked...)
+		m.deferred = nil
+	}
+	m.parkMu.Unlock()
+
+	if released {
+		m.wakeLoop()
+	}
+}
+
+// wakeLoop queues a no-op command so the event loop gets to deliver parked events
+func (m *Machine) wakeLoop() {
+	m.queue.pushCommand(&queuedEvent{command: func() error { return nil }}, false)
+}
@@ -0,0 +1,247 @@
+package patterns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+// Battery slot states
+const (
+	BatteryAbsent  librefsm.StateID = "absent"
+	BatteryPresent librefsm.StateID = "present" // Parent of seated and active
+	BatterySeated  librefsm.StateID = "seated"
+	BatteryActive  librefsm.StateID = "active"
+	BatteryFault   librefsm.StateID = "fault"
+)
+
+// Battery slot events
+const (
+	BatteryInserted      librefsm.EventID = "battery:inserted"
+	BatteryRemoved       librefsm.EventID = "battery:removed"
+	BatteryActivate      librefsm.EventID = "battery:activate"
+	BatteryDeactivate    librefsm.EventID = "battery:deactivate"
+	BatteryHeartbeat     librefsm.EventID = "battery:heartbeat"
+	BatteryHeartbeatLost librefsm.EventID = "battery:heartbeat-lost"
+	BatteryFaulted       librefsm.EventID = "battery:fault"
+	BatteryCleared       librefsm.EventID = "battery:cleared"
+)
+
+// BatteryStatusEvent is the ID of the combined status event published by a BatteryManager
+const BatteryStatusEvent librefsm.EventID = "battery:status"
+
+// batteryHeartbeatTimer is the timer watching a present battery's heartbeat
+const batteryHeartbeatTimer = "battery:heartbeat"
+
+// BatterySlotConfig configures BatterySlot
+type BatterySlotConfig struct {
+	// HeartbeatTimeout faults a present battery that stops sending heartbeats; zero means 5s
+	HeartbeatTimeout time.Duration
+
+	OnActivate   func(ctx *librefsm.Context) error // Entry to active, e.g. closing the contactor
+	OnDeactivate func(ctx *librefsm.Context) error // Exit from active, e.g. opening the contactor
+	OnFault      func(ctx *librefsm.Context) error // Entry to fault
+}
+
+// BatterySlot returns the definition of one battery slot:
+//
+//	absent --inserted--> present/seated --activate--> present/active
+//	present/active --deactivate--> present/seated
+//	present --removed--> absent
+//	present --fault, heartbeat lost--> fault --cleared--> present, --removed--> absent
+//
+// Every heartbeat while present restarts the heartbeat timeout.
+func BatterySlot(cfg BatterySlotConfig) *librefsm.Definition {
+	timeout := orDuration(cfg.HeartbeatTimeout, 5*time.Second)
+
+	activeOpts := []librefsm.StateOption{librefsm.WithParent(BatteryPresent)}
+	if cfg.OnActivate != nil {
+		activeOpts = append(activeOpts, librefsm.WithOnEnter(cfg.OnActivate))
+	}
+	if cfg.OnDeactivate != nil {
+		activeOpts = append(activeOpts, librefsm.WithOnExit(cfg.OnDeactivate))
+	}
+	var faultOpts []librefsm.StateOption
+	if cfg.OnFault != nil {
+		faultOpts = append(faultOpts, librefsm.WithOnEnter(cfg.OnFault))
+	}
+
+	watch := func(ctx *librefsm.Context) error {
+		ctx.StartTimer(batteryHeartbeatTimer, timeout, librefsm.Event{ID: BatteryHeartbeatLost})
+		return nil
+	}
+
+	return librefsm.NewDefinition().
+		State(BatteryAbsent).
+		State(BatteryPresent,
+			librefsm.WithDefaultChild(BatterySeated),
+			librefsm.WithOnEnter(watch),
+			librefsm.WithOnEvent(BatteryHeartbeat, func(ctx *librefsm.Context) error {
+				ctx.ResetTimer(batteryHeartbeatTimer, timeout)
+				return nil
+			})).
+		State(BatterySeated, librefsm.WithParent(BatteryPresent)).
+		State(BatteryActive, activeOpts...).
+		State(BatteryFault, faultOpts...).
+		Transition(BatteryAbsent, BatteryInserted, BatteryPresent).
+		Transition(BatterySeated, BatteryActivate, BatteryActive).
+		Transition(BatteryActive, BatteryDeactivate, BatterySeated).
+		Transition(BatteryPresent, BatteryRemoved, BatteryAbsent).
+		Transition(BatteryPresent, BatteryFaulted, BatteryFault).
+		Transition(BatteryPresent, BatteryHeartbeatLost, BatteryFault).
+		Transition(BatteryFault, BatteryCleared, BatteryPresent).
+		Transition(BatteryFault, BatteryRemoved, BatteryAbsent).
+		Initial(BatteryAbsent)
+}
+
+// BatteryStatus is the combined status of all slots
+type BatteryStatus struct {
+	Slots  []librefsm.StateID // Leaf state per slot
+	Active int                // Index of the active slot, -1 if none
+	Faults int                // Number of slots in fault
+}
+
+// Present returns the number of slots holding a battery, including faulted ones
+func (s BatteryStatus) Present() int {
+	n := 0
+	for _, state := range s.Slots {
+		if state != BatteryAbsent {
+			n++
+		}
+	}
+	return n
+}
+
+// BatteryManager runs one BatterySlot machine per slot and aggregates their
+// states into a BatteryStatus, published as BatteryStatusEvent on each change
+type BatteryManager struct {
+	slots    []*librefsm.Machine
+	bus      *librefsm.Bus
+	onStatus func(BatteryStatus)
+
+	mu     sync.Mutex
+	states []librefsm.StateID
+}
+
+// BatteryManagerConfig configures NewBatteryManager
+type BatteryManagerConfig struct {
+	Slots int // Number of slots; zero means 2
+	Slot  BatterySlotConfig
+
+	Bus      *librefsm.Bus       // Optional: receives BatteryStatusEvent with a BatteryStatus payload
+	OnStatus func(BatteryStatus) // Optional: called with every status change
+
+	// MachineOptions are applied to every slot machine, e.g. a logger
+	MachineOptions []librefsm.MachineOption
+}
+
+// NewBatteryManager builds a machine per slot. Status callbacks run on the
+// slot machines' event loops and must not block.
+func NewBatteryManager(cfg BatteryManagerConfig) (*BatteryManager, error) {
+	n := cfg.Slots
+	if n <= 0 {
+		n = 2
+	}
+	bm := &BatteryManager{
+		bus:      cfg.Bus,
+		onStatus: cfg.OnStatus,
+		states:   make([]librefsm.StateID, n),
+	}
+	for i := 0; i < n; i++ {
+		i := i
+		opts := append([]librefsm.MachineOption{}, cfg.MachineOptions...)
+		opts = append(opts, librefsm.WithStateChangeCallback(func(from, to librefsm.StateID) {
+			bm.update(i, to)
+		}))
+		m, err := BatterySlot(cfg.Slot).Build(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("battery slot %d: %w", i, err)
+		}
+		bm.slots = append(bm.slots, m)
+		bm.states[i] = BatteryAbsent
+	}
+	return bm, nil
+}
+
+// Start starts all slot machines and publishes the initial status
+func (bm *BatteryManager) Start(ctx context.Context) error {
+	for i, m := range bm.slots {
+		if err := m.Start(ctx); err != nil {
+			bm.Stop()
+			return fmt.Errorf("battery slot %d: %w", i, err)
+		}
+	}
+	bm.publish(bm.Status())
+	return nil
+}
+
+// Stop stops all slot machines
+func (bm *BatteryManager) Stop() {
+	for _, m := range bm.slots {
+		m.Stop()
+	}
+}
+
+// Send delivers an event to the machine of the given slot
+func (bm *BatteryManager) Send(slot int, event librefsm.Event) error {
+	if slot < 0 || slot >= len(bm.slots) {
+		return fmt.Errorf("battery slot %d out of range [0, %d)", slot, len(bm.slots))
+	}
+	bm.slots[slot].Send(event)
+	return nil
+}
+
+// Slot returns the machine of the given slot, or nil if out of range
+func (bm *BatteryManager) Slot(slot int) *librefsm.Machine {
+	if slot < 0 || slot >= len(bm.slots) {
+		return nil
+	}
+	return bm.slots[slot]
+}
+
+// Status returns the current combined status
+func (bm *BatteryManager) Status() BatteryStatus {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.statusLocked()
+}
+
+func (bm *BatteryManager) statusLocked() BatteryStatus {
+	s := BatteryStatus{Slots: append([]librefsm.StateID(nil), bm.states...), Active: -1}
+	for i, state := range bm.states {
+		switch state {
+		case BatteryActive:
+			if s.Active < 0 {
+				s.Active = i
+			}
+		case BatteryFault:
+			s.Faults++
+		}
+	}
+	return s
+}
+
+// update records a slot's new state and publishes the status if it changed
+func (bm *BatteryManager) update(slot int, state librefsm.StateID) {
+	bm.mu.Lock()
+	if bm.states[slot] == state {
+		bm.mu.Unlock()
+		return
+	}
+	bm.states[slot] = state
+	status := bm.statusLocked()
+	bm.mu.Unlock()
+	bm.publish(status)
+}
+
+func (bm *BatteryManager) publish(status BatteryStatus) {
+	if bm.onStatus != nil {
+		bm.onStatus(status)
+	}
+	if bm.bus != nil {
+		bm.bus.Publish(librefsm.Event{ID: BatteryStatusEvent, Payload: status})
+	}
+}
@@ -0,0 +1,54 @@
+package patterns
+
+import (
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+// DebounceConfig configures DebounceConfirm. Zero IDs fall back to the defaults in parentheses.
+type DebounceConfig struct {
+	Parent librefsm.StateID // Optional parent of the fragment's states
+
+	Inactive  librefsm.StateID // Input released ("input-inactive")
+	Pending   librefsm.StateID // Input active, not yet confirmed ("input-pending")
+	Confirmed librefsm.StateID // Input held for Hold ("input-confirmed")
+
+	Active  librefsm.EventID // Input became active, e.g. "kickstand:down" ("input:active")
+	Release librefsm.EventID // Input became inactive ("input:inactive")
+
+	// Hold is how long the input must stay active to be confirmed; zero means 50ms
+	Hold time.Duration
+
+	// OnConfirm runs on entry to Confirmed
+	OnConfirm func(ctx *librefsm.Context) error
+}
+
+// DebounceConfirm returns a fragment that only reports a noisy input, such as
+// a brake lever or kickstand switch, once it stayed active for Hold:
+//
+//	input-inactive --active--> input-pending --hold--> input-confirmed
+//	input-pending, input-confirmed --inactive--> input-inactive
+//
+// Repeated Active events while pending do not restart the hold time.
+func DebounceConfirm(cfg DebounceConfig) *librefsm.Definition {
+	inactive := orState(cfg.Inactive, "input-inactive")
+	pending := orState(cfg.Pending, "input-pending")
+	confirmed := orState(cfg.Confirmed, "input-confirmed")
+	active := orEvent(cfg.Active, "input:active")
+	release := orEvent(cfg.Release, "input:inactive")
+	hold := orDuration(cfg.Hold, 50*time.Millisecond)
+
+	confirmedOpts := parent(cfg.Parent)
+	if cfg.OnConfirm != nil {
+		confirmedOpts = append(confirmedOpts, librefsm.WithOnEnter(cfg.OnConfirm))
+	}
+
+	return librefsm.NewDefinition().
+		State(inactive, parent(cfg.Parent)...).
+		State(pending, append(parent(cfg.Parent), librefsm.WithTimeoutTransition(hold, confirmed))...).
+		State(confirmed, confirmedOpts...).
+		Transition(inactive, active, pending).
+		Transition(pending, release, inactive).
+		Transition(confirmed, release, inactive)
+}
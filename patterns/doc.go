@@ -0,0 +1,9 @@
+// Package patterns provides parameterized statechart building blocks common to
+// librescoot services. Each constructor returns a Definition fragment to add
+// to a service's definition with Definition.Merge; fragments have no initial
+// state and are entered through transitions of the surrounding chart.
+//
+// State and event IDs are configurable, so several instances of a pattern can
+// live in one chart. Internal events a fragment sends to itself are derived
+// from its state IDs.
+package patterns
@@ -0,0 +1,96 @@
+package patterns
+
+import (
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+// RetryInitConfig configures RetryInit. Zero IDs fall back to the defaults in parentheses.
+type RetryInitConfig struct {
+	Parent librefsm.StateID // Optional parent of the fragment's states
+
+	Initializing librefsm.StateID // Runs Init on entry ("initializing")
+	Backoff      librefsm.StateID // Waits before the next attempt ("init-backoff")
+	Failed       librefsm.StateID // Entered after MaxAttempts failures ("init-failed")
+	Ready        librefsm.StateID // Required: entered once Init succeeds, outside the fragment
+
+	// Required: performs one initialization attempt, e.g. connecting to Redis
+	Init func(ctx *librefsm.Context) error
+
+	MaxAttempts int           // Attempts before giving up; zero retries forever
+	Delay       time.Duration // Backoff after the first failure, doubled per attempt; zero means 1s
+	MaxDelay    time.Duration // Backoff cap; zero means 30s
+}
+
+// RetryInit returns an initialization sequence that retries a failing Init
+// with exponential backoff:
+//
+//	initializing --ok--> ready
+//	initializing --error--> init-backoff --delay--> initializing
+//	initializing --error, attempts exhausted--> init-failed
+//
+// The attempt count is kept in the machine variable "<initializing>.attempts"
+// and reset on success and on entering Failed, so a transition out of Failed
+// back to Initializing starts over.
+func RetryInit(cfg RetryInitConfig) *librefsm.Definition {
+	initializing := orState(cfg.Initializing, "initializing")
+	backoff := orState(cfg.Backoff, "init-backoff")
+	failed := orState(cfg.Failed, "init-failed")
+	delay := orDuration(cfg.Delay, time.Second)
+	maxDelay := orDuration(cfg.MaxDelay, 30*time.Second)
+
+	attemptsVar := string(initializing) + ".attempts"
+	evOK := internalEvent(initializing, "ok")
+	evRetry := internalEvent(initializing, "retry")
+	evGiveUp := internalEvent(initializing, "give-up")
+	evWake := internalEvent(backoff, "wake")
+
+	attempts := func(ctx *librefsm.Context) int {
+		n, _ := ctx.Var(attemptsVar)
+		count, _ := n.(int)
+		return count
+	}
+
+	if cfg.Init == nil {
+		panic("patterns: RetryInit requires Init")
+	}
+
+	return librefsm.NewDefinition().
+		State(initializing, append(parent(cfg.Parent), librefsm.WithOnEnter(func(ctx *librefsm.Context) error {
+			n := attempts(ctx) + 1
+			ctx.SetVar(attemptsVar, n)
+			err := cfg.Init(ctx)
+			switch {
+			case err == nil:
+				ctx.SetVar(attemptsVar, 0)
+				ctx.Send(librefsm.Event{ID: evOK})
+			case cfg.MaxAttempts > 0 && n >= cfg.MaxAttempts:
+				ctx.Logger.Error("initialization failed, giving up", "attempts", n, "error", err)
+				ctx.Send(librefsm.Event{ID: evGiveUp})
+			default:
+				ctx.Logger.Warn("initialization failed, retrying", "attempt", n, "error", err)
+				ctx.Send(librefsm.Event{ID: evRetry})
+			}
+			return nil
+		}))...).
+		State(backoff, append(parent(cfg.Parent), librefsm.WithOnEnter(func(ctx *librefsm.Context) error {
+			d := delay
+			for i := 1; i < attempts(ctx) && d < maxDelay; i++ {
+				d *= 2
+			}
+			if d > maxDelay {
+				d = maxDelay
+			}
+			ctx.StartTimer(string(evWake), d, librefsm.Event{ID: evWake})
+			return nil
+		}))...).
+		State(failed, append(parent(cfg.Parent), librefsm.WithOnEnter(func(ctx *librefsm.Context) error {
+			ctx.SetVar(attemptsVar, 0)
+			return nil
+		}))...).
+		Transition(initializing, evOK, cfg.Ready).
+		Transition(initializing, evRetry, backoff).
+		Transition(initializing, evGiveUp, failed).
+		Transition(backoff, evWake, initializing)
+}
@@ -0,0 +1,70 @@
+package patterns
+
+import (
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+// LockConfig configures LockUnlock. Zero IDs fall back to the defaults in parentheses.
+type LockConfig struct {
+	Parent librefsm.StateID // Optional parent of the fragment's states
+
+	Locked    librefsm.StateID // ("locked")
+	Unlocking librefsm.StateID // ("unlocking")
+	Unlocked  librefsm.StateID // ("unlocked")
+	Locking   librefsm.StateID // ("locking")
+
+	Lock      librefsm.EventID // Lock request ("lock")
+	Unlock    librefsm.EventID // Unlock request ("unlock")
+	Confirmed librefsm.EventID // Actuator confirmation ("lock:confirmed")
+
+	// Actuate drives the actuator, e.g. by publishing a Redis command. It runs on
+	// entry to Locking (locked = true) and Unlocking (locked = false).
+	Actuate func(ctx *librefsm.Context, locked bool) error
+
+	// Timeout bounds the wait for Confirmed; on expiry the fragment returns to
+	// the state it came from. Zero means 5s.
+	Timeout time.Duration
+}
+
+// LockUnlock returns a lock/unlock fragment that only reports a new position
+// once the actuator confirms it:
+//
+//	locked --unlock--> unlocking --confirmed--> unlocked
+//	unlocked --lock--> locking --confirmed--> locked
+//
+// Unconfirmed moves time out back to the previous position.
+func LockUnlock(cfg LockConfig) *librefsm.Definition {
+	locked := orState(cfg.Locked, "locked")
+	unlocking := orState(cfg.Unlocking, "unlocking")
+	unlocked := orState(cfg.Unlocked, "unlocked")
+	locking := orState(cfg.Locking, "locking")
+	lock := orEvent(cfg.Lock, "lock")
+	unlock := orEvent(cfg.Unlock, "unlock")
+	confirmed := orEvent(cfg.Confirmed, "lock:confirmed")
+	timeout := orDuration(cfg.Timeout, 5*time.Second)
+
+	actuate := func(lockedPos bool) func(*librefsm.Context) error {
+		return func(ctx *librefsm.Context) error {
+			if cfg.Actuate == nil {
+				return nil
+			}
+			return cfg.Actuate(ctx, lockedPos)
+		}
+	}
+
+	return librefsm.NewDefinition().
+		State(locked, parent(cfg.Parent)...).
+		State(unlocking, append(parent(cfg.Parent),
+			librefsm.WithOnEnter(actuate(false)),
+			librefsm.WithTimeoutTransition(timeout, locked))...).
+		State(unlocked, parent(cfg.Parent)...).
+		State(locking, append(parent(cfg.Parent),
+			librefsm.WithOnEnter(actuate(true)),
+			librefsm.WithTimeoutTransition(timeout, unlocked))...).
+		Transition(locked, unlock, unlocking).
+		Transition(unlocking, confirmed, unlocked).
+		Transition(unlocked, lock, locking).
+		Transition(locking, confirmed, locked)
+}
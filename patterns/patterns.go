@@ -0,0 +1,41 @@
+package patterns
+
+import (
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+// parent returns the state options placing a fragment state under p, if set
+func parent(p librefsm.StateID) []librefsm.StateOption {
+	if p == "" {
+		return nil
+	}
+	return []librefsm.StateOption{librefsm.WithParent(p)}
+}
+
+func orState(id, def librefsm.StateID) librefsm.StateID {
+	if id == "" {
+		return def
+	}
+	return id
+}
+
+func orEvent(id, def librefsm.EventID) librefsm.EventID {
+	if id == "" {
+		return def
+	}
+	return id
+}
+
+func orDuration(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// internalEvent derives the ID of an event a fragment sends to itself
+func internalEvent(state librefsm.StateID, name string) librefsm.EventID {
+	return librefsm.EventID(string(state) + "." + name)
+}
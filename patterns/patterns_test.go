@@ -0,0 +1,219 @@
+package patterns
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+func start(t *testing.T, def *librefsm.Definition) *librefsm.Machine {
+	t.Helper()
+	m, err := def.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	t.Cleanup(func() { m.Stop() })
+	return m
+}
+
+func expectState(t *testing.T, m *librefsm.Machine, want librefsm.StateID) {
+	t.Helper()
+	if got := m.CurrentState(); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestLockUnlock(t *testing.T) {
+	var mu sync.Mutex
+	var commands []bool
+	def := librefsm.NewDefinition().
+		Merge(LockUnlock(LockConfig{
+			Timeout: 30 * time.Millisecond,
+			Actuate: func(ctx *librefsm.Context, locked bool) error {
+				mu.Lock()
+				defer mu.Unlock()
+				commands = append(commands, locked)
+				return nil
+			},
+		})).
+		Initial("locked")
+	m := start(t, def)
+
+	m.SendSync(librefsm.Event{ID: "unlock"})
+	expectState(t, m, "unlocking")
+	m.SendSync(librefsm.Event{ID: "lock:confirmed"})
+	expectState(t, m, "unlocked")
+
+	m.SendSync(librefsm.Event{ID: "lock"})
+	time.Sleep(60 * time.Millisecond) // Actuator never confirms
+	expectState(t, m, "unlocked")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(commands) != 2 || commands[0] || !commands[1] {
+		t.Errorf("expected unlock then lock commands, got %v", commands)
+	}
+}
+
+func TestRetryInit(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	init := func(*librefsm.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			return errors.New("redis not ready")
+		}
+		return nil
+	}
+
+	def := librefsm.NewDefinition().
+		Merge(RetryInit(RetryInitConfig{Init: init, Ready: "ready", Delay: 5 * time.Millisecond})).
+		State("ready").
+		Initial("initializing")
+	m := start(t, def)
+
+	time.Sleep(50 * time.Millisecond) // 5ms + 10ms backoff
+	expectState(t, m, "ready")
+
+	failing := librefsm.NewDefinition().
+		Merge(RetryInit(RetryInitConfig{
+			Init:        func(*librefsm.Context) error { return errors.New("no modem") },
+			Ready:       "ready",
+			MaxAttempts: 2,
+			Delay:       time.Millisecond,
+		})).
+		State("ready").
+		Initial("initializing")
+	m = start(t, failing)
+
+	time.Sleep(30 * time.Millisecond)
+	expectState(t, m, "init-failed")
+	if n, _ := m.GetVar("initializing.attempts"); n != 0 {
+		t.Errorf("expected attempts to reset on failure, got %v", n)
+	}
+}
+
+func TestDebounceConfirm(t *testing.T) {
+	confirmed := make(chan struct{}, 1)
+	def := librefsm.NewDefinition().
+		Merge(DebounceConfirm(DebounceConfig{
+			Active:  "kickstand:down",
+			Release: "kickstand:up",
+			Hold:    20 * time.Millisecond,
+			OnConfirm: func(*librefsm.Context) error {
+				confirmed <- struct{}{}
+				return nil
+			},
+		})).
+		Initial("input-inactive")
+	m := start(t, def)
+
+	// Bounce shorter than the hold time
+	m.SendSync(librefsm.Event{ID: "kickstand:down"})
+	m.SendSync(librefsm.Event{ID: "kickstand:up"})
+	time.Sleep(30 * time.Millisecond)
+	expectState(t, m, "input-inactive")
+
+	m.SendSync(librefsm.Event{ID: "kickstand:down"})
+	select {
+	case <-confirmed:
+	case <-time.After(time.Second):
+		t.Fatal("expected confirmation after hold time")
+	}
+	expectState(t, m, "input-confirmed")
+}
+
+func TestStagedShutdown(t *testing.T) {
+	var mu sync.Mutex
+	var ran []librefsm.StateID
+	stage := func(id librefsm.StateID, err error) func(*librefsm.Context) error {
+		return func(*librefsm.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, id)
+			return err
+		}
+	}
+
+	def := librefsm.NewDefinition().
+		State("running").
+		Transition("running", "power:off", "park-motor").
+		Merge(StagedShutdown(ShutdownConfig{Stages: []ShutdownStage{
+			{State: "park-motor", Action: stage("park-motor", errors.New("motor busy"))},
+			{State: "await-ack", Action: stage("await-ack", nil), Wait: true, Timeout: 20 * time.Millisecond},
+			{State: "flush", Action: stage("flush", nil)},
+		}})).
+		Initial("running")
+	m := start(t, def)
+
+	m.SendSync(librefsm.Event{ID: "power:off"})
+	time.Sleep(50 * time.Millisecond)
+	expectState(t, m, "off")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ran) != 3 || ran[2] != "flush" {
+		t.Errorf("expected all stages to run in order, got %v", ran)
+	}
+}
+
+func TestBatteryManager(t *testing.T) {
+	bus := librefsm.NewBus()
+	statuses := make(chan BatteryStatus, 32)
+	bus.Subscribe(BatteryStatusEvent, func(e librefsm.Event) {
+		statuses <- e.Payload.(BatteryStatus)
+	})
+
+	bm, err := NewBatteryManager(BatteryManagerConfig{
+		Slot: BatterySlotConfig{HeartbeatTimeout: 40 * time.Millisecond},
+		Bus:  bus,
+	})
+	if err != nil {
+		t.Fatalf("manager failed: %v", err)
+	}
+	if err := bm.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer bm.Stop()
+
+	if s := <-statuses; s.Present() != 0 || s.Active != -1 {
+		t.Errorf("expected empty initial status, got %+v", s)
+	}
+
+	bm.Slot(1).SendSync(librefsm.Event{ID: BatteryInserted})
+	bm.Slot(1).SendSync(librefsm.Event{ID: BatteryActivate})
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		bm.Slot(1).SendSync(librefsm.Event{ID: BatteryHeartbeat})
+	}
+	if s := bm.Status(); s.Active != 1 || s.Present() != 1 {
+		t.Errorf("expected slot 1 active while heartbeats arrive, got %+v", s)
+	}
+
+	time.Sleep(80 * time.Millisecond) // Heartbeats stop
+	s := bm.Status()
+	if s.Slots[1] != BatteryFault || s.Faults != 1 || s.Active != -1 {
+		t.Errorf("expected slot 1 to fault without heartbeats, got %+v", s)
+	}
+
+	if err := bm.Send(2, librefsm.Event{ID: BatteryInserted}); err == nil {
+		t.Errorf("expected out of range slot to fail")
+	}
+
+	var last BatteryStatus
+	for len(statuses) > 0 {
+		last = <-statuses
+	}
+	if last.Slots[1] != BatteryFault {
+		t.Errorf("expected the last published status to report the fault, got %+v", last)
+	}
+}
@@ -0,0 +1,70 @@
+package patterns
+
+import (
+	"time"
+
+	"github.com/librescoot/librefsm"
+)
+
+// ShutdownStage is one step of a staged shutdown
+type ShutdownStage struct {
+	State librefsm.StateID
+
+	// Action runs on entry, e.g. parking the motor or flushing logs. Errors are
+	// logged and do not stop the shutdown.
+	Action func(ctx *librefsm.Context) error
+
+	// Wait keeps the stage active after Action returns until the Done event
+	// (or the timeout) arrives, e.g. for an asynchronous acknowledgement
+	Wait bool
+
+	// Timeout moves on to the next stage regardless; zero means 5s
+	Timeout time.Duration
+}
+
+// ShutdownConfig configures StagedShutdown. Zero IDs fall back to the defaults in parentheses.
+type ShutdownConfig struct {
+	Parent librefsm.StateID // Optional parent of the fragment's states
+
+	Stages []ShutdownStage  // Run in order; enter the first to begin the shutdown
+	Done   librefsm.EventID // Completes a waiting stage ("shutdown:stage-done")
+	Off    librefsm.StateID // Final state after the last stage ("off")
+}
+
+// StagedShutdown returns a shutdown sequence that runs each stage's action in
+// turn and always reaches Off, even if stages fail or never acknowledge:
+//
+//	stage1 --done/timeout--> stage2 --done/timeout--> ... --> off
+//
+// Since Done is shared by all stages, an acknowledgement arriving after its
+// stage timed out completes the next stage too; use distinct Done events per
+// service if that matters.
+func StagedShutdown(cfg ShutdownConfig) *librefsm.Definition {
+	done := orEvent(cfg.Done, "shutdown:stage-done")
+	off := orState(cfg.Off, "off")
+
+	def := librefsm.NewDefinition()
+	for i, stage := range cfg.Stages {
+		stage := stage
+		next := off
+		if i+1 < len(cfg.Stages) {
+			next = cfg.Stages[i+1].State
+		}
+
+		def.State(stage.State, append(parent(cfg.Parent),
+			librefsm.WithOnEnter(func(ctx *librefsm.Context) error {
+				if stage.Action != nil {
+					if err := stage.Action(ctx); err != nil {
+						ctx.Logger.Warn("shutdown stage failed", "stage", stage.State, "error", err)
+					}
+				}
+				if !stage.Wait {
+					ctx.Send(librefsm.Event{ID: done})
+				}
+				return nil
+			}),
+			librefsm.WithTimeoutTransition(orDuration(stage.Timeout, 5*time.Second), next))...).
+			Transition(stage.State, done, next)
+	}
+	return def.FinalState(off, parent(cfg.Parent)...)
+}
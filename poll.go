@@ -0,0 +1,63 @@
+package librefsm
+
+import (
+	"fmt"
+	"time"
+)
+
+// PollingState adds a state that evaluates route every interval until it
+// returns a non-empty target, then transitions there, e.g. "wait until the
+// modem registered, then continue". The polling timer is state-scoped and
+// stops when the state is left for any other reason. Declare the possible
+// targets with WithPossibleTargets for validation and diagrams.
+func (d *Definition) PollingState(id StateID, interval time.Duration, route func(*Context) StateID, opts ...StateOption) *Definition {
+	s := &State{
+		ID:           id,
+		Type:         StateNormal,
+		Condition:    route,
+		PollInterval: interval,
+	}
+	if interval <= 0 {
+		s.errs = append(s.errs, fmt.Errorf("poll interval must be positive, got %v", interval))
+	}
+	if route == nil {
+		s.errs = append(s.errs, fmt.Errorf("nil routing function"))
+	}
+	return d.addState("PollingState", s, opts)
+}
+
+// pollTimerName is the state-scoped timer driving a polling state
+func pollTimerName(id StateID) string {
+	return "_poll_" + string(id)
+}
+
+// armPoll schedules the next evaluation of a polling state. Followers do not
+// poll since the authority they mirror decides when the state is left.
+func (m *Machine) armPoll(id StateID, state *State) {
+	if state.PollInterval <= 0 || state.Condition == nil || m.followEvent != "" {
+		return
+	}
+	m.startTimerInternal(pollTimerName(id), state.PollInterval, Event{ID: eventPoll, Payload: id}, TimerScopeState, id)
+}
+
+// poll evaluates a polling state's routing function, ignoring stale timer events
+func (m *Machine) poll(event Event) error {
+	id, _ := event.Payload.(StateID)
+	state := m.definition.states[id]
+	if state == nil || state.Condition == nil || !m.isInStateInternal(id) || m.followEvent != "" {
+		return nil
+	}
+
+	ctx := m.makeContext(nil)
+	ctx.state = id
+	target := state.Condition(ctx)
+	if target == "" {
+		m.armPoll(id, state)
+		return nil
+	}
+	if !state.allowsTarget(target) {
+		return fmt.Errorf("polling state %q returned undeclared target %q", id, target)
+	}
+	m.trace("polling state routed", "state", id, "to", target)
+	return m.runTransition(&Transition{From: id, Event: eventPoll, To: target}, target, nil)
+}
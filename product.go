@@ -0,0 +1,381 @@
+package librefsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ProductState returns the ID of the product state in which the first
+// machine is in a and the second in b, see Product
+func ProductState(a, b StateID) StateID {
+	return a + "|" + b
+}
+
+// Product builds the synchronized product of two flat definitions, e.g. a
+// vehicle and a battery machine, so that their interaction can be analyzed,
+// linted and simulated as one machine. Its states are the pairs of component
+// states, named by ProductState, and it starts in the pair of initial states.
+//
+// sync maps events of a to events of b that happen together: a transition
+// of a on such an event is only taken jointly with a transition of b on its
+// partner event, when both guards pass. Either event ID triggers the joint
+// move. All other transitions interleave: they move one component and leave
+// the other in place. If both components handle an unsynchronized event, a
+// handles it.
+//
+// Entry actions run only for the component whose state changes; exit
+// actions run as part of the product transition leaving the state. Guards
+// and actions see product state IDs. Hierarchical states, state timeouts,
+// polling states and minimum dwell times cannot be combined and are
+// reported as definition errors.
+func Product(a, b *Definition, sync map[EventID]EventID) *Definition {
+	d := NewDefinition()
+	if a == nil || b == nil {
+		d.recordError("Product", 2, fmt.Errorf("nil definition"))
+		return d
+	}
+	d.errs = append(d.errs, a.errs...)
+	d.errs = append(d.errs, b.errs...)
+
+	for _, def := range []*Definition{a, b} {
+		for _, id := range def.stateIDs() {
+			if err := productSupported(def.states[id]); err != nil {
+				d.recordError("Product", 2, fmt.Errorf("state %q: %w", id, err))
+			}
+		}
+	}
+
+	aIDs, bIDs := a.stateIDs(), b.stateIDs()
+
+	// Product state -> component states, for entry actions
+	pairs := make(map[StateID][2]StateID, len(aIDs)*len(bIDs))
+	for _, ia := range aIDs {
+		for _, ib := range bIDs {
+			pairs[ProductState(ia, ib)] = [2]StateID{ia, ib}
+		}
+	}
+	for _, ia := range aIDs {
+		for _, ib := range bIDs {
+			d.states[ProductState(ia, ib)] = productPair(a.states[ia], b.states[ib], pairs)
+		}
+	}
+
+	synced := make([]EventID, 0, len(sync))
+	partners := make(map[EventID]bool, len(sync))
+	for ea, eb := range sync {
+		synced = append(synced, ea)
+		partners[eb] = true
+	}
+	sort.Slice(synced, func(i, j int) bool { return synced[i] < synced[j] })
+	for _, ea := range synced {
+		d.productJoint(a, b, ea, sync[ea])
+	}
+	for i := range a.transitions {
+		if t := &a.transitions[i]; sync[t.Event] == "" || t.Eventless {
+			d.productInterleave(a, t, aIDs, bIDs, true)
+		}
+	}
+	for i := range b.transitions {
+		if t := &b.transitions[i]; !partners[t.Event] || t.Eventless {
+			d.productInterleave(b, t, bIDs, aIDs, false)
+		}
+	}
+
+	d.productInitial(a, b)
+	d.productActions(a, b)
+	return d
+}
+
+// productSupported reports state features the product cannot preserve
+func productSupported(s *State) error {
+	switch {
+	case s.Parent != "" || s.DefaultChild != "":
+		return fmt.Errorf("hierarchical states are not supported")
+	case s.Timeout > 0 || len(s.TimeoutStages) > 0:
+		return fmt.Errorf("state timeouts are not supported")
+	case s.PollInterval > 0:
+		return fmt.Errorf("polling states are not supported")
+	case s.MinimumDwell > 0:
+		return fmt.Errorf("minimum dwell times are not supported")
+	}
+	return nil
+}
+
+// productPair builds the product state of two component states
+func productPair(sa, sb *State, pairs map[StateID][2]StateID) *State {
+	s := &State{
+		ID:           ProductState(sa.ID, sb.ID),
+		Type:         StateNormal,
+		Transitional: sa.Transitional || sb.Transitional,
+		source:       sa.source,
+	}
+	if sa.Type == StateFinal && sb.Type == StateFinal {
+		s.Type = StateFinal
+	}
+
+	// A pseudo-state component routes the pair; a is resolved first
+	switch {
+	case sa.Condition != nil && sa.Type != StateNormal:
+		s.Type = sa.Type
+		s.Condition = productCondition(sa.Condition, func(t StateID) StateID { return ProductState(t, sb.ID) })
+		for _, t := range sa.PossibleTargets {
+			s.PossibleTargets = append(s.PossibleTargets, ProductState(t, sb.ID))
+		}
+		s.OnExit, s.OnExitName = sa.OnExit, sa.OnExitName
+	case sb.Condition != nil && sb.Type != StateNormal:
+		s.Type = sb.Type
+		s.Condition = productCondition(sb.Condition, func(t StateID) StateID { return ProductState(sa.ID, t) })
+		for _, t := range sb.PossibleTargets {
+			s.PossibleTargets = append(s.PossibleTargets, ProductState(sa.ID, t))
+		}
+		s.OnExit, s.OnExitName = sb.OnExit, sb.OnExitName
+	}
+
+	if hasEntry(sa) || hasEntry(sb) {
+		s.OnEnter = func(c *Context) error {
+			from, ok := pairs[c.FromState]
+			if !ok || from[0] != sa.ID {
+				if err := runComponent(c, c.FSM.entryAction(sa)); err != nil {
+					return err
+				}
+			}
+			if !ok || from[1] != sb.ID {
+				return runComponent(c, c.FSM.entryAction(sb))
+			}
+			return nil
+		}
+	}
+	return s
+}
+
+// productCondition maps the targets of a component's condition to product states
+func productCondition(cond func(*Context) StateID, pair func(StateID) StateID) func(*Context) StateID {
+	return func(c *Context) StateID {
+		if t := cond(c); t != "" {
+			return pair(t)
+		}
+		return ""
+	}
+}
+
+func hasEntry(s *State) bool {
+	return s.OnEnter != nil || s.OnEnterName != ""
+}
+
+func runComponent(c *Context, action func(*Context) error) error {
+	if action == nil {
+		return nil
+	}
+	return action(c)
+}
+
+// componentMove is one component's part of a product transition
+type componentMove struct {
+	t       *Transition
+	exit    *State // Exited before the action, nil if the component stays
+	reenter *State // Re-entered after the action by external self-transitions
+}
+
+// productMove describes how a component transition moves its component
+func productMove(def *Definition, t *Transition, from StateID) componentMove {
+	mv := componentMove{t: t}
+	if t.Kind == TransitionInternal {
+		return mv
+	}
+	if t.To != from || t.Select != nil || t.Kind == TransitionExternal {
+		mv.exit = def.states[from]
+	}
+	if t.To == from && t.Kind == TransitionExternal {
+		mv.reenter = def.states[from]
+	}
+	return mv
+}
+
+// productAction runs the exit actions, transition actions and re-entries of
+// the component moves, in that order
+func productAction(moves ...componentMove) func(*Context) error {
+	needed := false
+	for _, mv := range moves {
+		needed = needed || mv.t.Action != nil || mv.t.ActionName != "" ||
+			(mv.exit != nil && (mv.exit.OnExit != nil || mv.exit.OnExitName != "")) ||
+			(mv.reenter != nil && hasEntry(mv.reenter))
+	}
+	if !needed {
+		return nil
+	}
+	return func(c *Context) error {
+		for _, mv := range moves {
+			if mv.exit != nil {
+				if err := runComponent(c, c.FSM.exitAction(mv.exit)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, mv := range moves {
+			if err := runComponent(c, c.FSM.transitionAction(mv.t)); err != nil {
+				return err
+			}
+		}
+		for _, mv := range moves {
+			if mv.reenter != nil {
+				if err := runComponent(c, c.FSM.entryAction(mv.reenter)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// expandSource returns the component states a transition leaves from
+func expandSource(t *Transition, ids []StateID) []StateID {
+	if t.From == WildcardState {
+		return ids
+	}
+	return []StateID{t.From}
+}
+
+// productInterleave adds the product transitions moving one component by t,
+// a transition of def, while the other stays in each of its states
+func (d *Definition) productInterleave(def *Definition, t *Transition, own, other []StateID, first bool) {
+	pair := func(mine, theirs StateID) StateID {
+		if first {
+			return ProductState(mine, theirs)
+		}
+		return ProductState(theirs, mine)
+	}
+	for _, from := range expandSource(t, own) {
+		mv := productMove(def, t, from)
+		for _, o := range other {
+			pt := *t
+			pt.From = pair(from, o)
+			pt.To = ""
+			if t.To != "" {
+				pt.To = pair(t.To, o)
+			}
+			if t.Select != nil {
+				sel, o := t.Select, o
+				pt.Select = func(payload any) StateID {
+					if to := sel(payload); to != "" {
+						return pair(to, o)
+					}
+					return ""
+				}
+				pt.Branches = nil
+				for _, br := range t.Branches {
+					pt.Branches = append(pt.Branches, pair(br, o))
+				}
+			}
+			pt.Action, pt.ActionName = productAction(mv), ""
+			pt.errs = nil
+			d.transitions = append(d.transitions, pt)
+		}
+	}
+}
+
+// productJoint adds the product transitions moving both components together
+// on the synchronized events ea of a and eb of b
+func (d *Definition) productJoint(a, b *Definition, ea, eb EventID) {
+	for i := range a.transitions {
+		ta := &a.transitions[i]
+		if ta.Event != ea || ta.Eventless {
+			continue
+		}
+		for j := range b.transitions {
+			tb := &b.transitions[j]
+			if tb.Event != eb || tb.Eventless {
+				continue
+			}
+			if ta.Select != nil || tb.Select != nil {
+				d.recordError("Product", 3, fmt.Errorf("synchronized event %q: switch transitions are not supported", ea))
+				continue
+			}
+			for _, fa := range expandSource(ta, a.stateIDs()) {
+				for _, fb := range expandSource(tb, b.stateIDs()) {
+					pt := Transition{
+						From:        ProductState(fa, fb),
+						Event:       ea,
+						To:          ProductState(fa, fb),
+						Kind:        TransitionInternal,
+						Label:       ta.Label,
+						Priority:    ta.Priority,
+						ActionRetry: ta.ActionRetry,
+						source:      ta.source,
+					}
+					if ta.Kind != TransitionInternal || tb.Kind != TransitionInternal {
+						pt.Kind = TransitionNormal
+						pt.To = ProductState(ta.To, tb.To)
+						if ta.Kind == TransitionExternal || tb.Kind == TransitionExternal {
+							pt.Kind = TransitionExternal
+						}
+					}
+					if ta.hasGuard() || tb.hasGuard() {
+						pt.GuardErr = jointGuard(ta, tb)
+						pt.GuardName = ta.guardLabel() + " && " + tb.guardLabel()
+					}
+					pt.Action = productAction(productMove(a, ta, fa), productMove(b, tb, fb))
+					d.transitions = append(d.transitions, pt)
+					if eb != ea {
+						pt.Event = eb
+						d.transitions = append(d.transitions, pt)
+					}
+				}
+			}
+		}
+	}
+}
+
+// jointGuard passes when the guards of both component transitions pass
+func jointGuard(ta, tb *Transition) func(*GuardContext) (bool, error) {
+	return func(c *GuardContext) (bool, error) {
+		for _, t := range []*Transition{ta, tb} {
+			ok, err := c.fsm.checkGuard(t, c.Event)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// productInitial starts the product in the pair of initial states
+func (d *Definition) productInitial(a, b *Definition) {
+	d.initial = ProductState(a.initial, b.initial)
+	if a.initialFunc == nil && b.initialFunc == nil {
+		return
+	}
+	d.initialFunc = func(c *Context) StateID {
+		ia, ib := a.initial, b.initial
+		if a.initialFunc != nil {
+			if id := a.initialFunc(c); id != "" {
+				ia = id
+			}
+		}
+		if b.initialFunc != nil {
+			if id := b.initialFunc(c); id != "" {
+				ib = id
+			}
+		}
+		return ProductState(ia, ib)
+	}
+}
+
+// productActions registers the named actions of both components
+func (d *Definition) productActions(a, b *Definition) {
+	for _, def := range []*Definition{a, b} {
+		names := make([]string, 0, len(def.actions))
+		for name := range def.actions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if d.actions[name] != nil {
+				d.recordError("Product", 3, fmt.Errorf("duplicate action %q", name))
+			}
+			if d.actions == nil {
+				d.actions = make(map[string]func(*Context) error)
+			}
+			d.actions[name] = def.actions[name]
+		}
+	}
+}
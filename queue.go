@@ -0,0 +1,404 @@
+package librefsm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultQueueSize is the event queue capacity unless configured
+const defaultQueueSize = 100
+
+// controlQueueSize is the capacity of the control lane
+const controlQueueSize = 16
+
+// queuedEvent is an event waiting in the queue
+type queuedEvent struct {
+	event   Event
+	done    chan error   // Set for SendSync, receives the processing result
+	control bool         // Goes to the control lane
+	timer   bool         // Raised by the machine itself, e.g. by a timer; never dropped since it cannot be sent again
+	batch   []Event      // Set for SendSequence, processed back-to-back instead of event
+	command func() error // Set for lifecycle commands such as Reset, run instead of event
+	seq     uint64       // Order of acceptance into the queue, stamped by push
+	result  *SendResult  // Set for SendSyncResult, filled in while processing
+	queued  time.Time    // When the event was first accepted, for latency metrics
+}
+
+// eventQueue is a bounded FIFO of events. Unlike a channel it allows queued
+// events to be inspected and replaced, which coalescing relies on.
+//
+// Ordering contract: every accepted event is stamped with a sequence number and
+// events are processed in sequence order within their lane. Control events have
+// their own lane that is always drained first. Timer events share the regular
+// FIFO with external events, ordered by the time they fired, and are exempt from
+// the capacity limit so they are never dropped; with WithTimerPriority they get a
+// lane drained after the control lane but before regular events.
+type eventQueue struct {
+	mu               sync.Mutex
+	items            []*queuedEvent
+	control          []*queuedEvent
+	timers           []*queuedEvent
+	capacity         int
+	seq              uint64
+	coalesce         map[EventID]bool
+	controlEvents    map[EventID]bool
+	prioritizeTimers bool
+	inflight         *queuedEvent    // Last popped event until finish
+	waiters          []chan struct{} // Producers blocked in pushWait, first come first served
+	paused           bool            // Only commands are popped while paused
+	closed           bool            // Set when the event loop exits; pushes fail
+	notify           chan struct{}
+}
+
+func newEventQueue(capacity int) *eventQueue {
+	return &eventQueue{
+		capacity: capacity,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// push appends an event, or replaces the payload of a queued event with the same
+// ID if the ID coalesces. It returns false if the queue is full.
+func (q *eventQueue) push(qe *queuedEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	if qe.control || q.controlEvents[qe.event.ID] {
+		if len(q.control) >= controlQueueSize && !qe.timer {
+			return false
+		}
+		q.control = q.accept(q.control, qe)
+		return true
+	}
+
+	if qe.event.ID == eventVarChanged && q.foldVarChange(qe.event) {
+		return true
+	}
+
+	if qe.timer {
+		if q.prioritizeTimers {
+			q.timers = q.accept(q.timers, qe)
+		} else {
+			q.items = q.accept(q.items, qe)
+		}
+		return true
+	}
+
+	if qe.done == nil && qe.batch == nil && q.coalesce[qe.event.ID] {
+		for _, queued := range q.items {
+			if queued.done == nil && queued.batch == nil && queued.event.ID == qe.event.ID {
+				queued.event.Payload = qe.event.Payload
+				return true
+			}
+		}
+	}
+
+	// Freed slots belong to blocked producers first
+	if len(q.items) >= q.capacity || len(q.waiters) > 0 {
+		return false
+	}
+	q.items = q.accept(q.items, qe)
+	return true
+}
+
+// foldVarChange merges a variable change into a pending change of the same
+// variable, keeping its old value, so that a variable set in a tight loop
+// holds at most one place in the queue. Called with mu held.
+func (q *eventQueue) foldVarChange(event Event) bool {
+	change, _ := event.Payload.(VarChange)
+	for _, lane := range [][]*queuedEvent{q.timers, q.items} {
+		for _, queued := range lane {
+			pending, ok := queued.event.Payload.(VarChange)
+			if queued.event.ID == eventVarChanged && ok && pending.Name == change.Name {
+				pending.New = change.New
+				queued.event.Payload = pending
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pushWait appends a regular event, waiting for a free slot if the queue is
+// full. Waiting producers get slots in arrival order, and non-blocking pushes
+// cannot take a slot while anyone waits.
+func (q *eventQueue) pushWait(ctx context.Context, qe *queuedEvent) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrMachineStopped
+	}
+	if len(q.waiters) == 0 && len(q.items) < q.capacity {
+		q.items = q.accept(q.items, qe)
+		q.mu.Unlock()
+		return nil
+	}
+	wake := make(chan struct{}, 1)
+	q.waiters = append(q.waiters, wake)
+	q.mu.Unlock()
+
+	for {
+		select {
+		case <-wake:
+			q.mu.Lock()
+			if q.closed {
+				q.removeWaiter(wake)
+				q.mu.Unlock()
+				return ErrMachineStopped
+			}
+			if len(q.items) < q.capacity {
+				q.waiters = q.waiters[1:]
+				q.items = q.accept(q.items, qe)
+				q.wakeWaiter()
+				q.mu.Unlock()
+				return nil
+			}
+			q.mu.Unlock()
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.removeWaiter(wake)
+			// Pass on a wake-up this producer may have consumed
+			q.wakeWaiter()
+			q.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// removeWaiter drops a blocked producer from the line. Called with mu held.
+func (q *eventQueue) removeWaiter(wake chan struct{}) {
+	for i, w := range q.waiters {
+		if w == wake {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// wakeWaiter lets the first blocked producer retry if a slot is free.
+// Called with mu held.
+func (q *eventQueue) wakeWaiter() {
+	if len(q.waiters) == 0 || len(q.items) >= q.capacity {
+		return
+	}
+	select {
+	case q.waiters[0] <- struct{}{}:
+	default:
+	}
+}
+
+// accept stamps an event, appends it to a lane and wakes the event loop.
+// Called with mu held.
+func (q *eventQueue) accept(lane []*queuedEvent, qe *queuedEvent) []*queuedEvent {
+	q.seq++
+	qe.seq = q.seq
+	if qe.queued.IsZero() {
+		qe.queued = time.Now()
+	}
+	q.signal()
+	return append(lane, qe)
+}
+
+// signal wakes the event loop. Called with mu held.
+func (q *eventQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// isControl reports whether the event ID is routed to the control lane
+func (q *eventQueue) isControl(id EventID) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.controlEvents[id]
+}
+
+// len returns the number of queued events across all lanes
+func (q *eventQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.control) + len(q.timers) + len(q.items)
+}
+
+// pop removes the next event: control lane first, then prioritized timers,
+// then regular events. While paused only a command at the head of the control
+// lane is returned.
+func (q *eventQueue) pop() (*queuedEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.paused && (len(q.control) == 0 || q.control[0].command == nil) {
+		return nil, false
+	}
+
+	for _, lane := range []*[]*queuedEvent{&q.control, &q.timers, &q.items} {
+		if len(*lane) > 0 {
+			qe := (*lane)[0]
+			(*lane)[0] = nil
+			*lane = (*lane)[1:]
+			q.inflight = qe
+			q.wakeWaiter()
+			return qe, true
+		}
+	}
+	return nil, false
+}
+
+// pushCommand queues a lifecycle command, ahead of all events on the control
+// lane or behind them at the tail of the regular lane. Commands ignore capacity.
+func (q *eventQueue) pushCommand(qe *queuedEvent, ahead bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+	if ahead {
+		q.control = append(q.accept(nil, qe), q.control...)
+	} else {
+		q.items = q.accept(q.items, qe)
+	}
+	return true
+}
+
+// setPaused pauses or resumes popping events
+func (q *eventQueue) setPaused(paused bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = paused
+	q.signal()
+}
+
+// removeAll empties all lanes and returns what was queued, in processing order
+func (q *eventQueue) removeAll() []*queuedEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var out []*queuedEvent
+	for _, lane := range []*[]*queuedEvent{&q.control, &q.timers, &q.items} {
+		out = append(out, *lane...)
+		*lane = nil
+	}
+	return out
+}
+
+// close rejects further pushes, releases blocked producers and returns what
+// was still queued
+func (q *eventQueue) close() []*queuedEvent {
+	q.mu.Lock()
+	q.closed = true
+	for _, w := range q.waiters {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+	q.mu.Unlock()
+	return q.removeAll()
+}
+
+// isClosed reports whether the queue was closed by an exiting event loop
+func (q *eventQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// reopen accepts events again after a restart
+func (q *eventQueue) reopen() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = false
+}
+
+// finish marks the last popped event as processed
+func (q *eventQueue) finish() {
+	q.mu.Lock()
+	q.inflight = nil
+	q.mu.Unlock()
+}
+
+// WithEventCoalescing makes queued events with the given IDs collapse: while an
+// event is pending, sending it again replaces its payload instead of queueing a
+// second copy. Meant for periodic updates where only the latest value matters.
+// Events sent with SendSync are never coalesced.
+func WithEventCoalescing(events ...EventID) MachineOption {
+	return func(m *Machine) {
+		if m.queue.coalesce == nil {
+			m.queue.coalesce = make(map[EventID]bool)
+		}
+		for _, ev := range events {
+			m.queue.coalesce[ev] = true
+		}
+	}
+}
+
+// WithControlEvents routes the given event IDs through the control lane, e.g.
+// emergency shutdown commands that must not wait behind a flooded queue.
+func WithControlEvents(events ...EventID) MachineOption {
+	return func(m *Machine) {
+		if m.queue.controlEvents == nil {
+			m.queue.controlEvents = make(map[EventID]bool)
+		}
+		for _, ev := range events {
+			m.queue.controlEvents[ev] = true
+		}
+	}
+}
+
+// SendControl queues an event on the control lane. It is processed before any
+// regular queued event and bypasses debounce, throttle and coalescing.
+func (m *Machine) SendControl(event Event) {
+	m.enqueueItem(&queuedEvent{event: event, control: true})
+}
+
+// WithTimerPriority processes timer events before queued external events
+// (but after control events), instead of in the order they fired.
+func WithTimerPriority() MachineOption {
+	return func(m *Machine) {
+		m.queue.prioritizeTimers = true
+	}
+}
+
+// SendWait queues an event, blocking while the queue is full instead of
+// dropping the event. Blocked producers are served in arrival order, and while
+// any producer waits, Send and SendSync drop regular events rather than jump
+// the line.
+// It returns ctx's error if ctx ends first. Debounce, throttle and coalescing
+// do not apply.
+//
+// SendWait must not be called from actions or callbacks running on the event
+// loop: the loop cannot free a slot while it waits for itself.
+func (m *Machine) SendWait(ctx context.Context, event Event) error {
+	qe := &queuedEvent{event: event}
+	if qe.control = m.queue.isControl(event.ID); qe.control {
+		return m.tryEnqueue(qe)
+	}
+	if err := m.checkPayloads(qe); err != nil {
+		m.logEvent(event, EventDropped, "", time.Now(), 0)
+		return err
+	}
+	if err := m.queue.pushWait(ctx, qe); err != nil {
+		return err
+	}
+	m.afterPush(qe)
+	return nil
+}
+
+// SendSequence queues events to be processed back-to-back, with no other event
+// interleaved, e.g. the steps of a protocol handshake. The sequence takes a
+// single queue slot and is dropped as a whole if the queue is full. Debounce,
+// throttle and coalescing do not apply to its events.
+func (m *Machine) SendSequence(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	batch := append([]Event(nil), events...)
+	m.enqueueItem(&queuedEvent{event: batch[0], batch: batch})
+}
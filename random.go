@@ -0,0 +1,87 @@
+package librefsm
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// lockedRand is a rand.Rand safe for concurrent use
+type lockedRand struct {
+	mu     sync.Mutex
+	r      *rand.Rand
+	seed   int64
+	seeded bool // seed is known, i.e. not set with WithRandSource
+}
+
+func newLockedRand(src rand.Source) *lockedRand {
+	return &lockedRand{r: rand.New(src)}
+}
+
+func newSeededRand(seed int64) *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed)), seed: seed, seeded: true}
+}
+
+// WithSeed seeds every randomized behavior of the machine: random choice
+// states, retry jitter (see WithRetryJitter) and Context.RandIntn. Machines
+// built with the same seed make the same choices for the same events. Without
+// it the seed is derived from the clock; Seed reports it so that a field
+// issue can be reproduced.
+func WithSeed(seed int64) MachineOption {
+	return func(m *Machine) {
+		m.rand = newSeededRand(seed)
+	}
+}
+
+// WithRandSource sets the source of all randomized behavior, like WithSeed.
+// Seed cannot report the seed of a custom source.
+func WithRandSource(src rand.Source) MachineOption {
+	return func(m *Machine) {
+		m.rand = newLockedRand(src)
+	}
+}
+
+// Seed returns the seed of the machine's randomness, or false if it was set
+// with WithRandSource. It is also logged at debug level on Start and included in Snapshot.
+func (m *Machine) Seed() (int64, bool) {
+	return m.rand.seed, m.rand.seeded
+}
+
+// defaultSeed derives a seed from the clock
+func defaultSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+// randFloat64 returns a pseudo-random number in [0, 1)
+func (m *Machine) randFloat64() float64 {
+	m.rand.mu.Lock()
+	defer m.rand.mu.Unlock()
+	return m.rand.r.Float64()
+}
+
+// RandIntn returns a pseudo-random number in [0, n) from the machine's
+// seeded source, so that actions drawing random numbers stay reproducible.
+// It panics if n <= 0.
+func (c *Context) RandIntn(n int) int {
+	c.FSM.rand.mu.Lock()
+	defer c.FSM.rand.mu.Unlock()
+	return c.FSM.rand.r.Intn(n)
+}
+
+// WithRetryJitter randomizes retry backoffs (see WithRetry, WithActionRetry)
+// by up to ±fraction of each delay, e.g. 0.2 for ±20%, so that machines
+// restarting together do not retry in lockstep. The jitter is drawn from the
+// machine's seeded source.
+func WithRetryJitter(fraction float64) MachineOption {
+	return func(m *Machine) {
+		m.retryJitter = min(max(fraction, 0), 1)
+	}
+}
+
+// jitter randomizes d by the configured retry jitter
+func (m *Machine) jitter(d time.Duration) time.Duration {
+	if m.retryJitter == 0 || d <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + m.retryJitter*(2*m.randFloat64()-1)))
+}
@@ -0,0 +1,90 @@
+package librefsm
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimit bounds how often a transition may be taken
+type RateLimit struct {
+	Max    int           // Transitions allowed per Window
+	Window time.Duration // Sliding window
+
+	// Defer re-queues excess events for when the window has room again instead
+	// of dropping them
+	Defer bool
+
+	// Optional: called for each excess event, with whether it was deferred
+	OnLimit func(event Event, deferred bool)
+}
+
+// rateLimiter tracks recent occurrences of a rate-limited transition
+type rateLimiter struct {
+	limit    RateLimit
+	taken    []time.Time
+	deferred uint64
+}
+
+type rateKey struct {
+	from  StateID
+	event EventID
+}
+
+// WithRateLimit limits how often the transition from state on event may be taken,
+// so a misbehaving producer cannot drive e.g. rapid lock/unlock cycles that wear
+// hardware. Excess events are dropped or, with RateLimit.Defer, delivered later.
+// A dropped event fails SendSync with ErrRateLimited and is logged as
+// EventDropped. A limit without a positive Max and Window fails Build.
+func WithRateLimit(from StateID, event EventID, limit RateLimit) MachineOption {
+	if limit.Max <= 0 || limit.Window <= 0 {
+		err := builderError("WithRateLimit", 1, fmt.Errorf("%q on %q: Max and Window must be positive", from, event))
+		return func(m *Machine) { m.optErrs = append(m.optErrs, err) }
+	}
+	return func(m *Machine) {
+		if m.rateLimits == nil {
+			m.rateLimits = make(map[rateKey]*rateLimiter)
+		}
+		m.rateLimits[rateKey{from, event}] = &rateLimiter{limit: limit}
+	}
+}
+
+// allowTransition checks a transition against its rate limit. Excess events are
+// deferred, returning false, or dropped, returning false and ErrRateLimited.
+func (m *Machine) allowTransition(t *Transition, event Event) (bool, error) {
+	rl := m.rateLimits[rateKey{t.From, t.Event}]
+	if rl == nil {
+		return true, nil
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-rl.limit.Window)
+	kept := rl.taken[:0]
+	for _, ts := range rl.taken {
+		if ts.After(cutoff) {
+			kept = append(kept, ts)
+		}
+	}
+	rl.taken = kept
+
+	if len(rl.taken) < rl.limit.Max {
+		rl.taken = append(rl.taken, now)
+		return true, nil
+	}
+
+	if rl.limit.Defer {
+		wait := rl.taken[0].Add(rl.limit.Window).Sub(now)
+		rl.deferred++
+		name := fmt.Sprintf("_ratelimit_%s_%s_%d", t.From, t.Event, rl.deferred)
+		m.logger.Debug("transition rate limited, deferring event", "event", event.ID, "from", t.From, "wait", wait)
+		m.startTimerInternal(name, wait, event, TimerScopeGlobal, "")
+	} else {
+		m.logger.Warn("transition rate limited, dropping event", "event", event.ID, "from", t.From)
+	}
+	if rl.limit.OnLimit != nil {
+		rl.limit.OnLimit(event, rl.limit.Defer)
+	}
+	if rl.limit.Defer {
+		return false, nil
+	}
+	return false, ErrRateLimited
+}
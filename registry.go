@@ -0,0 +1,138 @@
+package librefsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RegisterAction adds a named action that states and transitions can reference
+// with WithNamedOnEnter, WithNamedOnExit and WithNamedAction, and that
+// documents can reference by name. Machines may override registered actions
+// with WithActionRegistry, e.g. to stub hardware in tests.
+func (d *Definition) RegisterAction(name string, fn func(*Context) error) *Definition {
+	d.mustBeMutable("RegisterAction", 2)
+	switch {
+	case name == "":
+		d.recordError("RegisterAction", 2, fmt.Errorf("empty action name"))
+	case fn == nil:
+		d.recordError("RegisterAction", 2, fmt.Errorf("nil action %q", name))
+	case d.actions[name] != nil:
+		d.recordError("RegisterAction", 2, fmt.Errorf("duplicate action %q", name))
+	}
+	if d.actions == nil {
+		d.actions = make(map[string]func(*Context) error)
+	}
+	d.actions[name] = fn
+	return d
+}
+
+// WithActionRegistry supplies named actions for this machine. They take
+// precedence over actions registered on the definition.
+func WithActionRegistry(actions map[string]func(*Context) error) MachineOption {
+	return func(m *Machine) {
+		if m.actions == nil {
+			m.actions = make(map[string]func(*Context) error)
+		}
+		for name, fn := range actions {
+			m.actions[name] = fn
+		}
+	}
+}
+
+// WithNamedOnEnter sets the entry action to the registered action with the given name
+func WithNamedOnEnter(name string) StateOption {
+	return func(s *State) {
+		s.OnEnter = nil
+		s.OnEnterName = name
+	}
+}
+
+// WithNamedOnExit sets the exit action to the registered action with the given name
+func WithNamedOnExit(name string) StateOption {
+	return func(s *State) {
+		s.OnExit = nil
+		s.OnExitName = name
+	}
+}
+
+// WithNamedAction sets the transition's action to the registered action with the given name
+func WithNamedAction(name string) TransitionOption {
+	return func(t *Transition) {
+		t.Action = nil
+		t.ActionName = name
+	}
+}
+
+// lookupAction resolves a named action, preferring the machine's registry
+func (m *Machine) lookupAction(d *Definition, name string) func(*Context) error {
+	if fn := m.actions[name]; fn != nil {
+		return fn
+	}
+	return d.actions[name]
+}
+
+// checkActionNames verifies that every action referenced by name in d resolves
+func (m *Machine) checkActionNames(d *Definition) error {
+	var missing []string
+	for _, name := range d.actionNames() {
+		if m.lookupAction(d, name) == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("unregistered actions: %v", missing)
+	}
+	return nil
+}
+
+// actionNames returns the sorted names of all actions referenced by name in d
+func (d *Definition) actionNames() []string {
+	var names []string
+	add := func(name string) {
+		if name != "" && !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, id := range d.stateIDs() {
+		add(d.states[id].OnEnterName)
+		add(d.states[id].OnExitName)
+	}
+	for i := range d.transitions {
+		add(d.transitions[i].ActionName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// entryAction returns the state's entry action, resolving it by name if needed
+func (m *Machine) entryAction(state *State) func(*Context) error {
+	if state.OnEnter != nil || state.OnEnterName == "" {
+		return state.OnEnter
+	}
+	return m.lookupAction(m.definition, state.OnEnterName)
+}
+
+// exitAction returns the state's exit action, resolving it by name if needed
+func (m *Machine) exitAction(state *State) func(*Context) error {
+	if state.OnExit != nil || state.OnExitName == "" {
+		return state.OnExit
+	}
+	return m.lookupAction(m.definition, state.OnExitName)
+}
+
+// transitionAction returns the transition's action, resolving it by name if needed
+func (m *Machine) transitionAction(t *Transition) func(*Context) error {
+	if t.Action != nil || t.ActionName == "" {
+		return t.Action
+	}
+	return m.lookupAction(m.definition, t.ActionName)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
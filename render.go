@@ -0,0 +1,126 @@
+package librefsm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RenderText writes a compact ASCII tree of the definition for terminals
+// without graphics, e.g. a serial console. Each state lists its outgoing
+// transitions as "event [guard] / action -> target"; states on the active
+// path of current are marked with "*". Pass an empty current to mark nothing.
+func (d *Definition) RenderText(w io.Writer, current StateID) error {
+	g := newDiagram(d.Describe())
+
+	active := make(map[StateID]bool)
+	for id := current; id != ""; {
+		s, ok := d.states[id]
+		if !ok {
+			break
+		}
+		active[id] = true
+		id = s.Parent
+	}
+
+	from := make(map[StateID][]TransitionDescription)
+	for _, t := range g.Transitions {
+		from[t.From] = append(from[t.From], t)
+	}
+
+	bw := bufio.NewWriter(w)
+	var writeState func(s StateDescription, depth int)
+	writeState = func(s StateDescription, depth int) {
+		indent := strings.Repeat("  ", depth)
+		marker := "  "
+		if active[s.ID] {
+			marker = "* "
+		}
+		fmt.Fprintf(bw, "%s%s%s%s\n", marker, indent, s.ID, g.stateNotes(s))
+		for _, t := range from[s.ID] {
+			fmt.Fprintf(bw, "  %s    %s\n", indent, edgeLine(t))
+		}
+		for _, target := range s.PossibleTargets {
+			fmt.Fprintf(bw, "  %s    ? -> %s\n", indent, target)
+		}
+		for _, child := range s.Children {
+			writeState(g.byID[child], depth+1)
+		}
+	}
+
+	for _, s := range g.States {
+		if s.Parent == "" {
+			writeState(s, 0)
+		}
+	}
+	if ts := from[WildcardState]; len(ts) > 0 {
+		bw.WriteString("  (any state)\n")
+		for _, t := range ts {
+			fmt.Fprintf(bw, "      %s\n", edgeLine(t))
+		}
+	}
+	return bw.Flush()
+}
+
+// RenderText writes the machine's definition with the current state marked, see Definition.RenderText
+func (m *Machine) RenderText(w io.Writer) error {
+	m.mu.RLock()
+	def, current := m.definition, m.currentState
+	m.mu.RUnlock()
+	return def.RenderText(w, current)
+}
+
+// stateNotes returns the bracketed annotations rendered after a state's ID
+func (g *diagram) stateNotes(s StateDescription) string {
+	var notes []string
+	if s.ID == g.Initial {
+		notes = append(notes, "initial")
+	}
+	if parent, ok := g.byID[s.Parent]; ok && parent.DefaultChild == s.ID {
+		notes = append(notes, "default")
+	}
+	if s.Type != StateNormal.String() {
+		notes = append(notes, s.Type)
+	}
+	if s.Timeout != "" {
+		notes = append(notes, "timeout "+s.Timeout)
+	}
+	for _, stage := range s.TimeoutStages {
+		notes = append(notes, "after "+stage.After+" "+string(stage.Event))
+	}
+	if s.PollInterval != "" {
+		notes = append(notes, "poll "+s.PollInterval)
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(notes, ", ") + ")"
+}
+
+// edgeLine renders a transition as "label -> target[|target...]"
+func edgeLine(t TransitionDescription) string {
+	label := edgeLabel(t)
+	if t.Eventless {
+		label = strings.TrimSpace("(eventless) " + label)
+	}
+	targets := make([]string, len(t.targets()))
+	for i, id := range t.targets() {
+		targets[i] = string(id)
+	}
+	line := label + " -> " + strings.Join(targets, "|")
+	if t.Kind == TransitionInternal.String() {
+		line = label + " (internal)"
+	}
+	if t.Delay != "" {
+		line += " after " + t.Delay
+	}
+	if t.WaitInterval != "" {
+		line += " (waits, every " + t.WaitInterval
+		if t.WaitTimeout != "" {
+			line += " for " + t.WaitTimeout
+		}
+		line += ")"
+	}
+	return line
+}
@@ -0,0 +1,254 @@
+package librefsm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// replHelp lists the commands understood by RunREPL
+const replHelp = `commands:
+  <event> [json]      send an event, optionally with a JSON payload (alias: send)
+  state               show the active states, outermost first
+  events              list the events handled in the current state
+  chart               show the statechart with the active states marked
+  timers              list running timers
+  fire <timer>        fire a running timer now
+  history [n]         show the last n processed events
+  vars                show machine variables
+  set <state>         force the machine into a state
+  reset               re-enter the initial state
+  snapshot            print a JSON snapshot
+  help                show this help
+  quit                stop the machine and exit`
+
+// RunREPL starts a machine built from def and reads commands from in until it
+// is closed or a quit command is read, writing results to out. Actions that
+// are referenced by name but not registered are replaced by stubs that print
+// their name, so documents can be explored before any code exists.
+func RunREPL(ctx context.Context, def *Definition, in io.Reader, out io.Writer, opts ...MachineOption) error {
+	w := &syncWriter{w: out}
+
+	stubs := make(map[string]func(*Context) error)
+	for _, name := range def.actionNames() {
+		if def.actions[name] != nil {
+			continue
+		}
+		name := name
+		stubs[name] = func(*Context) error {
+			fmt.Fprintf(w, "  action %s\n", name)
+			return nil
+		}
+	}
+
+	opts = append([]MachineOption{
+		WithActionRegistry(stubs),
+		WithStateChangeCallback(func(from, to StateID) {
+			fmt.Fprintf(w, "  %s -> %s\n", from, to)
+		}),
+	}, opts...)
+	m, err := def.Build(opts...)
+	if err != nil {
+		return err
+	}
+	if err := m.Start(ctx); err != nil {
+		return err
+	}
+	defer m.Stop()
+
+	fmt.Fprintf(w, "in %s, type help for commands\n", m.CurrentState())
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(w, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+		if err := m.replCommand(w, line); err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// replCommand runs a single REPL command line
+func (m *Machine) replCommand(w io.Writer, line string) error {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case "help":
+		fmt.Fprintln(w, replHelp)
+	case "state":
+		snap := m.Snapshot()
+		fmt.Fprintln(w, formatPath(snap.Path))
+	case "chart":
+		return m.RenderText(w)
+	case "events":
+		for _, id := range m.handledEvents() {
+			fmt.Fprintf(w, "  %s\n", id)
+		}
+	case "timers":
+		for _, t := range m.Snapshot().Timers {
+			fmt.Fprintf(w, "  %s: %s in %s\n", t.Name, t.Event, t.Remaining)
+		}
+	case "fire":
+		err := m.fireTimer(arg)
+		if errors.Is(err, ErrNoTransition) {
+			err = nil
+		}
+		return err
+	case "history":
+		n := 10
+		if arg != "" {
+			v, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("history: %w", err)
+			}
+			n = v
+		}
+		for _, rec := range m.RecentEvents(n) {
+			fmt.Fprintf(w, "  %s %s: %s -> %s (%s)\n", rec.Received.Format("15:04:05.000"), rec.ID, rec.State, rec.Result, rec.Outcome)
+		}
+	case "vars":
+		vars := m.Vars()
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s = %v\n", name, vars[name])
+		}
+	case "set":
+		if arg == "" {
+			return errors.New("set: state required")
+		}
+		return m.SetState(StateID(arg))
+	case "reset":
+		return m.Reset()
+	case "snapshot":
+		data, err := m.SnapshotJSON()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+	case "send":
+		if arg == "" {
+			return errors.New("send: event required")
+		}
+		cmd, arg, _ = strings.Cut(arg, " ")
+		return m.replSend(w, EventID(cmd), strings.TrimSpace(arg))
+	default:
+		return m.replSend(w, EventID(cmd), arg)
+	}
+	return nil
+}
+
+// replSend sends an event with an optional JSON payload and reports the outcome
+func (m *Machine) replSend(w io.Writer, id EventID, payload string) error {
+	event := Event{ID: id}
+	if payload != "" {
+		v, err := JSONCodec{Types: m.PayloadTypes()}.Decode(id, []byte(payload))
+		if err != nil {
+			return err
+		}
+		event.Payload = v
+	}
+	err := m.SendSync(event)
+	if errors.Is(err, ErrNoTransition) {
+		err = nil
+	}
+	if recent := m.RecentEvents(1); err == nil && len(recent) == 1 && recent[0].Outcome == EventUnhandled {
+		fmt.Fprintf(w, "  unhandled in %s\n", recent[0].State)
+	}
+	return err
+}
+
+// handledEvents returns the events with a transition from an active state, sorted
+func (m *Machine) handledEvents() []EventID {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	active := make(map[StateID]bool)
+	for _, id := range m.activeChain() {
+		active[id] = true
+	}
+	var events []EventID
+	for i := range m.definition.transitions {
+		t := &m.definition.transitions[i]
+		if t.Eventless || (!active[t.From] && t.From != WildcardState) {
+			continue
+		}
+		if !containsEvent(events, t.Event) {
+			events = append(events, t.Event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i] < events[j] })
+	return events
+}
+
+// fireTimer fires a running timer now and waits until its event is processed
+func (m *Machine) fireTimer(name string) error {
+	m.timerMu.Lock()
+	entry, ok := m.timers[name]
+	if ok {
+		entry.timer.Stop()
+		delete(m.timers, name)
+	}
+	m.timerMu.Unlock()
+	if !ok {
+		return fmt.Errorf("fire: no running timer %q", name)
+	}
+
+	if entry.action != nil {
+		if err := m.runAction(m.makeContext(nil), m.actionTimeout, "timer action", entry.action); err != nil {
+			return err
+		}
+	}
+	return m.SendSync(entry.event)
+}
+
+func formatPath(path []StateID) string {
+	parts := make([]string, len(path))
+	for i, id := range path {
+		parts[i] = string(id)
+	}
+	return strings.Join(parts, " > ")
+}
+
+func containsEvent(list []EventID, id EventID) bool {
+	for _, v := range list {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// syncWriter serializes writes from the REPL and the machine's callbacks
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
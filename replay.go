@@ -0,0 +1,146 @@
+package librefsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ReplayOptions controls how a recorded journal is replayed
+type ReplayOptions struct {
+	// Speed scales the recorded gaps between events: 1 is real time, 2 twice as fast.
+	// Zero replays instantly.
+	Speed float64
+	// Payload rebuilds an event payload from its record. Records only keep a
+	// payload summary, so without it events are replayed without payloads.
+	Payload func(EventRecord) any
+}
+
+// ReplayMismatch is a replayed event whose outcome differs from the recording
+type ReplayMismatch struct {
+	Index  int // Position in the journal
+	Record EventRecord
+	Got    StateID // State reached by the replaying machine
+	Err    error   // Processing error of the replaying machine, if any
+}
+
+func (mm ReplayMismatch) String() string {
+	s := fmt.Sprintf("#%d %s in %s: recorded %s (%s), replayed %s", mm.Index, mm.Record.ID, mm.Record.State, mm.Record.Result, mm.Record.Outcome, mm.Got)
+	if mm.Err != nil {
+		s += fmt.Sprintf(" (%v)", mm.Err)
+	}
+	return s
+}
+
+// ReplayResult summarizes a replay
+type ReplayResult struct {
+	Replayed   int // Events sent to the machine
+	Skipped    int // Dropped and internal events that were not replayed
+	Mismatches []ReplayMismatch
+}
+
+// Err returns an error listing the mismatches, or nil if the replay matched the recording
+func (r *ReplayResult) Err() error {
+	if len(r.Mismatches) == 0 {
+		return nil
+	}
+	lines := make([]string, len(r.Mismatches))
+	for i, mm := range r.Mismatches {
+		lines[i] = mm.String()
+	}
+	return fmt.Errorf("replay diverged in %d of %d events:\n%s", len(r.Mismatches), r.Replayed, strings.Join(lines, "\n"))
+}
+
+// Replay drives a fresh machine built from def through a recorded journal, e.g.
+// RecentEvents or the recent_events of a snapshot from the field, and checks
+// that every event leads to the recorded state.
+//
+// The machine is moved to the state of the first record before replaying.
+// Dropped and internal events are skipped. The replaying machine runs its own
+// timers, so with a non-zero speed they may fire alongside recorded timer events.
+func Replay(ctx context.Context, def *Definition, journal []EventRecord, opts ReplayOptions, machineOpts ...MachineOption) (*ReplayResult, error) {
+	m, err := def.Build(machineOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Start(ctx); err != nil {
+		return nil, err
+	}
+	defer m.Stop()
+
+	result := &ReplayResult{}
+	err = replayJournal(ctx, m, journal, opts, func(i int, rec EventRecord, err error) {
+		if rec.Outcome == EventDropped || isInternalEvent(rec.ID) {
+			result.Skipped++
+			return
+		}
+		result.Replayed++
+		if got := m.CurrentState(); diverged(rec, got, err) {
+			result.Mismatches = append(result.Mismatches, ReplayMismatch{Index: i, Record: rec, Got: got, Err: err})
+		}
+	})
+	return result, err
+}
+
+// replayJournal sends the journal's events to a started machine, calling visit
+// after each record. Skipped records are visited without being sent.
+func replayJournal(ctx context.Context, m *Machine, journal []EventRecord, opts ReplayOptions, visit func(int, EventRecord, error)) error {
+	var last time.Time
+	for i, rec := range journal {
+		if rec.Outcome == EventDropped || isInternalEvent(rec.ID) {
+			visit(i, rec, nil)
+			continue
+		}
+
+		if last.IsZero() {
+			if rec.State != "" && rec.State != m.CurrentState() {
+				if err := m.SetState(rec.State); err != nil {
+					return fmt.Errorf("replay start state: %w", err)
+				}
+			}
+		} else if opts.Speed > 0 {
+			if err := sleepContext(ctx, time.Duration(float64(rec.Received.Sub(last))/opts.Speed)); err != nil {
+				return err
+			}
+		}
+		last = rec.Received
+
+		event := Event{ID: rec.ID}
+		if opts.Payload != nil {
+			event.Payload = opts.Payload(rec)
+		}
+		err := m.SendSync(event)
+		if errors.Is(err, ErrNoTransition) {
+			err = nil
+		}
+		visit(i, rec, err)
+	}
+	return nil
+}
+
+// diverged reports whether a replayed event ended differently than recorded
+func diverged(rec EventRecord, got StateID, err error) bool {
+	return (rec.Result != "" && got != rec.Result) || (err != nil) != (rec.Outcome == EventFailed)
+}
+
+// isInternalEvent reports whether id is one of the machine's own bookkeeping events
+func isInternalEvent(id EventID) bool {
+	return id == eventDelayed || id == eventWaitCheck || id == eventPoll || id == eventJob || id == eventVarChanged || id == eventReset
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,124 @@
+package librefsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationIssue is a single problem found in a definition
+type ValidationIssue struct {
+	Severity Severity
+	State    StateID // Offending state, if any
+	Location string  // "file:line" of the builder call, if known
+	Message  string
+
+	err error
+}
+
+func (i ValidationIssue) String() string {
+	var b strings.Builder
+	if i.Location != "" {
+		b.WriteString(i.Location)
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "%s: %s", i.Severity, i.Message)
+	return b.String()
+}
+
+// ValidationReport lists every problem in a definition, errors first
+type ValidationReport struct {
+	Issues []ValidationIssue
+}
+
+// Report checks the definition in one pass and returns all builder errors,
+// validation errors and lint warnings (DefaultLintRules), so CI output and
+// editors can show everything wrong at once.
+func (d *Definition) Report() *ValidationReport {
+	r := &ValidationReport{}
+
+	for _, err := range d.errs {
+		issue := ValidationIssue{Severity: SeverityError, Message: err.Error(), err: err}
+		var be *BuilderError
+		if errors.As(err, &be) {
+			issue.Location = sourceLocation{File: be.File, Line: be.Line}.String()
+			issue.Message = fmt.Sprintf("%s: %v", be.Call, be.Err)
+		}
+		r.Issues = append(r.Issues, issue)
+	}
+
+	d.validate(func(issue ValidationIssue) {
+		r.Issues = append(r.Issues, issue)
+	})
+
+	locations := d.stateLocations()
+	for _, f := range d.Lint() {
+		r.Issues = append(r.Issues, ValidationIssue{
+			Severity: f.Severity,
+			State:    f.State,
+			Location: locations[f.State],
+			Message:  fmt.Sprintf("%s: %s", f.Rule, f.Message),
+		})
+	}
+
+	sort.SliceStable(r.Issues, func(i, j int) bool { return r.Issues[i].Severity > r.Issues[j].Severity })
+	return r
+}
+
+// HasErrors reports whether the report contains error-level issues
+func (r *ValidationReport) HasErrors() bool {
+	return len(r.Errors()) > 0
+}
+
+// Errors returns the error-level issues
+func (r *ValidationReport) Errors() []ValidationIssue {
+	return r.filter(SeverityError)
+}
+
+// Warnings returns the warning-level issues
+func (r *ValidationReport) Warnings() []ValidationIssue {
+	return r.filter(SeverityWarning)
+}
+
+// Err joins all error-level issues, or returns nil if there are none
+func (r *ValidationReport) Err() error {
+	var errs []error
+	for _, issue := range r.Errors() {
+		if issue.err != nil {
+			errs = append(errs, issue.err)
+		} else {
+			errs = append(errs, errors.New(issue.Message))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String renders one issue per line
+func (r *ValidationReport) String() string {
+	var b strings.Builder
+	for _, issue := range r.Issues {
+		b.WriteString(issue.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (r *ValidationReport) filter(s Severity) []ValidationIssue {
+	var out []ValidationIssue
+	for _, issue := range r.Issues {
+		if issue.Severity == s {
+			out = append(out, issue)
+		}
+	}
+	return out
+}
+
+// stateLocations maps state IDs to their builder call sites
+func (d *Definition) stateLocations() map[StateID]string {
+	locations := make(map[StateID]string, len(d.states))
+	for id, s := range d.states {
+		locations[id] = s.source.String()
+	}
+	return locations
+}
@@ -0,0 +1,88 @@
+package librefsm
+
+// SetStateOption adjusts which actions a forced state change runs
+type SetStateOption func(*setStateConfig)
+
+// setStateConfig collects SetState options
+type setStateConfig struct {
+	skipExit       bool
+	entry          entryMode
+	suppressTimers bool
+	reason         string
+}
+
+// entryMode selects what runs when states are entered
+type entryMode int
+
+const (
+	entryActions entryMode = iota // OnEnter, the normal case
+	entrySkip                     // Nothing
+	entryRestore                  // OnRestore where defined
+)
+
+// SkipExitActions skips the exit action of the state being left, e.g. a
+// phantom state the machine booted into before its real state was known
+func SkipExitActions() SetStateOption {
+	return func(c *setStateConfig) {
+		c.skipExit = true
+	}
+}
+
+// SkipEntryActions skips the entry actions of the entered states. Timeouts
+// and default children are still set up.
+func SkipEntryActions() SetStateOption {
+	return func(c *setStateConfig) {
+		c.entry = entrySkip
+	}
+}
+
+// SuppressTimers enters states without arming their timeouts and polling,
+// e.g. when the legacy code forcing the state still owns those deadlines
+func SuppressTimers() SetStateOption {
+	return func(c *setStateConfig) {
+		c.suppressTimers = true
+	}
+}
+
+// Reason records why the state was forced, reported by LastTransition and the log
+func Reason(reason string) SetStateOption {
+	return func(c *setStateConfig) {
+		c.reason = reason
+	}
+}
+
+// RunRestoreHooks runs the OnRestore hook of entered states instead of their
+// entry actions; states without one run nothing
+func RunRestoreHooks() SetStateOption {
+	return func(c *setStateConfig) {
+		c.entry = entryRestore
+	}
+}
+
+// WithOnRestore sets a hook that runs instead of the entry action when the
+// state is re-established by Restore, e.g. re-reading hardware instead of
+// arming motors again
+func WithOnRestore(fn func(*Context) error) StateOption {
+	return func(s *State) {
+		s.OnRestore = fn
+	}
+}
+
+// Restore puts the machine into a previously persisted state, e.g. after a
+// watchdog reboot: the current state's exit action is skipped and entered
+// states run their OnRestore hooks instead of entry actions.
+func (m *Machine) Restore(state StateID) error {
+	return m.SetState(state, SkipExitActions(), RunRestoreHooks())
+}
+
+// entryHook returns the callback to run when entering state under the current entry mode
+func (m *Machine) entryHook(state *State) (func(*Context) error, string) {
+	switch m.entryMode {
+	case entrySkip:
+		return nil, ""
+	case entryRestore:
+		return state.OnRestore, "restore hook"
+	default:
+		return m.entryAction(state), "entry action"
+	}
+}
@@ -0,0 +1,72 @@
+package librefsm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryPolicy controls how a failing action is retried before its error is reported
+type RetryPolicy struct {
+	Attempts int           // Total number of attempts, including the first one
+	Backoff  time.Duration // Delay before the first retry, doubled after each further attempt
+}
+
+// WithRetry retries the state's entry action on failure.
+// The action runs at most attempts times; backoff is the delay before the first
+// retry and doubles after each subsequent failure, see also WithRetryJitter.
+// The event loop is blocked while waiting.
+func WithRetry(attempts int, backoff time.Duration) StateOption {
+	return func(s *State) {
+		if attempts < 1 {
+			s.errs = append(s.errs, fmt.Errorf("WithRetry: attempts must be at least 1"))
+		}
+		s.EnterRetry = &RetryPolicy{Attempts: attempts, Backoff: backoff}
+	}
+}
+
+// WithActionRetry retries the transition action on failure (see WithRetry)
+func WithActionRetry(attempts int, backoff time.Duration) TransitionOption {
+	return func(t *Transition) {
+		if attempts < 1 {
+			t.errs = append(t.errs, fmt.Errorf("WithActionRetry: attempts must be at least 1"))
+		}
+		t.ActionRetry = &RetryPolicy{Attempts: attempts, Backoff: backoff}
+	}
+}
+
+// runWithRetry runs fn according to the retry policy and returns the last error
+func (m *Machine) runWithRetry(policy *RetryPolicy, name string, fn func() error) error {
+	if policy == nil || policy.Attempts <= 1 {
+		return fn()
+	}
+
+	var done <-chan struct{}
+	if m.ctx != nil {
+		done = m.ctx.Done()
+	}
+
+	delay := policy.Backoff
+	var err error
+	for attempt := 1; attempt <= policy.Attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == policy.Attempts || errors.Is(err, ErrTransitionAborted) {
+			break
+		}
+
+		wait := m.jitter(delay)
+		m.logger.Warn("action failed, retrying", "action", name, "attempt", attempt, "backoff", wait, "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return err
+		}
+		delay *= 2
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package librefsm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxScheduleWait bounds how long a scheduled event sleeps before re-checking
+// the wall clock, so clock corrections (e.g. RTC or NTP sync) are picked up.
+const maxScheduleWait = time.Minute
+
+// ScheduledEvent is an event waiting for its wall-clock time
+type ScheduledEvent struct {
+	At    time.Time
+	Event Event
+}
+
+type scheduledEntry struct {
+	ScheduledEvent
+	timer *time.Timer
+}
+
+type scheduler struct {
+	mu      sync.Mutex
+	entries []*scheduledEntry
+}
+
+// SendAt queues event at the wall-clock time at, e.g. "enter hibernation at 02:00".
+// Times in the past are delivered immediately, so a schedule saved with
+// ScheduledEvents can be handed back to SendAt after a restart and missed events
+// still fire. Scheduled events are discarded by Stop.
+func (m *Machine) SendAt(at time.Time, event Event) {
+	at = at.Round(0) // Compare by wall clock only
+	if !time.Now().Before(at) {
+		m.enqueue(event)
+		return
+	}
+
+	e := &scheduledEntry{ScheduledEvent: ScheduledEvent{At: at, Event: event}}
+
+	m.schedule.mu.Lock()
+	m.schedule.entries = append(m.schedule.entries, e)
+	m.armScheduled(e)
+	m.schedule.mu.Unlock()
+}
+
+// ScheduledEvents returns the pending scheduled events ordered by time
+func (m *Machine) ScheduledEvents() []ScheduledEvent {
+	m.schedule.mu.Lock()
+	defer m.schedule.mu.Unlock()
+
+	out := make([]ScheduledEvent, 0, len(m.schedule.entries))
+	for _, e := range m.schedule.entries {
+		out = append(out, e.ScheduledEvent)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	return out
+}
+
+// armScheduled starts the timer of an entry. Called with schedule.mu held.
+func (m *Machine) armScheduled(e *scheduledEntry) {
+	wait := time.Until(e.At)
+	if wait > maxScheduleWait {
+		wait = maxScheduleWait
+	}
+	e.timer = time.AfterFunc(wait, func() { m.fireScheduled(e) })
+}
+
+// fireScheduled delivers a due entry, or re-arms it if the wall clock is not there yet
+func (m *Machine) fireScheduled(e *scheduledEntry) {
+	m.schedule.mu.Lock()
+	idx := -1
+	for i, queued := range m.schedule.entries {
+		if queued == e {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		m.schedule.mu.Unlock()
+		return
+	}
+	if time.Now().Round(0).Before(e.At) {
+		m.armScheduled(e)
+		m.schedule.mu.Unlock()
+		return
+	}
+	m.schedule.entries = append(m.schedule.entries[:idx], m.schedule.entries[idx+1:]...)
+	m.schedule.mu.Unlock()
+
+	m.logger.Debug("scheduled event due", "event", e.Event.ID, "at", e.At)
+	m.enqueueItem(&queuedEvent{event: e.Event, timer: true})
+}
+
+// rearmScheduled re-checks scheduled events against the wall clock after a
+// suspend, during which their timers did not advance
+func (m *Machine) rearmScheduled() {
+	m.schedule.mu.Lock()
+	defer m.schedule.mu.Unlock()
+	for _, e := range m.schedule.entries {
+		if e.timer.Stop() {
+			m.armScheduled(e)
+		}
+	}
+}
+
+// stopScheduled discards all scheduled events
+func (m *Machine) stopScheduled() {
+	m.schedule.mu.Lock()
+	defer m.schedule.mu.Unlock()
+	for _, e := range m.schedule.entries {
+		e.timer.Stop()
+	}
+	m.schedule.entries = nil
+}
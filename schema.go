@@ -0,0 +1,83 @@
+package librefsm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PayloadPolicy decides what happens to events whose payload does not match
+// the type registered with RegisterEvent
+type PayloadPolicy int
+
+const (
+	// PayloadReject drops the event and logs an error; SendSync returns
+	// ErrPayloadMismatch. This is the default.
+	PayloadReject PayloadPolicy = iota
+	// PayloadWarn logs a warning and queues the event anyway
+	PayloadWarn
+)
+
+// RegisterEvent declares T as the payload type of events with the given ID.
+// Payloads are checked when events are queued, so producer bugs surface at the
+// boundary instead of as failed type assertions inside actions. A nil payload
+// only matches pointer, interface, map, slice, chan and func types. The
+// registrations are also available to codecs through Machine.PayloadTypes.
+func RegisterEvent[T any](id EventID) MachineOption {
+	return func(m *Machine) {
+		RegisterPayload[T](&m.payloadTypes, id)
+	}
+}
+
+// WithPayloadPolicy sets how payload mismatches of registered events are handled
+func WithPayloadPolicy(p PayloadPolicy) MachineOption {
+	return func(m *Machine) {
+		m.payloadPolicy = p
+	}
+}
+
+// PayloadTypes returns the payload types registered with RegisterEvent, for
+// use with a PayloadCodec
+func (m *Machine) PayloadTypes() *PayloadTypes {
+	return &m.payloadTypes
+}
+
+// checkPayloads validates the payloads of a queued item against the registry
+func (m *Machine) checkPayloads(qe *queuedEvent) error {
+	events := qe.batch
+	if events == nil {
+		events = []Event{qe.event}
+	}
+	for _, event := range events {
+		err := m.checkPayload(event)
+		if err == nil {
+			continue
+		}
+		if m.payloadPolicy == PayloadWarn {
+			m.logger.Warn("event payload mismatch", "event", event.ID, "error", err)
+			continue
+		}
+		m.logger.Error("rejecting event with mismatched payload", "event", event.ID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// checkPayload returns an ErrPayloadMismatch error if the event's payload does
+// not have the registered type
+func (m *Machine) checkPayload(event Event) error {
+	want := m.payloadTypes.lookup(event.ID)
+	if want == nil {
+		return nil
+	}
+	if event.Payload == nil {
+		switch want.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+			return nil
+		}
+		return fmt.Errorf("%w: %q expects %s, got nil", ErrPayloadMismatch, event.ID, want)
+	}
+	if got := reflect.TypeOf(event.Payload); !got.AssignableTo(want) {
+		return fmt.Errorf("%w: %q expects %s, got %s", ErrPayloadMismatch, event.ID, want, got)
+	}
+	return nil
+}
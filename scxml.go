@@ -0,0 +1,70 @@
+package librefsm
+
+// Semantics selects a behavioral profile for the machine
+type Semantics int
+
+const (
+	// SemanticsDefault is librefsm's native behavior
+	SemanticsDefault Semantics = iota
+	// SemanticsSCXML follows the W3C SCXML algorithm where the two differ:
+	//   - transitions of a state are tried in document order, without preferring
+	//     exact event matches over prefix patterns
+	//   - transitions are external: a transition whose target is its source or a
+	//     descendant of it exits and re-enters the source
+	//   - entering a final state raises DoneEvent(parent) ahead of queued events
+	// Exit and entry order are child-first and parent-first, and eventless
+	// transitions are taken until the configuration is stable, as in SCXML.
+	SemanticsSCXML
+)
+
+// WithSemantics selects the machine's semantic profile
+func WithSemantics(s Semantics) MachineOption {
+	return func(m *Machine) {
+		m.semantics = s
+		if s == SemanticsSCXML {
+			m.exitOrder = DefaultOrder
+			m.entryOrder = DefaultOrder
+		}
+	}
+}
+
+// DoneEvent returns the ID of the event raised under SemanticsSCXML when a final
+// child of state is entered, i.e. SCXML's "done.state.<id>"
+func DoneEvent(state StateID) EventID {
+	return EventID("done.state." + string(state))
+}
+
+// scxmlDomain returns the transition domain under SCXML semantics: a source that
+// contains the target is itself exited and re-entered
+func (m *Machine) scxmlDomain(t *Transition, target, lca StateID) StateID {
+	source := m.definition.states[t.From]
+	if source == nil || !m.isDescendantOrSelf(target, t.From) {
+		return lca
+	}
+	return source.Parent
+}
+
+// isDescendantOrSelf reports whether id is ancestor or one of its descendants
+func (m *Machine) isDescendantOrSelf(id, ancestor StateID) bool {
+	for id != "" {
+		if id == ancestor {
+			return true
+		}
+		state := m.definition.states[id]
+		if state == nil {
+			return false
+		}
+		id = state.Parent
+	}
+	return false
+}
+
+// raiseDone queues the done event of a final state's parent on the control lane,
+// so it is processed before any external event
+func (m *Machine) raiseDone(final *State) {
+	if m.semantics != SemanticsSCXML || final.Parent == "" {
+		return
+	}
+	m.logger.Debug("raising done event", "state", final.Parent)
+	m.enqueueItem(&queuedEvent{event: Event{ID: DoneEvent(final.Parent)}, control: true})
+}
@@ -0,0 +1,120 @@
+package librefsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SendOutcome says what processing an event did, see SendSyncResult
+type SendOutcome int
+
+const (
+	SendTransitioned   SendOutcome = iota // A transition was taken
+	SendDeferred                          // A transition matched but waits, see WithDelay and WithWaitFor
+	SendNoMatch                           // No transition matches the event in the current state
+	SendGuardsRejected                    // Transitions matched but all guards rejected the event
+	SendFinalState                        // The machine is in a final state and ignores events
+	SendRateLimited                       // The transition exceeded its rate limit, see WithRateLimit
+	SendDuplicate                         // The event was dropped as a duplicate, see WithDeduplication
+	SendFailed                            // Processing returned an error
+	SendAborted                           // The transition action aborted the transition, see Context.Abort
+)
+
+func (o SendOutcome) String() string {
+	switch o {
+	case SendTransitioned:
+		return "transitioned"
+	case SendDeferred:
+		return "deferred"
+	case SendNoMatch:
+		return "no match"
+	case SendGuardsRejected:
+		return "guards rejected"
+	case SendFinalState:
+		return "final state"
+	case SendRateLimited:
+		return "rate limited"
+	case SendDuplicate:
+		return "duplicate"
+	case SendFailed:
+		return "failed"
+	case SendAborted:
+		return "aborted"
+	default:
+		return fmt.Sprintf("SendOutcome(%d)", int(o))
+	}
+}
+
+// SendResult describes what happened to an event sent with SendSyncResult
+type SendResult struct {
+	Event   EventID
+	Outcome SendOutcome
+	From    StateID // State when processing started
+	To      StateID // State after processing, including eventless follow-ups
+
+	// Labels of the guards that rejected the event, in evaluation order.
+	// Unnamed guards are reported as "<anonymous>".
+	RejectedGuards []string
+
+	// Reason given to Context.Abort
+	AbortReason string
+
+	noted bool // Outcome was set during processing
+}
+
+// Changed reports whether the machine ended up in a different state
+func (r SendResult) Changed() bool {
+	return r.From != r.To
+}
+
+// SendSyncResult sends an event, waits for it to be processed and reports what
+// it did: the transition taken, or why nothing happened. Unlike SendSync, an
+// unhandled or rate-limited event is not an error even in strict mode; the
+// returned error is reserved for events that could not be queued or failed
+// while processing.
+func (m *Machine) SendSyncResult(event Event) (SendResult, error) {
+	result, done, err := m.sendWithResult(event)
+	if err != nil {
+		return *result, err
+	}
+	return settleResult(result, <-done)
+}
+
+// sendWithResult queues an event whose processing fills in the returned result
+func (m *Machine) sendWithResult(event Event) (*SendResult, chan error, error) {
+	result := &SendResult{Event: event.ID, Outcome: SendNoMatch}
+	done := make(chan error, 1)
+	if err := m.tryEnqueue(&queuedEvent{event: event, done: done, result: result}); err != nil {
+		result.Outcome = SendFailed
+		return result, nil, err
+	}
+	return result, done, nil
+}
+
+// settleResult turns the processing error of a result's event into the error
+// reported to the sender
+func settleResult(result *SendResult, err error) (SendResult, error) {
+	if errors.Is(err, ErrNoTransition) || errors.Is(err, ErrRateLimited) {
+		err = nil
+	} else if err != nil {
+		result.Outcome = SendFailed
+	}
+	return *result, err
+}
+
+// noteOutcome records what the event being processed did, keeping the first
+// outcome so that eventless follow-ups do not overwrite it. Callers hold mu.
+func (m *Machine) noteOutcome(o SendOutcome) {
+	if m.sendResult == nil || m.sendResult.noted {
+		return
+	}
+	m.sendResult.Outcome = o
+	m.sendResult.noted = true
+}
+
+// finishResult completes the result of the processed event. Callers hold mu.
+func (m *Machine) finishResult(from StateID) {
+	m.sendResult.From = from
+	m.sendResult.To = m.currentState
+	m.sendResult = nil
+}
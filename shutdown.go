@@ -0,0 +1,72 @@
+package librefsm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ShutdownEvent is sent by Stop when a shutdown state is configured.
+// Definitions may handle it to route shutdown through their own transitions.
+const ShutdownEvent EventID = "fsm.shutdown"
+
+// defaultShutdownTimeout bounds how long Stop waits for the shutdown state
+const defaultShutdownTimeout = 5 * time.Second
+
+// WithShutdownState makes Stop route the machine into state before
+// terminating, so its exit and entry actions (persisting data, powering off
+// peripherals) run instead of the loop being cut mid-state. Stop sends
+// ShutdownEvent ahead of queued events; if no transition handles it, the
+// machine transitions to state directly. Stop waits up to timeout (5s if
+// zero) for this, then stops regardless.
+func WithShutdownState(state StateID, timeout time.Duration) MachineOption {
+	return func(m *Machine) {
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		m.shutdownState = state
+		m.shutdownTimeout = timeout
+	}
+}
+
+// shutdown routes the machine into the shutdown state and waits, bounded,
+// for it to get there
+func (m *Machine) shutdown() {
+	if m.shutdownState == "" || !m.shuttingDown.CompareAndSwap(false, true) {
+		return
+	}
+
+	done := make(chan error, 1)
+	qe := &queuedEvent{event: Event{ID: ShutdownEvent}, done: done, command: m.enterShutdown}
+	if !m.queue.pushCommand(qe, true) {
+		return
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			m.logger.Error("shutdown transition failed", "state", m.shutdownState, "error", err)
+		}
+	case <-time.After(m.shutdownTimeout):
+		m.logger.Warn("shutdown state not reached in time, stopping anyway", "state", m.shutdownState, "timeout", m.shutdownTimeout)
+	}
+}
+
+// enterShutdown runs on the event loop on behalf of Stop
+func (m *Machine) enterShutdown() error {
+	event := Event{ID: ShutdownEvent}
+	if err := m.processEvent(event, 0, nil); err != nil && !errors.Is(err, ErrNoTransition) {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.isInStateInternal(m.shutdownState) {
+		return nil
+	}
+	t := &Transition{From: m.currentState, Event: ShutdownEvent, To: m.shutdownState}
+	if err := m.runTransition(t, m.shutdownState, &event); err != nil {
+		return fmt.Errorf("enter shutdown state %q: %w", m.shutdownState, err)
+	}
+	return m.settle()
+}
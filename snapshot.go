@@ -0,0 +1,74 @@
+package librefsm
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Snapshot is a human-readable picture of a running machine for debugging,
+// e.g. to attach to a support ticket
+type Snapshot struct {
+	Time           time.Time       `json:"time"`
+	State          StateID         `json:"state"`
+	Path           []StateID       `json:"path"` // Active states, outermost first
+	Timers         []TimerSnapshot `json:"timers,omitempty"`
+	LastTransition *TransitionInfo `json:"last_transition,omitempty"`
+	RecentEvents   []EventRecord   `json:"recent_events,omitempty"`
+	Vars           map[string]any  `json:"vars,omitempty"`
+	Stats          Stats           `json:"stats"`
+	Seed           *int64          `json:"seed,omitempty"` // See WithSeed; nil for a custom source
+}
+
+// TimerSnapshot describes a running timer
+type TimerSnapshot struct {
+	Name      string    `json:"name"`
+	Event     EventID   `json:"event"`
+	Owner     StateID   `json:"owner,omitempty"` // Owning state of state-scoped timers
+	Deadline  time.Time `json:"deadline"`
+	Remaining string    `json:"remaining"`
+}
+
+// Snapshot captures the machine's current state, timers, recent history and stats
+func (m *Machine) Snapshot() Snapshot {
+	now := time.Now()
+	snap := Snapshot{Time: now}
+
+	m.mu.RLock()
+	snap.State = m.currentState
+	snap.Path = m.activeChain()
+	reverseStates(snap.Path)
+	if m.lastTransition != nil {
+		last := *m.lastTransition
+		snap.LastTransition = &last
+	}
+	m.mu.RUnlock()
+
+	m.timerMu.Lock()
+	for name, entry := range m.timers {
+		snap.Timers = append(snap.Timers, TimerSnapshot{
+			Name:      name,
+			Event:     entry.event.ID,
+			Owner:     entry.ownerState,
+			Deadline:  entry.deadline,
+			Remaining: entry.deadline.Sub(now).Round(time.Millisecond).String(),
+		})
+	}
+	m.timerMu.Unlock()
+	sort.Slice(snap.Timers, func(i, j int) bool { return snap.Timers[i].Deadline.Before(snap.Timers[j].Deadline) })
+
+	snap.RecentEvents = m.RecentEvents(0)
+	if vars := m.Vars(); len(vars) > 0 {
+		snap.Vars = vars
+	}
+	snap.Stats = m.Stats()
+	if seed, ok := m.Seed(); ok {
+		snap.Seed = &seed
+	}
+	return snap
+}
+
+// SnapshotJSON returns the snapshot as indented JSON
+func (m *Machine) SnapshotJSON() ([]byte, error) {
+	return json.MarshalIndent(m.Snapshot(), "", "  ")
+}
@@ -1,6 +1,9 @@
 package librefsm
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // State defines a state in the machine
 type State struct {
@@ -12,25 +15,77 @@ type State struct {
 	OnEnter func(ctx *Context) error
 	OnExit  func(ctx *Context) error
 
-	// For condition/junction states: evaluated on entry to determine next state
-	Condition func(ctx *Context) StateID
+	// Runs instead of OnEnter when the state is restored, see WithOnRestore
+	OnRestore func(ctx *Context) error
+
+	// Registered actions used when OnEnter/OnExit are nil, see RegisterAction
+	OnEnterName string
+	OnExitName  string
+
+	// Optional retry policy for OnEnter
+	EnterRetry *RetryPolicy
+
+	// Overrides the machine-wide action timeout for OnEnter/OnExit
+	ActionTimeout time.Duration
+
+	// For condition/junction states: evaluated on entry to determine next state.
+	// For polling states: evaluated every PollInterval, see Definition.PollingState
+	Condition    func(ctx *Context) StateID
+	PollInterval time.Duration
+
+	// Optional set of states Condition may return, checked by Validate and at runtime
+	PossibleTargets []StateID
 
 	// Declarative timeout: auto-started on entry, auto-cancelled on exit
 	Timeout       time.Duration
 	TimeoutEvent  EventID
 	TimeoutAction func(*Context) error // Optional callback to run before sending timeout event
-	TimeoutTarget StateID               // If set, auto-creates transition on timeout (with generated event)
+	TimeoutTarget StateID              // If set, auto-creates transition on timeout (with generated event)
+
+	// Guarded timeout transitions, see WithGuardedTimeoutTransition
+	TimeoutRoutes []Transition
+
+	// Further timeouts armed alongside Timeout, see WithTimeoutStage
+	TimeoutStages []TimeoutStage
+
+	// Optional: defers entering DefaultChild until it passes, see WithChildEntryGuard
+	ChildEntryGuard func(ctx *GuardContext) bool
+	HoldEvent       EventID // Sent when child entry is deferred
 
 	// Declared timers (for auto-cleanup on state exit)
 	DeclaredTimers []string
+
+	// In-place event handlers, see WithOnEvent
+	EventHandlers []EventHandler
+
+	// Presentation hints for diagram exports, see WithLayout
+	Layout Layout
+
+	// Events are parked while the state is active, see WithTransitional
+	Transitional bool
+
+	// Transitions out are deferred until the state was active this long, see WithMinimumDwell
+	MinimumDwell time.Duration
+
+	errs   []error        // Option misuse, collected by the builder
+	source sourceLocation // Builder call site, for validation reports
 }
 
 // StateOption is a functional option for configuring a State
 type StateOption func(*State)
 
+// EventHandler reacts to an event without leaving the state
+type EventHandler struct {
+	Event   EventID
+	Handler func(ctx *Context) error
+}
+
 // WithParent sets the parent state for hierarchy
 func WithParent(parent StateID) StateOption {
 	return func(s *State) {
+		if parent == s.ID {
+			s.errs = append(s.errs, fmt.Errorf("WithParent: state cannot be its own parent"))
+		}
 		s.Parent = parent
 	}
 }
@@ -38,6 +93,9 @@ func WithParent(parent StateID) StateOption {
 // WithDefaultChild sets the default child state to auto-enter
 func WithDefaultChild(child StateID) StateOption {
 	return func(s *State) {
+		if child == s.ID {
+			s.errs = append(s.errs, fmt.Errorf("WithDefaultChild: state cannot be its own default child"))
+		}
 		s.DefaultChild = child
 	}
 }
@@ -46,6 +104,7 @@ func WithDefaultChild(child StateID) StateOption {
 func WithOnEnter(fn func(*Context) error) StateOption {
 	return func(s *State) {
 		s.OnEnter = fn
+		s.OnEnterName = ""
 	}
 }
 
@@ -53,6 +112,20 @@ func WithOnEnter(fn func(*Context) error) StateOption {
 func WithOnExit(fn func(*Context) error) StateOption {
 	return func(s *State) {
 		s.OnExit = fn
+		s.OnExitName = ""
+	}
+}
+
+// WithOnEvent handles an event in place: the handler runs as the action of an
+// internal self-transition, so no exit or entry actions run and timers keep
+// running. The transition is added where the state is declared, ahead of
+// transitions for the same event declared later.
+func WithOnEvent(event EventID, handler func(*Context) error) StateOption {
+	return func(s *State) {
+		if handler == nil {
+			s.errs = append(s.errs, fmt.Errorf("WithOnEvent: nil handler for %q", event))
+		}
+		s.EventHandlers = append(s.EventHandlers, EventHandler{Event: event, Handler: handler})
 	}
 }
 
@@ -60,6 +133,9 @@ func WithOnExit(fn func(*Context) error) StateOption {
 // An optional third argument specifies a callback to run before the timeout event is sent.
 func WithTimeout(duration time.Duration, event EventID, action ...func(*Context) error) StateOption {
 	return func(s *State) {
+		if duration <= 0 {
+			s.errs = append(s.errs, fmt.Errorf("WithTimeout: non-positive duration %s", duration))
+		}
 		s.Timeout = duration
 		s.TimeoutEvent = event
 		if len(action) > 0 {
@@ -68,11 +144,45 @@ func WithTimeout(duration time.Duration, event EventID, action ...func(*Context)
 	}
 }
 
+// TimeoutStage is one step of an escalating timeout, see WithTimeoutStage
+type TimeoutStage struct {
+	After time.Duration
+	Event EventID
+}
+
+// WithTimeoutStage adds a timeout that sends event once the state has been
+// active for after. Stages are armed together on entry and cancelled on exit,
+// so repeated options express warn-then-act escalation without intermediate
+// states:
+//
+//	WithTimeoutStage(30*time.Second, evWarn), WithTimeoutStage(60*time.Second, evForce)
+func WithTimeoutStage(after time.Duration, event EventID) StateOption {
+	return func(s *State) {
+		if after <= 0 {
+			s.errs = append(s.errs, fmt.Errorf("WithTimeoutStage: non-positive duration %s", after))
+		}
+		if event == "" {
+			s.errs = append(s.errs, fmt.Errorf("WithTimeoutStage: empty event"))
+		}
+		s.TimeoutStages = append(s.TimeoutStages, TimeoutStage{After: after, Event: event})
+	}
+}
+
+// timeoutStageTimer is the state-scoped timer backing a timeout stage
+func timeoutStageTimer(id StateID, stage int) string {
+	return fmt.Sprintf("_timeout_%s_%d", id, stage)
+}
+
 // WithTimeoutTransition sets a declarative timeout that automatically transitions to the target state.
 // The transition is auto-created during Build() with a generated internal event.
 // An optional third argument specifies a callback to run before the timeout transition occurs.
 func WithTimeoutTransition(duration time.Duration, target StateID, action ...func(*Context) error) StateOption {
 	return func(s *State) {
+		if duration <= 0 {
+			s.errs = append(s.errs, fmt.Errorf("WithTimeoutTransition: non-positive duration %s", duration))
+		} else if len(s.TimeoutRoutes) > 0 && s.Timeout != duration {
+			s.errs = append(s.errs, fmt.Errorf("WithTimeoutTransition: duration %s conflicts with timeout %s", duration, s.Timeout))
+		}
 		s.Timeout = duration
 		s.TimeoutTarget = target
 		// Generate internal event name from state ID and target
@@ -83,9 +193,83 @@ func WithTimeoutTransition(duration time.Duration, target StateID, action ...fun
 	}
 }
 
+// WithGuardedTimeoutTransition adds a timeout transition to target that is only
+// taken if its guard passes, e.g. to standby if the seatbox is closed, else to
+// error. Repeat the option for several targets; their guards are tried in
+// declaration order and an unguarded WithTimeoutTransition on the same state
+// acts as the fallback. All routes share one duration. If no guard passes the
+// timeout is unhandled and the state stays active. The generated transitions
+// are validated and exported like normal ones; opts configure them as in
+// Definition.Transition.
+func WithGuardedTimeoutTransition(duration time.Duration, target StateID, opts ...TransitionOption) StateOption {
+	return func(s *State) {
+		if duration <= 0 {
+			s.errs = append(s.errs, fmt.Errorf("WithGuardedTimeoutTransition: non-positive duration %s", duration))
+		} else if s.Timeout > 0 && s.Timeout != duration {
+			s.errs = append(s.errs, fmt.Errorf("WithGuardedTimeoutTransition: duration %s conflicts with timeout %s", duration, s.Timeout))
+		}
+		s.Timeout = duration
+		t := Transition{From: s.ID, To: target}
+		for _, opt := range opts {
+			opt(&t)
+		}
+		for _, err := range t.errs {
+			s.errs = append(s.errs, fmt.Errorf("WithGuardedTimeoutTransition: %w", err))
+		}
+		t.errs = nil
+		s.TimeoutRoutes = append(s.TimeoutRoutes, t)
+	}
+}
+
+// timeoutRoutes turns guarded timeout transitions, followed by a plain timeout
+// target as the fallback, into transitions on one shared timeout event
+func (s *State) timeoutRoutes() []Transition {
+	if len(s.TimeoutRoutes) == 0 {
+		return nil
+	}
+	if s.TimeoutEvent != "" && s.TimeoutTarget == "" {
+		s.errs = append(s.errs, fmt.Errorf("guarded timeout transitions conflict with timeout event %q", s.TimeoutEvent))
+	}
+	routes := s.TimeoutRoutes
+	if s.TimeoutTarget != "" {
+		routes = append(routes, Transition{From: s.ID, To: s.TimeoutTarget})
+		s.TimeoutTarget = ""
+	}
+	s.TimeoutEvent = EventID("__timeout_" + string(s.ID))
+	out := make([]Transition, len(routes))
+	for i, t := range routes {
+		t.Event = s.TimeoutEvent
+		out[i] = t
+	}
+	return out
+}
+
+// WithPossibleTargets declares the states a condition/junction state may route to.
+// Validate checks the targets exist and do not form condition loops; at runtime
+// returning any other state is an error.
+func WithPossibleTargets(targets ...StateID) StateOption {
+	return func(s *State) {
+		s.PossibleTargets = append(s.PossibleTargets, targets...)
+	}
+}
+
 // WithTimer declares a named timer for auto-cleanup on state exit
 func WithTimer(name string) StateOption {
 	return func(s *State) {
 		s.DeclaredTimers = append(s.DeclaredTimers, name)
 	}
 }
+
+// allowsTarget reports whether a condition may route to the target
+func (s *State) allowsTarget(target StateID) bool {
+	return len(s.PossibleTargets) == 0 || containsState(s.PossibleTargets, target)
+}
+
+func containsState(states []StateID, id StateID) bool {
+	for _, s := range states {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package librefsm
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time summary of the machine's activity since Start
+type Stats struct {
+	Uptime          time.Duration `json:"uptime"`           // In ns when encoded
+	EventsProcessed uint64        `json:"events_processed"` // Accepted, unhandled and failed events
+	EventsDropped   uint64        `json:"events_dropped"`   // Dropped because the queue was full, throttled or rejected
+	EventsUnhandled uint64        `json:"events_unhandled"`
+	EventsFailed    uint64        `json:"events_failed"` // Processing returned an error
+	Transitions     uint64        `json:"transitions"`   // Transitions executed, including internal ones
+	ActionErrors    uint64        `json:"action_errors"` // Failed action runs, including retried attempts
+	QueueDepth      int           `json:"queue_depth"`
+	Suspends        uint64        `json:"suspends"`  // NotifyResume calls after a suspend
+	Suspended       time.Duration `json:"suspended"` // Total time suspended, in ns when encoded
+
+	// Per-event latency, only with WithLatencyMetrics
+	Latency map[EventID]LatencyStats `json:"latency,omitempty"`
+}
+
+// machineStats holds the counters behind Stats
+type machineStats struct {
+	started     atomic.Int64 // UnixNano
+	processed   atomic.Uint64
+	dropped     atomic.Uint64
+	unhandled   atomic.Uint64
+	failed      atomic.Uint64
+	transitions atomic.Uint64
+	actionErrs  atomic.Uint64
+	suspends    atomic.Uint64
+	suspended   atomic.Int64 // Nanoseconds
+}
+
+// Stats returns the machine's counters, suitable for periodic reporting
+func (m *Machine) Stats() Stats {
+	s := Stats{
+		EventsProcessed: m.stats.processed.Load(),
+		EventsDropped:   m.stats.dropped.Load(),
+		EventsUnhandled: m.stats.unhandled.Load(),
+		EventsFailed:    m.stats.failed.Load(),
+		Transitions:     m.stats.transitions.Load(),
+		ActionErrors:    m.stats.actionErrs.Load(),
+		QueueDepth:      m.queue.len(),
+		Suspends:        m.stats.suspends.Load(),
+		Suspended:       time.Duration(m.stats.suspended.Load()),
+	}
+	if m.latency != nil {
+		s.Latency = m.latency.snapshot()
+	}
+	if started := m.stats.started.Load(); started != 0 {
+		s.Uptime = time.Since(time.Unix(0, started))
+	}
+	return s
+}
+
+// countOutcome updates the event counters for an event outcome
+func (m *Machine) countOutcome(outcome EventOutcome) {
+	switch outcome {
+	case EventDropped:
+		m.stats.dropped.Add(1)
+		return
+	case EventUnhandled:
+		m.stats.unhandled.Add(1)
+	case EventFailed:
+		m.stats.failed.Add(1)
+	}
+	m.stats.processed.Add(1)
+}
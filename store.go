@@ -0,0 +1,68 @@
+package librefsm
+
+import "sync"
+
+// StateStore is scratch storage scoped to one occupancy of a state. Entry actions
+// can stash handles (an open file, a sensor session) that the exit action picks
+// up; the store is discarded once the state has been exited.
+type StateStore struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// Get returns the value stored under key
+func (s *StateStore) Get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Set stores a value under key
+func (s *StateStore) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]any)
+	}
+	s.values[key] = value
+}
+
+// Delete removes the value stored under key
+func (s *StateStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+// StateStore returns the scratch store of the state whose entry or exit action is
+// running, or of the current state for transition and timer actions.
+func (c *Context) StateStore() *StateStore {
+	id := c.state
+	if id == "" {
+		id = c.FSM.currentState
+	}
+	return c.FSM.stateStore(id)
+}
+
+// stateStore returns the store of a state, creating it on first use
+func (m *Machine) stateStore(id StateID) *StateStore {
+	m.storesMu.Lock()
+	defer m.storesMu.Unlock()
+	if m.stateStores == nil {
+		m.stateStores = make(map[StateID]*StateStore)
+	}
+	s := m.stateStores[id]
+	if s == nil {
+		s = &StateStore{}
+		m.stateStores[id] = s
+	}
+	return s
+}
+
+// clearStateStore discards the store of an exited state
+func (m *Machine) clearStateStore(id StateID) {
+	m.storesMu.Lock()
+	defer m.storesMu.Unlock()
+	delete(m.stateStores, id)
+}
@@ -0,0 +1,132 @@
+package librefsm
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResumePolicy selects how NotifyResume treats timers paused by NotifySuspend
+type ResumePolicy int
+
+const (
+	// ResumeCredit counts the suspended time against deadlines: timers keep
+	// their wall-clock deadline and those that passed during the suspend fire
+	// right away. This is the default.
+	ResumeCredit ResumePolicy = iota
+	// ResumeFreeze resumes timers with the time they had left at suspend, as if
+	// no time had passed
+	ResumeFreeze
+	// ResumeRestart restarts timers with their full duration
+	ResumeRestart
+)
+
+func (p ResumePolicy) String() string {
+	switch p {
+	case ResumeCredit:
+		return "credit"
+	case ResumeFreeze:
+		return "freeze"
+	case ResumeRestart:
+		return "restart"
+	default:
+		return fmt.Sprintf("ResumePolicy(%d)", int(p))
+	}
+}
+
+// WithResumePolicy sets how NotifyResume re-arms timers
+func WithResumePolicy(p ResumePolicy) MachineOption {
+	return func(m *Machine) {
+		m.resumePolicy = p
+	}
+}
+
+// NotifySuspend pauses the machine's timers before the system sleeps: named
+// timers and state timeouts, delayed transitions, polling, debounce windows and
+// the idle watchdog. Go timers run on the monotonic clock, which does not
+// advance while the system is suspended, so without it deadlines silently
+// stretch by the time spent asleep. Timers started while suspended run
+// normally. Calling it again before NotifyResume has no effect.
+//
+// Events scheduled with SendAt follow the wall clock instead: they are not
+// paused, and NotifyResume delivers those that came due during the suspend.
+// Minimum dwell times, action timeouts, retry backoff, health checks and
+// heartbeats are not covered; they only count time the system was awake.
+func (m *Machine) NotifySuspend() {
+	m.timerMu.Lock()
+	if !m.suspendedAt.IsZero() {
+		m.timerMu.Unlock()
+		return
+	}
+	m.suspendedAt = time.Now().Round(0) // Wall clock, which advances during suspend
+
+	for name, entry := range m.timers {
+		if entry.timer.Stop() {
+			entry.remaining = time.Until(entry.deadline)
+			entry.suspended = true
+			m.logger.Debug("timer suspended", "name", name, "remaining", entry.remaining)
+		}
+	}
+	m.timerMu.Unlock()
+
+	m.suspendIdleWatchdog()
+	m.suspendDebounceTimers()
+}
+
+// NotifyResume re-arms the timers paused by NotifySuspend according to the
+// resume policy and returns how long the machine was suspended
+func (m *Machine) NotifyResume() time.Duration {
+	m.timerMu.Lock()
+	if m.suspendedAt.IsZero() {
+		m.timerMu.Unlock()
+		return 0
+	}
+	slept := time.Now().Round(0).Sub(m.suspendedAt)
+	m.suspendedAt = time.Time{}
+	if slept < 0 {
+		slept = 0
+	}
+	m.stats.suspends.Add(1)
+	m.stats.suspended.Add(int64(slept))
+
+	for name, entry := range m.timers {
+		if !entry.suspended {
+			continue
+		}
+		entry.suspended = false
+		d := m.resumeWait(entry.remaining, entry.duration, slept)
+		m.armTimerLocked(name, entry, d)
+		m.logger.Debug("timer resumed", "name", name, "remaining", d, "policy", m.resumePolicy)
+	}
+	m.timerMu.Unlock()
+
+	m.resumeIdleWatchdog(slept)
+	m.resumeDebounceTimers(slept)
+	m.rearmScheduled()
+	m.logger.Info("resumed from suspend", "suspended", slept)
+	return slept
+}
+
+// resumeWait returns how long a paused timer of duration full, with remaining
+// left at suspend, still waits after a suspend of slept
+func (m *Machine) resumeWait(remaining, full, slept time.Duration) time.Duration {
+	var d time.Duration
+	switch m.resumePolicy {
+	case ResumeFreeze:
+		d = remaining
+	case ResumeRestart:
+		d = full
+	default:
+		d = remaining - slept
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// Suspended reports whether NotifySuspend was called without a matching NotifyResume
+func (m *Machine) Suspended() bool {
+	m.timerMu.Lock()
+	defer m.timerMu.Unlock()
+	return !m.suspendedAt.IsZero()
+}
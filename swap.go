@@ -0,0 +1,114 @@
+package librefsm
+
+import "fmt"
+
+// Swap replaces the machine's definition while it is running, e.g. after an
+// over-the-air configuration update. The new definition is validated and the
+// current state is mapped into it by ID. Declarative timeouts and polling of the
+// active states are re-armed from the new definition with their full duration, and state-scoped
+// timers of states no longer active are cancelled. Processing continues with the
+// next event; no entry or exit actions run. Pending WithDelay and WithWaitFor
+// transitions of the old definition are cancelled.
+//
+// On a running machine the swap is queued like an event and applied by the
+// event loop between events; Swap waits for it. Like SendSync, it must not be
+// called from actions. It returns ErrMachineStopped after Stop.
+func (m *Machine) Swap(def *Definition) error {
+	if err := def.prepare(); err != nil {
+		return err
+	}
+	if err := m.checkActionNames(def); err != nil {
+		return fmt.Errorf("invalid definition: %w", err)
+	}
+	def.frozen = true
+
+	if m.cancel == nil { // Not started, nothing else runs yet
+		return m.swap(def)
+	}
+	done := make(chan error, 1)
+	if !m.queue.pushCommand(&queuedEvent{done: done, command: func() error { return m.swap(def) }}, false) {
+		return ErrMachineStopped
+	}
+	return <-done
+}
+
+// swap installs a validated definition on behalf of Swap
+func (m *Machine) swap(def *Definition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.currentState != "" {
+		if _, ok := def.states[m.currentState]; !ok {
+			return unknownState("current state %q not defined in new definition", m.currentState)
+		}
+	}
+
+	// Stop timeouts armed from the old definition
+	for _, id := range m.activeChain() {
+		m.StopTimer(fmt.Sprintf("_timeout_%s", id))
+		for i := range m.definition.states[id].TimeoutStages {
+			m.StopTimer(timeoutStageTimer(id, i))
+		}
+	}
+
+	// Delayed and waiting transitions point into the old definition
+	m.cancelDelayed()
+	m.cancelWait()
+
+	m.setDefinition(def)
+
+	active := m.activeChain()
+	m.timerMu.Lock()
+	for name, entry := range m.timers {
+		if entry.scope == TimerScopeState && !containsState(active, entry.ownerState) {
+			entry.timer.Stop()
+			delete(m.timers, name)
+			m.logger.Debug("timer cleaned up (definition swap)", "name", name, "state", entry.ownerState)
+		}
+	}
+	m.timerMu.Unlock()
+
+	// Re-arm timeouts and polling of the active states, outermost first
+	for i := len(active) - 1; i >= 0; i-- {
+		m.armTimeout(active[i], def.states[active[i]])
+		m.StopTimer(pollTimerName(active[i]))
+		m.armPoll(active[i], def.states[active[i]])
+	}
+
+	m.logger.Info("definition swapped", "state", m.currentState)
+	return nil
+}
+
+// setDefinition installs a prepared definition and computes hierarchy info
+func (m *Machine) setDefinition(d *Definition) {
+	m.definition = d
+
+	// Build parent-child relationships
+	m.children = make(map[StateID][]StateID)
+	for id, state := range d.states {
+		if state.Parent != "" {
+			m.children[state.Parent] = append(m.children[state.Parent], id)
+		}
+	}
+
+	// Compute depth for each state
+	m.depth = make(map[StateID]int)
+	for id := range d.states {
+		m.depth[id] = d.computeDepth(id)
+	}
+}
+
+// activeChain returns the current state followed by its ancestors
+func (m *Machine) activeChain() []StateID {
+	var chain []StateID
+	current := m.currentState
+	for current != "" {
+		chain = append(chain, current)
+		state := m.definition.states[current]
+		if state == nil {
+			break
+		}
+		current = state.Parent
+	}
+	return chain
+}
@@ -11,7 +11,10 @@ type timerEntry struct {
 	scope      TimerScope
 	ownerState StateID
 	duration   time.Duration
+	deadline   time.Time
 	action     func(*Context) error // Optional callback to run before sending event
+	suspended  bool                 // Paused by NotifySuspend
+	remaining  time.Duration        // Time left when suspended
 }
 
 // startTimerInternal starts a named timer with scope tracking
@@ -30,44 +33,51 @@ func (m *Machine) startTimerInternalWithAction(name string, duration time.Durati
 		delete(m.timers, name)
 	}
 
-	// Create new timer
-	t := time.AfterFunc(duration, func() {
-		m.timerMu.Lock()
-		// Check timer still exists (wasn't cancelled)
-		entry, ok := m.timers[name]
-		if ok {
-			timerAction := entry.action
-			delete(m.timers, name)
-			m.timerMu.Unlock()
-
-			m.logger.Debug("timer fired", "name", name, "event", event.ID)
-
-			// Run action callback before sending event
-			if timerAction != nil {
-				ctx := m.makeContext(nil)
-				if err := timerAction(ctx); err != nil {
-					m.logger.Error("timer action failed", "name", name, "error", err)
-				}
-			}
-
-			m.Send(event)
-		} else {
-			m.timerMu.Unlock()
-		}
-	})
-
-	m.timers[name] = &timerEntry{
-		timer:      t,
+	entry := &timerEntry{
 		event:      event,
 		scope:      scope,
 		ownerState: owner,
 		duration:   duration,
 		action:     action,
 	}
+	m.timers[name] = entry
+	m.armTimerLocked(name, entry, duration)
 
 	m.logger.Debug("timer started", "name", name, "duration", duration, "event", event.ID)
 }
 
+// armTimerLocked schedules entry to fire after d. Callers hold timerMu.
+func (m *Machine) armTimerLocked(name string, entry *timerEntry, d time.Duration) {
+	entry.deadline = time.Now().Add(d)
+	entry.timer = time.AfterFunc(d, func() {
+		m.timerFired(name, entry)
+	})
+}
+
+// timerFired runs a timer's action and queues its event, unless the timer was
+// cancelled or replaced in the meantime
+func (m *Machine) timerFired(name string, entry *timerEntry) {
+	m.timerMu.Lock()
+	if m.timers[name] != entry {
+		m.timerMu.Unlock()
+		return
+	}
+	delete(m.timers, name)
+	m.timerMu.Unlock()
+
+	m.logger.Debug("timer fired", "name", name, "event", entry.event.ID)
+
+	// Run action callback before sending event
+	if entry.action != nil {
+		ctx := m.makeContext(nil)
+		if err := m.runAction(ctx, m.actionTimeout, "timer action", entry.action); err != nil {
+			m.logger.Error("timer action failed", "name", name, "error", err)
+		}
+	}
+
+	m.enqueueItem(&queuedEvent{event: entry.event, timer: true})
+}
+
 // StartTimer starts a named timer (global scope by default from external calls)
 func (m *Machine) StartTimer(name string, duration time.Duration, event Event) {
 	m.startTimerInternal(name, duration, event, TimerScopeGlobal, "")
@@ -1,14 +1,70 @@
 package librefsm
 
+import (
+	"fmt"
+	"time"
+)
+
 // Transition defines a state change rule
 type Transition struct {
-	From   StateID // Source state (or "*" for any-state)
-	Event  EventID // Triggering event
-	To     StateID // Target state
-	Guard  func(ctx *Context) bool  // Optional: must return true to take transition
-	Action func(ctx *Context) error // Optional: runs during transition
+	From   StateID                      // Source state (or "*" for any-state)
+	Event  EventID                      // Triggering event
+	To     StateID                      // Target state
+	Guard  func(ctx *GuardContext) bool // Optional: must return true to take transition
+	Action func(ctx *Context) error     // Optional: runs during transition
+
+	ActionName  string       // Optional: registered action used when Action is nil, see RegisterAction
+	ActionRetry *RetryPolicy // Optional: retry policy for Action
+	Label       string       // Optional: human-readable description for logs and diagrams
+	GuardName   string       // Optional: name of Guard for logs and diagrams
+
+	// Optional: fallible guard, used instead of Guard. An error aborts event processing.
+	GuardErr func(ctx *GuardContext) (bool, error)
+
+	// How a transition treats its source state, see SelfTransition
+	Kind TransitionKind
+
+	// Eventless transitions have no Event and are evaluated whenever the machine settles
+	Eventless bool
+
+	// Payload-dispatched target selection, see Definition.SwitchTransition
+	Select   func(payload any) StateID
+	Branches []StateID
+
+	// Optional: postpone the state change after matching, see WithDelay
+	Delay time.Duration
+
+	// Optional: re-check a rejecting guard until it passes, see WithWaitFor
+	WaitInterval     time.Duration
+	WaitTimeout      time.Duration
+	WaitTimeoutEvent EventID
+
+	// Ordering under ConflictPriority, see WithPriority
+	Priority int
+
+	// Suppresses processing logs when taken, see WithQuiet
+	Quiet bool
+
+	// Presentation hints for diagram exports, see WithEdgeLayout
+	Layout Layout
+
+	errs   []error        // Option misuse, collected by the builder
+	source sourceLocation // Builder call site, for validation reports
 }
 
+// TransitionKind selects how a transition treats its source state
+type TransitionKind int
+
+const (
+	// TransitionNormal exits up to the least common ancestor of source and target
+	TransitionNormal TransitionKind = iota
+	// TransitionExternal exits and re-enters the source state, re-running its exit
+	// and entry actions and restarting its timers
+	TransitionExternal
+	// TransitionInternal only runs the action; no state is exited or entered and timers keep running
+	TransitionInternal
+)
+
 // WildcardState matches any state in transition rules
 const WildcardState StateID = "*"
 
@@ -16,16 +72,41 @@ const WildcardState StateID = "*"
 type TransitionOption func(*Transition)
 
 // WithGuard sets a guard condition for the transition
-func WithGuard(fn func(*Context) bool) TransitionOption {
+func WithGuard(fn func(*GuardContext) bool) TransitionOption {
 	return func(t *Transition) {
+		if fn == nil {
+			t.errs = append(t.errs, fmt.Errorf("WithGuard: nil guard"))
+		}
 		t.Guard = fn
+		t.GuardErr = nil
+		t.GuardName = ""
+	}
+}
+
+// WithNamedGuard sets a guard condition with a name that identifies it in logs
+// and diagrams, e.g. "kickstand_up"
+func WithNamedGuard(name string, fn func(*GuardContext) bool) TransitionOption {
+	return func(t *Transition) {
+		if fn == nil {
+			t.errs = append(t.errs, fmt.Errorf("WithNamedGuard: nil guard %q", name))
+		}
+		t.Guard = fn
+		t.GuardErr = nil
+		t.GuardName = name
 	}
 }
 
 // WithGuards sets multiple guard conditions that must ALL pass (AND logic)
-func WithGuards(guards ...func(*Context) bool) TransitionOption {
+func WithGuards(guards ...func(*GuardContext) bool) TransitionOption {
 	return func(t *Transition) {
-		t.Guard = func(ctx *Context) bool {
+		for i, g := range guards {
+			if g == nil {
+				t.errs = append(t.errs, fmt.Errorf("WithGuards: nil guard at index %d", i))
+			}
+		}
+		t.GuardErr = nil
+		t.GuardName = ""
+		t.Guard = func(ctx *GuardContext) bool {
 			for _, g := range guards {
 				if !g(ctx) {
 					return false
@@ -36,9 +117,38 @@ func WithGuards(guards ...func(*Context) bool) TransitionOption {
 	}
 }
 
+// WithGuardErr sets a guard that can fail, e.g. because it reads hardware state.
+// A guard error stops event processing and is reported like an action error.
+func WithGuardErr(fn func(*GuardContext) (bool, error)) TransitionOption {
+	return func(t *Transition) {
+		if fn == nil {
+			t.errs = append(t.errs, fmt.Errorf("WithGuardErr: nil guard"))
+		}
+		t.GuardErr = fn
+		t.Guard = nil
+		t.GuardName = ""
+	}
+}
+
 // WithAction sets an action to execute during the transition
 func WithAction(fn func(*Context) error) TransitionOption {
 	return func(t *Transition) {
+		if fn == nil {
+			t.errs = append(t.errs, fmt.Errorf("WithAction: nil action"))
+		}
 		t.Action = fn
+		t.ActionName = ""
+	}
+}
+
+// WithLabel sets a human-readable description of the transition
+func WithLabel(label string) TransitionOption {
+	return func(t *Transition) {
+		t.Label = label
 	}
 }
+
+// hasGuard reports whether the transition is guarded
+func (t *Transition) hasGuard() bool {
+	return t.Guard != nil || t.GuardErr != nil
+}
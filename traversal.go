@@ -0,0 +1,58 @@
+package librefsm
+
+// TraversalOrder selects the order in which a chain of nested states is exited or entered
+type TraversalOrder int
+
+const (
+	// DefaultOrder exits child-first and enters parent-first, as in UML and SCXML
+	DefaultOrder TraversalOrder = iota
+	// ChildFirst runs the innermost state's action first
+	ChildFirst
+	// ParentFirst runs the outermost state's action first
+	ParentFirst
+)
+
+// TraversalHooks run once per transition around the whole exit and entry chains,
+// rather than once per state. Each hook receives the affected states in traversal order.
+type TraversalHooks struct {
+	BeforeExit  func(ctx *Context, states []StateID) // States about to be exited
+	AfterExit   func(ctx *Context, states []StateID) // States that were exited
+	BeforeEnter func(ctx *Context, states []StateID) // Path about to be entered, up to the target
+	AfterEnter  func(ctx *Context, states []StateID) // States entered, including default children and condition routing
+}
+
+// WithTraversalHooks sets hooks around the exit and entry chains of each transition
+func WithTraversalHooks(hooks TraversalHooks) MachineOption {
+	return func(m *Machine) {
+		m.traversalHooks = hooks
+	}
+}
+
+// WithExitOrder sets the order in which nested states are exited (default ChildFirst)
+func WithExitOrder(order TraversalOrder) MachineOption {
+	return func(m *Machine) {
+		m.exitOrder = order
+	}
+}
+
+// WithEntryOrder sets the order in which the entry actions along a transition's
+// path run (default ParentFirst). With ChildFirst, the target's default children
+// and condition routing are resolved after all path entry actions ran.
+func WithEntryOrder(order TraversalOrder) MachineOption {
+	return func(m *Machine) {
+		m.entryOrder = order
+	}
+}
+
+// runTraversalHook invokes a traversal hook if it is set
+func (m *Machine) runTraversalHook(hook func(*Context, []StateID), ctx *Context, states []StateID) {
+	if hook != nil {
+		hook(ctx, states)
+	}
+}
+
+func reverseStates(states []StateID) {
+	for i, j := 0, len(states)-1; i < j; i, j = i+1, j-1 {
+		states[i], states[j] = states[j], states[i]
+	}
+}
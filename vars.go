@@ -0,0 +1,65 @@
+package librefsm
+
+import "reflect"
+
+// VarChange is the payload of the internal event queued when a variable changes
+type VarChange struct {
+	Name     string
+	Old, New any
+}
+
+// SetVar sets an extended state variable. Variables are visible to guards and
+// actions, and a change re-evaluates eventless transitions. Changes are queued
+// even when the queue is full; changes of a variable still waiting to be
+// processed are merged into one.
+func (m *Machine) SetVar(name string, value any) {
+	m.varsMu.Lock()
+	old, existed := m.vars[name]
+	if existed && reflect.DeepEqual(old, value) {
+		m.varsMu.Unlock()
+		return
+	}
+	if m.vars == nil {
+		m.vars = make(map[string]any)
+	}
+	m.vars[name] = value
+	m.varsMu.Unlock()
+
+	m.logger.Debug("variable changed", "name", name, "old", old, "new", value)
+	m.logStateChange("variable changed", "name", name, "old", old, "new", value)
+	m.enqueueItem(&queuedEvent{event: Event{ID: eventVarChanged, Payload: VarChange{Name: name, Old: old, New: value}}, timer: true})
+}
+
+// GetVar returns an extended state variable
+func (m *Machine) GetVar(name string) (any, bool) {
+	m.varsMu.RLock()
+	defer m.varsMu.RUnlock()
+	v, ok := m.vars[name]
+	return v, ok
+}
+
+// Vars returns a copy of all extended state variables
+func (m *Machine) Vars() map[string]any {
+	m.varsMu.RLock()
+	defer m.varsMu.RUnlock()
+	vars := make(map[string]any, len(m.vars))
+	for k, v := range m.vars {
+		vars[k] = v
+	}
+	return vars
+}
+
+// Var returns an extended state variable
+func (c *Context) Var(name string) (any, bool) {
+	return c.FSM.GetVar(name)
+}
+
+// SetVar sets an extended state variable
+func (c *Context) SetVar(name string, value any) {
+	c.FSM.SetVar(name, value)
+}
+
+// Var returns an extended state variable
+func (c *GuardContext) Var(name string) (any, bool) {
+	return c.fsm.GetVar(name)
+}
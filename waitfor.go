@@ -0,0 +1,100 @@
+package librefsm
+
+import (
+	"fmt"
+	"time"
+)
+
+// waitTimerName is the timer backing the pending wait-for-condition transition
+const waitTimerName = "_wait_transition"
+
+// pendingWait is a matched transition whose guard is re-checked until it passes
+type pendingWait struct {
+	t        *Transition
+	event    Event
+	deadline time.Time // Zero without a timeout
+}
+
+// WithWaitFor turns a guarded transition into one that waits for its guard: if
+// the guard rejects the event, it is re-checked every interval until it passes
+// and the transition is taken, replacing hand-built polling states. After
+// timeout (0 waits indefinitely) the wait is given up and timeoutEvent, if not
+// empty, is sent with the original payload. A waiting transition claims the
+// event, so lower-priority transitions are not tried. The wait is cancelled if
+// any other transition changes state first, and restarted if the same
+// transition matches again.
+func WithWaitFor(interval, timeout time.Duration, timeoutEvent EventID) TransitionOption {
+	return func(t *Transition) {
+		if interval <= 0 {
+			t.errs = append(t.errs, fmt.Errorf("WithWaitFor: interval must be positive, got %v", interval))
+		}
+		if timeout < 0 {
+			t.errs = append(t.errs, fmt.Errorf("WithWaitFor: timeout must not be negative, got %v", timeout))
+		}
+		t.WaitInterval = interval
+		t.WaitTimeout = timeout
+		t.WaitTimeoutEvent = timeoutEvent
+	}
+}
+
+// scheduleWait starts re-checking a transition whose guard rejected the event,
+// replacing any pending wait
+func (m *Machine) scheduleWait(t *Transition, event Event) {
+	w := &pendingWait{t: t, event: event}
+	if t.WaitTimeout > 0 {
+		w.deadline = time.Now().Add(t.WaitTimeout)
+	}
+	m.pendingWait = w
+	m.trace("waiting for guard", "event", event.ID, "from", t.From, "to", t.To, "guard", t.guardLabel(), "interval", t.WaitInterval)
+	m.armWait(w)
+}
+
+// armWait schedules the next guard check, no later than the deadline
+func (m *Machine) armWait(w *pendingWait) {
+	d := w.t.WaitInterval
+	if !w.deadline.IsZero() {
+		if left := time.Until(w.deadline); left < d {
+			d = max(left, 0)
+		}
+	}
+	m.startTimerInternal(waitTimerName, d, Event{ID: eventWaitCheck, Payload: w}, TimerScopeGlobal, "")
+}
+
+// checkWait re-evaluates the pending transition's guard, ignoring stale timer events
+func (m *Machine) checkWait(event Event) error {
+	w, _ := event.Payload.(*pendingWait)
+	if w == nil || w != m.pendingWait {
+		return nil
+	}
+	passed, err := m.checkGuard(w.t, &w.event)
+	if err != nil {
+		m.pendingWait = nil
+		return err
+	}
+	if passed {
+		m.pendingWait = nil
+		m.trace("executing transition (guard passed after waiting)", "event", w.event.ID, "from", w.t.From, "to", w.t.To, "guard", w.t.guardLabel())
+		_, err := m.takeTransition(w.t, w.event)
+		return err
+	}
+	if !w.deadline.IsZero() && !time.Now().Before(w.deadline) {
+		m.pendingWait = nil
+		m.trace("wait for guard timed out", "event", w.event.ID, "from", w.t.From, "to", w.t.To, "timeout", w.t.WaitTimeout)
+		if w.t.WaitTimeoutEvent != "" {
+			m.enqueue(Event{ID: w.t.WaitTimeoutEvent, Payload: w.event.Payload})
+		}
+		return nil
+	}
+	m.armWait(w)
+	return nil
+}
+
+// cancelWait drops the pending wait-for-condition transition, if any
+func (m *Machine) cancelWait() {
+	if m.pendingWait == nil {
+		return
+	}
+	m.trace("wait for guard cancelled", "event", m.pendingWait.event.ID, "to", m.pendingWait.t.To)
+	m.pendingWait = nil
+	m.StopTimer(waitTimerName)
+}
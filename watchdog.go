@@ -0,0 +1,104 @@
+package librefsm
+
+import (
+	"context"
+	"time"
+)
+
+// WithIdleTimeout sends event once no event at all has been processed for d,
+// regardless of the current state, e.g. to enter low-power mode when the whole
+// system goes quiet. The idle event itself does not restart the watchdog, so it
+// fires once per quiet period.
+func WithIdleTimeout(d time.Duration, event EventID) MachineOption {
+	return func(m *Machine) {
+		m.idleTimeout = d
+		m.idleEvent = event
+	}
+}
+
+// armIdleWatchdog (re)starts the idle watchdog after activity
+func (m *Machine) armIdleWatchdog() {
+	if m.idleTimeout <= 0 || m.baseContext().Err() != nil {
+		return
+	}
+	m.idleMu.Lock()
+	defer m.idleMu.Unlock()
+	m.idleDeadline = time.Now().Add(m.idleTimeout)
+	m.idleSuspended = false
+	if m.idleTimer == nil {
+		m.idleTimer = time.AfterFunc(m.idleTimeout, func() {
+			m.logger.Debug("machine idle", "timeout", m.idleTimeout)
+			m.enqueueItem(&queuedEvent{event: Event{ID: m.idleEvent}, timer: true})
+		})
+		return
+	}
+	m.idleTimer.Reset(m.idleTimeout)
+}
+
+// suspendIdleWatchdog pauses the idle watchdog, see NotifySuspend
+func (m *Machine) suspendIdleWatchdog() {
+	m.idleMu.Lock()
+	defer m.idleMu.Unlock()
+	if m.idleTimer != nil && m.idleTimer.Stop() {
+		m.idleRemaining = time.Until(m.idleDeadline)
+		m.idleSuspended = true
+	}
+}
+
+// resumeIdleWatchdog re-arms a paused idle watchdog per the resume policy
+func (m *Machine) resumeIdleWatchdog(slept time.Duration) {
+	m.idleMu.Lock()
+	defer m.idleMu.Unlock()
+	if !m.idleSuspended || m.idleTimer == nil {
+		return
+	}
+	m.idleSuspended = false
+	d := m.resumeWait(m.idleRemaining, m.idleTimeout, slept)
+	m.idleDeadline = time.Now().Add(d)
+	m.idleTimer.Reset(d)
+}
+
+// stopIdleWatchdog stops the idle watchdog
+func (m *Machine) stopIdleWatchdog() {
+	m.idleMu.Lock()
+	defer m.idleMu.Unlock()
+	if m.idleTimer != nil {
+		m.idleTimer.Stop()
+		m.idleTimer = nil
+	}
+}
+
+// Heartbeat reports the machine's liveness
+type Heartbeat struct {
+	Time       time.Time
+	State      StateID
+	QueueDepth int
+}
+
+// WithHeartbeat calls fn every interval with the current state and queue depth,
+// so external watchdogs can verify liveness. Reading the state waits for the
+// event being processed, so a stuck action also stops the heartbeat.
+func WithHeartbeat(interval time.Duration, fn func(Heartbeat)) MachineOption {
+	return func(m *Machine) {
+		m.heartbeatInterval = interval
+		m.heartbeatFn = fn
+	}
+}
+
+// runHeartbeat emits heartbeats until the machine stops
+func (m *Machine) runHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(m.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.heartbeatFn(Heartbeat{
+				Time:       now,
+				State:      m.CurrentState(),
+				QueueDepth: m.queue.len(),
+			})
+		}
+	}
+}